@@ -2,9 +2,151 @@
 
 package messaging
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"gopkg.in/square/go-jose.v2"
+)
 
 type ACLRule struct {
 	Source  string    `json:"acl_source"`
 	Expires time.Time `json:"acl_exp"`
 }
+
+// aclCache holds the ACL rules last listed, updated as senders are
+// permitted and blocked
+type aclCache struct {
+	rules []ACLRule
+	mu    sync.Mutex
+}
+
+func (ac *aclCache) set(rules []ACLRule) {
+	ac.mu.Lock()
+	ac.rules = append([]ACLRule{}, rules...)
+	ac.mu.Unlock()
+}
+
+func (ac *aclCache) get() []ACLRule {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	return append([]ACLRule{}, ac.rules...)
+}
+
+func (ac *aclCache) permit(source string, exp time.Time) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for i := range ac.rules {
+		if ac.rules[i].Source == source {
+			ac.rules[i].Expires = exp
+			return
+		}
+	}
+
+	ac.rules = append(ac.rules, ACLRule{Source: source, Expires: exp})
+}
+
+func (ac *aclCache) revoke(source string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for i := range ac.rules {
+		if ac.rules[i].Source == source {
+			ac.rules = append(ac.rules[:i], ac.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// aclPushed applies an ACL change pushed by the server, which was made by
+// another device of the identity
+func (c *Client) aclPushed(m *msgproto.AccessControlList) {
+	rule, err := c.pushedRule(m.Payload)
+	if err != nil {
+		c.report(fmt.Errorf("dropped acl change: %w", err))
+		return
+	}
+
+	switch m.Command {
+	case msgproto.ACLCommand_PERMIT:
+		c.aclRules.permit(rule.Source, rule.Expires)
+		c.emit(ACLChanged{SelfID: rule.Source, Permitted: true, Expires: rule.Expires, Remote: true})
+	case msgproto.ACLCommand_REVOKE:
+		c.aclRules.revoke(rule.Source)
+		c.emit(ACLChanged{SelfID: rule.Source, Remote: true})
+	default:
+		c.report(fmt.Errorf("dropped acl change: unsupported command %s", m.Command))
+	}
+}
+
+// pushedRule decodes the rule of a pushed ACL change, which must be signed
+// by the identity. Its signature is verified if public keys are available
+func (c *Client) pushedRule(payload []byte) (*ACLRule, error) {
+	var data []byte
+
+	if c.publicKeys != nil {
+		var err error
+
+		data, _, err = c.verifySigned(payload, c.selfID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		jws, err := jose.ParseSigned(string(payload))
+		if err != nil {
+			return nil, err
+		}
+
+		data = jws.UnsafePayloadWithoutVerification()
+	}
+
+	var rule struct {
+		Issuer string `json:"iss"`
+		ACLRule
+	}
+
+	err := json.Unmarshal(data, &rule)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Issuer != c.selfID {
+		return nil, errors.New("rule was not issued by the identity")
+	}
+
+	if rule.Source == "" {
+		return nil, errors.New("rule has no source")
+	}
+
+	return &rule.ACLRule, nil
+}
+
+// ensureACL permits the rules set with EnsureACLOnConnect, for servers that
+// reset permissions when a connection is closed. Rules with an expiry that
+// has passed are skipped, and failures are reported with OnError
+func (c *Client) ensureACL() {
+	now := c.serverNow()
+
+	for _, rule := range c.connectACL {
+		var err error
+
+		switch {
+		case rule.Expires.IsZero():
+			err = c.acl(msgproto.ACLCommand_PERMIT, rule.Source, nil)
+		case rule.Expires.After(now):
+			err = c.PermitSender(rule.Source, rule.Expires)
+		default:
+			continue
+		}
+
+		if err != nil && !c.IsClosed() {
+			c.report(fmt.Errorf("failed to permit %s on connect: %w", rule.Source, err))
+		}
+	}
+}
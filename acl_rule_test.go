@@ -0,0 +1,133 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pushedACL(command msgproto.ACLCommand, rule map[string]interface{}) []byte {
+	data, err := proto.Marshal(&msgproto.AccessControlList{
+		Id:      "push",
+		Type:    msgproto.MsgType_ACL,
+		Command: command,
+		Payload: testSignedPayload(privkey, rule),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+func TestClientACLPush(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+
+	s.out <- pushedACL(msgproto.ACLCommand_PERMIT, map[string]interface{}{
+		"iss":        "someID",
+		"exp":        time.Now().Add(time.Minute).Format(time.RFC3339),
+		"acl_source": "alice",
+		"acl_exp":    exp.Format(time.RFC3339),
+	})
+
+	e, ok := nextEvent(t, c).(ACLChanged)
+	require.True(t, ok)
+	assert.Equal(t, "alice", e.SelfID)
+	assert.True(t, e.Permitted)
+	assert.True(t, e.Remote)
+	assert.True(t, exp.Equal(e.Expires))
+
+	rules := c.CachedACLRules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "alice", rules[0].Source)
+
+	s.out <- pushedACL(msgproto.ACLCommand_REVOKE, map[string]interface{}{
+		"iss":        "someID",
+		"acl_source": "alice",
+	})
+
+	assert.Equal(t, ACLChanged{SelfID: "alice", Remote: true}, nextEvent(t, c))
+	assert.Len(t, c.CachedACLRules(), 0)
+}
+
+func TestClientACLPushWrongIssuer(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.out <- pushedACL(msgproto.ACLCommand_PERMIT, map[string]interface{}{
+		"iss":        "mallory",
+		"acl_source": "mallory",
+	})
+
+	select {
+	case err = <-c.Errors():
+		assert.Contains(t, err.Error(), "not issued by the identity")
+	case <-time.After(time.Second):
+		t.Fatal("acl change was not rejected")
+	}
+
+	assert.Len(t, c.CachedACLRules(), 0)
+}
+
+func TestClientEnsureACLOnConnect(t *testing.T) {
+	first := newScriptedConn(acknowledge)
+	second := newScriptedConn(acknowledge)
+
+	rules := []ACLRule{
+		{Source: "*"},
+		{Source: "alice", Expires: time.Now().Add(time.Hour)},
+		{Source: "bob", Expires: time.Now().Add(-time.Hour)},
+	}
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(first, second), AutoReconnect(true), RetryInterval(time.Millisecond*10), EnsureACLOnConnect(rules))
+	require.Nil(t, err)
+	defer c.Close()
+
+	permitted := func(sc *scriptedConn) {
+		for i := 0; sc.frames(msgproto.MsgType_ACL) < 2; i++ {
+			require.Less(t, i, 100, "acl rules were not permitted")
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	permitted(first)
+
+	first.fail(&websocket.CloseError{Code: websocket.CloseAbnormalClosure})
+
+	// the rules are permitted again on the new connection
+	permitted(second)
+
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, 2, second.frames(msgproto.MsgType_ACL))
+
+	sources := make(map[string]bool)
+	for _, rule := range c.CachedACLRules() {
+		sources[rule.Source] = true
+	}
+	assert.Equal(t, map[string]bool{"*": true, "alice": true}, sources)
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// DefaultArchiveBatchSize is the most messages archived in one batch
+	DefaultArchiveBatchSize = 100
+	// DefaultArchiveFlushInterval is the longest a message waits to be archived
+	DefaultArchiveFlushInterval = time.Second
+	// DefaultArchiveBuffer is the number of messages queued for archival
+	DefaultArchiveBuffer = 1024
+	// DefaultArchiveRetries is the number of times a failed batch is retried
+	DefaultArchiveRetries = 3
+)
+
+// ArchivedMessage is a sent or received message passed to an ArchiveSink.
+// Received messages are archived as delivered, after decryption
+type ArchivedMessage struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	Offset    int64     `json:"offset,omitempty"`
+	Payload   []byte    `json:"payload"`
+}
+
+// ArchiveSink stores batches of messages
+type ArchiveSink interface {
+	Archive(ctx context.Context, batch []*ArchivedMessage) error
+}
+
+// ArchiveConfig configures how messages are archived. Zero values use the defaults
+type ArchiveConfig struct {
+	// BatchSize is the most messages passed to the sink at once
+	BatchSize int
+	// FlushInterval is the longest a message is held before its batch is archived
+	FlushInterval time.Duration
+	// Buffer is the number of messages queued for archival. Messages are
+	// dropped rather than slowing the client down when the queue is full
+	Buffer int
+	// Retries is the number of times a failed batch is retried before it is dropped
+	Retries int
+	// Outbound archives acknowledged sent messages as well as received ones
+	Outbound bool
+}
+
+// archiver archives messages in batches from its own goroutine, so a slow
+// sink never blocks sending or receiving
+type archiver struct {
+	sink    ArchiveSink
+	cfg     ArchiveConfig
+	queue   chan *ArchivedMessage
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped int64
+	report  func(error)
+}
+
+func newArchiver(sink ArchiveSink, cfg ArchiveConfig, report func(error)) *archiver {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = DefaultArchiveBatchSize
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultArchiveFlushInterval
+	}
+
+	if cfg.Buffer < 1 {
+		cfg.Buffer = DefaultArchiveBuffer
+	}
+
+	if cfg.Retries < 1 {
+		cfg.Retries = DefaultArchiveRetries
+	}
+
+	a := &archiver{
+		sink:    sink,
+		cfg:     cfg,
+		queue:   make(chan *ArchivedMessage, cfg.Buffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		report:  report,
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *archiver) run() {
+	defer close(a.stopped)
+
+	batch := make([]*ArchivedMessage, 0, a.cfg.BatchSize)
+	ticker := time.NewTicker(a.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-a.queue:
+			batch = append(batch, m)
+			if len(batch) < a.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-a.done:
+			// archive everything that was queued before stopping
+			for {
+				select {
+				case m := <-a.queue:
+					batch = append(batch, m)
+					if len(batch) == a.cfg.BatchSize {
+						a.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					a.flush(batch)
+					return
+				}
+			}
+		}
+
+		a.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush archives a batch, retrying with a backoff if it fails
+func (a *archiver) flush(batch []*ArchivedMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the sink may keep the batch, so it is given its own copy
+	b := make([]*ArchivedMessage, len(batch))
+	copy(b, batch)
+
+	var err error
+
+	backoff := 100 * time.Millisecond
+
+	for attempt := 0; attempt <= a.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		err = a.sink.Archive(context.Background(), b)
+		if err == nil {
+			return
+		}
+	}
+
+	atomic.AddInt64(&a.dropped, int64(len(b)))
+	a.report(fmt.Errorf("failed to archive %d messages: %w", len(b), err))
+}
+
+// stop archives any queued messages and stops the archiver
+func (a *archiver) stop() {
+	if a == nil {
+		return
+	}
+
+	a.once.Do(func() {
+		close(a.done)
+	})
+
+	<-a.stopped
+}
+
+// archive queues a message for archival. Unacknowledged sent messages are not archived
+func (c *Client) archive(direction string, m *msgproto.Message, err error) {
+	if c.archiver == nil || err != nil {
+		return
+	}
+
+	if direction == AuditSent && !c.archiver.cfg.Outbound {
+		return
+	}
+
+	am := &ArchivedMessage{
+		Time:      c.clock.Now(),
+		Direction: direction,
+		ID:        m.Id,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		Offset:    m.Offset,
+		Payload:   m.Ciphertext,
+	}
+
+	select {
+	case c.archiver.queue <- am:
+	default:
+		atomic.AddInt64(&c.archiver.dropped, 1)
+		c.report(fmt.Errorf("archive queue is full, dropped message %s", m.Id))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryArchive struct {
+	batches  [][]*ArchivedMessage
+	failures int
+	mu       sync.Mutex
+}
+
+func (a *memoryArchive) Archive(ctx context.Context, batch []*ArchivedMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.failures > 0 {
+		a.failures--
+		return errors.New("unavailable")
+	}
+
+	a.batches = append(a.batches, batch)
+
+	return nil
+}
+
+func (a *memoryArchive) messages() []*ArchivedMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var ms []*ArchivedMessage
+	for _, b := range a.batches {
+		ms = append(ms, b...)
+	}
+
+	return ms
+}
+
+func TestClientArchive(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	archive := &memoryArchive{failures: 1}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, Archive(archive, ArchiveConfig{BatchSize: 2, Outbound: true}))
+	require.Nil(t, err)
+
+	err = c.Send(&msgproto.Message{Id: "out", Recipient: "test:1", Ciphertext: []byte("hello")})
+	require.Nil(t, err)
+
+	// rejected messages are not archived
+	err = c.Send(&msgproto.Message{Id: "rejected", Recipient: "error", Ciphertext: []byte("hello")})
+	require.NotNil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "in", Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+
+	_, err = c.Receive()
+	require.Nil(t, err)
+
+	c.Close()
+
+	ms := archive.messages()
+	require.Len(t, ms, 2)
+	assert.Equal(t, "out", ms[0].ID)
+	assert.Equal(t, AuditSent, ms[0].Direction)
+	assert.Equal(t, "in", ms[1].ID)
+	assert.Equal(t, AuditReceived, ms[1].Direction)
+	assert.Equal(t, []byte("hi"), ms[1].Payload)
+}
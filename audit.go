@@ -0,0 +1,147 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// AuditSent is the direction of records for sent messages
+	AuditSent = "sent"
+	// AuditReceived is the direction of records for received messages
+	AuditReceived = "received"
+)
+
+// AuditRecord is the metadata of a sent or received message. It never
+// contains the message's payload
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	// Type is the typ claim of the payload, if it could be read
+	Type string `json:"type,omitempty"`
+	// Outcome is one of acknowledged, rejected, failed or received
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditSink records audit records
+type AuditSink interface {
+	Record(r *AuditRecord) error
+}
+
+// WriterAuditSink writes audit records to a writer as JSON lines. It can
+// be used with a file or a syslog writer
+type WriterAuditSink struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewWriterAuditSink creates a sink that writes to w
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// Record writes a record as a line of JSON
+func (s *WriterAuditSink) Record(r *AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(data, '\n'))
+
+	return err
+}
+
+// HTTPAuditSink posts each audit record as JSON to a URL
+type HTTPAuditSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPAuditSink creates a sink that posts records to url
+func NewHTTPAuditSink(url string) *HTTPAuditSink {
+	return &HTTPAuditSink{URL: url, Client: &http.Client{Timeout: DefaultTimeout}}
+}
+
+// Record posts a record
+func (s *HTTPAuditSink) Record(r *AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit sink responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// auditor passes records through its redactors to its sink
+type auditor struct {
+	sink    AuditSink
+	redacts []func(*AuditRecord)
+}
+
+// audit records a sent or received message. err is the outcome of sending it
+func (c *Client) audit(direction string, m *msgproto.Message, err error) {
+	if c.auditor == nil {
+		return
+	}
+
+	r := &AuditRecord{
+		Time:      c.clock.Now(),
+		Direction: direction,
+		ID:        m.Id,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+	}
+
+	if env, perr := c.envelope(m); perr == nil {
+		r.Type = env.typ
+	}
+
+	switch {
+	case direction == AuditReceived:
+		r.Outcome = "received"
+	case err == nil:
+		r.Outcome = "acknowledged"
+	default:
+		r.Outcome = "failed"
+		if isServerError(err) {
+			r.Outcome = "rejected"
+		}
+		r.Error = err.Error()
+	}
+
+	for _, redact := range c.auditor.redacts {
+		redact(r)
+	}
+
+	err = c.auditor.sink.Record(r)
+	if err != nil {
+		c.report(fmt.Errorf("failed to record audit record: %w", err))
+	}
+}
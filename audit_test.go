@@ -0,0 +1,102 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) records(t *testing.T) []AuditRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []AuditRecord
+
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var r AuditRecord
+		require.Nil(t, json.Unmarshal([]byte(line), &r))
+		records = append(records, r)
+	}
+
+	return records
+}
+
+func TestClientAudit(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	var buf syncBuffer
+
+	redact := func(r *AuditRecord) {
+		r.Recipient = "redacted"
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, Audit(NewWriterAuditSink(&buf), redact))
+	require.Nil(t, err)
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	payload := []byte(`{"payload": "eyJ0eXAiOiJ0ZXN0In0"}`)
+
+	err = c.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "other:1", Ciphertext: payload})
+	require.Nil(t, err)
+
+	err = c.Send(&msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "error", Ciphertext: payload})
+	require.NotNil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "other:1", Recipient: "someID:1", Ciphertext: payload}
+
+	_, err = c.Receive()
+	require.Nil(t, err)
+
+	var records []AuditRecord
+
+	assert.Eventually(t, func() bool {
+		records = buf.records(t)
+		return len(records) == 3
+	}, time.Second, time.Millisecond*10)
+
+	assert.Equal(t, AuditSent, records[0].Direction)
+	assert.Equal(t, "1", records[0].ID)
+	assert.Equal(t, "test", records[0].Type)
+	assert.Equal(t, "acknowledged", records[0].Outcome)
+	assert.Equal(t, "redacted", records[0].Recipient)
+
+	assert.Equal(t, "rejected", records[1].Outcome)
+	assert.Equal(t, "recipient rejected", records[1].Error)
+
+	assert.Equal(t, AuditReceived, records[2].Direction)
+	assert.Equal(t, "2", records[2].ID)
+	assert.Equal(t, "other:1", records[2].Sender)
+	assert.Equal(t, "received", records[2].Outcome)
+
+	assert.NotContains(t, buf.buf.String(), "eyJ0eXAiOiJ0ZXN0In0")
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// TypeAuthenticationRequest is the typ claim of an authentication request
+	TypeAuthenticationRequest = "identities.authenticate.req"
+	// TypeAuthenticationResponse is the typ claim of an authentication response
+	TypeAuthenticationResponse = "identities.authenticate.resp"
+	// DefaultAuthenticationExpiry is how long an authentication request is valid for
+	DefaultAuthenticationExpiry = time.Minute * 5
+)
+
+// AuthenticationResponse is the verified response to an authentication request
+type AuthenticationResponse struct {
+	Claims   *Claims
+	Status   string
+	Accepted bool
+}
+
+// PendingAuthentication is an authentication request whose payload is
+// delivered out of band, such as in a QR code or deep link
+type PendingAuthentication struct {
+	// Payload is the signed request to deliver to the user
+	Payload        []byte
+	ConversationID string
+	subject        string
+	client         *Client
+	responses      chan *msgproto.Message
+}
+
+// Authenticate sends an authentication request to recipient, formatted as
+// selfID:deviceID, and waits for the user to accept or reject it
+func (c *Client) Authenticate(ctx context.Context, recipient string) (*AuthenticationResponse, error) {
+	if recipient == "" {
+		return nil, errors.New("authentication request has no recipient")
+	}
+
+	p, err := c.newAuthentication(selfIDOf(recipient))
+	if err != nil {
+		return nil, err
+	}
+	defer p.Cancel()
+
+	err = c.Send(c.RequestMessage(recipient, p.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Wait(ctx)
+}
+
+// AuthenticationRequest creates a signed authentication request that is not
+// addressed to anyone, for delivery by QR code or deep link. The response
+// is routed to the returned request, which must be waited on or cancelled
+func (c *Client) AuthenticationRequest() (*PendingAuthentication, error) {
+	return c.newAuthentication("")
+}
+
+func (c *Client) newAuthentication(subject string) (*PendingAuthentication, error) {
+	cid, payload, err := c.SignRequest(TypeAuthenticationRequest, subject, DefaultAuthenticationExpiry, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PendingAuthentication{
+		Payload:        payload,
+		ConversationID: cid,
+		subject:        subject,
+		client:         c,
+		responses:      c.requests.registerJWS(cid, 1),
+	}, nil
+}
+
+// Wait waits for the response to the authentication request. The signature,
+// type and conversation of the response are verified, and if the request was
+// addressed to a user the response must be issued by them
+func (p *PendingAuthentication) Wait(ctx context.Context) (*AuthenticationResponse, error) {
+	defer p.Cancel()
+
+	var m *msgproto.Message
+
+	select {
+	case m = <-p.responses:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+
+	claims, err := p.client.verifyResponse(m, TypeAuthenticationResponse, p.ConversationID, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.subject != "" && claims.Issuer != p.subject {
+		return nil, errors.New("response was not issued by the authenticating user")
+	}
+
+	return &AuthenticationResponse{
+		Claims:   claims,
+		Status:   body.Status,
+		Accepted: body.Status == "accepted",
+	}, nil
+}
+
+// Cancel stops waiting for a response to the request
+func (p *PendingAuthentication) Cancel() {
+	p.client.requests.cancelJWS(p.ConversationID)
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAuthResponse(issuer, cid, status string) *msgproto.Message {
+	return &msgproto.Message{
+		Type:      msgproto.MsgType_MSG,
+		Sender:    issuer + ":1",
+		Recipient: "someID:1",
+		Ciphertext: testSignedPayload(privkey, map[string]interface{}{
+			"jti":    "r1",
+			"typ":    TypeAuthenticationResponse,
+			"iss":    issuer,
+			"cid":    cid,
+			"status": status,
+		}),
+	}
+}
+
+func TestClientAuthenticate(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		issuer   string
+		status   string
+		accepted bool
+		err      bool
+	}{
+		{"user", "accepted", true, false},
+		{"user", "rejected", false, false},
+		{"imposter", "accepted", false, true},
+	} {
+		go func(issuer, status string) {
+			m := <-s.in
+
+			env, err := parseEnvelope(m.Ciphertext)
+			require.Nil(t, err)
+			assert.Equal(t, TypeAuthenticationRequest, env.typ)
+
+			s.out <- testAuthResponse(issuer, env.conversationID, status)
+		}(tc.issuer, tc.status)
+
+		resp, err := c.Authenticate(ctx, "user:1")
+		if tc.err {
+			assert.NotNil(t, err)
+			continue
+		}
+
+		require.Nil(t, err)
+		assert.Equal(t, tc.accepted, resp.Accepted)
+		assert.Equal(t, tc.status, resp.Status)
+	}
+
+	assert.Equal(t, 0, c.Stats().PendingRequests)
+}
+
+func TestClientAuthenticationRequest(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	p, err := c.AuthenticationRequest()
+	require.Nil(t, err)
+
+	env, err := parseEnvelope(p.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, p.ConversationID, env.conversationID)
+
+	s.out <- testAuthResponse("anyone", p.ConversationID, "accepted")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := p.Wait(ctx)
+	require.Nil(t, err)
+	assert.True(t, resp.Accepted)
+	assert.Equal(t, "anyone", resp.Claims.Issuer)
+}
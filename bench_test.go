@@ -0,0 +1,111 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+func benchmarkMessage() *msgproto.Message {
+	return &msgproto.Message{
+		Id:         "6f1d2a4e-6d3b-4c1e-9a57-1f0b2f3c4d5e",
+		Type:       msgproto.MsgType_MSG,
+		Sender:     "someID:1",
+		Recipient:  "other:1",
+		Ciphertext: make([]byte, 512),
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	m := benchmarkMessage()
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := proto.Marshal(m)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err := marshal(m)
+			if err != nil {
+				b.Fatal(err)
+			}
+			releaseMarshalBuffer(buf)
+		}
+	})
+}
+
+func BenchmarkHandleFrame(b *testing.B) {
+	c := &Client{
+		counters: &counters{},
+		requests: newRequestCache(),
+		clock:    systemClock{},
+	}
+
+	data, err := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: "1"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var hdr msgproto.Header
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.handleFrame(nil, &hdr, data)
+	}
+}
+
+// BenchmarkClientSend measures throughput through the test server with
+// concurrent senders. The msgs/s metric should comfortably exceed 10k
+func BenchmarkClientSend(b *testing.B) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, SendBuffer(1024))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	var n int64
+
+	b.ReportAllocs()
+	b.SetParallelism(16)
+	b.ResetTimer()
+
+	start := time.Now()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m := benchmarkMessage()
+			m.Id = strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+
+			err := c.Send(m)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package bridge forwards verified messages received by a messaging client
+// to an HTTP endpoint, so services that do not speak the messaging protocol
+// can consume them. Each message is POSTed as a JSON envelope signed with
+// an HMAC of the request body
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the timestamp
+	// header, a period and the request body, prefixed with "sha256="
+	SignatureHeader = "X-Self-Signature"
+	// TimestampHeader carries the unix time the request was signed at
+	TimestampHeader = "X-Self-Timestamp"
+
+	// DefaultRetries is the number of times a delivery is retried
+	DefaultRetries = 5
+	// DefaultBackoff is the delay before the first retry, doubling for each retry after
+	DefaultBackoff = time.Second
+)
+
+// Envelope is the JSON body posted for each message
+type Envelope struct {
+	ID             string          `json:"id"`
+	Sender         string          `json:"sender"`
+	Recipient      string          `json:"recipient"`
+	Offset         int64           `json:"offset"`
+	Type           string          `json:"type,omitempty"`
+	Issuer         string          `json:"iss"`
+	ConversationID string          `json:"cid,omitempty"`
+	IssuedAt       time.Time       `json:"iat,omitempty"`
+	ExpiresAt      time.Time       `json:"exp,omitempty"`
+	ReceivedAt     time.Time       `json:"received_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// Bridge posts messages received by a client to a webhook
+type Bridge struct {
+	// Retries is the number of times a failed delivery is retried. Deliveries
+	// rejected with a 4xx status are not retried
+	Retries int
+	// Backoff is the delay before the first retry
+	Backoff time.Duration
+	// HTTPClient is used to post messages
+	HTTPClient *http.Client
+	// OnError is called with messages that could not be verified or delivered
+	OnError func(m *msgproto.Message, err error)
+
+	client *messaging.Client
+	url    string
+	secret []byte
+}
+
+// New creates a bridge posting the messages received by client to url,
+// signed with secret. The client must be configured with a source of public keys
+func New(client *messaging.Client, url string, secret []byte) *Bridge {
+	return &Bridge{
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+		HTTPClient: http.DefaultClient,
+		client:     client,
+		url:        url,
+		secret:     secret,
+	}
+}
+
+// Run forwards received messages until the context is cancelled. Messages
+// are forwarded one at a time, in the order they are received
+func (b *Bridge) Run(ctx context.Context) error {
+	for {
+		select {
+		case m, ok := <-b.client.ReceiveChan():
+			if !ok {
+				return nil
+			}
+
+			err := b.Forward(ctx, m)
+			if err != nil && b.OnError != nil {
+				b.OnError(m, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Forward verifies a message and posts it to the webhook
+func (b *Bridge) Forward(ctx context.Context, m *msgproto.Message) error {
+	var payload json.RawMessage
+
+	claims, err := b.client.DecodePayload(m, &payload)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(&Envelope{
+		ID:             m.Id,
+		Sender:         m.Sender,
+		Recipient:      m.Recipient,
+		Offset:         m.Offset,
+		Type:           claims.Type,
+		Issuer:         claims.Issuer,
+		ConversationID: claims.ConversationID,
+		IssuedAt:       claims.IssuedAt,
+		ExpiresAt:      claims.ExpiresAt,
+		ReceivedAt:     time.Now().UTC(),
+		Payload:        payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	backoff := b.Backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := b.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= b.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// post sends a signed body to the webhook, returning whether a failure can be retried
+func (b *Bridge) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(b.secret, ts, body))
+
+	resp, err := b.HTTPClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Sign returns the hex encoded signature of a request body sent at the given timestamp
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a request received by a webhook
+// against its body, rejecting requests signed more than maxAge ago
+func Verify(secret []byte, header http.Header, body []byte, maxAge time.Duration) error {
+	ts := header.Get(TimestampHeader)
+
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	if maxAge > 0 && time.Since(time.Unix(secs, 0)) > maxAge {
+		return errors.New("request is too old")
+	}
+
+	expected := "sha256=" + Sign(secret, ts, body)
+
+	if !hmac.Equal([]byte(expected), []byte(header.Get(SignatureHeader))) {
+		return errors.New("signature is invalid")
+	}
+
+	return nil
+}
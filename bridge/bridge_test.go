@@ -0,0 +1,236 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package bridge
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// relay is a test server that forwards messages to the connection of their recipient
+type relay struct {
+	s     *httptest.Server
+	conns map[string]*websocket.Conn
+	mu    sync.Mutex
+}
+
+func newRelay() *relay {
+	r := &relay{conns: make(map[string]*websocket.Conn)}
+	r.s = httptest.NewServer(http.HandlerFunc(r.handler))
+	return r
+}
+
+func (r *relay) endpoint() string {
+	return "ws" + strings.TrimPrefix(r.s.URL, "http")
+}
+
+func (r *relay) write(wc *websocket.Conn, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (r *relay) ack(wc *websocket.Conn, id string) {
+	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: id})
+	r.write(wc, data)
+}
+
+func (r *relay) handler(w http.ResponseWriter, req *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	_, data, err := wc.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var auth msgproto.Auth
+
+	err = proto.Unmarshal(data, &auth)
+	if err != nil {
+		return
+	}
+
+	token, err := jose.ParseSigned(auth.Token)
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	json.Unmarshal(token.UnsafePayloadWithoutVerification(), &claims)
+
+	r.mu.Lock()
+	r.conns[claims.Issuer] = wc
+	r.mu.Unlock()
+
+	r.ack(wc, auth.Id)
+
+	for {
+		_, data, err := wc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return
+		}
+
+		r.ack(wc, m.Id)
+
+		if m.Type != msgproto.MsgType_MSG {
+			continue
+		}
+
+		r.mu.Lock()
+		rc, ok := r.conns[strings.Split(m.Recipient, ":")[0]]
+		r.mu.Unlock()
+
+		if ok {
+			r.write(rc, data)
+		}
+	}
+}
+
+func testKey() (string, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(priv.Seed()), pub
+}
+
+func TestBridge(t *testing.T) {
+	r := newRelay()
+	defer r.s.Close()
+
+	alicekey, alicepub := testKey()
+	bobkey, _ := testKey()
+
+	keys := messaging.PublicKeys(func(selfID string) ([]crypto.PublicKey, error) {
+		if selfID == "alice" {
+			return []crypto.PublicKey{alicepub}, nil
+		}
+		return nil, errors.New("unknown identity")
+	})
+
+	alice, err := messaging.New(r.endpoint(), "alice", "1", alicekey)
+	require.Nil(t, err)
+	defer alice.Close()
+
+	bob, err := messaging.New(r.endpoint(), "bob", "1", bobkey, keys)
+	require.Nil(t, err)
+	defer bob.Close()
+
+	secret := []byte("secret")
+	envelopes := make(chan *Envelope, 1)
+
+	var attempts int32
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+
+		err := Verify(secret, req.Header, body, time.Minute)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var env Envelope
+		json.Unmarshal(body, &env)
+		envelopes <- &env
+	}))
+	defer hook.Close()
+
+	failed := make(chan error, 1)
+
+	b := New(bob, hook.URL, secret)
+	b.Backoff = time.Millisecond
+	b.OnError = func(m *msgproto.Message, err error) { failed <- err }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	go b.Run(ctx)
+
+	payload, err := alice.Sign(map[string]interface{}{"typ": "greeting", "iss": "alice", "text": "hello"})
+	require.Nil(t, err)
+
+	err = alice.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "bob:1", Ciphertext: payload})
+	require.Nil(t, err)
+
+	select {
+	case env := <-envelopes:
+		assert.Equal(t, "1", env.ID)
+		assert.Equal(t, "alice:1", env.Sender)
+		assert.Equal(t, "greeting", env.Type)
+		assert.Equal(t, "alice", env.Issuer)
+		assert.Contains(t, string(env.Payload), `"text":"hello"`)
+	case <-ctx.Done():
+		t.Fatal("message was not forwarded")
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+
+	// unsigned messages are not forwarded
+	err = alice.Send(&msgproto.Message{Id: "2", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "bob:1", Ciphertext: []byte("unsigned")})
+	require.Nil(t, err)
+
+	select {
+	case err := <-failed:
+		assert.NotNil(t, err)
+	case <-ctx.Done():
+		t.Fatal("unsigned message was not rejected")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"1"}`)
+	ts := "1600000000"
+
+	header := http.Header{}
+	header.Set(TimestampHeader, ts)
+	header.Set(SignatureHeader, "sha256="+Sign(secret, ts, body))
+
+	assert.Nil(t, Verify(secret, header, body, 0))
+	assert.NotNil(t, Verify(secret, header, body, time.Minute))
+	assert.NotNil(t, Verify([]byte("other"), header, body, 0))
+	assert.NotNil(t, Verify(secret, header, []byte(`{"id":"2"}`), 0))
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// reclaimInterval is how often a wait for room in the budget checks for
+// buffered messages that have been taken by the application
+const reclaimInterval = time.Millisecond * 10
+
+// ErrMemoryBudgetExceeded is returned when a message can't be buffered without exceeding the MaxMemory budget
+var ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+
+// budget bounds the bytes buffered by the client. A nil budget is unlimited.
+// Received messages stay in the budget while they are buffered in a channel
+// for the application. The application can take them from the channel
+// directly, so their bytes are reclaimed by comparing the channel's depth
+// to the messages put in it when more room is needed
+type budget struct {
+	limit  int64
+	used   int64
+	freed  chan struct{}
+	queued map[chan *msgproto.Message][]int64 // bytes put in each channel, oldest first
+	mu     sync.Mutex
+}
+
+func newBudget(limit int64) *budget {
+	return &budget{limit: limit, freed: make(chan struct{}), queued: make(map[chan *msgproto.Message][]int64)}
+}
+
+// acquire reserves n bytes, waiting until the timeout or cancel channel
+// fires for other buffers to be released. If both are nil it does not wait
+func (b *budget) acquire(n int64, timeout <-chan time.Time, cancel <-chan struct{}) error {
+	if b == nil {
+		return nil
+	}
+
+	if n > b.limit {
+		return ErrMemoryBudgetExceeded
+	}
+
+	for {
+		b.mu.Lock()
+
+		if b.used+n > b.limit {
+			b.reclaim()
+		}
+
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+
+		freed := b.freed
+
+		var reclaim <-chan time.Time
+		if len(b.queued) > 0 {
+			reclaim = time.After(reclaimInterval)
+		}
+
+		b.mu.Unlock()
+
+		if timeout == nil && cancel == nil {
+			return ErrMemoryBudgetExceeded
+		}
+
+		select {
+		case <-freed:
+		case <-reclaim:
+		case <-timeout:
+			return ErrMemoryBudgetExceeded
+		case <-cancel:
+			return ErrMemoryBudgetExceeded
+		}
+	}
+}
+
+// release returns n bytes to the budget and wakes anything waiting for them
+func (b *budget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.free(n)
+	b.mu.Unlock()
+}
+
+// free returns n bytes with the lock held
+func (b *budget) free(n int64) {
+	b.used -= n
+	b.wake()
+}
+
+// wake wakes anything waiting for room, with the lock held
+func (b *budget) wake() {
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// hold keeps the n bytes of a message that has been put in ch in the
+// budget until it is taken from the channel
+func (b *budget) hold(ch chan *msgproto.Message, n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.queued[ch] = append(b.queued[ch], n)
+	// so waiters start checking whether it has been taken
+	b.wake()
+	b.mu.Unlock()
+}
+
+// drop releases the bytes held for a channel that is no longer read from
+func (b *budget) drop(ch chan *msgproto.Message) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sizes, ok := b.queued[ch]
+	if !ok {
+		return
+	}
+
+	delete(b.queued, ch)
+
+	var n int64
+	for _, size := range sizes {
+		n += size
+	}
+
+	b.free(n)
+}
+
+// reclaim releases the bytes of messages that have been taken from the
+// channels holding them, with the lock held. A message is held after it is
+// put in its channel, so a channel never appears to have had more taken
+// from it than it has
+func (b *budget) reclaim() {
+	var n int64
+
+	for ch, sizes := range b.queued {
+		taken := len(sizes) - len(ch)
+		if taken <= 0 {
+			continue
+		}
+
+		for _, size := range sizes[:taken] {
+			n += size
+		}
+
+		if taken == len(sizes) {
+			delete(b.queued, ch)
+		} else {
+			b.queued[ch] = sizes[taken:]
+		}
+	}
+
+	if n > 0 {
+		b.free(n)
+	}
+}
+
+// inUse returns the number of bytes currently reserved
+func (b *budget) inUse() int64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reclaim()
+
+	return b.used
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	b := newBudget(100)
+
+	assert.Equal(t, ErrMemoryBudgetExceeded, b.acquire(101, nil, nil))
+	require.Nil(t, b.acquire(60, nil, nil))
+	assert.Equal(t, ErrMemoryBudgetExceeded, b.acquire(60, nil, nil))
+	assert.Equal(t, ErrMemoryBudgetExceeded, b.acquire(60, time.After(10*time.Millisecond), nil))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.release(60)
+	}()
+
+	require.Nil(t, b.acquire(60, time.After(time.Second), nil))
+	assert.Equal(t, int64(60), b.inUse())
+}
+
+func TestClientMaxMemory(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	_, err := New(s.endpoint, "someID", "1", privkey, MaxMemory(0))
+	assert.NotNil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, MaxMemory(1024))
+	require.Nil(t, err)
+
+	err = c.Send(&msgproto.Message{Id: "big", Recipient: "test:1", Ciphertext: make([]byte, 2048)})
+	assert.Equal(t, ErrMemoryBudgetExceeded, err)
+
+	err = c.Send(&msgproto.Message{Id: "small", Recipient: "test:1", Ciphertext: make([]byte, 512)})
+	require.Nil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "in", Sender: "test:1", Recipient: "someID:1", Ciphertext: make([]byte, 512)}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "in", m.Id)
+	assert.Eventually(t, func() bool { return c.memory.inUse() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestBudgetHold(t *testing.T) {
+	b := newBudget(100)
+	ch := make(chan *msgproto.Message, 2)
+
+	for range []int{0, 1} {
+		require.Nil(t, b.acquire(40, nil, nil))
+		ch <- &msgproto.Message{}
+		b.hold(ch, 40)
+	}
+
+	// buffered messages stay in the budget until they are taken
+	assert.Equal(t, ErrMemoryBudgetExceeded, b.acquire(40, nil, nil))
+
+	<-ch
+	require.Nil(t, b.acquire(40, nil, nil))
+	assert.Equal(t, int64(80), b.inUse())
+
+	b.drop(ch)
+	assert.Equal(t, int64(40), b.inUse())
+}
+
+func TestClientMaxMemoryReceiveQueue(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, MaxMemory(1024))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "test:1", Recipient: "someID:1", Ciphertext: make([]byte, 512)}
+	}
+
+	for i := 0; c.ReceiveQueueDepth() < 2; i++ {
+		require.Less(t, i, 100, "messages were not received")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// messages waiting in the receive queue are counted against the budget
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 2, c.ReceiveQueueDepth())
+
+	m := <-c.ReceiveChan()
+	assert.Equal(t, "1", m.Id)
+
+	for i := 0; c.ReceiveQueueDepth() < 2; i++ {
+		require.Less(t, i, 100, "message was not received once there was room")
+		time.Sleep(time.Millisecond * 10)
+	}
+}
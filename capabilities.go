@@ -0,0 +1,116 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// ProtocolVersion is the version of the messaging protocol spoken by the client
+	ProtocolVersion = "1"
+
+	// ProtocolVersionHeader carries the protocol version in the websocket handshake
+	ProtocolVersionHeader = "X-Self-Protocol-Version"
+	// CapabilitiesHeader carries a comma separated list of capabilities in the websocket handshake
+	CapabilitiesHeader = "X-Self-Capabilities"
+
+	// CapabilityAcks indicates messages are acknowledged by the server
+	CapabilityAcks = "acks"
+	// CapabilityResume indicates sessions can be resumed after reconnecting
+	CapabilityResume = "resume"
+	// CapabilityCompression indicates frames can be compressed. The client
+	// does not compress frames, so it does not offer it
+	CapabilityCompression = "compression"
+	// CapabilityPriorities indicates the server honours message priorities
+	CapabilityPriorities = "priorities"
+	// CapabilityACLPush indicates the server pushes ACL changes made by the
+	// identity's other devices
+	CapabilityACLPush = "acl_push"
+)
+
+// clientCapabilities are the capabilities offered by the client
+var clientCapabilities = []string{CapabilityAcks, CapabilityResume, CapabilityPriorities, CapabilityACLPush}
+
+// legacyCapabilities are assumed for servers that do not advertise any
+var legacyCapabilities = []string{CapabilityAcks}
+
+// capabilities are the features supported by both the client and the server
+type capabilities struct {
+	version  string
+	features map[string]bool
+	mu       sync.RWMutex
+}
+
+// handshakeHeader returns the headers advertising the client's protocol version and capabilities
+func handshakeHeader() http.Header {
+	h := http.Header{}
+	h.Set(ProtocolVersionHeader, ProtocolVersion)
+	h.Set(CapabilitiesHeader, strings.Join(clientCapabilities, ","))
+	return h
+}
+
+// negotiate records the capabilities advertised in the server's handshake response
+// that are also supported by the client
+func (cp *capabilities) negotiate(resp *http.Response) {
+	version := ""
+	offered := legacyCapabilities
+
+	if resp != nil && resp.Header.Get(CapabilitiesHeader) != "" {
+		version = resp.Header.Get(ProtocolVersionHeader)
+		offered = strings.Split(resp.Header.Get(CapabilitiesHeader), ",")
+	}
+
+	features := make(map[string]bool)
+
+	for _, f := range offered {
+		f = strings.ToLower(strings.TrimSpace(f))
+
+		for _, cf := range clientCapabilities {
+			if f == cf {
+				features[f] = true
+			}
+		}
+	}
+
+	cp.mu.Lock()
+	cp.version = version
+	cp.features = features
+	cp.mu.Unlock()
+}
+
+// ServerProtocolVersion returns the protocol version advertised by the server
+// when the client last connected, or an empty string if it did not advertise one
+func (c *Client) ServerProtocolVersion() string {
+	c.capabilities.mu.RLock()
+	defer c.capabilities.mu.RUnlock()
+
+	return c.capabilities.version
+}
+
+// Capabilities returns the features supported by both the client and the server
+func (c *Client) Capabilities() []string {
+	c.capabilities.mu.RLock()
+	defer c.capabilities.mu.RUnlock()
+
+	var features []string
+
+	for _, f := range clientCapabilities {
+		if c.capabilities.features[f] {
+			features = append(features, f)
+		}
+	}
+
+	return features
+}
+
+// Supports reports whether a feature is supported by both the client and the
+// server it is connected to. Optional features are only used when supported
+func (c *Client) Supports(capability string) bool {
+	c.capabilities.mu.RLock()
+	defer c.capabilities.mu.RUnlock()
+
+	return c.capabilities.features[capability]
+}
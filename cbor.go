@@ -0,0 +1,335 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// cborSelfDescribe is the tag that marks data as CBOR (RFC 8949 section 3.4.6)
+var cborSelfDescribe = []byte{0xd9, 0xd9, 0xf7}
+
+// maxCBORDepth limits the nesting of decoded arrays and maps
+const maxCBORDepth = 64
+
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+)
+
+// isCBOR reports whether data starts with the CBOR self-describe tag
+func isCBOR(data []byte) bool {
+	return bytes.HasPrefix(data, cborSelfDescribe)
+}
+
+// encodeCBOR encodes a value decoded from JSON with UseNumber
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if val {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			if i < 0 {
+				writeCBORHead(buf, cborNegative, uint64(-1-i))
+			} else {
+				writeCBORHead(buf, cborUnsigned, uint64(i))
+			}
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(val), 10, 64); err == nil {
+			writeCBORHead(buf, cborUnsigned, u)
+			return nil
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return err
+		}
+		writeCBORFloat(buf, f)
+	case float64:
+		writeCBORFloat(buf, val)
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(val)))
+		buf.WriteString(val)
+	case []byte:
+		writeCBORHead(buf, cborBytes, uint64(len(val)))
+		buf.Write(val)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(val)))
+		for _, item := range val {
+			err := encodeCBOR(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// keys are sorted so encoding is deterministic
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeCBORHead(buf, cborMap, uint64(len(val)))
+		for _, k := range keys {
+			writeCBORHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+
+			err := encodeCBOR(buf, val[k])
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T as cbor", v)
+	}
+
+	return nil
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(m | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(m | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeCBORFloat writes a float in the shortest form that keeps its value
+func writeCBORFloat(buf *bytes.Buffer, f float64) {
+	if float64(float32(f)) == f || math.IsNaN(f) {
+		buf.WriteByte(0xfa)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(float32(f)))
+		return
+	}
+
+	buf.WriteByte(0xfb)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+// cborDecoder decodes CBOR into the values encoding/json decodes to, with
+// numbers as json.Number and byte strings as []byte
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(d.data) {
+		return nil, errors.New("cbor: unexpected data after value")
+	}
+
+	return v, nil
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errors.New("cbor: unexpected end of data")
+	}
+
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+
+	return b, nil
+}
+
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+
+	var n uint64
+
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		b, err = d.next(1)
+		if err == nil {
+			n = uint64(b[0])
+		}
+	case info == 25:
+		b, err = d.next(2)
+		if err == nil {
+			n = uint64(binary.BigEndian.Uint16(b))
+		}
+	case info == 26:
+		b, err = d.next(4)
+		if err == nil {
+			n = uint64(binary.BigEndian.Uint32(b))
+		}
+	case info == 27:
+		b, err = d.next(8)
+		if err == nil {
+			n = binary.BigEndian.Uint64(b)
+		}
+	default:
+		return 0, 0, 0, errors.New("cbor: indefinite lengths are not supported")
+	}
+
+	return major, info, n, err
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: value is nested too deeply")
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegative:
+		if n <= math.MaxInt64 {
+			return json.Number(strconv.FormatInt(-1-int64(n), 10)), nil
+		}
+		v := new(big.Int).SetUint64(n)
+		return json.Number(v.Neg(v.Add(v, big.NewInt(1))).String()), nil
+	case cborBytes:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case cborText:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("cbor: unexpected end of data")
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("cbor: unexpected end of data")
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("cbor: map keys must be text strings")
+			}
+			m[key], err = d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cborTag:
+		// tags, such as the self-describe tag, are ignored
+		return d.value(depth + 1)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return floatNumber(halfFloat(uint16(n)))
+	case 26:
+		return floatNumber(float64(math.Float32frombits(uint32(n))))
+	case 27:
+		return floatNumber(math.Float64frombits(n))
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func floatNumber(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("cbor: non-finite floats cannot be decoded")
+	}
+
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// halfFloat converts an IEEE 754 half precision float
+func halfFloat(h uint16) float64 {
+	exp := (h >> 10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, int(exp)-25)
+	}
+
+	if h&0x8000 != 0 {
+		f = -f
+	}
+
+	return f
+}
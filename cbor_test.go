@@ -0,0 +1,124 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// examples from RFC 8949 appendix A
+	cases := map[string]string{
+		"00":                 `0`,
+		"17":                 `23`,
+		"1818":               `24`,
+		"1903e8":             `1000`,
+		"1bffffffffffffffff": `18446744073709551615`,
+		"3863":               `-100`,
+		"3bffffffffffffffff": `-18446744073709551616`,
+		"f93c00":             `1`,
+		"f9c400":             `-4`,
+		"fb3ff199999999999a": `1.1`,
+		"fa47c35000":         `100000`,
+		"f4":                 `false`,
+		"f5":                 `true`,
+		"f6":                 `null`,
+		"6161":               `"a"`,
+		"4401020304":         `"AQIDBA=="`,
+		"83010203":           `[1,2,3]`,
+		"a26161016162820203": `{"a":1,"b":[2,3]}`,
+		"d9d9f7a1616101":     `{"a":1}`,
+		"c11a514b67b0":       `1363896240`,
+		"62c3bc":             `"ü"`,
+		"8301820203820405":   `[1,[2,3],[4,5]]`,
+		"a56161614161626142616361436164614461656145": `{"a":"A","b":"B","c":"C","d":"D","e":"E"}`,
+	}
+
+	for in, want := range cases {
+		data, err := hex.DecodeString(in)
+		require.Nil(t, err)
+
+		js, err := cborCodec{}.toJSON(data)
+		require.Nil(t, err, in)
+		assert.JSONEq(t, want, string(js), in)
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	cases := []string{
+		"",           // no value
+		"19",         // truncated head
+		"6461",       // truncated string
+		"9f01ff",     // indefinite array
+		"a10101",     // integer map key
+		"0000",       // trailing data
+		"f97e00",     // NaN
+		"9bffffffff", // huge array length
+	}
+
+	for _, in := range cases {
+		data, err := hex.DecodeString(in)
+		require.Nil(t, err)
+
+		_, err = decodeCBOR(data)
+		assert.NotNil(t, err, in)
+	}
+
+	deep := append(bytes.Repeat([]byte{0x81}, maxCBORDepth+2), 0x00)
+	_, err := decodeCBOR(deep)
+	assert.NotNil(t, err)
+}
+
+func TestCBORCodec(t *testing.T) {
+	type reading struct {
+		Sensor      string    `json:"sensor"`
+		Temperature float64   `json:"temperature"`
+		Humidity    int       `json:"humidity"`
+		Samples     []float64 `json:"samples"`
+		Raw         []byte    `json:"raw"`
+		Offline     bool      `json:"offline"`
+		Missing     *string   `json:"missing"`
+	}
+
+	in := reading{
+		Sensor:      "greenhouse-4",
+		Temperature: 21.5,
+		Humidity:    -3,
+		Samples:     []float64{1, 2.25, 1e10, 3.141592653589793},
+		Raw:         []byte{1, 2, 3},
+	}
+
+	data, err := CBORCodec.Marshal(in)
+	require.Nil(t, err)
+	assert.True(t, isCBOR(data))
+	codec, err := CodecOf(data, "")
+	require.Nil(t, err)
+	assert.Equal(t, CBORCodec, codec)
+
+	js, err := JSONCodec.Marshal(in)
+	require.Nil(t, err)
+	codec, err = CodecOf(js, "")
+	require.Nil(t, err)
+	assert.Equal(t, JSONCodec, codec)
+	assert.True(t, len(data) < len(js))
+
+	var out reading
+
+	require.Nil(t, CBORCodec.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+
+	// encoding is deterministic
+	again, err := CBORCodec.Marshal(in)
+	require.Nil(t, err)
+	assert.Equal(t, data, again)
+
+	var v map[string]interface{}
+	require.Nil(t, json.Unmarshal(js, &v))
+	assert.Equal(t, "greenhouse-4", v["sensor"])
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Faults configures faults injected into the client's connection, to test
+// how an application copes with an unreliable network. Probabilities are
+// between 0 and 1 and apply to frames in both directions. Faults are only
+// injected once the connection has authenticated
+type Faults struct {
+	// Drop is the probability a frame is lost
+	Drop float64
+	// Duplicate is the probability a frame is delivered twice
+	Duplicate float64
+	// Corrupt is the probability a byte of a frame is changed
+	Corrupt float64
+	// Delay is added before each frame is delivered
+	Delay time.Duration
+	// Disconnect drops each connection after it has been open this long
+	Disconnect time.Duration
+	// Seed seeds the faults, so a run can be reproduced
+	Seed int64
+}
+
+// chaosConn injects faults into the frames of a transport
+type chaosConn struct {
+	transport
+	faults  Faults
+	rand    *rand.Rand
+	pending []byte
+	timer   *time.Timer
+	dropped int32
+	mu      sync.Mutex
+}
+
+func (f Faults) wrap(t transport) transport {
+	cc := &chaosConn{
+		transport: t,
+		faults:    f,
+		rand:      rand.New(rand.NewSource(f.Seed)),
+	}
+
+	if f.Disconnect > 0 {
+		cc.timer = time.AfterFunc(f.Disconnect, func() {
+			atomic.StoreInt32(&cc.dropped, 1)
+			t.Close()
+		})
+	}
+
+	return cc
+}
+
+// roll reports whether a fault with probability p occurs
+func (cc *chaosConn) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.rand.Float64() < p
+}
+
+// corrupt returns data, or a copy of it with a byte changed
+func (cc *chaosConn) corrupt(data []byte) []byte {
+	if len(data) == 0 || !cc.roll(cc.faults.Corrupt) {
+		return data
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[cc.rand.Intn(len(corrupted))] ^= byte(1 + cc.rand.Intn(255))
+
+	return corrupted
+}
+
+// NextReader reads the next frame that is not dropped
+func (cc *chaosConn) NextReader() (int, io.Reader, error) {
+	if cc.pending != nil {
+		data := cc.pending
+		cc.pending = nil
+		return websocket.BinaryMessage, bytes.NewReader(data), nil
+	}
+
+	for {
+		t, r, err := cc.transport.NextReader()
+		if err != nil && atomic.LoadInt32(&cc.dropped) == 1 {
+			// forced disconnects look like the connection was lost
+			return t, r, &websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: "disconnected by fault injection"}
+		}
+		if err != nil || t != websocket.BinaryMessage {
+			return t, r, err
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return t, nil, err
+		}
+
+		if cc.roll(cc.faults.Drop) {
+			continue
+		}
+
+		data = cc.corrupt(data)
+
+		if cc.roll(cc.faults.Duplicate) {
+			cc.pending = data
+		}
+
+		time.Sleep(cc.faults.Delay)
+
+		return t, bytes.NewReader(data), nil
+	}
+}
+
+// ReadMessage reads the next frame that is not dropped
+func (cc *chaosConn) ReadMessage() (int, []byte, error) {
+	t, r, err := cc.NextReader()
+	if err != nil {
+		return t, nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+
+	return t, data, err
+}
+
+// WriteMessage writes a frame, unless it is dropped
+func (cc *chaosConn) WriteMessage(messageType int, data []byte) error {
+	if cc.roll(cc.faults.Drop) {
+		return nil
+	}
+
+	data = cc.corrupt(data)
+
+	time.Sleep(cc.faults.Delay)
+
+	err := cc.transport.WriteMessage(messageType, data)
+	if err != nil || !cc.roll(cc.faults.Duplicate) {
+		return err
+	}
+
+	return cc.transport.WriteMessage(messageType, data)
+}
+
+// Close stops any scheduled disconnect and closes the transport
+func (cc *chaosConn) Close() error {
+	if cc.timer != nil {
+		cc.timer.Stop()
+	}
+
+	return cc.transport.Close()
+}
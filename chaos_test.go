@@ -0,0 +1,95 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryTransport is a transport that records written frames and reads queued ones
+type memoryTransport struct {
+	*frameQueue
+	written [][]byte
+	mu      sync.Mutex
+}
+
+func newMemoryTransport() *memoryTransport {
+	return &memoryTransport{frameQueue: newFrameQueue(time.Now().Add(time.Minute))}
+}
+
+func (mt *memoryTransport) WriteMessage(messageType int, data []byte) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.written = append(mt.written, data)
+	return nil
+}
+
+func (mt *memoryTransport) frames() [][]byte {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return mt.written
+}
+
+func (mt *memoryTransport) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (mt *memoryTransport) Close() error {
+	mt.closeWith(&websocket.CloseError{Code: websocket.CloseAbnormalClosure})
+	return nil
+}
+
+func TestFaults(t *testing.T) {
+	frame := []byte("frame")
+
+	mt := newMemoryTransport()
+	cc := Faults{Drop: 1}.wrap(mt)
+	require.Nil(t, cc.WriteMessage(websocket.BinaryMessage, frame))
+	assert.Len(t, mt.written, 0)
+
+	mt = newMemoryTransport()
+	cc = Faults{Duplicate: 1}.wrap(mt)
+	require.Nil(t, cc.WriteMessage(websocket.BinaryMessage, frame))
+	assert.Equal(t, [][]byte{frame, frame}, mt.written)
+
+	require.Nil(t, mt.push(frame))
+
+	for i := 0; i < 2; i++ {
+		_, data, err := cc.ReadMessage()
+		require.Nil(t, err)
+		assert.Equal(t, frame, data)
+	}
+
+	mt = newMemoryTransport()
+	cc = Faults{Corrupt: 1}.wrap(mt)
+	require.Nil(t, cc.WriteMessage(websocket.BinaryMessage, frame))
+	assert.NotEqual(t, frame, mt.written[0])
+	assert.Equal(t, []byte("frame"), frame)
+
+	mt = newMemoryTransport()
+	cc = Faults{Disconnect: 10 * time.Millisecond}.wrap(mt)
+	_, _, err := cc.ReadMessage()
+	assert.IsType(t, &websocket.CloseError{}, err)
+}
+
+func TestClientInjectFaults(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	_, err := New(s.endpoint, "someID", "1", privkey, InjectFaults(Faults{Drop: 2}))
+	assert.NotNil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InjectFaults(Faults{Disconnect: 50 * time.Millisecond}), AutoReconnect(true), RetryInterval(10*time.Millisecond), OnError(func(error) {}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects >= 2
+	}, time.Second*2, time.Millisecond*10)
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"strings"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// Cipher encrypts messages for, and decrypts messages from, a device of
+// another identity. When a cipher is configured with the WithCipher option
+// it is applied to every message sent and received
+type Cipher interface {
+	Encrypt(recipient, device string, plaintext []byte) ([]byte, error)
+	Decrypt(sender, device string, ciphertext []byte) ([]byte, error)
+}
+
+// splitAddress splits an address in the form selfID:deviceID
+func splitAddress(address string) (string, string) {
+	parts := strings.SplitN(address, ":", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// encrypt returns a copy of the message with its payload encrypted
+func (c *Client) encrypt(m *msgproto.Message) (*msgproto.Message, error) {
+	if c.cipher == nil {
+		return m, nil
+	}
+
+	selfID, device := splitAddress(m.Recipient)
+
+	ciphertext, err := c.cipher.Encrypt(selfID, device, m.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	em := *m
+	em.Ciphertext = ciphertext
+
+	return &em, nil
+}
+
+// decrypt replaces the payload of a received message with its plaintext.
+// Payloads encrypted with a group key are decrypted with the group's key
+func (c *Client) decrypt(m *msgproto.Message) error {
+	plaintext, ok, err := c.openGroup(m.Ciphertext)
+	if ok {
+		if err != nil {
+			return err
+		}
+
+		m.Ciphertext = plaintext
+
+		return nil
+	}
+
+	if c.cipher == nil {
+		return nil
+	}
+
+	selfID, device := splitAddress(m.Sender)
+
+	plaintext, err = c.cipher.Decrypt(selfID, device, m.Ciphertext)
+	if err != nil {
+		return err
+	}
+
+	m.Ciphertext = plaintext
+
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prefixCipher prefixes payloads with the address they are encrypted for
+type prefixCipher struct{}
+
+func (prefixCipher) Encrypt(recipient, device string, plaintext []byte) ([]byte, error) {
+	return append([]byte(recipient+"/"+device+":"), plaintext...), nil
+}
+
+func (prefixCipher) Decrypt(sender, device string, ciphertext []byte) ([]byte, error) {
+	prefix := sender + "/" + device + ":"
+	if len(ciphertext) < len(prefix) || string(ciphertext[:len(prefix)]) != prefix {
+		return nil, errors.New("bad ciphertext")
+	}
+	return ciphertext[len(prefix):], nil
+}
+
+func TestClientCipher(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, WithCipher(prefixCipher{}), OnError(func(error) {}))
+	require.Nil(t, err)
+
+	m := &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "other:2", Ciphertext: []byte("hello")}
+
+	err = c.Send(m)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), m.Ciphertext)
+
+	sent, err := wait(s.in)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("other/2:hello"), sent.Ciphertext)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "other:2", Recipient: "someID:1", Ciphertext: []byte("bad")}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "other:2", Recipient: "someID:1", Ciphertext: []byte("other/2:hi")}
+
+	received, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "2", received.Id)
+	assert.Equal(t, []byte("hi"), received.Ciphertext)
+	assert.Equal(t, int64(1), c.Stats().Dropped)
+}
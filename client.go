@@ -3,11 +3,16 @@
 package messaging
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,95 +21,370 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	msgproto "github.com/selfid-net/self-messaging-client/proto"
-	"golang.org/x/crypto/ed25519"
-	"gopkg.in/square/go-jose.v2"
 )
 
 const (
-	DefaultBufferSize = 128
-	DefaultTimeout    = time.Second * 10
-	DefaultDeadline   = time.Second * 10
-	DefaultRetries    = 30
+	DefaultBufferSize        = 128
+	DefaultControlBufferSize = 16
+	DefaultTimeout           = time.Second * 10
+	DefaultDeadline          = time.Second * 10
+	DefaultRetries           = 30
+	DefaultRetryInterval     = time.Second * 10
 )
 
 var (
 	CloseMessage = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-	TimeFunc     = NewTime().Now
+
+	// ErrMessageTooLarge is returned when a message exceeds the MaxMessageSize limit
+	ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+	// ErrACLExpiryInPast is returned when a sender is permitted until a time that has passed
+	ErrACLExpiryInPast = errors.New("acl expiry is in the past")
+
+	// ErrACLExpiryTooLong is returned when a sender is permitted for longer than MaxACLExpiry allows
+	ErrACLExpiryTooLong = errors.New("acl expiry exceeds the maximum")
 )
 
 type request struct {
-	id       string
-	message  []byte
-	response chan error
+	id        string
+	buf       *proto.Buffer // pooled, released once written
+	size      int64         // bytes reserved from the memory budget
+	isMsg     bool
+	recipient string
+	queuedAt  time.Time
+	writtenAt time.Time
+	deadline  time.Time // zero if the request has no deadline
+	cancelled bool      // guarded by the send queue's lock
+	response  chan error
+}
+
+// connection is the state of a single websocket connection. A new
+// connection is created each time the client connects
+type connection struct {
+	ws          transport
+	done        chan struct{} // closed when the connection is torn down
+	readerDone  chan struct{} // closed when the reader exits
+	closewriter chan []byte
+	closing     int32
+	pipeline    *pipeline
 }
 
 // Client connection for self messaging
 type Client struct {
-	endpoint    string
-	token       string
-	selfID      string
-	deviceID    string
-	privateKey  string
-	reconnect   bool
-	maxretries  int
-	deadline    time.Duration
-	timeout     time.Duration
-	ws          *websocket.Conn
-	send        chan *request
-	recv        chan *msgproto.Message
-	closewriter chan bool
-	requests    *requestCache
-	closed      int32
+	skew              int64 // accessed atomically, must be first for alignment
+	offset            int64 // accessed atomically, offset of the last message received
+	authGeneration    int64 // accessed atomically, incremented when the client reauthenticates
+	counters          *counters
+	endpoint          string
+	resumption        resumption
+	standby           *standby
+	expvarName        string
+	takeOver          bool
+	priority          int
+	interceptors      []OutboundInterceptor
+	transforms        []InboundTransform
+	validators        map[string][]PayloadValidator
+	versions          map[string]*payloadVersion
+	codec             Codec
+	peerCodecs        peerCodecs
+	selfID            string
+	deviceID          string
+	privateKey        string
+	signingKey        *keySigner
+	keyID             string
+	signingKeys       map[string]*keySigner
+	activeKeyID       string
+	idempotency       *idempotency
+	handingOver       chan struct{}
+	handoverOnce      sync.Once
+	consumers         int32
+	latency           *latencyTracker
+	deadlines         *deadlineQueue
+	opts              []func(*Client) error
+	reconnect         bool
+	reconnectReplaced bool
+	maxretries        int
+	retryInterval     time.Duration
+	deadline          time.Duration
+	watchdogPeriod    time.Duration
+	timeout           time.Duration
+	clock             Clock
+	conn              *connection
+	connMu            sync.Mutex
+	send              chan *request
+	control           chan *request // ACL and other requests that are not messages
+	recv              chan *msgproto.Message
+	errors            chan error
+	events            chan Event
+	reconnecting      int32
+	requests          *requestCache
+	aclRules          *aclCache
+	connectACL        []ACLRule
+	restored          [][]byte // queued messages from restored state, sent once connected
+	queued            *sendQueue
+	envelopes         *envelopeCache
+	subscriptions     *subscriptions
+	streams           *streams
+	files             *files
+	jwsBuffer         int
+	publicKeys        PublicKeyFunc
+	directory         Directory
+	replays           *replayCache
+	senderLimits      *senderLimiter
+	senderPolicy      func(sender, device string) bool
+	messageTTL        time.Duration
+	expiryGrace       time.Duration // negative if expired messages are delivered
+	inboundQueues     []*inboundQueue
+	held              *heldMessages
+	skipPolicy        SkipPolicy
+	maxACLExpiry      time.Duration
+	reauthMu          sync.Mutex
+	dialOptions       dialOptions
+	dialer            dialFunc
+	keyMu             sync.RWMutex
+	sendWatermark     *watermark
+	recvWatermark     *watermark
+	auditor           *auditor
+	archiver          *archiver
+	outboxDrainer     *outboxDrainer
+	frameTap          func(*Frame)
+	idGenerator       func() string
+	cipher            Cipher
+	groupKeys         GroupKeyStore
+	deadLetters       DeadLetterQueue
+	onError           func(error)
+	outbound          MessageStore
+	inbound           MessageStore
+	consumerRetries   int
+	consumerBackoff   time.Duration
+	inboundWorkers    int
+	memory            *budget
+	maxOutbound       int64
+	recipients        *recipientLimiter
+	maxInbound        int64
+	capabilities      capabilities
+	longPollAfter     int32
+	longPollRetry     time.Duration
+	dialFailures      int32
+	faults            *Faults
+	shaping           *Shaping
+	closed            int32
 }
 
 // New create a new messaging client
 func New(endpoint, selfID, deviceID, privateKey string, opts ...func(*Client) error) (*Client, error) {
 	c := Client{
-		endpoint:    endpoint,
-		selfID:      selfID,
-		deviceID:    deviceID,
-		privateKey:  privateKey,
-		timeout:     DefaultTimeout,
-		deadline:    DefaultDeadline,
-		maxretries:  DefaultRetries,
-		send:        make(chan *request, DefaultBufferSize),
-		recv:        make(chan *msgproto.Message, DefaultBufferSize),
-		closewriter: make(chan bool),
-		requests:    newRequestCache(),
-	}
-
-	for _, opt := range opts {
-		err := opt(&c)
-		if err != nil {
-			return nil, err
-		}
+		endpoint:          endpoint,
+		selfID:            selfID,
+		deviceID:          deviceID,
+		privateKey:        privateKey,
+		timeout:           DefaultTimeout,
+		deadline:          DefaultDeadline,
+		maxretries:        DefaultRetries,
+		consumerRetries:   DefaultConsumerRetries,
+		consumerBackoff:   DefaultConsumerBackoff,
+		send:              make(chan *request, DefaultBufferSize),
+		control:           make(chan *request, DefaultControlBufferSize),
+		recv:              make(chan *msgproto.Message, DefaultBufferSize),
+		requests:          newRequestCache(),
+		aclRules:          &aclCache{},
+		queued:            newSendQueue(),
+		held:              &heldMessages{},
+		envelopes:         newEnvelopeCache(DefaultBufferSize * 2),
+		counters:          &counters{},
+		inboundWorkers:    DefaultInboundWorkers,
+		idGenerator:       newUUID,
+		groupKeys:         NewMemoryGroupKeyStore(),
+		files:             &files{incoming: make(map[string]*incomingFile)},
+		streams:           newStreams(),
+		subscriptions:     newSubscriptions(),
+		jwsBuffer:         DefaultBufferSize,
+		opts:              opts,
+		reconnectReplaced: true,
+		retryInterval:     DefaultRetryInterval,
+		longPollRetry:     DefaultLongPollRetry,
+		errors:            make(chan error, DefaultBufferSize),
+		events:            make(chan Event, DefaultEventBufferSize),
+		clock:             systemClock{},
+		expiryGrace:       -1,
+		idempotency:       newIdempotency(DefaultIdempotencyTTL, DefaultIdempotencySize),
+		handingOver:       make(chan struct{}),
+		latency:           newLatencyTracker(DefaultLatencyWindow),
+		deadlines:         &deadlineQueue{},
+	}
+
+	err := c.applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.claimDevice()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.publishExpvar()
+	if err != nil {
+		c.releaseDevice()
+		return nil, err
 	}
 
-	return &c, c.setup()
+	err = c.setup()
+	if err != nil {
+		c.unpublishExpvar()
+		c.releaseDevice()
+		return &c, err
+	}
+
+	go c.redeliver()
+	c.startOutbox()
+
+	err = c.sendRestored()
+	if err != nil {
+		return &c, err
+	}
+
+	return &c, nil
+}
+
+// NewID returns a new message or request id from the client's ID generator
+func (c *Client) NewID() string {
+	return c.idGenerator()
+}
+
+// SelfID returns the self ID the client authenticates as
+func (c *Client) SelfID() string {
+	return c.selfID
+}
+
+// DeviceID returns the device the client authenticates as
+func (c *Client) DeviceID() string {
+	return c.deviceID
+}
+
+// WithDevice creates a new client for the same identity that authenticates
+// as a different device. The new client is created with the same key and
+// options, so stores and queues passed as options are shared between them
+func (c *Client) WithDevice(deviceID string) (*Client, error) {
+	return New(c.endpoint, c.selfID, deviceID, c.key(), c.opts...)
 }
 
 func (c *Client) setup() error {
+	var ws transport
+
+	if c.standby != nil {
+		ws = c.standby.take()
+	}
+
+	if ws != nil {
+		var err error
+
+		c.watchPongs(ws)
+
+		ws, err = c.login(ws)
+		if err != nil {
+			c.report(fmt.Errorf("standby connection failed: %w", err))
+		}
+	}
+
+	if ws == nil {
+		var err error
+
+		ws, err = c.establish()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.faults != nil {
+		ws = c.faults.wrap(ws)
+	}
+
+	if c.shaping != nil {
+		ws = c.shaping.wrap(ws)
+	}
+
+	conn := &connection{
+		ws:          ws,
+		done:        make(chan struct{}),
+		readerDone:  make(chan struct{}),
+		closewriter: make(chan []byte, 1),
+	}
+
+	conn.pipeline = c.startPipeline(conn)
+
+	c.connMu.Lock()
+	c.conn = conn
 	atomic.StoreInt32(&c.closed, 0)
+	c.connMu.Unlock()
+
+	atomic.StoreInt64(&c.counters.connectedAt, c.clock.Now().UnixNano())
+	c.touch()
+
+	c.supervise("reader", conn, func() { c.reader(conn) })
+	c.supervise("writer", conn, func() { c.writer(conn) })
+	go c.labelled("watchdog", func() { c.watchdog(conn) })
+	go c.labelled("upgrade", func() { c.retryWebsocket(conn) })
+	go c.labelled("watermark", func() { c.watchReceiveQueue(conn) })
+	go c.labelled("resend", c.resend)
 
-	err := c.generateToken()
+	if len(c.connectACL) > 0 {
+		go c.labelled("acl", c.ensureACL)
+	}
+
+	if c.standby != nil {
+		c.standby.fill()
+	}
+
+	c.emit(Connected{})
+
+	return nil
+}
+
+// establish opens an authenticated connection to the server
+func (c *Client) establish() (transport, error) {
+	ws, err := c.connect()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.connect()
+	return c.login(ws)
+}
+
+// login authenticates a connection, resuming the session if possible. The
+// connection is closed if authentication fails
+func (c *Client) login(ws transport) (transport, error) {
+	resumed, err := c.resume(ws)
 	if err != nil {
-		return err
+		// the server may close the connection after rejecting the token,
+		// so full authentication uses a new one
+		ws.Close()
+
+		ws, err = c.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resumed {
+		return ws, nil
 	}
 
-	err = c.authenticate()
+	// the token is generated after connecting so it can account for
+	// the server's clock skew
+	token, err := c.generateToken()
 	if err != nil {
-		return err
+		ws.Close()
+		return nil, err
 	}
 
-	go c.reader()
-	go c.writer()
+	err = c.authenticate(ws, token)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
 
-	return nil
+	return ws, nil
 }
 
 func (c *Client) tryReconnect(err error) {
@@ -112,7 +392,18 @@ func (c *Client) tryReconnect(err error) {
 		return
 	}
 
+	// only one goroutine may reconnect at a time
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+
+	if err == ErrSessionReplaced && !c.reconnectReplaced {
+		return
+	}
+
 	switch e := err.(type) {
+	case *PanicError:
 	case net.Error:
 		if !e.Timeout() {
 			return
@@ -122,93 +413,141 @@ func (c *Client) tryReconnect(err error) {
 			return
 		}
 	default:
-		log.Println("unknown error type")
-		spew.Dump(e)
+		if err != ErrConnectionStalled && err != ErrSessionReplaced && err != errWebsocketAvailable {
+			log.Println("unknown error type")
+			spew.Dump(e)
+		}
 	}
 
+	c.retrySetup()
+}
+
+// retrySetup reconnects until it succeeds or runs out of retries
+func (c *Client) retrySetup() {
+	c.labelled("reconnect", c.reconnectLoop)
+}
+
+func (c *Client) reconnectLoop() {
+	var err error
+
 	for i := 0; i < c.maxretries; i++ {
 		log.Println("attempting reconnect")
+		c.emit(ReconnectAttempt{N: i + 1})
 
-		err := c.setup()
+		err = c.setup()
 		if err == nil {
-			atomic.StoreInt32(&c.closed, 0)
+			atomic.AddInt64(&c.counters.reconnects, 1)
 			return
 		}
 
-		time.Sleep(DefaultTimeout)
+		<-c.clock.After(c.retryInterval)
 	}
-}
 
-func (c *Client) generateToken() error {
-	pks, _ := base64.RawStdEncoding.DecodeString(c.privateKey)
-	pk := ed25519.NewKeyFromSeed(pks)
+	c.report(fmt.Errorf("reconnect failed after %d attempts: %w", c.maxretries, err))
+}
 
+func (c *Client) generateToken() (string, error) {
 	claims, err := json.Marshal(map[string]interface{}{
-		"jti": uuid.New().String(),
+		"jti": c.NewID(),
 		"iss": c.selfID,
-		"iat": TimeFunc().Unix(),
-		"exp": TimeFunc().Add(time.Minute).Unix(),
+		"iat": c.serverNow().Unix(),
+		"exp": c.serverNow().Add(time.Minute).Unix(),
 	})
 
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: pk}, nil)
+	signer, err := c.signer(nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	signedPayload, err := signer.Sign(claims)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	token, err := signedPayload.CompactSerialize()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	c.token = string(token)
+	return string(token), nil
+}
 
-	return nil
+func (c *Client) connect() (transport, error) {
+	ws, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ws.SetReadDeadline(time.Now().Add(c.deadline))
+	c.watchPongs(ws)
+
+	return ws, nil
+}
+
+// watchPongs records the client's liveness when the connection receives a pong
+func (c *Client) watchPongs(ws transport) {
+	ws.SetPongHandler(func(string) error { c.touch(); c.ponged(); ws.SetReadDeadline(time.Now().Add(c.deadline)); return nil })
 }
 
-func (c *Client) connect() error {
-	ws, _, err := websocket.DefaultDialer.Dial(c.endpoint, nil)
+// dial connects with a websocket, falling back to long polling if the
+// websocket fails too many times
+func (c *Client) dial() (transport, error) {
+	if c.longPollAfter > 0 && atomic.LoadInt32(&c.dialFailures) >= c.longPollAfter {
+		return c.connectLongPoll()
+	}
+
+	started := c.clock.Now()
+
+	ws, resp, err := c.dialTransport()
 	if err != nil {
-		return err
+		if c.longPollAfter > 0 && atomic.AddInt32(&c.dialFailures, 1) >= c.longPollAfter {
+			return c.connectLongPoll()
+		}
+		return nil, err
 	}
 
-	c.ws = ws
+	atomic.StoreInt32(&c.dialFailures, 0)
 
-	ws.SetReadDeadline(time.Now().Add(c.deadline))
-	ws.SetPongHandler(func(string) error { ws.SetReadDeadline(time.Now().Add(c.deadline)); return nil })
+	c.measureSkew(resp, started, c.clock.Now())
+	c.capabilities.negotiate(resp)
 
-	return nil
+	return ws, nil
 }
 
-func (c *Client) authenticate() error {
+// authenticate sends an authentication request with a signed token or a
+// resumption token, and waits for the server to accept it
+func (c *Client) authenticate(ws transport, token string) error {
 	var resp msgproto.Notification
 
 	auth := msgproto.Auth{
-		Id:     uuid.New().String(),
+		Id:     c.NewID(),
 		Type:   msgproto.MsgType_AUTH,
-		Token:  c.token,
+		Token:  token,
 		Device: c.deviceID,
+		Offset: uint64(atomic.LoadInt64(&c.offset)),
 	}
 
+	c.withPriority(&auth)
+
 	data, err := proto.Marshal(&auth)
 	if err != nil {
 		return err
 	}
 
-	err = c.ws.WriteMessage(websocket.BinaryMessage, data)
+	err = ws.WriteMessage(websocket.BinaryMessage, data)
 	if err != nil {
 		return err
 	}
 
-	_, data, err = c.ws.ReadMessage()
+	c.tap(FrameOutbound, data)
+
+	_, data, err = ws.ReadMessage()
 	if err != nil {
 		return err
 	}
 
+	c.tap(FrameInbound, data)
+
 	err = proto.Unmarshal(data, &resp)
 	if err != nil {
 		return err
@@ -216,120 +555,398 @@ func (c *Client) authenticate() error {
 
 	switch resp.Type {
 	case msgproto.MsgType_ACK:
+		c.storeResumeToken(&resp)
 		return nil
 	case msgproto.MsgType_ERR:
-		return errors.New(resp.Error)
+		return newServerError(&resp)
 	default:
 		return errors.New("unknown authentication error")
 	}
 }
 
-func (c *Client) reader() {
+func (c *Client) reader(conn *connection) {
+	// the header is reused for every frame
+	var hdr msgproto.Header
+
+	// the pipeline delivers what was dispatched before the reader exits
+	defer conn.pipeline.stop()
+
 	for {
-		if c.IsClosed() {
-			return
-		}
+		buf := getReadBuffer()
 
-		_, data, err := c.ws.ReadMessage()
+		skipped, err := c.readFrame(conn, buf)
 		if err != nil {
-			c.close()
+			conn.pipeline.stop()
+
+			if atomic.LoadInt32(&conn.closing) == 1 {
+				// the close was initiated by the client
+				c.teardown(conn, nil)
+				return
+			}
+
+			ce, ok := err.(*websocket.CloseError)
+			if ok && ce.Code == CloseSessionReplaced {
+				err = ErrSessionReplaced
+			}
+
+			if !c.teardown(conn, err) {
+				return
+			}
+
+			switch {
+			case err == ErrSessionReplaced:
+				c.report(err)
+			case ok && ce.Code != websocket.CloseAbnormalClosure:
+				c.report(&CloseError{Code: ce.Code, Reason: ce.Text})
+			default:
+				c.report(fmt.Errorf("read failed: %w", err))
+			}
+
 			c.tryReconnect(err)
 			return
 		}
 
-		var hdr msgproto.Header
-
-		err = proto.Unmarshal(data, &hdr)
-		if err != nil {
-			continue
+		if skipped != nil {
+			c.skipFrame(skipped)
+		} else {
+			c.handleFrame(conn, &hdr, buf.Bytes())
 		}
 
-		var m proto.Message
+		releaseReadBuffer(buf)
+	}
+}
+
+// readFrame reads the next frame from the connection into buf. A frame over
+// the inbound size limit is scanned to the end without being kept, and is
+// returned as skipped so that the connection can carry on
+func (c *Client) readFrame(conn *connection, buf *bytes.Buffer) (*skippedFrame, error) {
+	_, r, err := conn.ws.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.maxInbound == 0 {
+		_, err = buf.ReadFrom(r)
+		return nil, err
+	}
+
+	n, err := buf.ReadFrom(io.LimitReader(r, c.maxInbound+1))
+	if err != nil || n <= c.maxInbound {
+		return nil, err
+	}
+
+	return scanSkipped(io.MultiReader(bytes.NewReader(buf.Bytes()), r))
+}
+
+// handleFrame decodes a frame and routes it to a waiting request or the
+// connection's pipeline. Decoded messages do not reference data, so it can
+// be reused. Without a connection, messages are delivered immediately
+func (c *Client) handleFrame(conn *connection, hdr *msgproto.Header, data []byte) {
+	c.touch()
+	c.tap(FrameInbound, data)
+	atomic.AddInt64(&c.counters.bytesIn, int64(len(data)))
 
-		switch hdr.Type {
-		case msgproto.MsgType_MSG:
-			m = &msgproto.Message{}
-		case msgproto.MsgType_ACL:
-			m = &msgproto.AccessControlList{}
-		case msgproto.MsgType_ACK, msgproto.MsgType_ERR:
-			m = &msgproto.Notification{}
+	hdr.Reset()
+
+	err := proto.Unmarshal(data, hdr)
+	if err != nil {
+		c.dropped("", fmt.Errorf("failed to decode frame header: %w", err))
+		return
+	}
+
+	var m proto.Message
+
+	switch hdr.Type {
+	case msgproto.MsgType_MSG:
+		m = &msgproto.Message{}
+	case msgproto.MsgType_ACL:
+		m = &msgproto.AccessControlList{}
+	case msgproto.MsgType_ACK, msgproto.MsgType_ERR:
+		m = &msgproto.Notification{}
+	default:
+		c.dropped(hdr.Id, fmt.Errorf("received frame with unknown type %d", hdr.Type))
+		return
+	}
+
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		c.dropped(hdr.Id, fmt.Errorf("failed to decode %s frame: %w", hdr.Type, err))
+		return
+	}
+
+	switch hdr.Type {
+	case msgproto.MsgType_ACK:
+		atomic.AddInt64(&c.counters.acks, 1)
+		c.idempotency.acknowledge(hdr.Id, c.clock.Now())
+		c.requests.send(hdr.Id, m)
+	case msgproto.MsgType_ERR:
+		atomic.AddInt64(&c.counters.errors, 1)
+		c.requests.send(hdr.Id, m)
+	case msgproto.MsgType_ACL:
+		atomic.AddInt64(&c.counters.acls, 1)
+		if !c.requests.send(hdr.Id, m) {
+			// ACLs that are not a response were pushed by the server
+			c.aclPushed(m.(*msgproto.AccessControlList))
 		}
+	case msgproto.MsgType_MSG:
+		atomic.AddInt64(&c.counters.received, 1)
 
-		err = proto.Unmarshal(data, m)
-		if err != nil {
-			continue
+		msg := m.(*msgproto.Message)
+
+		if !c.acceptSender(msg) {
+			c.dropped(msg.Id, nil)
+			return
 		}
 
-		switch hdr.Type {
-		case msgproto.MsgType_ACK, msgproto.MsgType_ERR, msgproto.MsgType_ACL:
-			c.requests.send(hdr.Id, m)
-		case msgproto.MsgType_MSG:
-			msg := m.(*msgproto.Message)
-			msgID := getJWSResponseID(msg.Ciphertext)
-			ok := c.requests.sendJWS(msgID, msg)
-			if !ok {
-				c.recv <- msg
+		if conn != nil {
+			// wait for room in the budget, which stops reading from the connection
+			err = c.memory.acquire(int64(len(msg.Ciphertext)), nil, conn.done)
+			if err != nil {
+				c.dropped(msg.Id, fmt.Errorf("dropped message %s: %w", msg.Id, err))
+				return
 			}
+
+			conn.pipeline.dispatch(msg)
+		} else {
+			c.deliver(msg)
 		}
 	}
 }
 
-func (c *Client) writer() {
+// acceptSender returns false if the AcceptSender policy rejects a message's sender
+func (c *Client) acceptSender(m *msgproto.Message) bool {
+	if c.senderPolicy == nil {
+		return true
+	}
+
+	parts := strings.SplitN(m.Sender, ":", 2)
+	if len(parts) < 2 {
+		return c.senderPolicy(parts[0], "")
+	}
+
+	return c.senderPolicy(parts[0], parts[1])
+}
+
+// writer writes queued requests and pings to the connection. Pings and
+// control requests, such as ACL changes, are written before queued
+// messages so a full send queue can't delay keepalives
+func (c *Client) writer(conn *connection) {
 	var err error
 
-	for {
-		if c.IsClosed() {
-			return
-		}
+	ping := c.clock.NewTicker(c.deadline / 2)
+	defer ping.Stop()
 
+	for {
 		select {
-		case <-c.closewriter:
-			err = c.ws.WriteControl(websocket.CloseMessage, CloseMessage, time.Now().Add(c.deadline))
-		case request := <-c.send:
-			err = c.ws.WriteMessage(websocket.BinaryMessage, request.message)
-			request.response <- err
-		case <-time.After(c.deadline / 2):
-			err = c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.deadline))
+		case <-ping.Chan():
+			err = c.ping(conn)
+		case request := <-c.control:
+			err = c.write(conn, request)
+		default:
+			if request := c.deadlines.next(c.send); request != nil {
+				c.checkSendQueue()
+				err = c.writeScheduled(conn, request)
+				break
+			}
+
+			select {
+			case <-conn.done:
+				return
+			case data := <-conn.closewriter:
+				err = conn.ws.WriteControl(websocket.CloseMessage, data, time.Now().Add(c.deadline))
+				if err == nil {
+					// nothing can be written after a close frame
+					return
+				}
+			case <-ping.Chan():
+				err = c.ping(conn)
+			case request := <-c.control:
+				err = c.write(conn, request)
+			case request := <-c.send:
+				c.checkSendQueue()
+				if c.schedule(request) {
+					continue
+				}
+				err = c.write(conn, request)
+			}
 		}
 
 		if err != nil {
-			c.close()
+			err = fmt.Errorf("write failed: %w", err)
+			if c.teardown(conn, err) {
+				c.report(err)
+			}
 			return
 		}
 	}
 }
 
-// Send send a message
+func (c *Client) ping(conn *connection) error {
+	return conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.deadline))
+}
+
+// write writes a request unless it has been cancelled, returning the
+// error that is also passed to the request
+func (c *Client) write(conn *connection, request *request) error {
+	if !c.queued.take(request) {
+		return nil
+	}
+
+	if request.isMsg && c.idempotency.acknowledged(request.id, c.clock.Now()) {
+		// the server accepted the message before it was resent, so it is
+		// acknowledged again without being written twice
+		c.requests.send(request.id, &msgproto.Notification{Type: msgproto.MsgType_ACK, Id: request.id})
+		releaseMarshalBuffer(request.buf)
+		c.memory.release(request.size)
+		request.response <- nil
+		return nil
+	}
+
+	data := request.buf.Bytes()
+
+	request.writtenAt = c.clock.Now()
+
+	err := conn.ws.WriteMessage(websocket.BinaryMessage, data)
+	if err == nil {
+		c.tap(FrameOutbound, data)
+		atomic.AddInt64(&c.counters.bytesOut, int64(len(data)))
+		if request.isMsg {
+			atomic.AddInt64(&c.counters.sent, 1)
+		}
+	}
+
+	releaseMarshalBuffer(request.buf)
+	c.memory.release(request.size)
+	request.response <- err
+
+	return err
+}
+
+// Send send a message. Messages rejected by the server are passed
+// to the dead letter queue if one is configured
 func (c *Client) Send(m *msgproto.Message) error {
+	err := c.sendEncrypted(m, time.Time{})
+	c.audit(AuditSent, m, err)
+	c.archive(AuditSent, m, err)
+
+	return err
+}
+
+func (c *Client) sendEncrypted(m *msgproto.Message, deadline time.Time) error {
+	em, err := c.encrypt(m)
+	if err != nil {
+		return err
+	}
+
+	err = c.persist(em)
+	if err != nil {
+		return err
+	}
+
+	err = c.sendMessage(em, deadline)
+	c.settle(em, err)
+	c.deadLetter(em, err)
+
+	return err
+}
+
+func (c *Client) sendMessage(m *msgproto.Message, deadline time.Time) error {
 	if c.IsClosed() {
 		return errors.New("connection is closed")
 	}
 
-	resp, err := c.request(m.Id, m)
+	resp, err := c.requestBefore(m.Id, m, deadline)
 	if err != nil {
 		return err
 	}
 
-	n, ok := resp.(*msgproto.Notification)
-	if ok {
-		if n.Type == msgproto.MsgType_ACK {
-			return nil
-		}
-		if n.Type == msgproto.MsgType_ERR {
-			return errors.New(n.Error)
-		}
+	return notificationError(resp)
+}
+
+// SendAsync queues a message without waiting for the server to acknowledge it.
+// fn, if not nil, is called from another goroutine once the message has been
+// acknowledged, rejected or has timed out. If the send queue is full, the
+// message is not queued and fn is called with an error
+func (c *Client) SendAsync(m *msgproto.Message, fn func(error)) {
+	if fn == nil {
+		fn = func(error) {}
 	}
 
+	done := func(err error) {
+		c.audit(AuditSent, m, err)
+		c.archive(AuditSent, m, err)
+		fn(err)
+	}
+
+	em, err := c.encrypt(m)
+	if err != nil {
+		go done(err)
+		return
+	}
+
+	c.sendAsync(em, time.Time{}, done)
+}
+
+// sendAsync queues a message that has already been encrypted
+func (c *Client) sendAsync(m *msgproto.Message, deadline time.Time, fn func(error)) {
+	if fn == nil {
+		fn = func(error) {}
+	}
+
+	err := c.queueAsync(m, deadline, false, fn)
+	if err != nil {
+		go fn(err)
+	}
+}
+
+// queueAsync queues a message that has already been encrypted, calling fn
+// once it has been acknowledged, rejected or has timed out. If the message
+// can't be queued the error is returned and fn is not called. When block is
+// set it waits for room in the send queue for up to the request timeout
+func (c *Client) queueAsync(m *msgproto.Message, deadline time.Time, block bool, fn func(error)) error {
+	err := c.persist(m)
+	if err != nil {
+		return err
+	}
+
+	r, err := c.enqueue(m.Id, m, block, deadline)
+	if err != nil {
+		c.deadLetter(m, err)
+		return err
+	}
+
+	go func() {
+		resp, err := c.await(r)
+		if err == nil {
+			err = notificationError(resp)
+		}
+
+		c.settle(m, err)
+		c.deadLetter(m, err)
+		fn(err)
+	}()
+
 	return nil
 }
 
 // Receive receive a message
 func (c *Client) Receive() (*msgproto.Message, error) {
+	m := c.held.take(func(*msgproto.Message) bool { return true })
+	if m != nil {
+		return m, nil
+	}
+
+	return c.receiveFrom(c.recv)
+}
+
+// receiveFrom receives the next message from a queue until the client is closed
+func (c *Client) receiveFrom(ch chan *msgproto.Message) (*msgproto.Message, error) {
 	for {
 		select {
-		case m := <-c.recv:
+		case m := <-ch:
+			c.checkReceiveQueue()
 			return m, nil
-		case <-time.After(time.Second):
+		case <-c.clock.After(time.Second):
 			if c.IsClosed() {
 				return nil, errors.New("connection is closed")
 			}
@@ -337,32 +954,102 @@ func (c *Client) Receive() (*msgproto.Message, error) {
 	}
 }
 
+// ReceiveInto receives the next message, verifies it and decodes its payload into v.
+// There is no generic Receive[T] variant, as the module still supports go versions
+// that predate type parameters
+func (c *Client) ReceiveInto(v interface{}) (*Claims, error) {
+	m, err := c.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.DecodePayload(m, v)
+}
+
 // ReceiveChan returns a channel of all incoming messages
 func (c *Client) ReceiveChan() chan *msgproto.Message {
 	return c.recv
 }
 
+// Messages returns an iterator over incoming messages. With go 1.23 or
+// later it can be used with range:
+//
+//	for msg, err := range client.Messages(ctx) {
+//	    ...
+//	}
+//
+// Iteration stops when the context is cancelled, or after an error is
+// yielded if the connection is closed
+func (c *Client) Messages(ctx context.Context) func(yield func(*msgproto.Message, error) bool) {
+	return func(yield func(*msgproto.Message, error) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-c.recv:
+				c.checkReceiveQueue()
+				if !yield(m, nil) {
+					return
+				}
+			case <-c.clock.After(time.Second):
+				if c.IsClosed() {
+					yield(nil, errors.New("connection is closed"))
+					return
+				}
+			}
+		}
+	}
+}
+
 // PermitAll permits messages from all identities
 func (c *Client) PermitAll() error {
 	return c.acl(msgproto.ACLCommand_PERMIT, "*", nil)
 }
 
-// PermitSender permits messages from a given sender
+// PermitSender permits messages from a given sender until exp. It returns
+// ErrACLExpiryInPast if exp has passed and, if the MaxACLExpiry option is
+// set, ErrACLExpiryTooLong if it is further away than it allows
 func (c *Client) PermitSender(selfID string, exp time.Time) error {
+	now := c.serverNow()
+
+	if !exp.After(now) {
+		return ErrACLExpiryInPast
+	}
+
+	if c.maxACLExpiry > 0 && exp.Sub(now) > c.maxACLExpiry {
+		return ErrACLExpiryTooLong
+	}
+
 	return c.acl(msgproto.ACLCommand_PERMIT, selfID, &exp)
 }
 
+// PermitSenderFor permits messages from a given sender for ttl
+func (c *Client) PermitSenderFor(selfID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrACLExpiryInPast
+	}
+
+	return c.PermitSender(selfID, c.serverNow().Add(ttl))
+}
+
 // BlockSender blocks messages from a given sender
 func (c *Client) BlockSender(selfID string) error {
 	return c.acl(msgproto.ACLCommand_REVOKE, selfID, nil)
 }
 
+// CachedACLRules returns the ACL rules last returned by ListACLRules,
+// updated with the senders permitted and blocked since, without
+// contacting the server
+func (c *Client) CachedACLRules() []ACLRule {
+	return c.aclRules.get()
+}
+
 // ListACLRules returns all active ACL rules for the authenticated identity
 func (c *Client) ListACLRules() ([]ACLRule, error) {
 	var rules []ACLRule
 
 	req := msgproto.AccessControlList{
-		Id:      uuid.New().String(),
+		Id:      c.NewID(),
 		Type:    msgproto.MsgType_ACL,
 		Command: msgproto.ACLCommand_LIST,
 	}
@@ -374,17 +1061,21 @@ func (c *Client) ListACLRules() ([]ACLRule, error) {
 
 	switch r := resp.(type) {
 	case *msgproto.Notification:
-		err = errors.New(r.Error)
+		err = newServerError(r)
 	case *msgproto.AccessControlList:
 		err = json.Unmarshal(r.Payload, &rules)
+		if err == nil {
+			c.aclRules.set(rules)
+		}
 	}
 
 	return rules, err
 }
 
-// JWSRequest makes a JWS request and returns the response
+// JWSRequest makes a JWS request and returns the response. The registration
+// is only removed by JWSResponse, so JWSRequestAndWait should be preferred
 func (c *Client) JWSRequest(id string, m *msgproto.Message) (chan *msgproto.Message, error) {
-	ch := c.requests.registerJWS(id)
+	ch := c.requests.registerJWS(id, 1)
 
 	err := c.Send(m)
 	if err != nil {
@@ -397,47 +1088,298 @@ func (c *Client) JWSRequest(id string, m *msgproto.Message) (chan *msgproto.Mess
 
 // JWSResponse waits for a message response for a given JWS request
 func (c *Client) JWSResponse(id string, timeout time.Duration) (*msgproto.Message, error) {
-	return c.requests.waitJWS(id, timeout)
+	m, err := c.requests.waitJWS(id, c.clock.After(timeout))
+	if err != nil {
+		c.timedOut(id, err)
+	}
+
+	return m, err
 }
 
 // JWSRegister registers a jws request by id
 func (c *Client) JWSRegister(id string) {
-	c.requests.registerJWS(id)
+	c.requests.registerJWS(id, 1)
+}
+
+// JWSResponses registers a conversation that can receive multiple responses.
+// Responses are delivered on the returned channel until CloseJWSResponses is
+// called, and are dropped if more than the JWSResponseBuffer are pending
+func (c *Client) JWSResponses(id string) <-chan *msgproto.Message {
+	return c.requests.registerJWS(id, c.jwsBuffer)
+}
+
+// CloseJWSResponses stops delivering responses for a conversation and closes its channel
+func (c *Client) CloseJWSResponses(id string) {
+	c.requests.closeJWS(id)
+}
+
+// JWSRequestAndWait sends a JWS request and waits for the response with the
+// given conversation id. The registration is removed when it returns,
+// whether a response was received or not
+func (c *Client) JWSRequestAndWait(ctx context.Context, id string, m *msgproto.Message) (*msgproto.Message, error) {
+	ch := c.requests.registerJWS(id, 1)
+	defer c.requests.cancelJWS(id)
+
+	err := c.Send(m)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 // Request send a message that expects a response
 func (c *Client) request(id string, m proto.Message) (proto.Message, error) {
+	return c.requestBefore(id, m, time.Time{})
+}
+
+// requestBefore sends a request that is dropped if it has not been written
+// by the deadline, unless the deadline is zero
+func (c *Client) requestBefore(id string, m proto.Message, deadline time.Time) (proto.Message, error) {
+	generation := atomic.LoadInt64(&c.authGeneration)
+
+	resp, err := c.requestOnce(id, m, deadline)
+	if err != nil || !authExpired(resp) {
+		return resp, err
+	}
+
+	// the token expired during the session, so the request is sent
+	// again once the client has authenticated with a new token
+	err = c.reauthenticate(generation)
+	if err != nil {
+		c.report(fmt.Errorf("failed to reauthenticate: %w", err))
+		return resp, nil
+	}
+
+	return c.requestOnce(id, m, deadline)
+}
+
+func (c *Client) requestOnce(id string, m proto.Message, deadline time.Time) (proto.Message, error) {
+	r, err := c.enqueue(id, m, true, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.await(r)
+}
+
+// authExpired returns true if the server rejected a request because the
+// client's authentication is no longer valid
+func authExpired(resp proto.Message) bool {
+	n, ok := resp.(*msgproto.Notification)
+	if !ok || n.Type != msgproto.MsgType_ERR {
+		return false
+	}
+
+	return newServerError(n).Code == ErrorAuth
+}
+
+// reauthenticate replaces the connection with one authenticated with a new
+// token. Requests that failed on the same connection share one reconnect,
+// identified by the generation they were sent in
+func (c *Client) reauthenticate(generation int64) error {
+	c.reauthMu.Lock()
+	defer c.reauthMu.Unlock()
+
+	if atomic.LoadInt64(&c.authGeneration) != generation {
+		return nil
+	}
+
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return errors.New("reconnect already in progress")
+	}
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	if conn == nil || !c.teardown(conn, nil) {
+		atomic.StoreInt32(&c.reconnecting, 0)
+		return errors.New("connection is closed")
+	}
+
+	// the session was rejected, so it cannot be resumed
+	c.resumption.set("")
+
+	if c.standby != nil {
+		// the standby connection may have been rejected too
+		c.standby.discard()
+	}
+
+	err := c.setup()
+	if err != nil {
+		if c.reconnect {
+			// keep trying in the background, holding the reconnect flag
+			go func() {
+				defer atomic.StoreInt32(&c.reconnecting, 0)
+				c.retrySetup()
+			}()
+		} else {
+			atomic.StoreInt32(&c.reconnecting, 0)
+		}
+		return err
+	}
+
+	atomic.StoreInt32(&c.reconnecting, 0)
+
+	atomic.AddInt64(&c.authGeneration, 1)
+	atomic.AddInt64(&c.counters.reconnects, 1)
+
+	return nil
+}
+
+// enqueue registers a request and queues it for the writer. If the send
+// queue is full, enqueue waits for up to the request timeout when block is
+// set, otherwise it fails immediately. Messages with a deadline are written
+// earliest deadline first, and dropped if it passes before they are written
+func (c *Client) enqueue(id string, m proto.Message, block bool, deadline time.Time) (*request, error) {
 	if c.IsClosed() {
 		return nil, errors.New("connection is closed")
 	}
 
-	data, err := proto.Marshal(m)
+	buf, err := marshal(m)
 	if err != nil {
 		return nil, err
 	}
 
-	r := request{id: id, message: data, response: make(chan error)}
-	c.requests.register(r.id)
-	c.send <- &r
+	if c.maxOutbound > 0 && int64(len(buf.Bytes())) > c.maxOutbound {
+		releaseMarshalBuffer(buf)
+		return nil, ErrMessageTooLarge
+	}
+
+	r := request{id: id, buf: buf, size: int64(len(buf.Bytes())), queuedAt: c.clock.Now(), deadline: deadline, response: make(chan error, 1)}
 
-	err = <-r.response
+	if msg, ok := m.(*msgproto.Message); ok {
+		r.isMsg = true
+		r.recipient = msg.Recipient
+	}
+
+	var timeout <-chan time.Time
+	if block {
+		timeout = c.clock.After(c.timeout)
+	}
+
+	if r.isMsg {
+		err = c.recipients.acquire(r.recipient, timeout)
+		if err != nil {
+			releaseMarshalBuffer(buf)
+			return nil, err
+		}
+	}
+
+	err = c.memory.acquire(r.size, timeout, nil)
 	if err != nil {
+		c.releaseRecipient(&r)
+		releaseMarshalBuffer(buf)
 		return nil, err
 	}
 
-	resp, err := c.requests.wait(r.id, c.timeout)
+	c.requests.register(r.id)
+	c.queued.add(&r)
+
+	if !deadline.IsZero() {
+		c.deadlines.expect(1)
+	}
+
+	// messages share the send queue, while other requests use the control lane
+	queue := c.send
+	if !r.isMsg {
+		queue = c.control
+	}
+
+	if block {
+		select {
+		case queue <- &r:
+			c.checkSendQueue()
+			return &r, nil
+		case <-timeout:
+		}
+	} else {
+		select {
+		case queue <- &r:
+			c.checkSendQueue()
+			return &r, nil
+		default:
+		}
+	}
+
+	c.queued.remove(&r)
+	c.requests.cancel(r.id)
+	c.memory.release(r.size)
+	c.releaseRecipient(&r)
+	releaseMarshalBuffer(buf)
+
+	if !deadline.IsZero() {
+		c.deadlines.expect(-1)
+	}
+
+	return nil, errors.New("send queue is full")
+}
+
+// releaseRecipient frees the recipient slot held by a message
+func (c *Client) releaseRecipient(r *request) {
+	if r.isMsg {
+		c.recipients.release(r.recipient)
+	}
+}
+
+// await waits for a queued request to be written and for the server's response
+func (c *Client) await(r *request) (proto.Message, error) {
+	defer c.releaseRecipient(r)
+
+	select {
+	case err := <-r.response:
+		if err != nil {
+			c.requests.cancel(r.id)
+			return nil, err
+		}
+	case <-c.clock.After(c.timeout):
+		c.requests.cancel(r.id)
+		c.emit(RequestTimedOut{ID: r.id})
+		return nil, ErrRequestTimeout
+	}
+
+	resp, err := c.requests.wait(r.id, c.clock.After(c.timeout))
+
+	if r.isMsg && !r.writtenAt.IsZero() {
+		n, ok := resp.(*msgproto.Notification)
+		if err != nil || ok && n.Type == msgproto.MsgType_ACK {
+			c.latency.observe(c.clock.Now().Sub(r.writtenAt), err == nil)
+		}
+	}
+
 	if err != nil {
+		c.timedOut(r.id, err)
 		return nil, err
 	}
 
 	return resp, nil
 }
 
+// notificationError converts a server notification into an error
+func notificationError(resp proto.Message) error {
+	n, ok := resp.(*msgproto.Notification)
+	if !ok {
+		return nil
+	}
+
+	if n.Type == msgproto.MsgType_ERR {
+		return newServerError(n)
+	}
+
+	return nil
+}
+
 func (c *Client) acl(action msgproto.ACLCommand, selfID string, exp *time.Time) error {
 	rule := map[string]string{
 		"iss":        c.selfID,
-		"exp":        time.Now().Add(time.Minute).Format(time.RFC3339),
-		"jti":        uuid.New().String(),
+		"exp":        c.serverNow().Add(time.Minute).Format(time.RFC3339),
+		"jti":        c.NewID(),
 		"acl_source": selfID,
 	}
 
@@ -450,10 +1392,7 @@ func (c *Client) acl(action msgproto.ACLCommand, selfID string, exp *time.Time)
 		return err
 	}
 
-	pks, _ := base64.RawStdEncoding.DecodeString(c.privateKey)
-	pk := ed25519.NewKeyFromSeed(pks)
-
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: pk}, nil)
+	signer, err := c.signer(nil)
 	if err != nil {
 		return err
 	}
@@ -464,7 +1403,7 @@ func (c *Client) acl(action msgproto.ACLCommand, selfID string, exp *time.Time)
 	}
 
 	acl := msgproto.AccessControlList{
-		Id:      uuid.New().String(),
+		Id:      c.NewID(),
 		Type:    msgproto.MsgType_ACL,
 		Command: action,
 		Payload: []byte(signedPayload.FullSerialize()),
@@ -482,9 +1421,20 @@ func (c *Client) acl(action msgproto.ACLCommand, selfID string, exp *time.Time)
 
 	switch n.Type {
 	case msgproto.MsgType_ACK:
+		if action == msgproto.ACLCommand_PERMIT {
+			var expires time.Time
+			if exp != nil {
+				expires = *exp
+			}
+			c.aclRules.permit(selfID, expires)
+			c.emit(ACLChanged{SelfID: selfID, Permitted: true, Expires: expires})
+		} else {
+			c.aclRules.revoke(selfID)
+			c.emit(ACLChanged{SelfID: selfID})
+		}
 		return nil
 	case msgproto.MsgType_ERR:
-		return errors.New(n.Error)
+		return newServerError(n)
 	default:
 		return errors.New("unknown response from server")
 	}
@@ -495,18 +1445,51 @@ func (c *Client) IsClosed() bool {
 	return atomic.LoadInt32(&(c.closed)) != 0
 }
 
+// Close closes the connection with a normal closure status
 func (c *Client) Close() {
-	c.closewriter <- true
-	time.Sleep(time.Millisecond * 10)
-	c.ws.Close()
+	if c.standby != nil {
+		c.standby.close()
+	}
+	c.closeWith(CloseMessage)
+	c.outboxDrainer.stop()
+	c.archiver.stop()
+	c.unpublishExpvar()
+	c.releaseDevice()
 }
 
 func (c *Client) close() {
-	if c.IsClosed() {
-		return
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	if conn != nil {
+		c.teardown(conn, nil)
+	}
+}
+
+// teardown closes a connection if it is still the client's current
+// connection, emitting a Disconnected event with the error that caused it.
+// It returns true if the connection was closed by this call
+func (c *Client) teardown(conn *connection, err error) bool {
+	c.connMu.Lock()
+
+	if c.conn != conn || c.IsClosed() {
+		c.connMu.Unlock()
+		return false
 	}
 
-	atomic.StoreInt32(&(c.closed), int32(1))
+	atomic.StoreInt32(&c.closed, 1)
+	close(conn.done)
+
+	c.connMu.Unlock()
+
+	conn.ws.Close()
+
+	c.emit(Disconnected{Err: err})
+
+	return true
+}
 
-	c.Close()
+func newUUID() string {
+	return uuid.New().String()
 }
@@ -3,14 +3,20 @@
 package messaging
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	msgproto "github.com/selfid-net/self-messaging-client/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,7 +47,7 @@ func testToken(id string) (string, string, ed25519.PublicKey) {
 	claims, err := json.Marshal(map[string]interface{}{
 		"jti": uuid.New().String(),
 		"iss": id,
-		"exp": TimeFunc().Add(time.Minute).Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
 	})
 
 	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: priv}, nil)
@@ -133,6 +139,32 @@ func TestClientBusy(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func TestClientPermitSenderExpiry(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	// the expiry is only limited if a maximum is set
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	require.Nil(t, c.PermitSenderFor("alice", time.Hour*24*365*2))
+	c.Close()
+
+	c, err = New(s.endpoint, "someID", "1", privkey, MaxACLExpiry(time.Hour*24))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, ErrACLExpiryInPast, c.PermitSender("alice", time.Now().Add(-time.Minute)))
+	assert.Equal(t, ErrACLExpiryInPast, c.PermitSenderFor("alice", 0))
+	assert.Equal(t, ErrACLExpiryTooLong, c.PermitSenderFor("alice", time.Hour*48))
+
+	require.Nil(t, c.PermitSenderFor("alice", time.Hour))
+
+	rules := c.CachedACLRules()
+	require.Len(t, rules, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), rules[0].Expires, time.Minute)
+}
+
 func TestClientJWSRequestResponse(t *testing.T) {
 	s := newServer()
 	defer s.close()
@@ -153,3 +185,650 @@ func TestClientJWSRequestResponse(t *testing.T) {
 	require.NotNil(t, resp)
 	assert.Equal(t, []byte(request), resp.Ciphertext)
 }
+
+func TestClientJWSResponses(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, JWSResponseBuffer(2), OnError(func(error) {}))
+	require.Nil(t, err)
+
+	response := `{"payload": "eyJjaWQiOiAiMTIzNDU2In0"}`
+
+	ch := c.JWSResponses("123456")
+
+	for i := 0; i < 3; i++ {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: strconv.Itoa(i), Sender: "tset", Recipient: "test", Ciphertext: []byte(response)}
+	}
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Dropped == 1
+	}, time.Second, time.Millisecond*10)
+
+	for i := 0; i < 2; i++ {
+		m := <-ch
+		assert.Equal(t, strconv.Itoa(i), m.Id)
+	}
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "3", Sender: "tset", Recipient: "test", Ciphertext: []byte(response)}
+	assert.Equal(t, "3", (<-ch).Id)
+
+	c.CloseJWSResponses("123456")
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestClientJWSRequestAndWait(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	request := `{"payload": "eyJjaWQiOiAiMTIzNDU2In0"}`
+
+	m := &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte(request)}
+
+	go func() {
+		<-s.in
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "tset", Recipient: "test", Ciphertext: []byte(request)}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := c.JWSRequestAndWait(ctx, "123456", m)
+	require.Nil(t, err)
+	assert.Equal(t, []byte(request), resp.Ciphertext)
+	assert.Equal(t, 0, c.Stats().PendingRequests)
+
+	go func() {
+		<-s.in
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err = c.JWSRequestAndWait(ctx, "654321", m)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, c.Stats().PendingRequests)
+}
+
+func testSignedPayload(seed string, payload map[string]interface{}) []byte {
+	pks, _ := base64.RawStdEncoding.DecodeString(seed)
+	pk := ed25519.NewKeyFromSeed(pks)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: pk}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	signedPayload, err := signer.Sign(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return []byte(signedPayload.FullSerialize())
+}
+
+func TestClientReceiveInto(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	payload := testSignedPayload(privkey, map[string]interface{}{
+		"jti": "1",
+		"typ": "test.message",
+		"iss": "test",
+		"cid": "123456",
+		"iat": time.Now().Format(time.RFC3339),
+		"msg": "hello",
+	})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "tset", Ciphertext: payload}
+
+	var v struct {
+		Msg string `json:"msg"`
+	}
+
+	claims, err := c.ReceiveInto(&v)
+	require.Nil(t, err)
+	assert.Equal(t, "hello", v.Msg)
+	assert.Equal(t, "test", claims.Issuer)
+	assert.Equal(t, "123456", claims.ConversationID)
+	assert.Equal(t, "test.message", claims.Type)
+	assert.False(t, claims.IssuedAt.IsZero())
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "other:1", Recipient: "tset", Ciphertext: payload}
+
+	_, err = c.ReceiveInto(&v)
+	assert.EqualError(t, err, "payload issuer does not match sender")
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Recipient: "tset", Ciphertext: payload}
+
+	_, err = c.ReceiveInto(&v)
+	assert.EqualError(t, err, "message has no sender")
+
+	_, otherkey, _ := testToken("test")
+
+	forged := testSignedPayload(otherkey, map[string]interface{}{
+		"jti": "2",
+		"iss": "test",
+		"msg": "hello",
+	})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "tset", Ciphertext: forged}
+
+	_, err = c.ReceiveInto(&v)
+	assert.EqualError(t, err, "payload signature is invalid")
+
+	expired := testSignedPayload(privkey, map[string]interface{}{
+		"jti": "3",
+		"iss": "test",
+		"exp": time.Now().Add(-time.Minute).Format(time.RFC3339),
+		"msg": "hello",
+	})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "tset", Ciphertext: expired}
+
+	_, err = c.ReceiveInto(&v)
+	assert.EqualError(t, err, "payload has expired")
+}
+
+func TestClientReceiveIntoWithoutPublicKeys(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	payload := testSignedPayload(privkey, map[string]interface{}{
+		"jti": "1",
+		"iss": "test",
+	})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "tset", Ciphertext: payload}
+
+	_, err = c.ReceiveInto(nil)
+	assert.EqualError(t, err, "no public key source configured")
+}
+
+func TestClientSendAsync(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	done := make(chan error, 1)
+
+	m := &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte("hello")}
+	c.SendAsync(m, func(err error) {
+		done <- err
+	})
+
+	rm, err := wait(s.in)
+	require.Nil(t, err)
+	assert.Equal(t, m.Id, rm.Id)
+
+	select {
+	case err = <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("callback was not called")
+	}
+}
+
+func TestClientSendAsyncError(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	done := make(chan error, 1)
+
+	m := &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "error", Ciphertext: []byte("hello")}
+	c.SendAsync(m, func(err error) {
+		done <- err
+	})
+
+	select {
+	case err = <-done:
+		assert.EqualError(t, err, "recipient rejected")
+	case <-time.After(time.Second):
+		t.Fatal("callback was not called")
+	}
+
+	c.close()
+
+	c.SendAsync(m, func(err error) {
+		done <- err
+	})
+
+	select {
+	case err = <-done:
+		assert.EqualError(t, err, "connection is closed")
+	case <-time.After(time.Second):
+		t.Fatal("callback was not called")
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	m := &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte("hello")}
+	err = c.Send(m)
+	require.Nil(t, err)
+
+	_, err = wait(s.in)
+	require.Nil(t, err)
+
+	m = &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "error", Ciphertext: []byte("hello")}
+	err = c.Send(m)
+	require.NotNil(t, err)
+
+	s.out <- []byte("garbage")
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte("hello")}
+
+	_, err = c.Receive()
+	require.Nil(t, err)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.MessagesSent)
+	assert.Equal(t, int64(1), stats.MessagesReceived)
+	assert.Equal(t, int64(1), stats.Acks)
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, int64(1), stats.Dropped)
+	assert.Equal(t, int64(0), stats.Reconnects)
+	assert.Equal(t, 0, stats.PendingRequests)
+	assert.True(t, stats.BytesOut > 0)
+	assert.True(t, stats.BytesIn > 0)
+	assert.True(t, stats.Uptime > 0)
+}
+
+func TestClientDeadLetters(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	dlq := NewMemoryDeadLetterQueue(10)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, DeadLetters(dlq))
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	m := &msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "error", Ciphertext: []byte("hello")}
+	err = c.Send(m)
+	require.NotNil(t, err)
+
+	letters := dlq.Drain()
+	require.Len(t, letters, 1)
+	assert.Equal(t, m.Id, letters[0].Message.Id)
+	assert.Equal(t, err, letters[0].Err)
+
+	c.close()
+
+	err = c.Send(m)
+	require.NotNil(t, err)
+	assert.Equal(t, 0, dlq.Len())
+}
+
+func TestMemoryDeadLetterQueue(t *testing.T) {
+	q := NewMemoryDeadLetterQueue(0)
+	require.Nil(t, q.Put(DeadLetter{}))
+	assert.Equal(t, 0, q.Len())
+
+	q = NewMemoryDeadLetterQueue(2)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, q.Put(DeadLetter{Message: &msgproto.Message{Id: strconv.Itoa(i)}}))
+	}
+
+	letters := q.Drain()
+	require.Len(t, letters, 2)
+	assert.Equal(t, "1", letters[0].Message.Id)
+	assert.Equal(t, "2", letters[1].Message.Id)
+}
+
+func TestClientMessages(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		s.out <- &msgproto.Message{Id: strconv.Itoa(i), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var ids []string
+
+	c.Messages(ctx)(func(m *msgproto.Message, err error) bool {
+		require.Nil(t, err)
+		ids = append(ids, m.Id)
+		return len(ids) < 3
+	})
+
+	assert.Equal(t, []string{"0", "1", "2"}, ids)
+}
+
+func TestClientSupervisePanic(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	c.supervise("writer", c.conn, func() {
+		panic("boom")
+	})
+
+	assert.Eventually(t, c.IsClosed, time.Second, time.Millisecond*10)
+}
+
+func TestClientWatchdog(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, Watchdog(time.Millisecond*100))
+	require.Nil(t, err)
+
+	assert.False(t, c.IsClosed())
+	assert.Eventually(t, c.IsClosed, time.Second, time.Millisecond*10)
+}
+
+func TestClientClockSkew(t *testing.T) {
+	c := &Client{clock: systemClock{}}
+
+	now := time.Now()
+	resp := &http.Response{Header: http.Header{}}
+
+	resp.Header.Set("Date", now.Add(time.Minute*5).UTC().Format(http.TimeFormat))
+	c.measureSkew(resp, now, now)
+	assert.InDelta(t, float64(time.Minute*5), float64(c.ClockSkew()), float64(time.Second))
+	assert.InDelta(t, float64(time.Minute*5), float64(c.serverNow().Sub(time.Now())), float64(time.Second*2))
+
+	resp.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	c.measureSkew(resp, now, now)
+	assert.Equal(t, time.Duration(0), c.ClockSkew())
+}
+
+func TestClientErrors(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	handled := make(chan error, 1)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, OnError(func(err error) {
+		handled <- err
+	}))
+	require.Nil(t, err)
+
+	s.out <- []byte("garbage")
+
+	select {
+	case err = <-c.Errors():
+		assert.Contains(t, err.Error(), "failed to decode frame header")
+	case <-time.After(time.Second):
+		t.Fatal("error was not published")
+	}
+
+	select {
+	case err = <-handled:
+		assert.Contains(t, err.Error(), "failed to decode frame header")
+	case <-time.After(time.Second):
+		t.Fatal("error handler was not called")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), MaxRetries(3), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.disconnect()
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	}, time.Second*2, time.Millisecond*10)
+
+	err = c.Send(&msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"})
+	require.Nil(t, err)
+
+	_, err = wait(s.in)
+	require.Nil(t, err)
+}
+
+func TestClientCloseWithReason(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	err = c.CloseWithReason(websocket.CloseGoingAway, "shutting down")
+	require.Nil(t, err)
+	assert.True(t, c.IsClosed())
+
+	select {
+	case ce := <-s.closes:
+		assert.Equal(t, websocket.CloseGoingAway, ce.Code)
+		assert.Equal(t, "shutting down", ce.Text)
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive close frame")
+	}
+
+	err = c.CloseWithReason(websocket.CloseNormalClosure, "")
+	assert.NotNil(t, err)
+}
+
+func TestClientServerClose(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, OnError(func(error) {}))
+	require.Nil(t, err)
+
+	s.shutdown(websocket.CloseTryAgainLater, "maintenance")
+
+	select {
+	case err := <-c.Errors():
+		var ce *CloseError
+		require.True(t, errors.As(err, &ce))
+		assert.Equal(t, websocket.CloseTryAgainLater, ce.Code)
+		assert.Equal(t, "maintenance", ce.Reason)
+	case <-time.After(time.Second):
+		t.Fatal("close was not reported")
+	}
+
+	assert.Eventually(t, c.IsClosed, time.Second, time.Millisecond*10)
+}
+
+func TestClientSessionReplaced(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), RetryInterval(time.Millisecond*10), ReconnectOnSessionReplaced(false), OnError(func(error) {}))
+	require.Nil(t, err)
+
+	s.shutdown(CloseSessionReplaced, "")
+
+	select {
+	case err := <-c.Errors():
+		assert.Equal(t, ErrSessionReplaced, err)
+	case <-time.After(time.Second):
+		t.Fatal("session replacement was not reported")
+	}
+
+	assert.Eventually(t, c.IsClosed, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+	assert.True(t, c.IsClosed())
+	assert.Equal(t, int64(0), c.Stats().Reconnects)
+
+	c, err = New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), RetryInterval(time.Millisecond*10), OnError(func(error) {}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.shutdown(CloseSessionReplaced, "")
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestClientWithDevice(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, SendBuffer(4))
+	require.Nil(t, err)
+
+	d, err := c.WithDevice("2")
+	require.Nil(t, err)
+	defer d.Close()
+
+	assert.Equal(t, "someID", d.selfID)
+	assert.Equal(t, "2", d.deviceID)
+	assert.Equal(t, 4, cap(d.send))
+	assert.False(t, d.IsClosed())
+}
+
+func TestClientIDGenerator(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	var n int64
+
+	gen := func() string {
+		return "id-" + strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+	}
+
+	frames := make(chan *Frame, 8)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, IDGenerator(gen), FrameTap(func(f *Frame) {
+		frames <- f
+	}))
+	require.Nil(t, err)
+
+	// the first id is the token's jti, the second the authentication request's
+	assert.Equal(t, "id-2", (<-frames).ID)
+	assert.Equal(t, "id-3", c.NewID())
+}
+
+func TestClientMaxMessageSize(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	_, err := New(s.endpoint, "someID", "1", privkey, MaxMessageSize(-1, 0))
+	assert.NotNil(t, err)
+
+	errs := make(chan error, 8)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, MaxMessageSize(1024, 1024), OnError(func(err error) { errs <- err }))
+	require.Nil(t, err)
+
+	err = c.Send(&msgproto.Message{Id: "big", Recipient: "test:1", Ciphertext: make([]byte, 2048)})
+	assert.Equal(t, ErrMessageTooLarge, err)
+
+	err = c.Send(&msgproto.Message{Id: "small", Recipient: "test:1", Ciphertext: make([]byte, 512)})
+	require.Nil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "big", Sender: "test:1", Recipient: "someID:1", Ciphertext: make([]byte, 2048), Offset: 7}
+
+	select {
+	case err := <-errs:
+		assert.True(t, errors.Is(err, ErrMessageTooLarge))
+	case <-time.After(time.Second):
+		t.Fatal("expected read limit error")
+	}
+
+	// the oversized message is skipped without dropping the connection
+	assert.Equal(t, int64(7), atomic.LoadInt64(&c.offset))
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "next", Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hello"), Offset: 8}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "next", m.Id)
+	assert.Equal(t, int64(0), c.Stats().Reconnects)
+}
+
+func TestClientCapabilities(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	// servers that don't advertise capabilities only support acks
+	assert.Equal(t, []string{CapabilityAcks}, c.Capabilities())
+	assert.Equal(t, "", c.ServerProtocolVersion())
+	c.Close()
+
+	s.header = http.Header{}
+	s.header.Set(ProtocolVersionHeader, "2")
+	s.header.Set(CapabilitiesHeader, "acks, Priorities, compression, unknown")
+
+	c, err = New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, []string{CapabilityAcks, CapabilityPriorities}, c.Capabilities())
+	assert.Equal(t, "2", c.ServerProtocolVersion())
+	assert.True(t, c.Supports(CapabilityPriorities))
+	assert.False(t, c.Supports(CapabilityResume))
+	assert.False(t, c.Supports(CapabilityCompression))
+}
+
+func TestClientAcceptSender(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	policy := func(sender, device string) bool {
+		return sender == "alice" && device == "1"
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AcceptSender(policy))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "alice:2", Recipient: "someID:1", Ciphertext: []byte("hi")}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "bob:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "3", Sender: "alice:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "3", m.Id)
+	assert.Equal(t, int64(2), c.Stats().Dropped)
+}
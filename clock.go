@@ -0,0 +1,46 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import "time"
+
+// Clock provides the current time, timers and tickers used by the client.
+// A custom clock can be provided with the WithClock option to control time
+// in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// ntpTime is shared by all clients using the system clock
+var ntpTime = NewTime()
+
+// systemClock uses the system's timers, with the current time corrected by NTP
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return ntpTime.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) Chan() <-chan time.Time {
+	return t.C
+}
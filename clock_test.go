@@ -0,0 +1,100 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manualClock is a clock whose time only moves when advanced
+type manualClock struct {
+	now    time.Time
+	timers []manualTimer
+	mu     sync.Mutex
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Now()}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, manualTimer{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (c *manualClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+// Advance moves the clock forward, firing any timers that expire
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var pending []manualTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+
+	c.timers = pending
+}
+
+func TestClientClock(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	clock := newManualClock()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, WithClock(clock))
+	require.Nil(t, err)
+
+	c.JWSRegister("123456")
+
+	done := make(chan error)
+	go func() {
+		_, err := c.JWSResponse("123456", time.Hour)
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("response returned before the clock advanced")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	clock.Advance(time.Hour)
+
+	select {
+	case err = <-done:
+		assert.EqualError(t, err, "request timed out")
+	case <-time.After(time.Second):
+		t.Fatal("request did not time out")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseError is reported when the server closes the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("connection closed by server with code %d", e.Code)
+	}
+	return fmt.Sprintf("connection closed by server with code %d: %s", e.Code, e.Reason)
+}
+
+// CloseWithReason closes the connection with the given status code and reason.
+// It sends a close frame and waits for the server to echo it, up to the read
+// deadline, before closing the underlying connection
+func (c *Client) CloseWithReason(code int, reason string) error {
+	// control frames carry at most 125 bytes, two of which are the code
+	if len(reason) > 123 {
+		return errors.New("close reason is too long")
+	}
+
+	return c.closeWith(websocket.FormatCloseMessage(code, reason))
+}
+
+func (c *Client) closeWith(data []byte) error {
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	if conn == nil || c.IsClosed() {
+		return errors.New("connection is closed")
+	}
+
+	if !atomic.CompareAndSwapInt32(&conn.closing, 0, 1) {
+		return errors.New("connection is already closing")
+	}
+
+	conn.closewriter <- data
+
+	var err error
+
+	select {
+	case <-conn.readerDone:
+	case <-c.clock.After(c.deadline):
+		err = errors.New("timed out waiting for close acknowledgement")
+	}
+
+	c.teardown(conn, nil)
+
+	return err
+}
@@ -0,0 +1,296 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Command soaktest connects a number of clients to a messaging server,
+// exchanges messages between them and verifies every message arrives
+// intact, reporting throughput, latency and errors. Without an endpoint it
+// runs against a built in server:
+//
+//	soaktest -clients 10 -messages 1000
+//	soaktest -endpoint wss://messaging.example.net -identities identities.json
+//
+// Identities for a real server are read from a JSON file containing a list of
+// {"self_id": "...", "device_id": "...", "private_key": "..."} objects. Each
+// client sends its messages to the next client in the list.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// Identity is an identity a client connects as
+type Identity struct {
+	SelfID     string `json:"self_id"`
+	DeviceID   string `json:"device_id"`
+	PrivateKey string `json:"private_key"`
+}
+
+type config struct {
+	endpoint   string
+	identities []Identity
+	clients    int
+	messages   int
+	size       int
+	timeout    time.Duration
+}
+
+type result struct {
+	sent       int64
+	received   int64 // unique messages received
+	duplicates int64
+	corrupted  int64
+	errors     int64
+	duration   time.Duration
+	latencies  []time.Duration
+}
+
+func main() {
+	var cfg config
+	var identities string
+
+	flag.StringVar(&cfg.endpoint, "endpoint", "", "messaging server to test, or empty to use a built in server")
+	flag.StringVar(&identities, "identities", "", "JSON file of identities to connect as, required with -endpoint")
+	flag.IntVar(&cfg.clients, "clients", 10, "number of clients to connect with the built in server")
+	flag.IntVar(&cfg.messages, "messages", 100, "number of messages each client sends")
+	flag.IntVar(&cfg.size, "size", 256, "size of each message's payload in bytes")
+	flag.DurationVar(&cfg.timeout, "timeout", time.Minute, "time to wait for all messages to arrive")
+	flag.Parse()
+
+	if identities != "" {
+		data, err := ioutil.ReadFile(identities)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = json.Unmarshal(data, &cfg.identities)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	r, err := run(cfg)
+	if err != nil {
+		fatal(err)
+	}
+
+	r.report(os.Stdout)
+
+	if r.received != r.sent || r.corrupted > 0 || r.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "soaktest:", err)
+	os.Exit(2)
+}
+
+func run(cfg config) (*result, error) {
+	if cfg.endpoint == "" {
+		srv := newRelay()
+		defer srv.close()
+
+		cfg.endpoint = srv.endpoint()
+
+		if cfg.identities == nil {
+			for i := 0; i < cfg.clients; i++ {
+				key, _ := newKey()
+				cfg.identities = append(cfg.identities, Identity{SelfID: "soak" + strconv.Itoa(i), DeviceID: "1", PrivateKey: key})
+			}
+		}
+	}
+
+	if len(cfg.identities) < 2 {
+		return nil, errors.New("at least two identities are required")
+	}
+
+	if cfg.size < 40 {
+		return nil, errors.New("payloads must be at least 40 bytes")
+	}
+
+	clients := make([]*messaging.Client, len(cfg.identities))
+
+	for i, id := range cfg.identities {
+		c, err := messaging.New(cfg.endpoint, id.SelfID, id.DeviceID, id.PrivateKey, messaging.ReceiveBuffer(cfg.messages), messaging.OnError(func(error) {}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect %s: %w", id.SelfID, err)
+		}
+
+		defer c.Close()
+
+		clients[i] = c
+	}
+
+	var r result
+	var mu sync.Mutex
+	var receivers sync.WaitGroup
+
+	expected := int64(len(clients) * cfg.messages)
+	seen := make(map[string]bool)
+	done := make(chan struct{})
+
+	var finished sync.Once
+	finish := func() { finished.Do(func() { close(done) }) }
+
+	// every receiver stops at the timeout, even if messages were lost
+	timeout := time.AfterFunc(cfg.timeout, finish)
+	defer timeout.Stop()
+
+	for _, c := range clients {
+		receivers.Add(1)
+
+		go func(c *messaging.Client) {
+			defer receivers.Done()
+
+			for {
+				select {
+				case m := <-c.ReceiveChan():
+					latency, ok := verify(m.Ciphertext)
+
+					mu.Lock()
+					duplicate := seen[m.Id]
+					seen[m.Id] = true
+					if ok && !duplicate {
+						r.latencies = append(r.latencies, latency)
+					}
+					mu.Unlock()
+
+					if duplicate {
+						atomic.AddInt64(&r.duplicates, 1)
+						continue
+					}
+
+					if !ok {
+						atomic.AddInt64(&r.corrupted, 1)
+					}
+
+					if atomic.AddInt64(&r.received, 1) >= atomic.LoadInt64(&expected) {
+						finish()
+					}
+				case <-done:
+					return
+				}
+			}
+		}(c)
+	}
+
+	start := time.Now()
+
+	var senders sync.WaitGroup
+
+	for i, c := range clients {
+		senders.Add(1)
+
+		go func(c *messaging.Client, to Identity) {
+			defer senders.Done()
+
+			sender := c.SelfID() + ":" + c.DeviceID()
+
+			for n := 0; n < cfg.messages; n++ {
+				m := &msgproto.Message{
+					Id:         c.NewID(),
+					Type:       msgproto.MsgType_MSG,
+					Sender:     sender,
+					Recipient:  to.SelfID + ":" + to.DeviceID,
+					Ciphertext: payload(cfg.size),
+				}
+
+				err := c.Send(m)
+				if err != nil {
+					atomic.AddInt64(&r.errors, 1)
+					continue
+				}
+
+				atomic.AddInt64(&r.sent, 1)
+			}
+		}(c, cfg.identities[(i+1)%len(cfg.identities)])
+	}
+
+	senders.Wait()
+
+	// messages that failed to send will never arrive
+	atomic.StoreInt64(&expected, atomic.LoadInt64(&r.sent))
+
+	if atomic.LoadInt64(&r.received) >= atomic.LoadInt64(&expected) {
+		finish()
+	}
+
+	receivers.Wait()
+
+	r.duration = time.Since(start)
+
+	return &r, nil
+}
+
+// payload returns a payload of size bytes containing the time it was created,
+// random padding and a checksum of both
+func payload(size int) []byte {
+	p := make([]byte, size)
+
+	binary.BigEndian.PutUint64(p, uint64(time.Now().UnixNano()))
+
+	for i := 8; i < size-sha256.Size; i++ {
+		p[i] = byte(i)
+	}
+
+	sum := sha256.Sum256(p[:size-sha256.Size])
+	copy(p[size-sha256.Size:], sum[:])
+
+	return p
+}
+
+// verify checks a payload's checksum and returns how long it took to arrive
+func verify(p []byte) (time.Duration, bool) {
+	if len(p) < 8+sha256.Size {
+		return 0, false
+	}
+
+	sum := sha256.Sum256(p[:len(p)-sha256.Size])
+	if !bytes.Equal(sum[:], p[len(p)-sha256.Size:]) {
+		return 0, false
+	}
+
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(p)))
+
+	return time.Since(sent), true
+}
+
+func (r *result) report(w io.Writer) {
+	fmt.Fprintf(w, "sent:       %d\n", r.sent)
+	fmt.Fprintf(w, "received:   %d\n", r.received)
+	fmt.Fprintf(w, "duplicates: %d\n", r.duplicates)
+	fmt.Fprintf(w, "corrupted:  %d\n", r.corrupted)
+	fmt.Fprintf(w, "errors:     %d\n", r.errors)
+	fmt.Fprintf(w, "duration:   %s\n", r.duration.Round(time.Millisecond))
+
+	if r.duration > 0 {
+		fmt.Fprintf(w, "throughput: %.0f msgs/s\n", float64(r.received)/r.duration.Seconds())
+	}
+
+	if len(r.latencies) == 0 {
+		return
+	}
+
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+
+	for _, p := range []float64{50, 95, 99, 100} {
+		i := int(float64(len(r.latencies)-1) * p / 100)
+		fmt.Fprintf(w, "p%-3.0f        %s\n", p, r.latencies[i].Round(time.Microsecond))
+	}
+}
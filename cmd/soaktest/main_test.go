@@ -0,0 +1,49 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	r, err := run(config{clients: 3, messages: 20, size: 64, timeout: 5 * time.Second})
+	require.Nil(t, err)
+
+	assert.Equal(t, int64(60), r.sent)
+	assert.Equal(t, int64(60), r.received)
+	assert.Equal(t, int64(0), r.corrupted)
+	assert.Equal(t, int64(0), r.duplicates)
+	assert.Len(t, r.latencies, 60)
+
+	var out bytes.Buffer
+	r.report(&out)
+	assert.Contains(t, out.String(), "received:   60")
+
+	_, err = run(config{clients: 1, messages: 1, size: 64})
+	assert.NotNil(t, err)
+}
+
+func TestRunTimeout(t *testing.T) {
+	// every receiver stops at the timeout when messages have not arrived
+	r, err := run(config{clients: 3, messages: 200, size: 64, timeout: time.Millisecond})
+	require.Nil(t, err)
+	assert.Less(t, r.received, r.sent)
+}
+
+func TestVerify(t *testing.T) {
+	p := payload(64)
+
+	_, ok := verify(p)
+	assert.True(t, ok)
+
+	p[10] ^= 1
+
+	_, ok = verify(p)
+	assert.False(t, ok)
+}
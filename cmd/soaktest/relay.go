@@ -0,0 +1,131 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// relay is a minimal messaging server used when no endpoint is given. It
+// acknowledges every frame and forwards messages to their recipient
+type relay struct {
+	s     *httptest.Server
+	conns map[string]*websocket.Conn
+	mu    sync.Mutex
+}
+
+// newRelay starts a relay on a local port
+func newRelay() *relay {
+	r := &relay{conns: make(map[string]*websocket.Conn)}
+	r.s = httptest.NewServer(http.HandlerFunc(r.handler))
+	return r
+}
+
+// endpoint returns the websocket endpoint of the relay
+func (r *relay) endpoint() string {
+	return "ws" + strings.TrimPrefix(r.s.URL, "http")
+}
+
+func (r *relay) write(wc *websocket.Conn, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (r *relay) ack(wc *websocket.Conn, id string) {
+	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: id})
+	r.write(wc, data)
+}
+
+func (r *relay) handler(w http.ResponseWriter, req *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	_, data, err := wc.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var auth msgproto.Auth
+
+	err = proto.Unmarshal(data, &auth)
+	if err != nil {
+		return
+	}
+
+	token, err := jose.ParseSigned(auth.Token)
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	json.Unmarshal(token.UnsafePayloadWithoutVerification(), &claims)
+
+	r.mu.Lock()
+	r.conns[claims.Issuer] = wc
+	r.mu.Unlock()
+
+	r.ack(wc, auth.Id)
+
+	for {
+		_, data, err := wc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return
+		}
+
+		r.ack(wc, m.Id)
+
+		if m.Type != msgproto.MsgType_MSG {
+			continue
+		}
+
+		r.mu.Lock()
+		rc, ok := r.conns[strings.Split(m.Recipient, ":")[0]]
+		r.mu.Unlock()
+
+		if ok {
+			r.write(rc, data)
+		}
+	}
+}
+
+// close shuts down the relay
+func (r *relay) close() {
+	r.s.Close()
+}
+
+// newKey generates a private key encoded as the client expects and its public key
+func newKey() (string, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(priv.Seed()), pub
+}
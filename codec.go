@@ -0,0 +1,292 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Codec encodes and decodes payloads. Payloads encoded with a codec other
+// than JSON carry its name in the cty header of their JWS, so it must be
+// registered with RegisterCodec by the recipient
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// jsonConverter is implemented by codecs that can convert their payloads
+// to JSON without decoding them into Go values first
+type jsonConverter interface {
+	toJSON(data []byte) ([]byte, error)
+}
+
+var (
+	// JSONCodec encodes payloads as JSON
+	JSONCodec Codec = jsonCodec{}
+	// CBORCodec encodes payloads as CBOR, prefixed with the self-describe
+	// tag so they can be told apart from JSON. Values are encoded as
+	// encoding/json would encode them, so json struct tags apply
+	CBORCodec Codec = cborCodec{}
+	// MsgpackCodec encodes payloads as MessagePack. Values are encoded as
+	// encoding/json would encode them, so json struct tags apply
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var codecs = struct {
+	byName map[string]Codec
+	mu     sync.RWMutex
+}{
+	byName: map[string]Codec{
+		"json":    JSONCodec,
+		"cbor":    CBORCodec,
+		"msgpack": MsgpackCodec,
+	},
+}
+
+// RegisterCodec makes a codec available to encode and decode payloads by
+// its name, replacing any codec registered with the same name
+func RegisterCodec(codec Codec) error {
+	if codec == nil || codec.Name() == "" {
+		return errors.New("codec must have a name")
+	}
+
+	codecs.mu.Lock()
+	codecs.byName[codec.Name()] = codec
+	codecs.mu.Unlock()
+
+	return nil
+}
+
+// LookupCodec returns the codec registered with a name
+func LookupCodec(name string) (Codec, bool) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+
+	codec, ok := codecs.byName[name]
+
+	return codec, ok
+}
+
+// CodecOf returns the codec that encoded a payload, given the cty header
+// of its JWS, which may be empty
+func CodecOf(data []byte, contentType string) (Codec, error) {
+	if isCBOR(data) {
+		return CBORCodec, nil
+	}
+
+	if contentType == "" {
+		return JSONCodec, nil
+	}
+
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return nil, errors.New("unknown payload codec " + contentType)
+	}
+
+	return codec, nil
+}
+
+// jsonPayload returns a payload as JSON, converting it if it was encoded
+// with another codec
+func jsonPayload(data []byte, contentType string) ([]byte, error) {
+	codec, err := CodecOf(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec == JSONCodec {
+		return data, nil
+	}
+
+	if jc, ok := codec.(jsonConverter); ok {
+		return jc.toJSON(data)
+	}
+
+	var v interface{}
+
+	err = codec.Unmarshal(data, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// jsonValue decodes a payload encoded by encoding/json, keeping numbers
+// as json.Number so they can be re-encoded without losing precision
+func jsonValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err = dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(cborSelfDescribe)
+
+	err = encodeCBOR(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c cborCodec) Unmarshal(data []byte, v interface{}) error {
+	js, err := c.toJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, v)
+}
+
+// toJSON converts a CBOR payload to JSON. Byte strings are encoded as
+// base64 strings, as encoding/json encodes them
+func (cborCodec) toJSON(data []byte) ([]byte, error) {
+	v, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = encodeMsgpack(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	js, err := c.toJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, v)
+}
+
+// toJSON converts a MessagePack payload to JSON. Binary values are encoded
+// as base64 strings, as encoding/json encodes them
+func (msgpackCodec) toJSON(data []byte) ([]byte, error) {
+	v, err := decodeMsgpack(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// peerCodecs tracks the codecs used to encode payloads for peers
+type peerCodecs struct {
+	set     map[string]Codec
+	learned map[string]Codec
+	mu      sync.Mutex
+}
+
+// learn records the codec a peer encoded a verified payload with
+func (p *peerCodecs) learn(selfID string, codec Codec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.learned == nil {
+		p.learned = make(map[string]Codec)
+	}
+
+	p.learned[selfID] = codec
+}
+
+// SetPeerCodec sets the codec payloads signed for a peer with SignFor and
+// requests to it are encoded with, overriding the codec it last used
+func (c *Client) SetPeerCodec(selfID string, codec Codec) error {
+	if codec == nil {
+		return errors.New("payload codec must not be nil")
+	}
+
+	c.peerCodecs.mu.Lock()
+	defer c.peerCodecs.mu.Unlock()
+
+	if c.peerCodecs.set == nil {
+		c.peerCodecs.set = make(map[string]Codec)
+	}
+
+	c.peerCodecs.set[selfID] = codec
+
+	return nil
+}
+
+// peerCodec returns the codec to encode payloads for a peer with
+func (c *Client) peerCodec(selfID string) Codec {
+	c.peerCodecs.mu.Lock()
+	defer c.peerCodecs.mu.Unlock()
+
+	if codec, ok := c.peerCodecs.set[selfID]; ok {
+		return codec
+	}
+
+	if codec, ok := c.peerCodecs.learned[selfID]; ok {
+		return codec
+	}
+
+	return c.codec
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"encoding/json"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestClientPayloadCodec(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "test", "1", privkey, PublicKeys(keys), PayloadCodec(CBORCodec), MessageTTL(time.Minute))
+	require.Nil(t, err)
+	defer c.Close()
+
+	payload, err := c.Sign(map[string]interface{}{
+		"jti":    "1",
+		"typ":    "sensor.reading",
+		"iss":    "test",
+		"cid":    "123456",
+		"values": []int{20, 21, 22},
+	})
+	require.Nil(t, err)
+
+	jws, err := jose.ParseSigned(string(payload))
+	require.Nil(t, err)
+
+	raw, err := jws.Verify(pubkey)
+	require.Nil(t, err)
+	assert.True(t, isCBOR(raw))
+
+	env, err := parseEnvelope(payload)
+	require.Nil(t, err)
+	assert.Equal(t, "sensor.reading", env.typ)
+	assert.False(t, env.expires.IsZero())
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "test:1", Ciphertext: payload}
+
+	var v struct {
+		Values []int `json:"values"`
+	}
+
+	claims, err := c.ReceiveInto(&v)
+	require.Nil(t, err)
+	assert.Equal(t, "sensor.reading", claims.Type)
+	assert.Equal(t, "123456", claims.ConversationID)
+	assert.Equal(t, []int{20, 21, 22}, v.Values)
+}
+
+// reversedCodec is a codec without a fast path to json
+type reversedCodec struct{}
+
+func (reversedCodec) Name() string {
+	return "reversed"
+}
+
+func (reversedCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return reverse(data), err
+}
+
+func (reversedCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(reverse(data), v)
+}
+
+func reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.NotNil(t, RegisterCodec(nil))
+
+	_, err := CodecOf([]byte("}{"), "reversed")
+	assert.NotNil(t, err)
+
+	require.Nil(t, RegisterCodec(reversedCodec{}))
+
+	codec, ok := LookupCodec("reversed")
+	require.True(t, ok)
+
+	data, err := codec.Marshal(map[string]int{"a": 1})
+	require.Nil(t, err)
+
+	js, err := jsonPayload(data, "reversed")
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(js))
+}
+
+func TestClientPeerCodec(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "test", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+	defer c.Close()
+
+	payload, err := c.SignWithCodec(MsgpackCodec, map[string]interface{}{"jti": "1", "typ": "sensor.reading", "iss": "test", "value": 21})
+	require.Nil(t, err)
+
+	jws, err := jose.ParseSigned(string(payload))
+	require.Nil(t, err)
+	assert.Equal(t, "msgpack", jws.Signatures[0].Protected.ExtraHeaders[jose.HeaderContentType])
+
+	env, err := parseEnvelope(payload)
+	require.Nil(t, err)
+	assert.Equal(t, "sensor.reading", env.typ)
+
+	// payloads for a peer use json until it is known to use another codec
+	assert.Equal(t, Codec(nil), c.peerCodec("test"))
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "test:1", Ciphertext: payload}
+
+	var v struct {
+		Value int `json:"value"`
+	}
+
+	_, err = c.ReceiveInto(&v)
+	require.Nil(t, err)
+	assert.Equal(t, 21, v.Value)
+
+	// the peer used msgpack, so replies to it do too
+	assert.Equal(t, MsgpackCodec, c.peerCodec("test"))
+
+	reply, err := c.SignFor("test", map[string]interface{}{"typ": "sensor.ack"})
+	require.Nil(t, err)
+
+	jws, err = jose.ParseSigned(string(reply))
+	require.Nil(t, err)
+	assert.Equal(t, "msgpack", jws.Signatures[0].Protected.ExtraHeaders[jose.HeaderContentType])
+
+	require.Nil(t, c.SetPeerCodec("test", CBORCodec))
+	assert.Equal(t, CBORCodec, c.peerCodec("test"))
+
+	_, payload, err = c.SignRequest("sensor.req", "test", time.Minute, nil)
+	require.Nil(t, err)
+
+	raw, err := jose.ParseSigned(string(payload))
+	require.Nil(t, err)
+
+	data, err := raw.Verify(pubkey)
+	require.Nil(t, err)
+	assert.True(t, isCBOR(data))
+}
@@ -0,0 +1,281 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config configures a client created with NewFromConfig. It can be decoded
+// from JSON or YAML, or read from the environment with ConfigFromEnv. Zero
+// values use the client's defaults
+type Config struct {
+	Endpoint          string          `json:"endpoint" yaml:"endpoint"`
+	SelfID            string          `json:"self_id" yaml:"self_id"`
+	DeviceID          string          `json:"device_id" yaml:"device_id"`
+	PrivateKey        string          `json:"private_key" yaml:"private_key"`
+	RequestTimeout    Duration        `json:"request_timeout" yaml:"request_timeout"`
+	ReadDeadline      Duration        `json:"read_deadline" yaml:"read_deadline"`
+	SendBuffer        int             `json:"send_buffer" yaml:"send_buffer"`
+	ReceiveBuffer     int             `json:"receive_buffer" yaml:"receive_buffer"`
+	JWSResponseBuffer int             `json:"jws_response_buffer" yaml:"jws_response_buffer"`
+	TLS               TLSFiles        `json:"tls" yaml:"tls"`
+	Reconnect         ReconnectPolicy `json:"reconnect" yaml:"reconnect"`
+}
+
+// TLSFiles configures TLS with certificates and keys read from PEM files
+type TLSFiles struct {
+	CAFile             string `json:"ca_file" yaml:"ca_file"`
+	CertFile           string `json:"cert_file" yaml:"cert_file"`
+	KeyFile            string `json:"key_file" yaml:"key_file"`
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// ReconnectPolicy configures reconnecting after the connection is lost
+type ReconnectPolicy struct {
+	Enabled             bool     `json:"enabled" yaml:"enabled"`
+	KeepSessionReplaced bool     `json:"keep_session_replaced" yaml:"keep_session_replaced"`
+	MaxRetries          int      `json:"max_retries" yaml:"max_retries"`
+	Interval            Duration `json:"interval" yaml:"interval"`
+}
+
+// Duration is a time.Duration that is decoded from a string such as "10s",
+// or from a number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON decodes a duration from a string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if len(data) > 0 && data[0] == '"' {
+		err := json.Unmarshal(data, &s)
+		if err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalYAML decodes a duration from a string or a number of seconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+// MarshalJSON encodes a duration as a string such as "10s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) parse(s string) error {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// ConfigFromEnv reads a config from environment variables named with the
+// prefix followed by the upper case JSON field name, such as SELF_ENDPOINT
+// or SELF_RECONNECT_MAX_RETRIES for the prefix "SELF_". Unset variables
+// are left at their zero value
+func ConfigFromEnv(prefix string) (Config, error) {
+	var cfg Config
+
+	strs := map[string]*string{
+		"ENDPOINT":        &cfg.Endpoint,
+		"SELF_ID":         &cfg.SelfID,
+		"DEVICE_ID":       &cfg.DeviceID,
+		"PRIVATE_KEY":     &cfg.PrivateKey,
+		"TLS_CA_FILE":     &cfg.TLS.CAFile,
+		"TLS_CERT_FILE":   &cfg.TLS.CertFile,
+		"TLS_KEY_FILE":    &cfg.TLS.KeyFile,
+		"TLS_SERVER_NAME": &cfg.TLS.ServerName,
+	}
+
+	ints := map[string]*int{
+		"SEND_BUFFER":           &cfg.SendBuffer,
+		"RECEIVE_BUFFER":        &cfg.ReceiveBuffer,
+		"JWS_RESPONSE_BUFFER":   &cfg.JWSResponseBuffer,
+		"RECONNECT_MAX_RETRIES": &cfg.Reconnect.MaxRetries,
+	}
+
+	bools := map[string]*bool{
+		"TLS_INSECURE_SKIP_VERIFY":        &cfg.TLS.InsecureSkipVerify,
+		"RECONNECT_ENABLED":               &cfg.Reconnect.Enabled,
+		"RECONNECT_KEEP_SESSION_REPLACED": &cfg.Reconnect.KeepSessionReplaced,
+	}
+
+	durations := map[string]*Duration{
+		"REQUEST_TIMEOUT":    &cfg.RequestTimeout,
+		"READ_DEADLINE":      &cfg.ReadDeadline,
+		"RECONNECT_INTERVAL": &cfg.Reconnect.Interval,
+	}
+
+	for name, v := range strs {
+		if s, ok := os.LookupEnv(prefix + name); ok {
+			*v = s
+		}
+	}
+
+	for name, v := range ints {
+		if s, ok := os.LookupEnv(prefix + name); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s%s: %w", prefix, name, err)
+			}
+			*v = n
+		}
+	}
+
+	for name, v := range bools {
+		if s, ok := os.LookupEnv(prefix + name); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(s))
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s%s: %w", prefix, name, err)
+			}
+			*v = b
+		}
+	}
+
+	for name, v := range durations {
+		if s, ok := os.LookupEnv(prefix + name); ok {
+			err := v.parse(strings.TrimSpace(s))
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s%s: %w", prefix, name, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Options returns the options that apply the config
+func (cfg Config) Options() ([]func(c *Client) error, error) {
+	var opts []func(c *Client) error
+
+	if cfg.RequestTimeout > 0 {
+		opts = append(opts, RequestTimeout(time.Duration(cfg.RequestTimeout)))
+	}
+
+	if cfg.ReadDeadline > 0 {
+		opts = append(opts, ReadDeadline(time.Duration(cfg.ReadDeadline)))
+	}
+
+	if cfg.SendBuffer > 0 {
+		opts = append(opts, SendBuffer(cfg.SendBuffer))
+	}
+
+	if cfg.ReceiveBuffer > 0 {
+		opts = append(opts, ReceiveBuffer(cfg.ReceiveBuffer))
+	}
+
+	if cfg.JWSResponseBuffer > 0 {
+		opts = append(opts, JWSResponseBuffer(cfg.JWSResponseBuffer))
+	}
+
+	tlsConfig, err := cfg.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		opts = append(opts, TLSConfig(tlsConfig))
+	}
+
+	if cfg.Reconnect.Enabled {
+		opts = append(opts, AutoReconnect(true), ReconnectOnSessionReplaced(!cfg.Reconnect.KeepSessionReplaced))
+	}
+
+	if cfg.Reconnect.MaxRetries > 0 {
+		opts = append(opts, MaxRetries(cfg.Reconnect.MaxRetries))
+	}
+
+	if cfg.Reconnect.Interval > 0 {
+		opts = append(opts, RetryInterval(time.Duration(cfg.Reconnect.Interval)))
+	}
+
+	return opts, nil
+}
+
+// config loads the TLS files, returning nil if none are configured
+func (f TLSFiles) config() (*tls.Config, error) {
+	if f == (TLSFiles{}) {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         f.ServerName,
+		InsecureSkipVerify: f.InsecureSkipVerify,
+	}
+
+	if f.CAFile != "" {
+		pem, err := ioutil.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + f.CAFile)
+		}
+	}
+
+	if f.CertFile != "" || f.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// NewFromConfig creates a new client from a config. Any options are
+// applied after the config's, so they take precedence
+func NewFromConfig(cfg Config, opts ...func(*Client) error) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("config has no endpoint")
+	}
+
+	if cfg.SelfID == "" || cfg.DeviceID == "" || cfg.PrivateKey == "" {
+		return nil, errors.New("config must have a self id, device id and private key")
+	}
+
+	copts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cfg.Endpoint, cfg.SelfID, cfg.DeviceID, cfg.PrivateKey, append(copts, opts...)...)
+}
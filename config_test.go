@@ -0,0 +1,102 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigJSON(t *testing.T) {
+	data := `{
+		"endpoint": "wss://messaging.example.com",
+		"self_id": "someID",
+		"device_id": "1",
+		"request_timeout": "5s",
+		"read_deadline": 30,
+		"send_buffer": 256,
+		"reconnect": {"enabled": true, "max_retries": 3, "interval": "1m"}
+	}`
+
+	var cfg Config
+
+	err := json.Unmarshal([]byte(data), &cfg)
+	require.Nil(t, err)
+
+	assert.Equal(t, "wss://messaging.example.com", cfg.Endpoint)
+	assert.Equal(t, Duration(5*time.Second), cfg.RequestTimeout)
+	assert.Equal(t, Duration(30*time.Second), cfg.ReadDeadline)
+	assert.Equal(t, 256, cfg.SendBuffer)
+	assert.True(t, cfg.Reconnect.Enabled)
+	assert.Equal(t, 3, cfg.Reconnect.MaxRetries)
+	assert.Equal(t, Duration(time.Minute), cfg.Reconnect.Interval)
+
+	err = json.Unmarshal([]byte(`{"request_timeout": "soon"}`), &cfg)
+	assert.NotNil(t, err)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"TEST_SELF_ENDPOINT":          "wss://messaging.example.com",
+		"TEST_SELF_SELF_ID":           "someID",
+		"TEST_SELF_SEND_BUFFER":       "64",
+		"TEST_SELF_RECONNECT_ENABLED": "true",
+		"TEST_SELF_REQUEST_TIMEOUT":   "2s",
+	}
+
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	cfg, err := ConfigFromEnv("TEST_SELF_")
+	require.Nil(t, err)
+
+	assert.Equal(t, "wss://messaging.example.com", cfg.Endpoint)
+	assert.Equal(t, "someID", cfg.SelfID)
+	assert.Equal(t, 64, cfg.SendBuffer)
+	assert.True(t, cfg.Reconnect.Enabled)
+	assert.Equal(t, Duration(2*time.Second), cfg.RequestTimeout)
+
+	os.Setenv("TEST_SELF_SEND_BUFFER", "lots")
+
+	_, err = ConfigFromEnv("TEST_SELF_")
+	assert.NotNil(t, err)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	_, err := NewFromConfig(Config{Endpoint: s.endpoint})
+	assert.NotNil(t, err)
+
+	_, err = NewFromConfig(Config{
+		Endpoint:   s.endpoint,
+		SelfID:     "someID",
+		DeviceID:   "1",
+		PrivateKey: privkey,
+		TLS:        TLSFiles{CAFile: "missing.pem"},
+	})
+	assert.NotNil(t, err)
+
+	c, err := NewFromConfig(Config{
+		Endpoint:       s.endpoint,
+		SelfID:         "someID",
+		DeviceID:       "1",
+		PrivateKey:     privkey,
+		RequestTimeout: Duration(5 * time.Second),
+		SendBuffer:     16,
+	}, ReadDeadline(time.Minute))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, 5*time.Second, c.timeout)
+	assert.Equal(t, time.Minute, c.deadline)
+	assert.Equal(t, 16, cap(c.send))
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	DefaultConsumerRetries = 3
+	DefaultConsumerBackoff = time.Second
+)
+
+// Consume receives messages and dispatches them to a pool of workers.
+// Messages from the same sender are always handled by the same worker,
+// so they are processed in the order they were received. A handler that
+// returns an error is retried with a backoff, as configured with the
+// ConsumerRetries option, before the message is dropped. Messages that
+// are handled successfully are acknowledged with Ack.
+// Consume blocks until the context is cancelled or the connection is
+// closed, or Handover is called, then waits for the workers to finish
+// their current messages
+func (c *Client) Consume(ctx context.Context, workers int, handler func(*msgproto.Message) error) error {
+	if workers < 1 {
+		return errors.New("consumer requires at least one worker")
+	}
+
+	atomic.AddInt32(&c.consumers, 1)
+	defer atomic.AddInt32(&c.consumers, -1)
+
+	if c.handedOver() {
+		return ErrHandedOver
+	}
+
+	queues := make([]chan *msgproto.Message, workers)
+
+	var wg sync.WaitGroup
+
+	for i := range queues {
+		queues[i] = make(chan *msgproto.Message, DefaultBufferSize)
+
+		wg.Add(1)
+		go func(q chan *msgproto.Message) {
+			defer wg.Done()
+			pprof.Do(ctx, c.labels("consumer"), func(ctx context.Context) {
+				for m := range q {
+					c.handle(ctx, m, handler)
+				}
+			})
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.handingOver:
+			return ErrHandedOver
+		case m := <-c.recv:
+			c.checkReceiveQueue()
+			select {
+			case queues[partition(m.Sender, workers)] <- m:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-c.clock.After(time.Second):
+			if c.IsClosed() {
+				return errors.New("connection is closed")
+			}
+		}
+	}
+}
+
+func (c *Client) handle(ctx context.Context, m *msgproto.Message, handler func(*msgproto.Message) error) {
+	var err error
+
+	for attempt := 0; attempt <= c.consumerRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-c.clock.After(c.consumerBackoff * time.Duration(attempt)):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err = handler(m)
+		if err == nil {
+			err = c.Ack(m)
+			if err != nil {
+				c.report(fmt.Errorf("failed to acknowledge message: %w", err))
+			}
+			return
+		}
+	}
+
+	c.dropped(m.Id, fmt.Errorf("dropping message after failed retries: %w", err))
+}
+
+// partition maps a sender to a worker
+func partition(sender string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(sender))
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientConsume(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ConsumerRetries(2, time.Millisecond))
+	require.Nil(t, err)
+
+	senders := []string{"alice:1", "bob:1", "carol:1"}
+
+	for i := 0; i < 10; i++ {
+		for _, sender := range senders {
+			s.out <- &msgproto.Message{Id: strconv.Itoa(i), Type: msgproto.MsgType_MSG, Sender: sender, Recipient: "tset"}
+		}
+	}
+
+	var mu sync.Mutex
+	var failed bool
+	received := make(map[string][]string)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- c.Consume(ctx, 4, func(m *msgproto.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if m.Sender == "bob:1" && m.Id == "5" && !failed {
+				failed = true
+				return errors.New("temporary failure")
+			}
+
+			received[m.Sender] = append(received[m.Sender], m.Id)
+
+			if len(received["alice:1"])+len(received["bob:1"])+len(received["carol:1"]) == 30 {
+				cancel()
+			}
+
+			return nil
+		})
+	}()
+
+	select {
+	case err = <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not finish")
+	}
+
+	for _, sender := range senders {
+		require.Len(t, received[sender], 10)
+		for i, id := range received[sender] {
+			assert.Equal(t, strconv.Itoa(i), id)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// ErrConversationClosed is returned when using a conversation after it has been closed
+var ErrConversationClosed = errors.New("conversation is closed")
+
+// Conversation is a multi-step exchange with a peer, identified by the cid
+// claim of its payloads. Messages received in the conversation are
+// delivered to it instead of Receive until it is closed
+type Conversation struct {
+	ID       string
+	client   *Client
+	peer     string
+	messages <-chan *msgproto.Message
+	history  []*msgproto.Message
+	closed   bool
+	mu       sync.Mutex
+}
+
+// StartConversation starts a conversation with recipient under a new conversation id
+func (c *Client) StartConversation(recipient string) *Conversation {
+	return c.conversation(c.NewID(), recipient)
+}
+
+// JoinConversation continues the conversation of a received message, which
+// must have a cid claim issued by its sender. The message is the first in
+// the conversation's history
+func (c *Client) JoinConversation(m *msgproto.Message) (*Conversation, error) {
+	env, err := c.envelope(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.conversationID == "" {
+		return nil, errors.New("message has no conversation id")
+	}
+
+	if m.Sender == "" || selfIDOf(m.Sender) != env.issuer {
+		return nil, errors.New("payload issuer does not match sender")
+	}
+
+	cv := c.conversation(env.conversationID, m.Sender)
+	cv.history = append(cv.history, m)
+
+	return cv, nil
+}
+
+func (c *Client) conversation(cid, peer string) *Conversation {
+	return &Conversation{
+		ID:       cid,
+		client:   c,
+		peer:     peer,
+		messages: c.requests.registerJWS(cid, c.jwsBuffer),
+	}
+}
+
+// Send signs a payload in the conversation and sends it to the peer's
+// device that last sent a message in it. The payload must have a typ claim,
+// and the iss, jti, cid, iat, exp, sub and aud claims are set
+func (cv *Conversation) Send(payload map[string]interface{}) error {
+	typ, _ := payload["typ"].(string)
+	if typ == "" {
+		return errors.New("conversation payload has no typ")
+	}
+
+	cv.mu.Lock()
+	peer, closed := cv.peer, cv.closed
+	cv.mu.Unlock()
+
+	if closed {
+		return ErrConversationClosed
+	}
+
+	signed, err := cv.client.signConversation(typ, cv.ID, selfIDOf(peer), DefaultRequestExpiry, conversationFields(payload))
+	if err != nil {
+		return err
+	}
+
+	m := cv.client.RequestMessage(peer, signed)
+
+	err = cv.client.Send(m)
+	if err != nil {
+		return err
+	}
+
+	cv.mu.Lock()
+	cv.history = append(cv.history, m)
+	cv.mu.Unlock()
+
+	return nil
+}
+
+// Next waits for the next message in the conversation. Its payload is
+// verified with the PublicKeys option and must be issued by the peer
+func (cv *Conversation) Next(ctx context.Context) (*msgproto.Message, error) {
+	var m *msgproto.Message
+	var ok bool
+
+	select {
+	case m, ok = <-cv.messages:
+		if !ok {
+			return nil, ErrConversationClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	claims, err := cv.client.DecodePayload(m, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	if claims.Issuer != selfIDOf(cv.peer) {
+		return nil, errors.New("message is not from the conversation's peer")
+	}
+
+	// replies go to the device the peer is using
+	cv.peer = m.Sender
+	cv.history = append(cv.history, m)
+
+	return m, nil
+}
+
+// Messages returns the messages sent and received in the conversation, in order
+func (cv *Conversation) Messages() []*msgproto.Message {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	return append([]*msgproto.Message(nil), cv.history...)
+}
+
+// Close stops delivering messages to the conversation. Later messages in
+// it are received with Receive
+func (cv *Conversation) Close() {
+	cv.mu.Lock()
+	closed := cv.closed
+	cv.closed = true
+	cv.mu.Unlock()
+
+	if !closed {
+		cv.client.requests.closeJWS(cv.ID)
+	}
+}
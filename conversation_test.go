@@ -0,0 +1,121 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientConversation(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cv := c.StartConversation("user:1")
+
+	reply := func(sender, issuer, typ string) {
+		s.out <- &msgproto.Message{
+			Type:       msgproto.MsgType_MSG,
+			Id:         c.NewID(),
+			Sender:     sender,
+			Recipient:  "someID:1",
+			Ciphertext: testSignedPayload(privkey, map[string]interface{}{"jti": c.NewID(), "typ": typ, "iss": issuer, "cid": cv.ID}),
+		}
+	}
+
+	require.Nil(t, cv.Send(map[string]interface{}{"typ": "offer", "price": 10}))
+
+	offer := <-s.in
+	assert.Equal(t, "user:1", offer.Recipient)
+
+	env, err := parseEnvelope(offer.Ciphertext)
+	require.Nil(t, err)
+	assert.Equal(t, cv.ID, env.conversationID)
+
+	// the counter offer comes from another of the peer's devices
+	reply("user:2", "user", "counter")
+
+	m, err := cv.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "user:2", m.Sender)
+
+	require.Nil(t, cv.Send(map[string]interface{}{"typ": "accept"}))
+
+	accept := <-s.in
+	assert.Equal(t, "user:2", accept.Recipient)
+
+	reply("other:1", "other", "counter")
+
+	_, err = cv.Next(ctx)
+	assert.EqualError(t, err, "message is not from the conversation's peer")
+
+	history := cv.Messages()
+	require.Len(t, history, 3)
+	assert.Equal(t, offer.Id, history[0].Id)
+	assert.Equal(t, m.Id, history[1].Id)
+	assert.Equal(t, accept.Id, history[2].Id)
+
+	cv.Close()
+
+	_, err = cv.Next(ctx)
+	assert.Equal(t, ErrConversationClosed, err)
+	assert.Equal(t, ErrConversationClosed, cv.Send(map[string]interface{}{"typ": "accept"}))
+
+	// messages are received as usual once the conversation is closed
+	reply("user:1", "user", "counter")
+
+	m, err = c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "user:1", m.Sender)
+}
+
+func TestClientJoinConversation(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.out <- &msgproto.Message{
+		Type:       msgproto.MsgType_MSG,
+		Id:         "1",
+		Sender:     "user:7",
+		Recipient:  "someID:1",
+		Ciphertext: testSignedPayload(privkey, map[string]interface{}{"jti": "o1", "typ": "offer", "iss": "user", "cid": "conversation-1"}),
+	}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+
+	cv, err := c.JoinConversation(m)
+	require.Nil(t, err)
+	defer cv.Close()
+
+	assert.Equal(t, "conversation-1", cv.ID)
+	require.Nil(t, cv.Send(map[string]interface{}{"typ": "counter"}))
+
+	counter := <-s.in
+	assert.Equal(t, "user:7", counter.Recipient)
+	assert.Len(t, cv.Messages(), 2)
+
+	_, err = c.JoinConversation(&msgproto.Message{Sender: "user:7", Ciphertext: testSignedPayload(privkey, map[string]interface{}{"typ": "offer", "iss": "user"})})
+	assert.EqualError(t, err, "message has no conversation id")
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Credentials identify and authenticate a client
+type Credentials struct {
+	SelfID     string
+	DeviceID   string
+	PrivateKey string
+}
+
+// CredentialSource loads credentials, such as from the environment or files
+type CredentialSource interface {
+	Load() (Credentials, error)
+}
+
+// EnvCredentials loads credentials from the environment variables
+// SELF_ID, DEVICE_ID and PRIVATE_KEY, each preceded by Prefix
+type EnvCredentials struct {
+	Prefix string
+}
+
+// Load reads the credentials from the environment
+func (e EnvCredentials) Load() (Credentials, error) {
+	creds := Credentials{
+		SelfID:     os.Getenv(e.Prefix + "SELF_ID"),
+		DeviceID:   os.Getenv(e.Prefix + "DEVICE_ID"),
+		PrivateKey: os.Getenv(e.Prefix + "PRIVATE_KEY"),
+	}
+
+	return creds, creds.validate()
+}
+
+// FileCredentials loads credentials from the files in a directory named
+// self_id, device_id and private_key, which is how Kubernetes mounts the
+// keys of a secret
+type FileCredentials struct {
+	Dir string
+}
+
+// Load reads the credentials from their files
+func (f FileCredentials) Load() (Credentials, error) {
+	var creds Credentials
+
+	values := map[string]*string{
+		"self_id":     &creds.SelfID,
+		"device_id":   &creds.DeviceID,
+		"private_key": &creds.PrivateKey,
+	}
+
+	for name, v := range values {
+		data, err := ioutil.ReadFile(filepath.Join(f.Dir, name))
+		if err != nil {
+			return creds, err
+		}
+		*v = strings.TrimSpace(string(data))
+	}
+
+	return creds, creds.validate()
+}
+
+func (creds Credentials) validate() error {
+	if creds.SelfID == "" || creds.DeviceID == "" || creds.PrivateKey == "" {
+		return errors.New("credentials must have a self id, device id and private key")
+	}
+
+	return validateKey(creds.PrivateKey)
+}
+
+// validateKey checks that a private key is a base64 encoded ed25519 seed
+func validateKey(key string) error {
+	seed, err := base64.RawStdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return errors.New("invalid private key length")
+	}
+
+	return nil
+}
+
+// NewFromCredentials creates a new client with credentials loaded from a source
+func NewFromCredentials(endpoint string, src CredentialSource, opts ...func(*Client) error) (*Client, error) {
+	creds, err := src.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(endpoint, creds.SelfID, creds.DeviceID, creds.PrivateKey, opts...)
+}
+
+// key returns the client's current private key
+func (c *Client) key() string {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+
+	return c.privateKey
+}
+
+// RotateKey replaces the key the client signs payloads and tokens with.
+// The current connection is kept, and the new key is used the next time
+// the client authenticates. Clients created with the SigningKey option keep
+// signing with that key
+func (c *Client) RotateKey(privateKey string) error {
+	err := validateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	c.keyMu.Lock()
+	c.privateKey = privateKey
+	c.keyMu.Unlock()
+
+	// sessions authenticated with the old key are not resumed
+	c.resumption.set("")
+
+	return nil
+}
+
+// WatchCredentials reloads credentials from a source every interval, and
+// whenever a value is received on changed, rotating the client's key when
+// it changes. changed may be nil, or fed by a file system watcher such as
+// fsnotify to pick up changes without waiting for the interval. Credentials
+// for another identity or device are reported as errors and ignored.
+// WatchCredentials blocks until the context is done
+func (c *Client) WatchCredentials(ctx context.Context, src CredentialSource, interval time.Duration, changed <-chan struct{}) error {
+	if interval <= 0 {
+		return errors.New("credential reload interval must be positive")
+	}
+
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.Chan():
+		case <-changed:
+		}
+
+		err := c.reloadCredentials(src)
+		if err != nil {
+			c.report(fmt.Errorf("failed to reload credentials: %w", err))
+		}
+	}
+}
+
+func (c *Client) reloadCredentials(src CredentialSource) error {
+	creds, err := src.Load()
+	if err != nil {
+		return err
+	}
+
+	if creds.SelfID != c.selfID || creds.DeviceID != c.deviceID {
+		return errors.New("credentials are for a different identity or device")
+	}
+
+	if creds.PrivateKey == c.key() {
+		return nil
+	}
+
+	return c.RotateKey(creds.PrivateKey)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func writeCredentials(t *testing.T, dir string, creds Credentials) {
+	files := map[string]string{
+		"self_id":     creds.SelfID,
+		"device_id":   creds.DeviceID,
+		"private_key": creds.PrivateKey + "\n",
+	}
+
+	for name, v := range files {
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(v), 0600))
+	}
+}
+
+func TestClientWatchCredentials(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeCredentials(t, dir, Credentials{SelfID: "someID", DeviceID: "1", PrivateKey: privkey})
+
+	errs := make(chan error, 1)
+
+	c, err := NewFromCredentials(s.endpoint, FileCredentials{Dir: dir}, OnError(func(err error) { errs <- err }))
+	require.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{})
+	go c.WatchCredentials(ctx, FileCredentials{Dir: dir}, time.Hour, changed)
+
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	rotated := base64.RawStdEncoding.EncodeToString(sk.Seed())
+
+	writeCredentials(t, dir, Credentials{SelfID: "someID", DeviceID: "1", PrivateKey: rotated})
+	changed <- struct{}{}
+
+	assert.Eventually(t, func() bool {
+		return c.key() == rotated
+	}, time.Second, 10*time.Millisecond)
+
+	writeCredentials(t, dir, Credentials{SelfID: "otherID", DeviceID: "1", PrivateKey: privkey})
+	changed <- struct{}{}
+
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "different identity")
+	case <-time.After(time.Second):
+		t.Fatal("expected reload error")
+	}
+
+	assert.Equal(t, rotated, c.key())
+	assert.NotNil(t, c.RotateKey("invalid"))
+}
+
+func TestEnvCredentials(t *testing.T) {
+	os.Setenv("TEST_SELF_ID", "someID")
+	os.Setenv("TEST_DEVICE_ID", "1")
+	os.Setenv("TEST_PRIVATE_KEY", privkey)
+	defer os.Unsetenv("TEST_SELF_ID")
+	defer os.Unsetenv("TEST_DEVICE_ID")
+	defer os.Unsetenv("TEST_PRIVATE_KEY")
+
+	creds, err := EnvCredentials{Prefix: "TEST_"}.Load()
+	require.Nil(t, err)
+	assert.Equal(t, Credentials{SelfID: "someID", DeviceID: "1", PrivateKey: privkey}, creds)
+
+	_, err = EnvCredentials{Prefix: "MISSING_"}.Load()
+	assert.NotNil(t, err)
+}
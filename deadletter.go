@@ -0,0 +1,93 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// DeadLetter is a message that could not be delivered to the server
+type DeadLetter struct {
+	Message *msgproto.Message
+	Err     error
+	Time    time.Time
+}
+
+// DeadLetterQueue stores messages that failed to send
+type DeadLetterQueue interface {
+	Put(dl DeadLetter) error
+}
+
+// MemoryDeadLetterQueue is a bounded in memory dead letter queue.
+// When full, the oldest dead letter is discarded. A queue with a
+// size of zero or less discards every dead letter
+type MemoryDeadLetterQueue struct {
+	letters []DeadLetter
+	size    int
+	mu      sync.Mutex
+}
+
+// NewMemoryDeadLetterQueue creates a dead letter queue that holds up to size messages
+func NewMemoryDeadLetterQueue(size int) *MemoryDeadLetterQueue {
+	return &MemoryDeadLetterQueue{size: size}
+}
+
+// Put adds a dead letter to the queue
+func (q *MemoryDeadLetterQueue) Put(dl DeadLetter) error {
+	if q.size < 1 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.letters) >= q.size {
+		q.letters = q.letters[1:]
+	}
+
+	q.letters = append(q.letters, dl)
+
+	return nil
+}
+
+// Drain removes and returns all dead letters in the queue
+func (q *MemoryDeadLetterQueue) Drain() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := q.letters
+	q.letters = nil
+
+	return letters
+}
+
+// Len returns the number of dead letters in the queue
+func (q *MemoryDeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.letters)
+}
+
+// deadLetter stores a message the server has rejected. Local failures,
+// such as a closed connection, a full send queue or a request timeout,
+// are only returned to the caller, as the message was either never
+// handed to the server or its outcome is unknown
+func (c *Client) deadLetter(m *msgproto.Message, err error) {
+	if c.deadLetters == nil || err == nil {
+		return
+	}
+
+	if !isServerError(err) {
+		return
+	}
+
+	perr := c.deadLetters.Put(DeadLetter{Message: m, Err: err, Time: c.clock.Now()})
+	if perr != nil {
+		c.report(fmt.Errorf("failed to store dead letter: %w", perr))
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// ErrDeadlineExceeded is returned for messages whose deadline passed before they were written
+var ErrDeadlineExceeded = errors.New("message deadline exceeded before it was written")
+
+// deadlineQueue holds messages taken from the send queue so the writer
+// can write them earliest deadline first. Messages without a deadline are
+// written after those with one, in the order they were queued
+type deadlineQueue struct {
+	requests requestHeap
+	seq      uint64
+	waiting  int64 // requests with a deadline in the send queue, accessed atomically
+	mu       sync.Mutex
+}
+
+type scheduled struct {
+	*request
+	seq uint64
+}
+
+type requestHeap []scheduled
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	a, b := h[i].deadline, h[j].deadline
+
+	switch {
+	case a.IsZero() != b.IsZero():
+		return b.IsZero()
+	case !a.Equal(b):
+		return a.Before(b)
+	}
+
+	return h[i].seq < h[j].seq
+}
+
+func (h requestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(scheduled)) }
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+func (q *deadlineQueue) len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.requests)
+}
+
+// expect counts requests with a deadline added to, or removed from, the send queue
+func (q *deadlineQueue) expect(n int64) {
+	if q != nil {
+		atomic.AddInt64(&q.waiting, n)
+	}
+}
+
+func (q *deadlineQueue) push(r *request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.add(r)
+}
+
+// add adds a request taken from the send queue. The lock must be held
+func (q *deadlineQueue) add(r *request) {
+	if !r.deadline.IsZero() {
+		atomic.AddInt64(&q.waiting, -1)
+	}
+
+	q.seq++
+	heap.Push(&q.requests, scheduled{request: r, seq: q.seq})
+}
+
+// next moves the requests waiting in the send queue into the deadline
+// queue, up to the send queue's capacity, and returns the request with the
+// earliest deadline. It returns nil if the deadline queue is empty, so
+// requests are only scheduled once one with a deadline has been queued
+func (q *deadlineQueue) next(send chan *request) *request {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.requests) == 0 {
+		return nil
+	}
+
+	for drained := false; !drained && len(q.requests) < cap(send); {
+		select {
+		case r := <-send:
+			q.add(r)
+		default:
+			drained = true
+		}
+	}
+
+	return heap.Pop(&q.requests).(scheduled).request
+}
+
+// schedule queues a request taken from the send queue, returning false if
+// it can be written straight away because no request with a deadline is
+// queued that could be written ahead of it
+func (c *Client) schedule(r *request) bool {
+	if c.deadlines == nil {
+		return false
+	}
+
+	if r.deadline.IsZero() && c.deadlines.len() == 0 && atomic.LoadInt64(&c.deadlines.waiting) == 0 {
+		return false
+	}
+
+	c.deadlines.push(r)
+
+	return true
+}
+
+// writeScheduled writes the next request from the deadline queue, dropping
+// it instead if its deadline has passed
+func (c *Client) writeScheduled(conn *connection, r *request) error {
+	if r.deadline.IsZero() || c.clock.Now().Before(r.deadline) {
+		return c.write(conn, r)
+	}
+
+	if !c.queued.take(r) {
+		return nil
+	}
+
+	releaseMarshalBuffer(r.buf)
+	c.memory.release(r.size)
+	c.dropped(r.id, ErrDeadlineExceeded)
+	r.response <- ErrDeadlineExceeded
+
+	return nil
+}
+
+// SendBefore sends a message that must be written by a deadline. Messages
+// with deadlines are written earliest deadline first, ahead of messages
+// without one, and a message whose deadline passes while it is queued is
+// dropped with a MessageDropped event and ErrDeadlineExceeded
+func (c *Client) SendBefore(m *msgproto.Message, deadline time.Time) error {
+	err := c.sendEncrypted(m, deadline)
+	c.audit(AuditSent, m, err)
+	c.archive(AuditSent, m, err)
+
+	return err
+}
+
+// SendAsyncBefore queues a message that must be written by a deadline
+// without waiting for the server to acknowledge it, as with SendAsync
+func (c *Client) SendAsyncBefore(m *msgproto.Message, deadline time.Time, fn func(error)) {
+	if fn == nil {
+		fn = func(error) {}
+	}
+
+	done := func(err error) {
+		c.audit(AuditSent, m, err)
+		c.archive(AuditSent, m, err)
+		fn(err)
+	}
+
+	em, err := c.encrypt(m)
+	if err != nil {
+		go done(err)
+		return
+	}
+
+	c.sendAsync(em, deadline, done)
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSendBefore(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+
+	// the first message holds up the writer until the others are queued
+	sc := newScriptedConn(func(sc *scriptedConn, hdr *msgproto.Header) error {
+		if hdr.Id == "a" {
+			close(blocked)
+			<-release
+		}
+		return acknowledge(sc, hdr)
+	})
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	msg := func(id string) *msgproto.Message {
+		return &msgproto.Message{Id: id, Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"}
+	}
+
+	results := make(chan error, 5)
+	result := func(err error) { results <- err }
+
+	c.SendAsync(msg("a"), result)
+	<-blocked
+
+	now := time.Now()
+
+	c.SendAsync(msg("b"), result)
+	c.SendAsyncBefore(msg("c"), now.Add(time.Hour), result)
+	c.SendAsyncBefore(msg("d"), now.Add(time.Minute), result)
+
+	expired := make(chan error, 1)
+	c.SendAsyncBefore(msg("e"), now.Add(time.Millisecond), func(err error) { expired <- err })
+
+	assert.Equal(t, 4, c.SendQueueDepth())
+
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-results:
+			require.Nil(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("messages were not acknowledged")
+		}
+	}
+
+	assert.Equal(t, ErrDeadlineExceeded, <-expired)
+	assert.Equal(t, MessageDropped{ID: "e", Err: ErrDeadlineExceeded}, nextEvent(t, c))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var ids []string
+	for _, hdr := range sc.written {
+		if hdr.Type == msgproto.MsgType_MSG {
+			ids = append(ids, hdr.Id)
+		}
+	}
+
+	assert.Equal(t, []string{"a", "d", "c", "b"}, ids)
+}
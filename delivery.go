@@ -0,0 +1,353 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// MessageStore persists messages for at least once delivery
+type MessageStore interface {
+	Put(m *msgproto.Message) error
+	Delete(id string) error
+	List() ([]*msgproto.Message, error)
+}
+
+// MemoryStore is a message store that holds messages in memory.
+// It does not survive a restart and is intended for testing
+type MemoryStore struct {
+	messages map[string]*msgproto.Message
+	order    []string
+	mu       sync.Mutex
+}
+
+// NewMemoryStore creates a new in memory message store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: make(map[string]*msgproto.Message)}
+}
+
+// Put stores a message
+func (s *MemoryStore) Put(m *msgproto.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[m.Id]; !ok {
+		s.order = append(s.order, m.Id)
+	}
+
+	s.messages[m.Id] = m
+
+	return nil
+}
+
+// Delete removes a message
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[id]; !ok {
+		return nil
+	}
+
+	delete(s.messages, id)
+
+	for i := range s.order {
+		if s.order[i] == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// List returns all stored messages in the order they were stored
+func (s *MemoryStore) List() ([]*msgproto.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]*msgproto.Message, len(s.order))
+	for i, id := range s.order {
+		messages[i] = s.messages[id]
+	}
+
+	return messages, nil
+}
+
+// DirectoryStore is a message store that writes each message to a file in a
+// directory. File names start with a sequence number, so messages are listed
+// in the order they were first stored, however close together they were
+// written and whatever the resolution of the file system's timestamps
+type DirectoryStore struct {
+	dir   string
+	seq   uint64
+	names map[string]string // file name of each stored message by id
+	mu    sync.Mutex
+}
+
+// NewDirectoryStore creates a message store in the given directory, creating it if needed
+func NewDirectoryStore(dir string) (*DirectoryStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DirectoryStore{dir: dir, names: make(map[string]string)}
+
+	for _, f := range files {
+		seq, id, ok := parseStoreName(f.Name())
+		if f.IsDir() || !ok {
+			continue
+		}
+
+		s.names[id] = f.Name()
+
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+
+	return s, nil
+}
+
+// parseStoreName returns the sequence number and hex encoded id of a
+// message file
+func parseStoreName(name string) (uint64, string, bool) {
+	if !strings.HasSuffix(name, ".msg") {
+		return 0, "", false
+	}
+
+	parts := strings.SplitN(strings.TrimSuffix(name, ".msg"), "-", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return seq, parts[1], true
+}
+
+// Put writes a message to disk. The file is written atomically so a crash
+// can not leave a partially written message behind. A message that is
+// stored again keeps its place in the order
+func (s *DirectoryStore) Put(m *msgproto.Message) error {
+	if m.Id == "" {
+		return errors.New("message has no id")
+	}
+
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	cerr := tmp.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	id := hex.EncodeToString([]byte(m.Id))
+
+	name, ok := s.names[id]
+	if !ok {
+		s.seq++
+		name = fmt.Sprintf("%020d-%s.msg", s.seq, id)
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.names[id] = name
+
+	return nil
+}
+
+// Delete removes a message from disk
+func (s *DirectoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := hex.EncodeToString([]byte(id))
+
+	name, ok := s.names[key]
+	if !ok {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(s.names, key)
+
+	return nil
+}
+
+// List reads all stored messages, oldest first
+func (s *DirectoryStore) List() ([]*msgproto.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// files are listed sorted by name, which is in sequence order
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*msgproto.Message
+
+	for _, f := range files {
+		if _, _, ok := parseStoreName(f.Name()); f.IsDir() || !ok {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &m)
+	}
+
+	return messages, nil
+}
+
+// Ack acknowledges that a received message has been processed, removing it
+// from the inbound journal. It has no effect if at least once delivery is
+// not enabled
+func (c *Client) Ack(m *msgproto.Message) error {
+	if c.inbound == nil {
+		return nil
+	}
+
+	return c.inbound.Delete(m.Id)
+}
+
+// persist stores an outbound message until it has been acknowledged
+func (c *Client) persist(m *msgproto.Message) error {
+	if c.outbound == nil {
+		return nil
+	}
+
+	return c.outbound.Put(m)
+}
+
+// settle removes an outbound message from the store once the server has
+// responded to it. Messages that failed locally are kept so they can be
+// resent when the client reconnects
+func (c *Client) settle(m *msgproto.Message, err error) {
+	if c.outbound == nil {
+		return
+	}
+
+	if err != nil {
+		if !isServerError(err) {
+			return
+		}
+	}
+
+	derr := c.outbound.Delete(m.Id)
+	if derr != nil {
+		c.report(fmt.Errorf("failed to remove settled message: %w", derr))
+	}
+}
+
+// journal stores an inbound message before it is delivered to the application
+func (c *Client) journal(m *msgproto.Message) {
+	if c.inbound == nil {
+		return
+	}
+
+	err := c.inbound.Put(m)
+	if err != nil {
+		c.report(fmt.Errorf("failed to journal inbound message: %w", err))
+	}
+}
+
+// resend sends any stored outbound messages that are not already in flight
+func (c *Client) resend() {
+	if c.outbound == nil {
+		return
+	}
+
+	messages, err := c.outbound.List()
+	if err != nil {
+		c.report(fmt.Errorf("failed to list stored messages: %w", err))
+		return
+	}
+
+	for _, m := range messages {
+		if c.requests.registered(m.Id) {
+			continue
+		}
+
+		// stored messages have already been encrypted
+		c.sendAsync(m, time.Time{}, nil)
+	}
+}
+
+// redeliver delivers any journaled messages that were not acknowledged
+// before the client last stopped
+func (c *Client) redeliver() {
+	if c.inbound == nil {
+		return
+	}
+
+	messages, err := c.inbound.List()
+	if err != nil {
+		c.report(fmt.Errorf("failed to list journaled messages: %w", err))
+		return
+	}
+
+	for _, m := range messages {
+		c.queueFor(newInbound(m)) <- m
+	}
+}
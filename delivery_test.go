@@ -0,0 +1,115 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messaging")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewDirectoryStore(dir)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Put(&msgproto.Message{Id: "1/a", Ciphertext: []byte("hello")}))
+	require.Nil(t, s.Put(&msgproto.Message{Id: "2", Ciphertext: []byte("world")}))
+
+	messages, err := s.List()
+	require.Nil(t, err)
+	require.Len(t, messages, 2)
+
+	require.Nil(t, s.Delete("1/a"))
+	require.Nil(t, s.Delete("missing"))
+
+	messages, err = s.List()
+	require.Nil(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "2", messages[0].Id)
+	assert.Equal(t, []byte("world"), messages[0].Ciphertext)
+}
+
+func TestClientAtLeastOnce(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	outbound := NewMemoryStore()
+	inbound := NewMemoryStore()
+
+	inbound.Put(&msgproto.Message{Id: "unacked", Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"})
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AtLeastOnce(outbound, inbound))
+	require.Nil(t, err)
+	require.NotNil(t, c)
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "unacked", m.Id)
+	require.Nil(t, c.Ack(m))
+
+	err = c.Send(&msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"})
+	require.Nil(t, err)
+
+	_, err = wait(s.in)
+	require.Nil(t, err)
+
+	pending, err := outbound.List()
+	require.Nil(t, err)
+	assert.Len(t, pending, 0)
+
+	s.out <- &msgproto.Message{Id: "new", Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"}
+
+	m, err = c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "new", m.Id)
+
+	journaled, err := inbound.List()
+	require.Nil(t, err)
+	require.Len(t, journaled, 1)
+
+	require.Nil(t, c.Ack(m))
+
+	journaled, err = inbound.List()
+	require.Nil(t, err)
+	assert.Len(t, journaled, 0)
+}
+
+func TestDirectoryStoreOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messaging")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewDirectoryStore(dir)
+	require.Nil(t, err)
+
+	// messages written within the file system's timestamp resolution
+	for _, id := range []string{"c", "a", "b"} {
+		require.Nil(t, s.Put(&msgproto.Message{Id: id}))
+	}
+	require.Nil(t, s.Put(&msgproto.Message{Id: "c", Ciphertext: []byte("again")}))
+
+	// are listed in the order they were stored, after reopening the store
+	s, err = NewDirectoryStore(dir)
+	require.Nil(t, err)
+	require.Nil(t, s.Put(&msgproto.Message{Id: "d"}))
+
+	messages, err := s.List()
+	require.Nil(t, err)
+
+	var ids []string
+	for _, m := range messages {
+		ids = append(ids, m.Id)
+	}
+
+	assert.Equal(t, []string{"c", "a", "b", "d"}, ids)
+	assert.Equal(t, []byte("again"), messages[0].Ciphertext)
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// detachedSeparator separates the signature of a detached payload
+// from its content in a message
+const detachedSeparator = '\n'
+
+// SignDetached signs content without embedding it in the JWS. The content
+// is signed unencoded (RFC 7797), so large payloads are not base64 inflated,
+// and the returned compact signature has an empty payload section. The
+// issuer and, if the MessageTTL option is set, expiry are protected headers
+func (c *Client) SignDetached(content []byte) ([]byte, error) {
+	opts := (&jose.SignerOptions{}).WithBase64(false).WithHeader("iss", c.selfID)
+
+	now := c.serverNow()
+	opts.WithHeader("iat", now.Unix())
+
+	if c.messageTTL > 0 {
+		opts.WithHeader("exp", now.Add(c.messageTTL).Unix())
+	}
+
+	signer, err := c.signer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	jws, err := signer.Sign(content)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := jws.DetachedCompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(sig), nil
+}
+
+// VerifyDetached verifies a detached signature over content with the keys
+// of the issuer named in its protected header
+func (c *Client) VerifyDetached(signature, content []byte) (*Claims, error) {
+	if c.publicKeys == nil {
+		return nil, errors.New("no public key source configured")
+	}
+
+	if content == nil {
+		content = []byte{}
+	}
+
+	jws, err := jose.ParseDetached(string(signature), content)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := jws.Signatures[0].Protected.ExtraHeaders
+
+	issuer, _ := hdr["iss"].(string)
+	if issuer == "" {
+		return nil, errors.New("payload has no issuer")
+	}
+
+	keys, err := c.publicKeys(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := jws.Signatures[0].Header.KeyID
+
+	for _, k := range keys {
+		if !keyMatches(k, kid) || jws.DetachedVerify(content, k) != nil {
+			continue
+		}
+
+		claims := &Claims{
+			Issuer:    issuer,
+			IssuedAt:  headerTime(hdr["iat"]),
+			ExpiresAt: headerTime(hdr["exp"]),
+		}
+
+		if !claims.ExpiresAt.IsZero() && c.clock.Now().After(claims.ExpiresAt) {
+			return nil, errors.New("payload has expired")
+		}
+
+		return claims, nil
+	}
+
+	return nil, errors.New("payload signature is invalid")
+}
+
+// DetachedMessage signs content and wraps it in a message to recipient,
+// carrying the signature and the raw content separately
+func (c *Client) DetachedMessage(recipient string, content []byte) (*msgproto.Message, error) {
+	sig, err := c.SignDetached(content)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(sig)+1+len(content))
+	data = append(data, sig...)
+	data = append(data, detachedSeparator)
+	data = append(data, content...)
+
+	return c.RequestMessage(recipient, data), nil
+}
+
+// DecodeDetached verifies a message created with DetachedMessage and
+// returns its content. The issuer of the signature must match the sender
+func (c *Client) DecodeDetached(m *msgproto.Message) ([]byte, *Claims, error) {
+	i := bytes.IndexByte(m.Ciphertext, detachedSeparator)
+	if i < 0 {
+		return nil, nil, errors.New("message has no detached signature")
+	}
+
+	content := m.Ciphertext[i+1:]
+
+	claims, err := c.VerifyDetached(m.Ciphertext[:i], content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m.Sender == "" {
+		return nil, nil, errors.New("message has no sender")
+	}
+
+	if selfIDOf(m.Sender) != claims.Issuer {
+		return nil, nil, errors.New("payload issuer does not match sender")
+	}
+
+	return content, claims, nil
+}
+
+// headerTime converts a numeric date header to a time
+func headerTime(v interface{}) time.Time {
+	secs, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(secs), 0)
+}
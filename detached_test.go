@@ -0,0 +1,60 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientDetachedPayload(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys), MessageTTL(time.Minute))
+	require.Nil(t, err)
+	defer c.Close()
+
+	content := bytes.Repeat([]byte{0, 1, 2, 0xff}, 1<<18)
+
+	sig, err := c.SignDetached(content)
+	require.Nil(t, err)
+
+	// the content is not embedded in the signature
+	assert.Less(t, len(sig), 512)
+	assert.Contains(t, string(sig), "..")
+
+	claims, err := c.VerifyDetached(sig, content)
+	require.Nil(t, err)
+	assert.Equal(t, "someID", claims.Issuer)
+	assert.False(t, claims.ExpiresAt.IsZero())
+
+	tampered := append([]byte{}, content...)
+	tampered[0] = 1
+
+	_, err = c.VerifyDetached(sig, tampered)
+	assert.NotNil(t, err)
+
+	m, err := c.DetachedMessage("test:1", content)
+	require.Nil(t, err)
+	assert.Less(t, len(m.Ciphertext), len(content)+512)
+
+	data, claims, err := c.DecodeDetached(m)
+	require.Nil(t, err)
+	assert.Equal(t, content, data)
+	assert.Equal(t, "someID", claims.Issuer)
+
+	m.Sender = "mallory:1"
+
+	_, _, err = c.DecodeDetached(m)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrAlreadyConnected is returned by New when another open client in the
+// process is connected to the same server as the same device. The server
+// only allows a device one session, so the clients would repeatedly
+// replace each other's
+var ErrAlreadyConnected = errors.New("device is already connected by another client")
+
+// openDevices holds the open client for each device and server in the process
+var openDevices = struct {
+	clients map[string]*Client
+	mu      sync.Mutex
+}{
+	clients: make(map[string]*Client),
+}
+
+func (c *Client) deviceKey() string {
+	return c.endpoint + " " + c.selfID + ":" + c.deviceID
+}
+
+// claimDevice records the client as the device's open client. If another
+// client holds the device and TakeOver is enabled, that client is closed
+// once. The claim fails if the device is still held after it has closed,
+// which happens when a third client claims it in the meantime
+func (c *Client) claimDevice() error {
+	key := c.deviceKey()
+
+	for closed := false; ; closed = true {
+		openDevices.mu.Lock()
+		other, ok := openDevices.clients[key]
+		if !ok || other == c || other.disconnected() {
+			openDevices.clients[key] = c
+			openDevices.mu.Unlock()
+			return nil
+		}
+		openDevices.mu.Unlock()
+
+		if !c.takeOver || closed {
+			return ErrAlreadyConnected
+		}
+
+		other.Close()
+	}
+}
+
+// releaseDevice allows another client to connect as the device
+func (c *Client) releaseDevice() {
+	key := c.deviceKey()
+
+	openDevices.mu.Lock()
+	if openDevices.clients[key] == c {
+		delete(openDevices.clients, key)
+	}
+	openDevices.mu.Unlock()
+}
+
+// disconnected reports whether the client is closed and not reconnecting
+func (c *Client) disconnected() bool {
+	return c.IsClosed() && atomic.LoadInt32(&c.reconnecting) == 0
+}
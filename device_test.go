@@ -0,0 +1,100 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientAlreadyConnected(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	_, err = New(s.endpoint, "someID", "1", privkey)
+	assert.Equal(t, ErrAlreadyConnected, err)
+
+	// other devices, and the same device on other servers, can connect
+	d, err := c.WithDevice("2")
+	require.Nil(t, err)
+	defer d.Close()
+
+	o := newServer()
+	defer o.close()
+
+	oc, err := New(o.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer oc.Close()
+
+	c.Close()
+
+	c, err = New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+}
+
+func TestClientTakeOver(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	n, err := New(s.endpoint, "someID", "1", privkey, TakeOver(true))
+	require.Nil(t, err)
+	defer n.Close()
+
+	assert.True(t, c.IsClosed())
+	assert.False(t, n.IsClosed())
+}
+
+func TestClientDisconnectedReleasesDevice(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	s.disconnect()
+
+	assert.Eventually(t, c.IsClosed, time.Second, time.Millisecond*10)
+
+	c, err = New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+}
+
+func TestClientTakeOverHeldDevice(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	d, err := New(s.endpoint, "someID", "2", privkey)
+	require.Nil(t, err)
+	defer d.Close()
+
+	// a client that still holds the device after it has been closed
+	atomic.StoreInt32(&d.reconnecting, 1)
+
+	key := s.endpoint + " someID:1"
+
+	openDevices.mu.Lock()
+	openDevices.clients[key] = d
+	openDevices.mu.Unlock()
+
+	defer func() {
+		openDevices.mu.Lock()
+		delete(openDevices.clients, key)
+		openDevices.mu.Unlock()
+	}()
+
+	_, err = New(s.endpoint, "someID", "1", privkey, TakeOver(true))
+	assert.Equal(t, ErrAlreadyConnected, err)
+	assert.True(t, d.IsClosed())
+}
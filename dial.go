@@ -0,0 +1,45 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+//go:build !js
+// +build !js
+
+package messaging
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebsocket opens a websocket to the endpoint. unix:// endpoints are
+// dialed over a unix domain socket
+func dialWebsocket(endpoint string, header http.Header, opts *dialOptions) (transport, *http.Response, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = opts.tls
+	dialer.NetDialContext = opts.netDial()
+	dialer.Proxy = opts.proxyFunc()
+
+	socket, wsURL, ok, err := unixEndpoint(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ok {
+		var d net.Dialer
+
+		endpoint = wsURL
+		dialer.Proxy = nil
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	ws, resp, err := dialer.Dial(endpoint, header)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return ws, resp, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+//go:build js && wasm
+// +build js,wasm
+
+package messaging
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// browserConn is a websocket provided by the browser's WebSocket API
+type browserConn struct {
+	*frameQueue
+	ws    js.Value
+	funcs []js.Func
+}
+
+// dialWebsocket opens a websocket to the endpoint using the browser's
+// WebSocket API. Browsers do not allow handshake headers to be set or
+// read, so the header is ignored and no response is returned. TLS is
+// configured by the browser, so the dial options are also ignored
+func dialWebsocket(endpoint string, header http.Header, opts *dialOptions) (transport, *http.Response, error) {
+	if strings.HasPrefix(endpoint, "unix://") {
+		return nil, nil, errors.New("unix endpoints are not supported by this environment")
+	}
+
+	constructor := js.Global().Get("WebSocket")
+	if constructor.IsUndefined() {
+		return nil, nil, errors.New("websockets are not supported by this environment")
+	}
+
+	bc := &browserConn{
+		frameQueue: newFrameQueue(time.Now().Add(DefaultDeadline)),
+		ws:         constructor.New(endpoint),
+	}
+
+	bc.ws.Set("binaryType", "arraybuffer")
+
+	opened := make(chan error, 1)
+
+	bc.on("open", func(js.Value) {
+		select {
+		case opened <- nil:
+		default:
+		}
+	})
+
+	bc.on("message", func(event js.Value) {
+		data := js.Global().Get("Uint8Array").New(event.Get("data"))
+		frame := make([]byte, data.Get("length").Int())
+		js.CopyBytesToGo(frame, data)
+
+		err := bc.push(frame)
+		if err == websocket.ErrReadLimit {
+			bc.fail(err)
+			bc.Close()
+		}
+	})
+
+	bc.on("close", func(event js.Value) {
+		ce := &websocket.CloseError{Code: event.Get("code").Int(), Text: event.Get("reason").String()}
+
+		select {
+		case opened <- ce:
+		default:
+		}
+
+		bc.closeWith(ce)
+		bc.release()
+	})
+
+	err := <-opened
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bc, nil, nil
+}
+
+// on registers an event handler on the websocket
+func (bc *browserConn) on(event string, fn func(js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		fn(args[0])
+		return nil
+	})
+
+	bc.funcs = append(bc.funcs, f)
+	bc.ws.Set("on"+event, f)
+}
+
+// release frees the event handlers once the websocket has closed
+func (bc *browserConn) release() {
+	for _, event := range []string{"open", "message", "close"} {
+		bc.ws.Set("on"+event, js.Null())
+	}
+
+	for _, f := range bc.funcs {
+		f.Release()
+	}
+}
+
+// WriteMessage sends a binary frame
+func (bc *browserConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.BinaryMessage {
+		return errors.New("only binary frames are supported")
+	}
+
+	select {
+	case <-bc.done:
+		return errors.New("websocket is closed")
+	default:
+	}
+
+	arr := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(arr, data)
+	bc.ws.Call("send", arr)
+
+	return nil
+}
+
+// WriteControl emulates control frames. The browser answers pings itself,
+// so pings succeed while the websocket is open
+func (bc *browserConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	switch messageType {
+	case websocket.PingMessage:
+		return bc.ping()
+	case websocket.CloseMessage:
+		ce := closeError(data)
+
+		// browsers only allow normal closure and application defined codes
+		if ce.Code != websocket.CloseNormalClosure && (ce.Code < 3000 || ce.Code > 4999) {
+			ce.Code = websocket.CloseNormalClosure
+		}
+
+		bc.ws.Call("close", ce.Code, ce.Text)
+
+		return nil
+	}
+
+	return errors.New("unsupported control frame")
+}
+
+// Close closes the websocket without waiting for the server
+func (bc *browserConn) Close() error {
+	bc.ws.Call("close")
+	bc.shutdown()
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultDirectoryTTL is how long directory responses are cached before they are revalidated
+const DefaultDirectoryTTL = time.Minute * 5
+
+// Directory looks up the devices and public keys of identities
+type Directory interface {
+	Devices(selfID string) ([]string, error)
+	PublicKeys(selfID string) ([]crypto.PublicKey, error)
+}
+
+// HTTPDirectory looks up identities with the self API. Responses are cached
+// for the TTL, then revalidated with their ETag
+type HTTPDirectory struct {
+	// Endpoint is the base URL of the API, such as https://api.joinself.com
+	Endpoint string
+	// Token, if set, is sent as a bearer token
+	Token  string
+	TTL    time.Duration
+	Client *http.Client
+	cache  map[string]*directoryEntry
+	mu     sync.Mutex
+}
+
+type directoryEntry struct {
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// NewHTTPDirectory creates a directory for the API at endpoint
+func NewHTTPDirectory(endpoint string) *HTTPDirectory {
+	return &HTTPDirectory{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		TTL:      DefaultDirectoryTTL,
+		Client:   &http.Client{Timeout: DefaultTimeout},
+		cache:    make(map[string]*directoryEntry),
+	}
+}
+
+// Devices returns the devices registered to an identity
+func (d *HTTPDirectory) Devices(selfID string) ([]string, error) {
+	body, err := d.get("/v1/identities/" + url.PathEscape(selfID) + "/devices")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+
+	return devices, json.Unmarshal(body, &devices)
+}
+
+// PublicKeys returns the public keys of an identity
+func (d *HTTPDirectory) PublicKeys(selfID string) ([]crypto.PublicKey, error) {
+	body, err := d.get("/v1/identities/" + url.PathEscape(selfID) + "/public_keys")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Key string `json:"key"`
+	}
+
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]crypto.PublicKey, 0, len(entries))
+
+	for _, e := range entries {
+		k, err := decodeKey(e.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		pk, err := parsePublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, pk)
+	}
+
+	return keys, nil
+}
+
+// parsePublicKey parses a raw ed25519 public key, or an ECDSA or RSA
+// key in PKIX form
+func parsePublicKey(k []byte) (crypto.PublicKey, error) {
+	if len(k) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(k), nil
+	}
+
+	pk, err := x509.ParsePKIXPublicKey(k)
+	if err != nil {
+		return nil, errors.New("public key has an invalid length")
+	}
+
+	switch pk.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return pk, nil
+	}
+
+	return nil, errors.New("public key has an unsupported type")
+}
+
+func decodeKey(key string) ([]byte, error) {
+	key = strings.TrimRight(key, "=")
+
+	k, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return base64.RawStdEncoding.DecodeString(key)
+	}
+
+	return k, nil
+}
+
+func (d *HTTPDirectory) get(path string) ([]byte, error) {
+	d.mu.Lock()
+	entry, ok := d.cache[path]
+	d.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.body, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, d.Endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	}
+
+	if ok && entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		entry = &directoryEntry{body: entry.body, etag: entry.etag, expires: time.Now().Add(d.TTL)}
+	case resp.StatusCode == http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		entry = &directoryEntry{body: body, etag: resp.Header.Get("ETag"), expires: time.Now().Add(d.TTL)}
+	default:
+		return nil, fmt.Errorf("directory request failed with status %d", resp.StatusCode)
+	}
+
+	d.mu.Lock()
+	d.cache[path] = entry
+	d.mu.Unlock()
+
+	return entry.body, nil
+}
+
+// SendToDevices sends a copy of a message to every device of an identity,
+// as listed by the client's directory. Each copy is given its own id
+func (c *Client) SendToDevices(selfID string, m *msgproto.Message) error {
+	if c.directory == nil {
+		return errors.New("no directory configured")
+	}
+
+	devices, err := c.directory.Devices(selfID)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		dm := *m
+		dm.Id = m.Id + ":" + device
+		dm.Recipient = selfID + ":" + device
+
+		err = c.Send(&dm)
+		if err != nil {
+			return fmt.Errorf("failed to send to device %s: %w", device, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPDirectory(t *testing.T) {
+	var requests, revalidated int32
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidated, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+
+		switch r.URL.Path {
+		case "/v1/identities/alice/devices":
+			json.NewEncoder(w).Encode([]string{"1", "2"})
+		case "/v1/identities/alice/public_keys":
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"id": 1, "key": base64.RawURLEncoding.EncodeToString(pubkey)},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	d := NewHTTPDirectory(api.URL + "/")
+	d.Token = "token"
+	d.TTL = time.Millisecond * 50
+
+	keys, err := d.PublicKeys("alice")
+	require.Nil(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, pubkey, keys[0])
+
+	devices, err := d.Devices("alice")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, devices)
+
+	// cached responses are used until they expire
+	_, err = d.Devices("alice")
+	require.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	time.Sleep(time.Millisecond * 60)
+
+	devices, err = d.Devices("alice")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, devices)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidated))
+
+	_, err = d.Devices("bob")
+	assert.NotNil(t, err)
+}
+
+type testDirectory struct{}
+
+func (testDirectory) Devices(selfID string) ([]string, error) {
+	return []string{"1", "2"}, nil
+}
+
+func (testDirectory) PublicKeys(selfID string) ([]crypto.PublicKey, error) {
+	return []crypto.PublicKey{pubkey}, nil
+}
+
+func TestClientSendToDevices(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, WithDirectory(testDirectory{}))
+	require.Nil(t, err)
+	assert.NotNil(t, c.publicKeys)
+
+	recipients := make(chan string, 2)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			m := <-s.in
+			recipients <- m.Recipient
+		}
+	}()
+
+	err = c.SendToDevices("alice", &msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1"})
+	require.Nil(t, err)
+
+	assert.Equal(t, "alice:1", <-recipients)
+	assert.Equal(t, "alice:2", <-recipients)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pk, err := parsePublicKey(pubkey)
+	require.Nil(t, err)
+	assert.Equal(t, pubkey, pk)
+
+	eckey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(eckey.Public())
+	require.Nil(t, err)
+
+	pk, err = parsePublicKey(der)
+	require.Nil(t, err)
+	assert.Equal(t, eckey.Public(), pk)
+
+	_, err = parsePublicKey([]byte("garbage"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package election ensures only one instance of a replicated service holds
+// the connection for a device at a time. Instances that connect with the
+// same device replace each other's sessions, so instances campaign for a
+// shared lock and only the leader connects:
+//
+//	e := election.New(lock, hostname)
+//
+//	e.Run(ctx, func(ctx context.Context) {
+//		client, err := messaging.New(endpoint, appID, device, appKey, messaging.ReconnectOnSessionReplaced(false))
+//		if err != nil {
+//			return
+//		}
+//		defer client.Close()
+//
+//		// handle messages until leadership is lost
+//		...
+//	})
+//
+// If the leader fails, its lock expires and another instance takes over.
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTTL is how long the lock is held without being renewed
+const DefaultTTL = 15 * time.Second
+
+// Lock is a lock shared by all instances, for example a Redis key set with
+// SET NX PX and renewed with a script that checks its value, or an etcd lease
+type Lock interface {
+	// Acquire takes the lock for holder, or extends it if holder already
+	// has it, until ttl has passed. It reports whether holder has the lock
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lock if holder has it
+	Release(ctx context.Context, holder string) error
+}
+
+// Elector campaigns for leadership on behalf of an instance
+type Elector struct {
+	// TTL is how long leadership lasts without being renewed. It is renewed
+	// every third of the TTL, and other instances retry at the same interval
+	TTL time.Duration
+	// OnChange, if not nil, is called when the instance gains or loses leadership
+	OnChange func(leader bool)
+
+	lock   Lock
+	id     string
+	leader int32
+}
+
+// New creates an elector for the instance with the given id
+func New(lock Lock, id string) *Elector {
+	return &Elector{TTL: DefaultTTL, lock: lock, id: id}
+}
+
+// IsLeader reports whether the instance currently holds the lock
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Run campaigns until the context is cancelled. While the instance is the
+// leader, fn is run with a context that is cancelled when leadership is
+// lost. If fn returns while the instance is leader, leadership is given up
+// and the instance campaigns again. fn must return once its context is
+// cancelled, as leadership is only given up safely if it does before the
+// lock expires
+func (e *Elector) Run(ctx context.Context, fn func(ctx context.Context)) error {
+	if e.TTL <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	for {
+		start := time.Now()
+
+		if e.acquire(ctx) {
+			e.lead(ctx, start.Add(e.TTL), fn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.TTL / 3):
+		}
+	}
+}
+
+// acquire takes or renews the lock, giving up after a third of the TTL so
+// a hung lock leaves fn time to stop before the lock expires
+func (e *Elector) acquire(ctx context.Context) bool {
+	actx, cancel := context.WithTimeout(ctx, e.TTL/3)
+	defer cancel()
+
+	result := make(chan bool, 1)
+
+	go func() {
+		ok, err := e.lock.Acquire(actx, e.id, e.TTL)
+		result <- err == nil && ok
+	}()
+
+	select {
+	case ok := <-result:
+		return ok
+	case <-actx.Done():
+		return false
+	}
+}
+
+// lead runs fn while renewing the lock, which expires at expires unless renewed
+func (e *Elector) lead(ctx context.Context, expires time.Time, fn func(ctx context.Context)) {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	e.setLeader(true)
+	defer e.setLeader(false)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		fn(lctx)
+	}()
+
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			if e.acquire(ctx) {
+				expires = start.Add(e.TTL)
+				continue
+			}
+			// renewing the lock can't be confirmed, so step down before it expires
+		case <-done:
+		case <-ctx.Done():
+		}
+
+		// fn must stop before the lock is released to another instance. If
+		// it is still running when the lock expires, the lock is left to expire
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Until(expires)):
+			return
+		}
+
+		rctx, rcancel := context.WithDeadline(context.Background(), expires)
+		e.lock.Release(rctx, e.id)
+		rcancel()
+
+		return
+	}
+}
+
+func (e *Elector) setLeader(leader bool) {
+	v := int32(0)
+	if leader {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&e.leader, v) != v && e.OnChange != nil {
+		e.OnChange(leader)
+	}
+}
+
+// MemoryLock is a lock shared by electors in the same process
+type MemoryLock struct {
+	holder  string
+	expires time.Time
+	mu      sync.Mutex
+}
+
+// Acquire takes or extends the lock for holder
+func (l *MemoryLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if l.holder != "" && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+
+	l.holder = holder
+	l.expires = now.Add(ttl)
+
+	return true, nil
+}
+
+// Release gives up the lock if holder has it
+func (l *MemoryLock) Release(ctx context.Context, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder == holder {
+		l.holder = ""
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package election
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyLock fails to renew once broken
+type flakyLock struct {
+	MemoryLock
+	broken int32
+}
+
+func (l *flakyLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	if atomic.LoadInt32(&l.broken) == 1 && holder == "a" {
+		return false, nil
+	}
+
+	return l.MemoryLock.Acquire(ctx, holder, ttl)
+}
+
+// hungLock blocks renewals once hung, ignoring their context
+type hungLock struct {
+	MemoryLock
+	hung chan struct{}
+}
+
+func (l *hungLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	select {
+	case <-l.hung:
+		select {}
+	default:
+	}
+
+	return l.MemoryLock.Acquire(ctx, holder, ttl)
+}
+
+// eventually polls cond until it is true or a second has passed
+func eventually(t *testing.T, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		require.Less(t, i, 100, "condition was not met")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElector(t *testing.T) {
+	lock := &flakyLock{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var leaders []string
+	var running int32
+
+	campaign := func(id string) *Elector {
+		e := New(lock, id)
+		e.TTL = 90 * time.Millisecond
+
+		go e.Run(ctx, func(ctx context.Context) {
+			// only one instance leads at a time
+			assert.Equal(t, int32(1), atomic.AddInt32(&running, 1))
+
+			mu.Lock()
+			leaders = append(leaders, id)
+			mu.Unlock()
+
+			<-ctx.Done()
+			atomic.AddInt32(&running, -1)
+		})
+
+		return e
+	}
+
+	a := campaign("a")
+	eventually(t, a.IsLeader)
+
+	b := campaign("b")
+
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// a can no longer renew its lock, so b takes over
+	atomic.StoreInt32(&lock.broken, 1)
+
+	eventually(t, b.IsLeader)
+	assert.False(t, a.IsLeader())
+
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(leaders) == 2 && leaders[0] == "a" && leaders[1] == "b"
+	})
+}
+
+func TestElectorRenewalHangs(t *testing.T) {
+	lock := &hungLock{hung: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e := New(lock, "a")
+	e.TTL = 90 * time.Millisecond
+
+	started := make(chan time.Time, 1)
+	stopped := make(chan time.Time, 1)
+
+	go e.Run(ctx, func(ctx context.Context) {
+		started <- time.Now()
+		<-ctx.Done()
+		stopped <- time.Now()
+	})
+
+	<-started
+	close(lock.hung)
+
+	// leadership is given up before the lock can expire, although renewing it never returns
+	select {
+	case at := <-stopped:
+		lock.mu.Lock()
+		expires := lock.expires
+		lock.mu.Unlock()
+		assert.True(t, at.Before(expires))
+	case <-time.After(time.Second):
+		t.Fatal("leadership was not given up")
+	}
+
+	eventually(t, func() bool { return !e.IsLeader() })
+}
+
+func TestElectorStepsDown(t *testing.T) {
+	lock := &MemoryLock{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes := make(chan bool, 4)
+
+	e := New(lock, "a")
+	e.TTL = 30 * time.Millisecond
+	e.OnChange = func(leader bool) { changes <- leader }
+
+	result := make(chan error)
+	go func() { result <- e.Run(ctx, func(ctx context.Context) { <-ctx.Done() }) }()
+
+	assert.True(t, <-changes)
+
+	cancel()
+
+	assert.False(t, <-changes)
+	assert.Equal(t, context.Canceled, <-result)
+
+	// the lock is released when the elector stops
+	ok, err := lock.Acquire(context.Background(), "b", time.Minute)
+	require.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// envelope contains the claims of a message's JWS payload that are
+// needed for routing. The payload is decoded but not verified
+type envelope struct {
+	id             string
+	issuer         string
+	typ            string
+	conversationID string
+	expires        time.Time
+	payload        []byte
+}
+
+// inbound wraps a received message with its parsed envelope so the
+// payload only needs to be decoded once
+type inbound struct {
+	msg  *msgproto.Message
+	env  *envelope
+	size int64 // bytes reserved from the memory budget
+}
+
+func newInbound(m *msgproto.Message) *inbound {
+	env, _ := parseEnvelope(m.Ciphertext)
+	return &inbound{msg: m, env: env}
+}
+
+// conversationID returns the cid of the message, if it has one
+func (in *inbound) conversationID() string {
+	if in.env == nil {
+		return ""
+	}
+	return in.env.conversationID
+}
+
+// parseEnvelope decodes the unverified payload of a JWS in either
+// JSON or compact serialization. Only the string claims used for
+// routing are decoded, so malformed timestamps or unknown claims
+// do not prevent a message from being routed
+func parseEnvelope(data []byte) (*envelope, error) {
+	protected, encoded, err := encodedPayload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, base64.RawURLEncoding.DecodedLen(len(encoded)))
+
+	n, err := base64.RawURLEncoding.Decode(payload, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	// payloads encoded with another codec are converted to json
+	payload, err = jsonPayload(payload[:n], headerContentType(protected))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		ID             string          `json:"jti"`
+		Issuer         string          `json:"iss"`
+		Type           string          `json:"typ"`
+		ConversationID string          `json:"cid"`
+		ExpiresAt      json.RawMessage `json:"exp"`
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		// a claim of the wrong type does not stop the others being decoded
+		if _, ok := err.(*json.UnmarshalTypeError); !ok {
+			return nil, err
+		}
+	}
+
+	// an invalid expiry is left to be rejected when the payload is verified
+	expires, _ := parseTimeClaim(claims.ExpiresAt)
+
+	return &envelope{
+		id:             claims.ID,
+		issuer:         claims.Issuer,
+		typ:            claims.Type,
+		conversationID: claims.ConversationID,
+		expires:        expires,
+		payload:        payload,
+	}, nil
+}
+
+// encodedPayload returns the encoded protected header and payload of a JWS
+func encodedPayload(data []byte) ([]byte, []byte, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil, errors.New("empty payload")
+	}
+
+	if data[0] != '{' {
+		parts := bytes.Split(data, []byte("."))
+		if len(parts) != 3 {
+			return nil, nil, errors.New("invalid compact serialization")
+		}
+		return parts[0], parts[1], nil
+	}
+
+	var jws struct {
+		Payload    string `json:"payload"`
+		Protected  string `json:"protected"`
+		Signatures []struct {
+			Protected string `json:"protected"`
+		} `json:"signatures"`
+	}
+
+	err := json.Unmarshal(data, &jws)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if jws.Payload == "" {
+		return nil, nil, errors.New("jws has no payload")
+	}
+
+	if jws.Protected == "" && len(jws.Signatures) > 0 {
+		jws.Protected = jws.Signatures[0].Protected
+	}
+
+	return []byte(jws.Protected), []byte(jws.Payload), nil
+}
+
+// headerContentType returns the cty of an encoded protected header
+func headerContentType(protected []byte) string {
+	data, err := base64.RawURLEncoding.DecodeString(string(protected))
+	if err != nil {
+		return ""
+	}
+
+	var hdr struct {
+		ContentType string `json:"cty"`
+	}
+
+	json.Unmarshal(data, &hdr)
+
+	return hdr.ContentType
+}
+
+// envelopeCache holds the envelopes of recently delivered messages so
+// they can be reused when the application verifies or inspects them.
+// The oldest entries are evicted once the cache is full
+type envelopeCache struct {
+	envelopes map[*msgproto.Message]*envelope
+	order     []*msgproto.Message
+	size      int
+	mu        sync.Mutex
+}
+
+func newEnvelopeCache(size int) *envelopeCache {
+	return &envelopeCache{
+		envelopes: make(map[*msgproto.Message]*envelope, size),
+		size:      size,
+	}
+}
+
+func (ec *envelopeCache) put(m *msgproto.Message, env *envelope) {
+	if env == nil || ec.size < 1 {
+		return
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if _, ok := ec.envelopes[m]; ok {
+		return
+	}
+
+	if len(ec.order) >= ec.size {
+		delete(ec.envelopes, ec.order[0])
+		ec.order = ec.order[1:]
+	}
+
+	ec.envelopes[m] = env
+	ec.order = append(ec.order, m)
+}
+
+func (ec *envelopeCache) get(m *msgproto.Message) *envelope {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	return ec.envelopes[m]
+}
+
+// envelope returns the cached envelope for a message, parsing it if
+// it is not cached
+func (c *Client) envelope(m *msgproto.Message) (*envelope, error) {
+	env := c.envelopes.get(m)
+	if env != nil {
+		return env, nil
+	}
+
+	return parseEnvelope(m.Ciphertext)
+}
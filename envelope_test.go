@@ -0,0 +1,65 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/base64"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"1","iss":"test","typ":"test.message","cid":"123456"}`))
+
+	cases := []struct {
+		name string
+		data string
+		cid  string
+		err  bool
+	}{
+		{"json", `{"payload": "` + payload + `", "protected": "e30", "signature": "c2ln"}`, "123456", false},
+		{"compact", "e30." + payload + ".c2ln", "123456", false},
+		{"missing-payload", `{"protected": "e30", "signature": "c2ln"}`, "", true},
+		{"bad-base64", `{"payload": "!!!"}`, "", true},
+		{"bad-compact", "e30." + payload, "", true},
+		{"empty", "", "", true},
+		{"bad-iat", `{"payload": "` + base64.RawURLEncoding.EncodeToString([]byte(`{"cid":"123456","iat":"yesterday"}`)) + `"}`, "123456", false},
+		{"wrong-type", `{"payload": "` + base64.RawURLEncoding.EncodeToString([]byte(`{"cid":"123456","iss":42}`)) + `"}`, "123456", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := parseEnvelope([]byte(tc.data))
+			if tc.err {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			assert.Equal(t, tc.cid, env.conversationID)
+		})
+	}
+
+	env, err := parseEnvelope([]byte("e30." + payload + ".c2ln"))
+	require.Nil(t, err)
+	assert.Equal(t, "1", env.id)
+	assert.Equal(t, "test", env.issuer)
+	assert.Equal(t, "test.message", env.typ)
+}
+
+func TestEnvelopeCache(t *testing.T) {
+	ec := newEnvelopeCache(2)
+
+	m1, m2, m3 := &msgproto.Message{}, &msgproto.Message{}, &msgproto.Message{}
+
+	ec.put(m1, &envelope{id: "1"})
+	ec.put(m2, &envelope{id: "2"})
+	ec.put(m3, &envelope{id: "3"})
+
+	assert.Nil(t, ec.get(m1))
+	assert.Equal(t, "2", ec.get(m2).id)
+	assert.Equal(t, "3", ec.get(m3).id)
+}
@@ -0,0 +1,28 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import "log"
+
+// report surfaces an error from one of the client's background goroutines.
+// The error is passed to the OnError handler, or logged if there is none,
+// and is published on the Errors channel if there is room
+func (c *Client) report(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	} else {
+		log.Println(err)
+	}
+
+	select {
+	case c.errors <- err:
+	default:
+	}
+}
+
+// Errors returns a channel of errors that occur in the background, such as
+// undecodable frames, write failures and exhausted reconnect attempts.
+// Errors are discarded if the channel is full
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventBufferSize is the number of events buffered for Events
+const DefaultEventBufferSize = 64
+
+// ErrRequestTimeout is returned when the server or a peer does not respond
+// to a request in time
+var ErrRequestTimeout = errors.New("request timed out")
+
+// Event is something that happened to the client, received from Events.
+// It is one of Connected, Disconnected, ReconnectAttempt, MessageDropped,
+// RequestTimedOut or ACLChanged
+type Event interface {
+	event()
+}
+
+// Connected is emitted when the client has connected and authenticated
+type Connected struct{}
+
+// Disconnected is emitted when the connection is closed. Err is nil if the
+// client closed it
+type Disconnected struct {
+	Err error
+}
+
+// ReconnectAttempt is emitted before each attempt to reconnect, starting at one
+type ReconnectAttempt struct {
+	N int
+}
+
+// MessageDropped is emitted when a received message is not delivered.
+// ID is empty if the frame could not be decoded, and Err is nil if the
+// message was rejected by a sender policy or rate limit
+type MessageDropped struct {
+	ID  string
+	Err error
+}
+
+// RequestTimedOut is emitted when a request is not responded to in time
+type RequestTimedOut struct {
+	ID string
+}
+
+// ACLChanged is emitted when a sender is permitted or blocked. Expires is
+// zero for blocked senders. Remote is true if the change was made by
+// another device of the identity and pushed by the server
+type ACLChanged struct {
+	SelfID    string
+	Permitted bool
+	Expires   time.Time
+	Remote    bool
+}
+
+func (Connected) event()        {}
+func (Disconnected) event()     {}
+func (ReconnectAttempt) event() {}
+func (MessageDropped) event()   {}
+func (RequestTimedOut) event()  {}
+func (ACLChanged) event()       {}
+
+// Events returns a channel of the client's events, for supervisory code
+// that needs to observe the client. Events are discarded if the channel
+// is full
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+func (c *Client) emit(e Event) {
+	select {
+	case c.events <- e:
+	default:
+	}
+}
+
+// dropped counts a received message that was not delivered and reports
+// the error that caused it, if any
+func (c *Client) dropped(id string, err error) {
+	atomic.AddInt64(&c.counters.dropped, 1)
+	c.emit(MessageDropped{ID: id, Err: err})
+
+	if err != nil {
+		c.report(err)
+	}
+}
+
+// timedOut emits a RequestTimedOut event if err is a timeout
+func (c *Client) timedOut(id string, err error) {
+	if err == ErrRequestTimeout {
+		c.emit(RequestTimedOut{ID: id})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, c *Client) Event {
+	select {
+	case e := <-c.Events():
+		return e
+	case <-time.After(time.Second * 2):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	require.Nil(t, c.PermitSender("alice", time.Now().Add(time.Hour).Truncate(time.Second)))
+
+	e, ok := nextEvent(t, c).(ACLChanged)
+	require.True(t, ok)
+	assert.Equal(t, "alice", e.SelfID)
+	assert.True(t, e.Permitted)
+	assert.False(t, e.Expires.IsZero())
+
+	require.Nil(t, c.BlockSender("alice"))
+	assert.Equal(t, ACLChanged{SelfID: "alice"}, nextEvent(t, c))
+
+	s.out <- []byte("not a frame")
+
+	dropped, ok := nextEvent(t, c).(MessageDropped)
+	require.True(t, ok)
+	assert.Empty(t, dropped.ID)
+	assert.NotNil(t, dropped.Err)
+
+	c.JWSRegister("conversation")
+	_, err = c.JWSResponse("conversation", time.Millisecond)
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Equal(t, RequestTimedOut{ID: "conversation"}, nextEvent(t, c))
+
+	s.disconnect()
+
+	disconnected, ok := nextEvent(t, c).(Disconnected)
+	require.True(t, ok)
+	assert.NotNil(t, disconnected.Err)
+
+	assert.Equal(t, ReconnectAttempt{N: 1}, nextEvent(t, c))
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+}
+
+func TestClientEventsClose(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, EventBuffer(1))
+	require.Nil(t, err)
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	c.Close()
+
+	assert.Equal(t, Disconnected{}, nextEvent(t, c))
+}
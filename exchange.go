@@ -0,0 +1,109 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// DefaultRequestExpiry is how long a request sent with Request is valid for if its context has no deadline
+const DefaultRequestExpiry = time.Minute * 15
+
+// Request signs a payload with a new conversation id, sends it to recipient
+// and waits for the response in the same conversation. The payload must have
+// a typ claim, and the iss, jti, cid, iat, exp, sub and aud claims are set.
+// The response is verified with the PublicKeys option and must be issued by
+// the recipient's self ID. Its payload is returned
+func (c *Client) Request(ctx context.Context, recipient string, payload map[string]interface{}) (map[string]interface{}, error) {
+	if recipient == "" {
+		return nil, errors.New("request has no recipient")
+	}
+
+	typ, _ := payload["typ"].(string)
+	if typ == "" {
+		return nil, errors.New("request payload has no typ")
+	}
+
+	expiry := DefaultRequestExpiry
+	if deadline, ok := ctx.Deadline(); ok {
+		expiry = deadline.Sub(c.clock.Now())
+	}
+
+	cid, signed, err := c.SignRequest(typ, selfIDOf(recipient), expiry, conversationFields(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := c.JWSRequestAndWait(ctx, cid, c.RequestMessage(recipient, signed))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp map[string]interface{}
+
+	claims, err := c.DecodePayload(m, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ConversationID != cid {
+		return nil, errors.New("response does not belong to the conversation")
+	}
+
+	if claims.Issuer != selfIDOf(recipient) {
+		return nil, errors.New("response is not from the recipient")
+	}
+
+	return resp, nil
+}
+
+// Respond signs a payload in the conversation of a received message and
+// sends it to the device that sent the message. The payload must have a typ
+// claim, and the iss, jti, cid, iat, exp, sub and aud claims are set from the
+// original message, which must have a cid claim issued by its sender
+func (c *Client) Respond(original *msgproto.Message, payload map[string]interface{}) error {
+	env, err := c.envelope(original)
+	if err != nil {
+		return err
+	}
+
+	if env.conversationID == "" {
+		return errors.New("message has no conversation id")
+	}
+
+	if original.Sender == "" || selfIDOf(original.Sender) != env.issuer {
+		return errors.New("payload issuer does not match sender")
+	}
+
+	typ, _ := payload["typ"].(string)
+	if typ == "" {
+		return errors.New("response payload has no typ")
+	}
+
+	signed, err := c.signConversation(typ, env.conversationID, env.issuer, DefaultRequestExpiry, conversationFields(payload))
+	if err != nil {
+		return err
+	}
+
+	return c.Send(c.RequestMessage(original.Sender, signed))
+}
+
+// conversationFields returns the claims of a payload other than those set
+// when it is signed in a conversation
+func conversationFields(payload map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(payload))
+
+	for k, v := range payload {
+		switch k {
+		case "typ", "jti", "cid", "iss", "iat", "exp", "sub", "aud":
+		default:
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRequest(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+	defer c.Close()
+
+	respond := func(issuer string) {
+		m := <-s.in
+
+		env, err := parseEnvelope(m.Ciphertext)
+		require.Nil(t, err)
+		assert.Equal(t, "quote.req", env.typ)
+		assert.Equal(t, "someID", env.issuer)
+		assert.Equal(t, "user:1", m.Recipient)
+
+		s.out <- &msgproto.Message{
+			Type:      msgproto.MsgType_MSG,
+			Sender:    issuer + ":1",
+			Recipient: "someID:1",
+			Ciphertext: testSignedPayload(privkey, map[string]interface{}{
+				"jti":   c.NewID(),
+				"typ":   "quote.resp",
+				"iss":   issuer,
+				"cid":   env.conversationID,
+				"price": 10,
+			}),
+		}
+	}
+
+	go respond("user")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := c.Request(ctx, "user:1", map[string]interface{}{"typ": "quote.req", "item": "apple"})
+	require.Nil(t, err)
+	assert.Equal(t, "quote.resp", resp["typ"])
+	assert.Equal(t, float64(10), resp["price"])
+
+	// responses in the conversation must come from the recipient
+	go respond("other")
+
+	_, err = c.Request(ctx, "user:1", map[string]interface{}{"typ": "quote.req"})
+	assert.EqualError(t, err, "response is not from the recipient")
+
+	_, err = c.Request(ctx, "user:1", map[string]interface{}{"item": "apple"})
+	assert.EqualError(t, err, "request payload has no typ")
+
+	short, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	go func() { <-s.in }()
+
+	_, err = c.Request(short, "user:1", map[string]interface{}{"typ": "quote.req"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClientRespond(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.out <- &msgproto.Message{
+		Type:       msgproto.MsgType_MSG,
+		Id:         "1",
+		Sender:     "user:7",
+		Recipient:  "someID:1",
+		Ciphertext: testSignedPayload(privkey, map[string]interface{}{"jti": "r1", "typ": "quote.req", "iss": "user", "cid": "conversation-1"}),
+	}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+
+	err = c.Respond(m, map[string]interface{}{"typ": "quote.resp", "cid": "other", "price": 10})
+	require.Nil(t, err)
+
+	resp := <-s.in
+	assert.Equal(t, "user:7", resp.Recipient)
+	assert.Equal(t, "someID:1", resp.Sender)
+
+	env, err := parseEnvelope(resp.Ciphertext)
+	require.Nil(t, err)
+	assert.Equal(t, "quote.resp", env.typ)
+	assert.Equal(t, "someID", env.issuer)
+	assert.Equal(t, "conversation-1", env.conversationID)
+
+	var claims Claims
+	require.Nil(t, json.Unmarshal(env.payload, &claims))
+	assert.Equal(t, "user", claims.Subject)
+	assert.Equal(t, "user", claims.Audience)
+
+	err = c.Respond(m, map[string]interface{}{"price": 10})
+	assert.EqualError(t, err, "response payload has no typ")
+
+	// messages outside a conversation, or not issued by their sender, can't be answered
+	err = c.Respond(&msgproto.Message{Sender: "user:7", Ciphertext: testSignedPayload(privkey, map[string]interface{}{"typ": "quote.req", "iss": "user"})}, map[string]interface{}{"typ": "quote.resp"})
+	assert.EqualError(t, err, "message has no conversation id")
+
+	err = c.Respond(&msgproto.Message{Sender: "other:1", Ciphertext: m.Ciphertext}, map[string]interface{}{"typ": "quote.resp"})
+	assert.EqualError(t, err, "payload issuer does not match sender")
+}
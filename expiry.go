@@ -0,0 +1,45 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// withExpiry adds an exp claim ttl from now to an encoded payload that
+// does not have one
+func (c *Client) withExpiry(data []byte, ttl time.Duration) ([]byte, error) {
+	var claims map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &claims)
+	if err != nil {
+		return nil, errors.New("payload with a ttl must be a json object")
+	}
+
+	if _, ok := claims["exp"]; ok {
+		return data, nil
+	}
+
+	claims["exp"] = json.RawMessage(c.serverNow().Add(ttl).Format(`"` + time.RFC3339 + `"`))
+
+	return json.Marshal(claims)
+}
+
+// expired returns true if a received message should be discarded because
+// its payload expired more than the grace period ago
+func (c *Client) expired(in *inbound) bool {
+	if c.expiryGrace < 0 || in.env == nil || in.env.expires.IsZero() {
+		return false
+	}
+
+	if !c.serverNow().After(in.env.expires.Add(c.expiryGrace)) {
+		return false
+	}
+
+	atomic.AddInt64(&c.counters.expired, 1)
+
+	return true
+}
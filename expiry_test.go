@@ -0,0 +1,44 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientMessageExpiry(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, MessageTTL(time.Hour), DiscardExpired(time.Minute))
+	require.Nil(t, err)
+	defer c.Close()
+
+	payload, err := c.Sign(map[string]interface{}{"jti": "1"})
+	require.Nil(t, err)
+
+	env, err := parseEnvelope(payload)
+	require.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), env.expires, time.Minute)
+
+	// an explicit expiry is kept
+	payload, err = c.Sign(map[string]interface{}{"jti": "2", "exp": time.Now().Add(-2 * time.Minute).Unix()})
+	require.Nil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "expired", Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+
+	payload, err = c.Sign(map[string]interface{}{"jti": "3", "exp": time.Now().Add(-30 * time.Second).Unix()})
+	require.Nil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "grace", Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "grace", m.Id)
+	assert.Equal(t, int64(1), c.Stats().Expired)
+}
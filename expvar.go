@@ -0,0 +1,75 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+)
+
+// ExpvarPrefix is the expvar name clients publish their stats under
+const ExpvarPrefix = "messaging"
+
+// expvarClients holds the client publishing under each expvar name. expvar
+// can't remove variables, so a name stays published once used and reports
+// null while no client holds it
+var expvarClients = struct {
+	byName map[string]*Client
+	mu     sync.Mutex
+}{
+	byName: make(map[string]*Client),
+}
+
+// publishExpvar publishes the client's stats if PublishExpvar was used
+func (c *Client) publishExpvar() error {
+	if c.expvarName == "" {
+		return nil
+	}
+
+	expvarClients.mu.Lock()
+	defer expvarClients.mu.Unlock()
+
+	held, ok := expvarClients.byName[c.expvarName]
+	if held != nil {
+		return errors.New("expvar " + c.expvarName + " is published by another client")
+	}
+
+	if !ok && expvar.Get(c.expvarName) != nil {
+		return errors.New("expvar " + c.expvarName + " is already published")
+	}
+
+	if !ok {
+		name := c.expvarName
+
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			expvarClients.mu.Lock()
+			pc := expvarClients.byName[name]
+			expvarClients.mu.Unlock()
+
+			if pc == nil {
+				return nil
+			}
+
+			return pc.Stats()
+		}))
+	}
+
+	expvarClients.byName[c.expvarName] = c
+
+	return nil
+}
+
+// unpublishExpvar releases the client's expvar name
+func (c *Client) unpublishExpvar() {
+	if c.expvarName == "" {
+		return
+	}
+
+	expvarClients.mu.Lock()
+	defer expvarClients.mu.Unlock()
+
+	if expvarClients.byName[c.expvarName] == c {
+		expvarClients.byName[c.expvarName] = nil
+	}
+}
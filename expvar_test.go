@@ -0,0 +1,49 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/google/uuid"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPublishExpvar(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublishExpvar("expvar-test"))
+	require.Nil(t, err)
+
+	err = c.Send(&msgproto.Message{Id: uuid.New().String(), Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset"})
+	require.Nil(t, err)
+
+	_, err = wait(s.in)
+	require.Nil(t, err)
+
+	v := expvar.Get(ExpvarPrefix + ".expvar-test")
+	require.NotNil(t, v)
+
+	var stats Stats
+	require.Nil(t, json.Unmarshal([]byte(v.String()), &stats))
+	assert.Equal(t, int64(1), stats.MessagesSent)
+
+	// the name is held until the client is closed
+	_, err = New(s.endpoint, "someID", "2", privkey, PublishExpvar("expvar-test"))
+	assert.NotNil(t, err)
+
+	c.Close()
+	assert.Equal(t, "null", v.String())
+
+	c, err = New(s.endpoint, "someID", "1", privkey, PublishExpvar("expvar-test"))
+	require.Nil(t, err)
+	defer c.Close()
+
+	require.Nil(t, json.Unmarshal([]byte(v.String()), &stats))
+	assert.Equal(t, int64(0), stats.MessagesSent)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultAttemptDelay is how long to wait for a connection attempt before
+	// starting one to the next address, as recommended by RFC 8305
+	DefaultAttemptDelay = 250 * time.Millisecond
+	// DefaultAddressTimeout is how long a connection attempt to a single address may take
+	DefaultAddressTimeout = 5 * time.Second
+)
+
+// happyEyeballs dials the addresses of a host in parallel, staggered by a
+// delay, alternating between IPv6 and IPv4 addresses as described by
+// RFC 8305. A broken route for one address family costs the attempt delay
+// rather than the whole connect timeout
+type happyEyeballs struct {
+	attemptDelay   time.Duration
+	addressTimeout time.Duration
+	lookup         func(ctx context.Context, host string) ([]net.IPAddr, error)
+	dial           func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// DialContext connects to the first address of the host that accepts a connection
+func (he *happyEyeballs) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := he.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := interleaveFamilies(ips)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+
+	attempt := func(ip net.IPAddr) {
+		actx, acancel := context.WithTimeout(ctx, he.addressTimeout)
+		defer acancel()
+
+		conn, err := he.dialer()(actx, network, net.JoinHostPort(ip.String(), port))
+		results <- dialResult{conn: conn, err: err}
+	}
+
+	next := time.NewTimer(0)
+	defer next.Stop()
+
+	started, failed := 0, 0
+
+	var firstErr error
+
+	for {
+		select {
+		case <-next.C:
+		case r := <-results:
+			if r.err == nil {
+				go closeLateConns(results, started-failed-1)
+				return r.conn, nil
+			}
+
+			failed++
+			if firstErr == nil {
+				firstErr = r.err
+			}
+
+			if failed == len(addrs) {
+				return nil, firstErr
+			}
+
+			if started < len(addrs) {
+				// a failed attempt starts the next one without waiting for the delay
+				break
+			}
+			continue
+		case <-ctx.Done():
+			go closeLateConns(results, started-failed)
+			return nil, ctx.Err()
+		}
+
+		if started < len(addrs) {
+			go attempt(addrs[started])
+			started++
+			next.Reset(he.attemptDelay)
+		}
+	}
+}
+
+func (he *happyEyeballs) dialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if he.dial != nil {
+		return he.dial
+	}
+
+	var d net.Dialer
+
+	return d.DialContext
+}
+
+// closeLateConns closes connections from attempts that finish after another has succeeded
+func closeLateConns(results chan dialResult, pending int) {
+	for i := 0; i < pending; i++ {
+		r := <-results
+		if r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// interleaveFamilies orders addresses alternating between IPv6 and IPv4,
+// starting with IPv6
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	addrs := make([]net.IPAddr, 0, len(ips))
+
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+
+	return addrs
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+
+	addrs := interleaveFamilies(ips)
+	require.Len(t, addrs, 3)
+	assert.Equal(t, "2001:db8::1", addrs[0].String())
+	assert.Equal(t, "10.0.0.1", addrs[1].String())
+	assert.Equal(t, "10.0.0.2", addrs[2].String())
+}
+
+func TestHappyEyeballsFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	var d net.Dialer
+
+	he := &happyEyeballs{
+		attemptDelay:   50 * time.Millisecond,
+		addressTimeout: 10 * time.Second,
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+		},
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == net.JoinHostPort("2001:db8::1", port) {
+				// a broken route hangs until the attempt is abandoned
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+
+	started := time.Now()
+
+	conn, err := he.DialContext(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+	assert.True(t, time.Since(started) < time.Second)
+}
+
+func TestHappyEyeballsAllFail(t *testing.T) {
+	he := &happyEyeballs{
+		attemptDelay:   time.Second,
+		addressTimeout: time.Second,
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("10.0.0.1")}}, nil
+		},
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+
+	started := time.Now()
+
+	_, err := he.DialContext(context.Background(), "tcp", "example.com:443")
+	assert.EqualError(t, err, "connection refused")
+
+	// failures start the next attempt without waiting for the delay
+	assert.True(t, time.Since(started) < time.Second)
+}
+
+func TestClientHappyEyeballs(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, HappyEyeballs(0, 0))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.False(t, c.IsClosed())
+}
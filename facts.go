@@ -0,0 +1,141 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// TypeFactRequest is the typ claim of an identity information request
+	TypeFactRequest = "identities.facts.query.req"
+	// TypeFactResponse is the typ claim of an identity information response
+	TypeFactResponse = "identities.facts.query.resp"
+	// DefaultFactRequestExpiry is how long a fact request is valid for if no expiry is set
+	DefaultFactRequestExpiry = time.Minute * 15
+)
+
+// ErrFactRequestRejected is returned when the recipient rejects a fact request
+var ErrFactRequestRejected = errors.New("fact request was rejected")
+
+// Fact is a fact that is requested, or returned with its attestations
+type Fact struct {
+	Fact         string            `json:"fact"`
+	Sources      []string          `json:"sources,omitempty"`
+	Attestations []json.RawMessage `json:"attestations,omitempty"`
+}
+
+// FactRequest describes the facts requested from another identity
+type FactRequest struct {
+	// Recipient is the self ID and device the request is sent to, formatted as selfID:deviceID
+	Recipient   string
+	Facts       []Fact
+	Description string
+	Expiry      time.Duration
+}
+
+// FactResponse is the verified response to a fact request
+type FactResponse struct {
+	Claims *Claims
+	Status string
+	Facts  []Fact
+}
+
+// Attestation is a verified attestation of a fact
+type Attestation struct {
+	Claims   *Claims
+	Source   string
+	Verified bool
+	Value    interface{}
+}
+
+// RequestFacts signs and sends a fact request, then waits for the response.
+// The response is verified with the PublicKeys option, and
+// ErrFactRequestRejected is returned if the recipient did not accept it
+func (c *Client) RequestFacts(ctx context.Context, req *FactRequest) (*FactResponse, error) {
+	if req.Recipient == "" {
+		return nil, errors.New("fact request has no recipient")
+	}
+
+	if len(req.Facts) < 1 {
+		return nil, errors.New("fact request has no facts")
+	}
+
+	expiry := req.Expiry
+	if expiry == 0 {
+		expiry = DefaultFactRequestExpiry
+	}
+
+	cid, payload, err := c.SignRequest(TypeFactRequest, selfIDOf(req.Recipient), expiry, map[string]interface{}{
+		"facts":       req.Facts,
+		"description": req.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.JWSRequestAndWait(ctx, cid, c.RequestMessage(req.Recipient, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Status string `json:"status"`
+		Facts  []Fact `json:"facts"`
+	}
+
+	claims, err := c.verifyResponse(resp, TypeFactResponse, cid, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body.Status != "accepted" {
+		return nil, ErrFactRequestRejected
+	}
+
+	return &FactResponse{Claims: claims, Status: body.Status, Facts: body.Facts}, nil
+}
+
+// Attestations verifies and decodes the attestations of a fact. Each
+// attestation must be signed by its issuer, and carries the attested value
+// under the fact's name
+func (c *Client) Attestations(f Fact) ([]*Attestation, error) {
+	attestations := make([]*Attestation, 0, len(f.Attestations))
+
+	for _, data := range f.Attestations {
+		var raw string
+
+		// attestations may be compact serialized strings or JSON objects
+		if json.Unmarshal(data, &raw) != nil {
+			raw = string(data)
+		}
+
+		env, err := parseEnvelope([]byte(raw))
+		if err != nil {
+			return nil, err
+		}
+
+		payload, claims, err := c.verifySigned([]byte(raw), env.issuer)
+		if err != nil {
+			return nil, err
+		}
+
+		var body map[string]interface{}
+
+		err = json.Unmarshal(payload, &body)
+		if err != nil {
+			return nil, err
+		}
+
+		a := &Attestation{Claims: claims, Value: body[f.Fact]}
+		a.Source, _ = body["source"].(string)
+		a.Verified, _ = body["verified"].(bool)
+
+		attestations = append(attestations, a)
+	}
+
+	return attestations, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRequestFacts(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	respond := func(status string) {
+		m := <-s.in
+
+		env, err := parseEnvelope(m.Ciphertext)
+		require.Nil(t, err)
+		assert.Equal(t, TypeFactRequest, env.typ)
+		assert.Equal(t, "someID", env.issuer)
+
+		attestation := testSignedPayload(privkey, map[string]interface{}{
+			"jti":          "a1",
+			"iss":          "attester",
+			"source":       "user_specified",
+			"verified":     true,
+			"phone_number": "+441234567890",
+		})
+
+		s.out <- &msgproto.Message{
+			Type:      msgproto.MsgType_MSG,
+			Sender:    "user:1",
+			Recipient: "someID:1",
+			Ciphertext: testSignedPayload(privkey, map[string]interface{}{
+				"jti":    "r1",
+				"typ":    TypeFactResponse,
+				"iss":    "user",
+				"cid":    env.conversationID,
+				"status": status,
+				"facts": []interface{}{
+					map[string]interface{}{"fact": "phone_number", "attestations": []json.RawMessage{attestation}},
+				},
+			}),
+		}
+	}
+
+	go respond("accepted")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := c.RequestFacts(ctx, &FactRequest{Recipient: "user:1", Facts: []Fact{{Fact: "phone_number"}}})
+	require.Nil(t, err)
+	require.Len(t, resp.Facts, 1)
+	assert.Equal(t, "user", resp.Claims.Issuer)
+
+	attestations, err := c.Attestations(resp.Facts[0])
+	require.Nil(t, err)
+	require.Len(t, attestations, 1)
+	assert.Equal(t, "+441234567890", attestations[0].Value)
+	assert.Equal(t, "user_specified", attestations[0].Source)
+	assert.True(t, attestations[0].Verified)
+	assert.Equal(t, "attester", attestations[0].Claims.Issuer)
+
+	go respond("rejected")
+
+	_, err = c.RequestFacts(ctx, &FactRequest{Recipient: "user:1", Facts: []Fact{{Fact: "phone_number"}}})
+	assert.Equal(t, ErrFactRequestRejected, err)
+
+	_, err = c.RequestFacts(ctx, &FactRequest{Recipient: "user:1"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// FilePrefix prefixes the typ claim of file transfer frames
+	FilePrefix = "file."
+	// DefaultFileChunkSize is the amount of file data sent in each message
+	DefaultFileChunkSize = 32 * 1024
+)
+
+const (
+	fileChunk = FilePrefix + "chunk"
+	fileDone  = FilePrefix + "done"
+)
+
+// ErrChecksumMismatch is reported when a received file or chunk does not match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// FileMeta describes a file being transferred
+type FileMeta struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// FileTransfer is the progress of a file being sent. If sending fails it
+// can be passed to ResumeFile to continue from the last chunk that was sent
+type FileTransfer struct {
+	ID        string
+	Recipient string
+	Meta      FileMeta
+	Offset    int64
+	sum       hash.Hash
+}
+
+// FileHandler returns the writer a received file is written to. The writer
+// is closed once the whole file has been received and its checksum verified,
+// and is left open if the transfer fails
+type FileHandler func(id string, meta FileMeta) (io.WriteCloser, error)
+
+type fileFrame struct {
+	Meta   FileMeta `json:"meta"`
+	Offset int64    `json:"offset"`
+	Data   []byte   `json:"data,omitempty"`
+	Sum    string   `json:"sum"`
+}
+
+// files tracks files being received
+type files struct {
+	handler  FileHandler
+	incoming map[string]*incomingFile
+	mu       sync.Mutex
+}
+
+type incomingFile struct {
+	sender string
+	w      io.WriteCloser
+	offset int64
+	sum    hash.Hash
+}
+
+// SendFile sends the contents of r to recipient, formatted as
+// selfID:deviceID, in checksummed chunks. On failure the returned transfer
+// records how much was sent so it can be resumed
+func (c *Client) SendFile(ctx context.Context, recipient string, r io.Reader, meta FileMeta) (*FileTransfer, error) {
+	t := &FileTransfer{
+		ID:        c.NewID(),
+		Recipient: recipient,
+		Meta:      meta,
+		sum:       sha256.New(),
+	}
+
+	return t, c.ResumeFile(ctx, t, r)
+}
+
+// ResumeFile continues sending a file from the transfer's offset. r must
+// be positioned at the offset
+func (c *Client) ResumeFile(ctx context.Context, t *FileTransfer, r io.Reader) error {
+	buf := make([]byte, DefaultFileChunkSize)
+
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+
+		if n > 0 {
+			chunk := sha256.Sum256(buf[:n])
+
+			err = c.sendFileFrame(t, fileChunk, fileFrame{
+				Meta:   t.Meta,
+				Offset: t.Offset,
+				Data:   buf[:n],
+				Sum:    hex.EncodeToString(chunk[:]),
+			})
+			if err != nil {
+				return err
+			}
+
+			t.sum.Write(buf[:n])
+			t.Offset += int64(n)
+		}
+
+		if rerr != nil {
+			break
+		}
+	}
+
+	return c.sendFileFrame(t, fileDone, fileFrame{
+		Meta:   t.Meta,
+		Offset: t.Offset,
+		Sum:    hex.EncodeToString(t.sum.Sum(nil)),
+	})
+}
+
+func (c *Client) sendFileFrame(t *FileTransfer, typ string, f fileFrame) error {
+	payload, err := c.Sign(map[string]interface{}{
+		"typ":    typ,
+		"jti":    c.NewID(),
+		"cid":    t.ID,
+		"iss":    c.selfID,
+		"iat":    c.serverNow().Unix(),
+		"meta":   f.Meta,
+		"offset": f.Offset,
+		"data":   f.Data,
+		"sum":    f.Sum,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Send(c.RequestMessage(t.Recipient, payload))
+}
+
+// ReceiveFiles sets the handler for files sent to the client. Chunks that
+// have already been received are ignored, so senders can resume transfers
+func (c *Client) ReceiveFiles(handler FileHandler) {
+	c.files.mu.Lock()
+	c.files.handler = handler
+	c.files.mu.Unlock()
+}
+
+// deliverFile writes a received file frame to its transfer. It returns
+// false if the message is not a file frame or no handler is set
+func (c *Client) deliverFile(in *inbound) bool {
+	if in.env == nil || !strings.HasPrefix(in.env.typ, FilePrefix) {
+		return false
+	}
+
+	c.files.mu.Lock()
+	defer c.files.mu.Unlock()
+
+	if c.files.handler == nil {
+		return false
+	}
+
+	var f fileFrame
+
+	claims, err := c.DecodePayload(in.msg, &f)
+	if err == nil {
+		err = c.files.receive(in.msg.Sender, claims, &f)
+	}
+
+	if err != nil {
+		c.dropped(in.msg.Id, fmt.Errorf("dropped file frame: %w", err))
+	}
+
+	return true
+}
+
+func (fs *files) receive(sender string, claims *Claims, f *fileFrame) error {
+	if claims.ConversationID == "" {
+		return errors.New("frame has no transfer id")
+	}
+
+	in, ok := fs.incoming[claims.ConversationID]
+	if ok && in.sender != sender {
+		return errors.New("frame was not sent by the transfer's sender")
+	}
+
+	if !ok {
+		if f.Offset != 0 {
+			return errors.New("transfer is not in progress")
+		}
+
+		w, err := fs.handler(claims.ConversationID, f.Meta)
+		if err != nil {
+			return err
+		}
+
+		in = &incomingFile{sender: sender, w: w, sum: sha256.New()}
+		fs.incoming[claims.ConversationID] = in
+	}
+
+	switch claims.Type {
+	case fileChunk:
+		if f.Offset < in.offset {
+			// already received before the transfer was resumed
+			return nil
+		}
+
+		if f.Offset > in.offset {
+			return fmt.Errorf("expected chunk at offset %d but got %d", in.offset, f.Offset)
+		}
+
+		sum := sha256.Sum256(f.Data)
+		if hex.EncodeToString(sum[:]) != f.Sum {
+			return ErrChecksumMismatch
+		}
+
+		_, err := in.w.Write(f.Data)
+		if err != nil {
+			return err
+		}
+
+		in.sum.Write(f.Data)
+		in.offset += int64(len(f.Data))
+	case fileDone:
+		delete(fs.incoming, claims.ConversationID)
+
+		if f.Offset != in.offset || hex.EncodeToString(in.sum.Sum(nil)) != f.Sum {
+			return ErrChecksumMismatch
+		}
+
+		return in.w.Close()
+	}
+
+	return nil
+}
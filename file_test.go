@@ -0,0 +1,102 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFile struct {
+	bytes.Buffer
+	meta   FileMeta
+	closed chan struct{}
+}
+
+func (f *testFile) Close() error {
+	close(f.closed)
+	return nil
+}
+
+// failingReader fails after reading n bytes
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("read failed")
+	}
+
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+
+	n, err := f.r.Read(p)
+	f.n -= n
+
+	return n, err
+}
+
+func TestClientSendFile(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	// send everything back to the client
+	go func() {
+		for {
+			m := <-s.in
+			s.out <- &m
+		}
+	}()
+
+	received := &testFile{closed: make(chan struct{})}
+
+	c.ReceiveFiles(func(id string, meta FileMeta) (io.WriteCloser, error) {
+		received.meta = meta
+		return received, nil
+	})
+
+	data := make([]byte, DefaultFileChunkSize*3+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	meta := FileMeta{Name: "test.bin", ContentType: "application/octet-stream", Size: int64(len(data))}
+	r := bytes.NewReader(data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tr, err := c.SendFile(ctx, "someID:1", &failingReader{r: r, n: DefaultFileChunkSize * 2}, meta)
+	require.NotNil(t, err)
+	assert.Equal(t, int64(DefaultFileChunkSize*2), tr.Offset)
+
+	err = c.ResumeFile(ctx, tr, r)
+	require.Nil(t, err)
+
+	select {
+	case <-received.closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("file was not received")
+	}
+
+	assert.Equal(t, meta, received.meta)
+	assert.Equal(t, data, received.Bytes())
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// SkipPolicy is what ReceiveOfType does with the messages it skips
+type SkipPolicy int
+
+const (
+	// HoldSkipped keeps skipped messages in the order they were received and
+	// returns them from later calls to Receive and ReceiveOfType
+	HoldSkipped SkipPolicy = iota
+	// RequeueSkipped puts skipped messages back on the receive queue, where
+	// they are received after the messages already queued
+	RequeueSkipped
+)
+
+// maxSkipped is the number of messages ReceiveOfType holds or requeues
+// from a queue before it returns ErrTooManySkipped
+const maxSkipped = DefaultBufferSize
+
+// ErrTooManySkipped is returned by ReceiveOfType when it can't skip any more
+// messages until the held messages have been received
+var ErrTooManySkipped = errors.New("too many messages skipped")
+
+// heldMessages are messages skipped by ReceiveOfType
+type heldMessages struct {
+	messages []*msgproto.Message
+	mu       sync.Mutex
+}
+
+// push holds a message, returning false if too many are held
+func (h *heldMessages) push(m *msgproto.Message) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.messages) >= maxSkipped {
+		return false
+	}
+
+	h.messages = append(h.messages, m)
+
+	return true
+}
+
+// full returns true if no more messages can be held
+func (h *heldMessages) full() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.messages) >= maxSkipped
+}
+
+// take removes and returns the first held message that matches, if any
+func (h *heldMessages) take(match func(*msgproto.Message) bool) *msgproto.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, m := range h.messages {
+		if match(m) {
+			h.messages = append(h.messages[:i], h.messages[i+1:]...)
+			return m
+		}
+	}
+
+	return nil
+}
+
+// ReceiveOfType receives the next message whose payload has the given typ
+// claim, from the inbound queue the type is delivered to. The payload is
+// not verified. Messages of other types are held or requeued according to
+// the ReceiveSkipPolicy option, up to a limit after which
+// ErrTooManySkipped is returned. Held messages are only returned by
+// Receive, ReceiveQueue and ReceiveOfType, not by ReceiveChan, QueueChan or
+// Messages
+func (c *Client) ReceiveOfType(ctx context.Context, typ string) (*msgproto.Message, error) {
+	match := func(m *msgproto.Message) bool {
+		env, err := c.envelope(m)
+		return err == nil && env.typ == typ
+	}
+
+	ch, held := c.recv, c.held
+	if q := c.queueOfType(typ); q != nil {
+		ch, held = q.ch, q.held
+	}
+
+	m := held.take(match)
+	if m != nil {
+		return m, nil
+	}
+
+	// skipped messages are requeued once the call returns, so they are not
+	// received again by this call
+	var skipped []*msgproto.Message
+	if c.skipPolicy == RequeueSkipped {
+		defer func() {
+			requeue(ch, skipped)
+		}()
+	}
+
+	for {
+		if len(skipped) >= maxSkipped || c.skipPolicy == HoldSkipped && held.full() {
+			return nil, ErrTooManySkipped
+		}
+
+		select {
+		case m := <-ch:
+			c.checkReceiveQueue()
+			if match(m) {
+				return m, nil
+			}
+			if c.skipPolicy == RequeueSkipped {
+				skipped = append(skipped, m)
+			} else {
+				held.push(m)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock.After(time.Second):
+			if c.IsClosed() {
+				return nil, errors.New("connection is closed")
+			}
+		}
+	}
+}
+
+// requeue puts skipped messages back on their queue in order
+func requeue(ch chan *msgproto.Message, skipped []*msgproto.Message) {
+	if len(skipped) == 0 {
+		return
+	}
+
+	go func() {
+		for _, m := range skipped {
+			ch <- m
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientReceiveOfType(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, typ := range []string{"a", "b", "a", "c"} {
+		payload, err := c.Sign(map[string]interface{}{"typ": typ})
+		require.Nil(t, err)
+
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: typ, Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	m, err := c.ReceiveOfType(ctx, "c")
+	require.Nil(t, err)
+	assert.Equal(t, "c", m.Id)
+
+	// skipped messages are held in order
+	m, err = c.ReceiveOfType(ctx, "b")
+	require.Nil(t, err)
+	assert.Equal(t, "b", m.Id)
+
+	for range []int{0, 1} {
+		m, err = c.Receive()
+		require.Nil(t, err)
+		assert.Equal(t, "a", m.Id)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.ReceiveOfType(ctx, "c")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClientReceiveOfTypeRequeue(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ReceiveSkipPolicy(RequeueSkipped))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, typ := range []string{"a", "b"} {
+		payload, err := c.Sign(map[string]interface{}{"typ": typ})
+		require.Nil(t, err)
+
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: typ, Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+	}
+
+	m, err := c.ReceiveOfType(context.Background(), "b")
+	require.Nil(t, err)
+	assert.Equal(t, "b", m.Id)
+
+	select {
+	case m = <-c.ReceiveChan():
+		assert.Equal(t, "a", m.Id)
+	case <-time.After(time.Second):
+		t.Fatal("skipped message was not requeued")
+	}
+}
+
+func TestClientReceiveOfTypeLimits(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ReceiveBuffer(maxSkipped*2), InboundQueue("control", 4, "control.*"))
+	require.Nil(t, err)
+	defer c.Close()
+
+	other, err := c.Sign(map[string]interface{}{"typ": "other"})
+	require.Nil(t, err)
+
+	control, err := c.Sign(map[string]interface{}{"typ": "control.ping"})
+	require.Nil(t, err)
+
+	for i := 0; i < maxSkipped+1; i++ {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "other", Sender: "test:1", Recipient: "someID:1", Ciphertext: other}
+	}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "control", Sender: "test:1", Recipient: "someID:1", Ciphertext: control}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// types delivered to an inbound queue are received from it
+	m, err := c.ReceiveOfType(ctx, "control.ping")
+	require.Nil(t, err)
+	assert.Equal(t, "control", m.Id)
+
+	// skipped messages are held up to a limit
+	_, err = c.ReceiveOfType(ctx, "missing")
+	assert.Equal(t, ErrTooManySkipped, err)
+	assert.Len(t, c.held.messages, maxSkipped)
+
+	m, err = c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "other", m.Id)
+}
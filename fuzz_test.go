@@ -0,0 +1,92 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+//go:build go1.18
+// +build go1.18
+
+package messaging
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// compactJWS returns an unsigned JWS in compact serialization
+func compactJWS(header, payload string) []byte {
+	return []byte(base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln")
+}
+
+func frameSeeds(f *testing.F) {
+	payload := compactJWS(`{"alg":"EdDSA"}`, `{"jti":"1","iss":"alice","typ":"test","cid":"c1","exp":"2030-01-01T00:00:00Z"}`)
+
+	seeds := []proto.Message{
+		&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1", Ciphertext: payload},
+		&msgproto.Message{Id: "2", Type: msgproto.MsgType_MSG, Sender: "alice", Ciphertext: testSignedPayload(privkey, map[string]interface{}{"cid": "c2"})},
+		&msgproto.Notification{Id: "3", Type: msgproto.MsgType_ACK},
+		&msgproto.Notification{Id: "4", Type: msgproto.MsgType_ERR, Error: "rejected", Errtype: msgproto.ErrType_ErrAuth},
+		&msgproto.AccessControlList{Id: "5", Type: msgproto.MsgType_ACL, Command: msgproto.ACLCommand_PERMIT, Payload: payload},
+	}
+
+	for _, m := range seeds {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Add([]byte{})
+	f.Add([]byte{0x08, 0xff, 0xff, 0xff, 0xff, 0x0f})
+}
+
+// FuzzHandleFrame feeds frames through the path the reader decodes and
+// routes them with, which must not panic or block
+func FuzzHandleFrame(f *testing.F) {
+	frameSeeds(f)
+
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), ReceiveBuffer(0))
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer c.Close()
+
+	var hdr msgproto.Header
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			c.handleFrame(nil, &hdr, data)
+		}()
+
+		select {
+		case <-done:
+		case <-c.recv:
+			// delivered messages are discarded so delivery cannot block
+			<-done
+		case <-time.After(time.Second):
+			t.Fatal("handling frame blocked")
+		}
+	})
+}
+
+// FuzzParseEnvelope checks the unverified payload of any message can be
+// parsed for routing without panicking
+func FuzzParseEnvelope(f *testing.F) {
+	f.Add(compactJWS(`{"alg":"EdDSA"}`, `{"jti":"1","iss":"alice","typ":"test","cid":"c1","exp":1700000000}`))
+	f.Add(compactJWS(`{"alg":"EdDSA","cty":"msgpack"}`, "\x81\xa3cid\xa2c1"))
+	f.Add(compactJWS(`{"alg":"EdDSA"}`, "\xd9\xd9\xf7\xa1\x63cid\x62c1"))
+	f.Add(testSignedPayload(privkey, map[string]interface{}{"cid": "c1"}))
+	f.Add([]byte(`{"payload":"e30","signatures":[]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := newInbound(&msgproto.Message{Ciphertext: data})
+		in.conversationID()
+	})
+}
@@ -10,7 +10,6 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.1
 	github.com/stretchr/testify v1.4.0
-	github.com/tidwall/gjson v1.3.4
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	gopkg.in/square/go-jose.v2 v2.4.0
 )
@@ -0,0 +1,337 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// TypeGroupKey is the typ claim of messages that distribute a group key
+const TypeGroupKey = "group.key"
+
+// groupMagic prefixes payloads encrypted with a group key
+var groupMagic = []byte("\x00GRP")
+
+// ErrUnknownGroupKey is reported when a message is encrypted with a group key the client does not have
+var ErrUnknownGroupKey = errors.New("unknown group key")
+
+// GroupKey is a symmetric key shared by the members of a group. Each
+// rotation creates a key with a higher epoch
+type GroupKey struct {
+	GroupID string
+	Epoch   int
+	Owner   string
+	Key     []byte
+	Members []string
+	Created time.Time
+}
+
+// GroupKeyStore persists group keys
+type GroupKeyStore interface {
+	Put(key *GroupKey) error
+	Get(groupID string, epoch int) (*GroupKey, error)
+	// Latest returns the key with the highest epoch, or nil if there is none
+	Latest(groupID string) (*GroupKey, error)
+}
+
+// MemoryGroupKeyStore stores group keys in memory
+type MemoryGroupKeyStore struct {
+	keys map[string]map[int]*GroupKey
+	mu   sync.Mutex
+}
+
+// NewMemoryGroupKeyStore creates an empty group key store
+func NewMemoryGroupKeyStore() *MemoryGroupKeyStore {
+	return &MemoryGroupKeyStore{keys: make(map[string]map[int]*GroupKey)}
+}
+
+// Put stores a key
+func (s *MemoryGroupKeyStore) Put(key *GroupKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epochs, ok := s.keys[key.GroupID]
+	if !ok {
+		epochs = make(map[int]*GroupKey)
+		s.keys[key.GroupID] = epochs
+	}
+
+	epochs[key.Epoch] = key
+
+	return nil
+}
+
+// Get returns the key for a group's epoch
+func (s *MemoryGroupKeyStore) Get(groupID string, epoch int) (*GroupKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[groupID][epoch]
+	if !ok {
+		return nil, ErrUnknownGroupKey
+	}
+
+	return key, nil
+}
+
+// Latest returns the key with the highest epoch for a group
+func (s *MemoryGroupKeyStore) Latest(groupID string) (*GroupKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *GroupKey
+
+	for _, key := range s.keys[groupID] {
+		if latest == nil || key.Epoch > latest.Epoch {
+			latest = key
+		}
+	}
+
+	return latest, nil
+}
+
+type groupEnvelope struct {
+	GroupID    string `json:"gid"`
+	Epoch      int    `json:"epoch"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ct"`
+}
+
+// CreateGroup establishes a key for a group and sends it to each member,
+// formatted as selfID:deviceID. Keys are sent encrypted with the client's
+// Cipher, which must be configured
+func (c *Client) CreateGroup(groupID string, members []string) error {
+	return c.distributeGroupKey(groupID, 1, members)
+}
+
+// RotateGroupKey replaces a group's key and sends the new key to members.
+// Rotating with a new member list adds or removes members from the group
+func (c *Client) RotateGroupKey(groupID string, members []string) error {
+	latest, err := c.groupKeys.Latest(groupID)
+	if err != nil {
+		return err
+	}
+
+	if latest == nil {
+		return ErrUnknownGroupKey
+	}
+
+	return c.distributeGroupKey(groupID, latest.Epoch+1, members)
+}
+
+func (c *Client) distributeGroupKey(groupID string, epoch int, members []string) error {
+	if c.cipher == nil {
+		return errors.New("group keys require a cipher")
+	}
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return err
+	}
+
+	key := &GroupKey{
+		GroupID: groupID,
+		Epoch:   epoch,
+		Owner:   c.selfID,
+		Key:     secret,
+		Members: members,
+		Created: c.clock.Now(),
+	}
+
+	payload, err := c.Sign(map[string]interface{}{
+		"typ":     TypeGroupKey,
+		"jti":     c.NewID(),
+		"cid":     groupID,
+		"iss":     c.selfID,
+		"iat":     c.serverNow().Unix(),
+		"epoch":   epoch,
+		"key":     secret,
+		"members": members,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		err = c.Send(c.RequestMessage(member, payload))
+		if err != nil {
+			return fmt.Errorf("failed to send group key to %s: %w", member, err)
+		}
+	}
+
+	return c.groupKeys.Put(key)
+}
+
+// SendGroup encrypts a message once with the group's latest key and sends
+// it to every member. The message's recipient is ignored
+func (c *Client) SendGroup(groupID string, m *msgproto.Message) error {
+	key, err := c.groupKeys.Latest(groupID)
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		return ErrUnknownGroupKey
+	}
+
+	ciphertext, err := sealGroup(key, m.Ciphertext)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range key.Members {
+		gm := *m
+		gm.Id = c.NewID()
+		gm.Recipient = member
+		gm.Ciphertext = ciphertext
+
+		// the payload is already encrypted, so the pairwise cipher is skipped
+		err = c.persist(&gm)
+		if err == nil {
+			err = c.sendMessage(&gm, time.Time{})
+			c.settle(&gm, err)
+		}
+
+		am := gm
+		am.Ciphertext = m.Ciphertext
+		c.audit(AuditSent, &am, err)
+		c.archive(AuditSent, &am, err)
+
+		if err != nil {
+			return fmt.Errorf("failed to send to %s: %w", member, err)
+		}
+	}
+
+	return nil
+}
+
+func groupAEAD(key *GroupKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func groupAdditionalData(groupID string, epoch int) []byte {
+	return []byte(groupID + ":" + strconv.Itoa(epoch))
+}
+
+func sealGroup(key *GroupKey, plaintext []byte) ([]byte, error) {
+	aead, err := groupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := json.Marshal(groupEnvelope{
+		GroupID:    key.GroupID,
+		Epoch:      key.Epoch,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, groupAdditionalData(key.GroupID, key.Epoch)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, groupMagic...), env...), nil
+}
+
+// openGroup decrypts a payload encrypted with a group key. It returns
+// false if the payload was not encrypted with a group key
+func (c *Client) openGroup(ciphertext []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(ciphertext, groupMagic) {
+		return nil, false, nil
+	}
+
+	var env groupEnvelope
+
+	err := json.Unmarshal(ciphertext[len(groupMagic):], &env)
+	if err != nil {
+		return nil, true, err
+	}
+
+	key, err := c.groupKeys.Get(env.GroupID, env.Epoch)
+	if err != nil {
+		return nil, true, err
+	}
+
+	aead, err := groupAEAD(key)
+	if err != nil {
+		return nil, true, err
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, groupAdditionalData(env.GroupID, env.Epoch))
+
+	return plaintext, true, err
+}
+
+// deliverGroupKey stores a group key sent to the client. It returns false
+// if the message does not carry a group key
+func (c *Client) deliverGroupKey(in *inbound) bool {
+	if in.env == nil || in.env.typ != TypeGroupKey {
+		return false
+	}
+
+	var body struct {
+		Epoch   int      `json:"epoch"`
+		Key     []byte   `json:"key"`
+		Members []string `json:"members"`
+	}
+
+	claims, err := c.DecodePayload(in.msg, &body)
+	if err == nil {
+		err = c.storeGroupKey(&GroupKey{
+			GroupID: claims.ConversationID,
+			Epoch:   body.Epoch,
+			Owner:   claims.Issuer,
+			Key:     body.Key,
+			Members: body.Members,
+			Created: c.clock.Now(),
+		})
+	}
+
+	if err != nil {
+		c.dropped(in.msg.Id, fmt.Errorf("dropped group key: %w", err))
+	}
+
+	return true
+}
+
+func (c *Client) storeGroupKey(key *GroupKey) error {
+	if key.GroupID == "" {
+		return errors.New("group key has no group id")
+	}
+
+	latest, err := c.groupKeys.Latest(key.GroupID)
+	if err != nil {
+		return err
+	}
+
+	// only the group's owner can rotate its key
+	if latest != nil && latest.Owner != key.Owner {
+		return errors.New("group key was not sent by the group's owner")
+	}
+
+	return c.groupKeys.Put(key)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xorCipher is a symmetric cipher that ignores the address
+type xorCipher struct{}
+
+func (xorCipher) Encrypt(recipient, device string, plaintext []byte) ([]byte, error) {
+	return xor(plaintext), nil
+}
+
+func (xorCipher) Decrypt(sender, device string, ciphertext []byte) ([]byte, error) {
+	return xor(ciphertext), nil
+}
+
+func xor(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ 0x55
+	}
+	return out
+}
+
+func TestClientGroup(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys), WithCipher(xorCipher{}), OnError(func(error) {}))
+	require.Nil(t, err)
+
+	err = c.SendGroup("g1", &msgproto.Message{Type: msgproto.MsgType_MSG})
+	assert.Equal(t, ErrUnknownGroupKey, err)
+
+	sent := make(chan []byte, 16)
+
+	// send everything back to the client
+	go func() {
+		for {
+			m := <-s.in
+			sent <- m.Ciphertext
+			s.out <- &m
+		}
+	}()
+
+	members := []string{"someID:1", "other:1"}
+
+	err = c.CreateGroup("g1", members)
+	require.Nil(t, err)
+
+	<-sent
+	<-sent
+
+	err = c.SendGroup("g1", &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "someID:1", Ciphertext: []byte("hello")})
+	require.Nil(t, err)
+
+	// the payload is encrypted once for all members
+	first, second := <-sent, <-sent
+	assert.Equal(t, first, second)
+
+	for i := 0; i < 2; i++ {
+		m, err := c.Receive()
+		require.Nil(t, err)
+		assert.Equal(t, []byte("hello"), m.Ciphertext)
+	}
+
+	err = c.RotateGroupKey("g1", members[:1])
+	require.Nil(t, err)
+	<-sent
+
+	key, err := c.groupKeys.Latest("g1")
+	require.Nil(t, err)
+	assert.Equal(t, 2, key.Epoch)
+
+	// messages encrypted with the previous key can still be read
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "old", Sender: "other:1", Ciphertext: first}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), m.Ciphertext)
+
+	// keys for an existing group from anyone but its owner are rejected
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Ciphertext: xor(testSignedPayload(privkey, map[string]interface{}{
+		"typ":   TypeGroupKey,
+		"iss":   "test",
+		"cid":   "g1",
+		"epoch": 3,
+		"key":   make([]byte, 32),
+	}))}
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Dropped == 1
+	}, time.Second, time.Millisecond*10)
+
+	key, err = c.groupKeys.Latest("g1")
+	require.Nil(t, err)
+	assert.Equal(t, 2, key.Epoch)
+}
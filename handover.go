@@ -0,0 +1,169 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// handoverPollInterval is how often Handover checks whether in-flight work has finished
+const handoverPollInterval = time.Millisecond * 10
+
+// ErrHandedOver is returned by Consume once Handover has been called
+var ErrHandedOver = errors.New("client has been handed over")
+
+// Handover stops the client so a replacement can take over without losing
+// messages, as during a rolling deploy. Consume stops taking new messages
+// and returns ErrHandedOver once its handlers have finished, the messages
+// queued to send are written and acknowledged, and the connection is closed.
+// Messages received but not yet handled are discarded, and the returned
+// state resumes from before the first of them, so they are delivered again
+// to the client it is restored into with the RestoreState option.
+// If the context is done before the work has finished, the client is closed
+// anyway and the state is returned with the context's error, and it still
+// includes any messages that were not written
+func (c *Client) Handover(ctx context.Context) ([]byte, error) {
+	c.handoverOnce.Do(func() {
+		close(c.handingOver)
+	})
+
+	// messages in the outbox are sent by the replacement
+	c.outboxDrainer.stop()
+
+	err := c.awaitHandover(ctx)
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	c.Close()
+
+	offset := c.undelivered(conn)
+
+	data, serr := c.ExportState()
+	if serr != nil {
+		return nil, serr
+	}
+
+	if offset < 0 {
+		return data, err
+	}
+
+	var st state
+
+	serr = json.Unmarshal(data, &st)
+	if serr != nil {
+		return nil, serr
+	}
+
+	st.Offset = offset
+
+	data, serr = json.Marshal(&st)
+	if serr != nil {
+		return nil, serr
+	}
+
+	return data, err
+}
+
+// handedOver returns true once Handover has been called
+func (c *Client) handedOver() bool {
+	select {
+	case <-c.handingOver:
+		return true
+	default:
+		return false
+	}
+}
+
+// awaitHandover waits for consumers to finish their handlers and for
+// queued messages to be written and answered
+func (c *Client) awaitHandover(ctx context.Context) error {
+	for {
+		if atomic.LoadInt32(&c.consumers) == 0 && len(c.queued.messages()) == 0 && c.requests.awaiting() == 0 {
+			return nil
+		}
+
+		if c.IsClosed() && atomic.LoadInt32(&c.reconnecting) == 0 {
+			return errors.New("connection is closed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(handoverPollInterval):
+		}
+	}
+}
+
+// undelivered discards the messages that were received but not handled,
+// once the connection's pipeline has finished, and returns the offset to
+// resume from so they are received again. It returns -1 if there are none
+func (c *Client) undelivered(conn *connection) int64 {
+	var done chan struct{}
+
+	if conn != nil && conn.pipeline != nil {
+		done = make(chan struct{})
+		go func() {
+			conn.pipeline.wg.Wait()
+			close(done)
+		}()
+	}
+
+	offset := int64(-1)
+
+	discard := func(m *msgproto.Message) {
+		if m.Offset > 0 && (offset < 0 || m.Offset-1 < offset) {
+			offset = m.Offset - 1
+		}
+	}
+
+	queues := []chan *msgproto.Message{c.recv}
+	for _, q := range c.inboundQueues {
+		queues = append(queues, q.ch)
+	}
+
+	for {
+		for _, q := range queues {
+			for drained := false; !drained; {
+				select {
+				case m := <-q:
+					discard(m)
+				default:
+					drained = true
+				}
+			}
+		}
+
+		held := []*heldMessages{c.held}
+		for _, q := range c.inboundQueues {
+			held = append(held, q.held)
+		}
+
+		for _, h := range held {
+			for m := h.take(func(*msgproto.Message) bool { return true }); m != nil; m = h.take(func(*msgproto.Message) bool { return true }) {
+				discard(m)
+			}
+		}
+
+		for _, m := range c.senderLimits.drain() {
+			discard(m)
+		}
+
+		if done == nil {
+			return offset
+		}
+
+		select {
+		case <-done:
+			done = nil
+		case <-c.clock.After(handoverPollInterval):
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientHandover(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	var handled int32
+	release := make(chan struct{})
+
+	consumed := make(chan error, 1)
+
+	go func() {
+		consumed <- c.Consume(context.Background(), 1, func(m *msgproto.Message) error {
+			<-release
+			atomic.AddInt32(&handled, 1)
+			return nil
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		s.out <- &msgproto.Message{Id: "m", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1", Offset: int64(i)}
+	}
+
+	for i := 0; atomic.LoadInt64(&c.offset) < 3 || len(c.recv) > 0; i++ {
+		require.Less(t, i, 100, "messages were not received")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	done := make(chan []byte, 1)
+
+	go func() {
+		data, err := c.Handover(context.Background())
+		assert.Nil(t, err)
+		done <- data
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("handed over before handlers finished")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(release)
+
+	var data []byte
+
+	select {
+	case data = <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("handover did not finish")
+	}
+
+	assert.Equal(t, ErrHandedOver, <-consumed)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&handled))
+	assert.True(t, c.IsClosed())
+
+	var st state
+	require.Nil(t, json.Unmarshal(data, &st))
+	assert.Equal(t, int64(3), st.Offset)
+}
+
+func TestClientHandoverUnhandled(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	for i := 5; i <= 6; i++ {
+		s.out <- &msgproto.Message{Id: "m", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1", Offset: int64(i)}
+	}
+
+	for i := 0; len(c.recv) < 2; i++ {
+		require.Less(t, i, 100, "messages were not received")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	data, err := c.Handover(context.Background())
+	require.Nil(t, err)
+
+	// the messages that were not handled are received again after restoring
+	var st state
+	require.Nil(t, json.Unmarshal(data, &st))
+	assert.Equal(t, int64(4), st.Offset)
+
+	err = c.Consume(context.Background(), 1, func(*msgproto.Message) error { return nil })
+	assert.Equal(t, ErrHandedOver, err)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HealthOK is the status of a connected client
+	HealthOK = "ok"
+	// HealthReconnecting is the status of a client that is reconnecting
+	HealthReconnecting = "reconnecting"
+	// HealthDisconnected is the status of a client that is closed
+	HealthDisconnected = "disconnected"
+)
+
+// Health is the state of a client's connection as reported by HealthHandler
+type Health struct {
+	Status            string     `json:"status"`
+	LastPong          *time.Time `json:"last_pong,omitempty"`
+	LastFrame         *time.Time `json:"last_frame,omitempty"`
+	Uptime            float64    `json:"uptime_seconds"`
+	Reconnects        int64      `json:"reconnects"`
+	SendQueueDepth    int        `json:"send_queue_depth"`
+	ReceiveQueueDepth int        `json:"receive_queue_depth"`
+	PendingRequests   int        `json:"pending_requests"`
+}
+
+// ponged records that the server answered a ping
+func (c *Client) ponged() {
+	atomic.StoreInt64(&c.counters.lastPong, c.clock.Now().UnixNano())
+}
+
+// Health returns the state of the client's connection
+func (c *Client) Health() Health {
+	s := c.Stats()
+
+	h := Health{
+		Status:            HealthOK,
+		LastPong:          unixTime(atomic.LoadInt64(&c.counters.lastPong)),
+		LastFrame:         unixTime(atomic.LoadInt64(&c.counters.lastFrame)),
+		Uptime:            s.Uptime.Seconds(),
+		Reconnects:        s.Reconnects,
+		SendQueueDepth:    s.SendQueueDepth,
+		ReceiveQueueDepth: s.ReceiveQueueDepth,
+		PendingRequests:   s.PendingRequests,
+	}
+
+	if c.IsClosed() {
+		h.Status = HealthDisconnected
+		if atomic.LoadInt32(&c.reconnecting) != 0 {
+			h.Status = HealthReconnecting
+		}
+	}
+
+	return h
+}
+
+// HealthHandler returns a handler that serves the client's Health as JSON,
+// responding with 503 Service Unavailable while the client is not
+// connected, so it can be mounted on a service's admin port:
+//
+//	http.Handle("/healthz", c.HealthHandler())
+func (c *Client) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := c.Health()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if h.Status != HealthOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(h)
+	})
+}
+
+// unixTime converts a time in nanoseconds, returning nil if it is not set
+func unixTime(ns int64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+
+	t := time.Unix(0, ns).UTC()
+
+	return &t
+}
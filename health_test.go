@@ -0,0 +1,44 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientHealthHandler(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	c.ponged()
+
+	rec := httptest.NewRecorder()
+	c.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var h Health
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &h))
+	assert.Equal(t, HealthOK, h.Status)
+	assert.NotNil(t, h.LastPong)
+	assert.Equal(t, int64(0), h.Reconnects)
+
+	c.Close()
+
+	rec = httptest.NewRecorder()
+	c.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &h))
+	assert.Equal(t, HealthDisconnected, h.Status)
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long acknowledged message ids and
+	// idempotency keys are remembered
+	DefaultIdempotencyTTL = time.Minute * 10
+	// DefaultIdempotencySize is the most message ids and idempotency keys remembered
+	DefaultIdempotencySize = 4096
+)
+
+// idempotency remembers the ids of messages the server has acknowledged,
+// so a message resent after a reconnect that raced with its ACK is not
+// written twice, and the messages sent for caller supplied idempotency keys.
+// The oldest entries are evicted once there are more than size, or after
+// the ttl
+type idempotency struct {
+	ttl      time.Duration
+	size     int
+	acked    map[string]time.Time
+	ackOrder []string
+	keys     map[string]*idempotentSend
+	keyOrder []string
+	mu       sync.Mutex
+}
+
+// idempotentSend is an attempt to send the message for an idempotency key
+type idempotentSend struct {
+	id      string
+	done    chan struct{} // closed once the attempt has finished
+	settled bool          // the server accepted or rejected the message
+	err     error
+	at      time.Time
+}
+
+func newIdempotency(ttl time.Duration, size int) *idempotency {
+	return &idempotency{
+		ttl:   ttl,
+		size:  size,
+		acked: make(map[string]time.Time),
+		keys:  make(map[string]*idempotentSend),
+	}
+}
+
+// acknowledge records that the server accepted the message with an id
+func (r *idempotency) acknowledge(id string, now time.Time) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.acked[id]; !ok {
+		r.ackOrder = append(r.ackOrder, id)
+	}
+
+	r.acked[id] = now
+
+	for len(r.ackOrder) > 0 {
+		oldest := r.ackOrder[0]
+		if len(r.ackOrder) <= r.size && now.Sub(r.acked[oldest]) < r.ttl {
+			break
+		}
+
+		delete(r.acked, oldest)
+		r.ackOrder = r.ackOrder[1:]
+	}
+}
+
+// acknowledged returns true if the server has accepted the message with an id
+func (r *idempotency) acknowledged(id string, now time.Time) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at, ok := r.acked[id]
+
+	return ok && now.Sub(at) < r.ttl
+}
+
+// begin starts an attempt to send the message for a key. If the key is new
+// the message is sent with id, otherwise the id of the first attempt is
+// reused. It returns false if another attempt is in flight or the message
+// has already been settled, in which case the caller waits for its result
+func (r *idempotency) begin(key, id string, now time.Time) (*idempotentSend, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, ok := r.keys[key]
+	if ok && prev.settled && now.Sub(prev.at) >= r.ttl {
+		ok = false
+	}
+
+	if ok {
+		select {
+		case <-prev.done:
+			if prev.settled {
+				return prev, false
+			}
+			// the earlier attempt failed before the server responded,
+			// so the message is sent again with the same id
+			id = prev.id
+		default:
+			return prev, false
+		}
+	} else {
+		r.keyOrder = append(r.keyOrder, key)
+	}
+
+	s := &idempotentSend{id: id, done: make(chan struct{})}
+	r.keys[key] = s
+
+	for len(r.keyOrder) > r.size {
+		oldest := r.keyOrder[0]
+		r.keyOrder = r.keyOrder[1:]
+
+		if oldest != key {
+			delete(r.keys, oldest)
+		}
+	}
+
+	return s, true
+}
+
+// finish records the result of an attempt
+func (r *idempotency) finish(s *idempotentSend, err error, now time.Time) {
+	r.mu.Lock()
+	s.settled = err == nil || isServerError(err)
+	s.err = err
+	s.at = now
+	r.mu.Unlock()
+
+	close(s.done)
+}
+
+// SendIdempotent sends a message at most once for an idempotency key. If a
+// message has already been sent for the key, the message is not sent again
+// and the earlier result is returned, waiting for it if the earlier send is
+// still in flight. If the earlier send failed before the server responded,
+// the message is sent again with the id of the first attempt, so recipients
+// that deduplicate by id never see it twice. Keys are remembered for the
+// ttl set with the Idempotency option
+func (c *Client) SendIdempotent(key string, m *msgproto.Message) error {
+	if key == "" {
+		return errors.New("idempotency key must not be empty")
+	}
+
+	for {
+		s, first := c.idempotency.begin(key, m.Id, c.clock.Now())
+		if !first {
+			<-s.done
+
+			if s.settled {
+				return s.err
+			}
+
+			continue
+		}
+
+		sm := *m
+		sm.Id = s.id
+
+		err := c.Send(&sm)
+		c.idempotency.finish(s, err, c.clock.Now())
+
+		return err
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyAcknowledged(t *testing.T) {
+	r := newIdempotency(time.Minute, 2)
+	now := time.Now()
+
+	r.acknowledge("1", now)
+	assert.True(t, r.acknowledged("1", now))
+	assert.False(t, r.acknowledged("2", now))
+
+	// acknowledgements expire after the ttl
+	assert.False(t, r.acknowledged("1", now.Add(time.Minute)))
+
+	// the oldest is evicted once there are more than size
+	r.acknowledge("2", now)
+	r.acknowledge("3", now)
+	assert.False(t, r.acknowledged("1", now))
+	assert.True(t, r.acknowledged("3", now))
+}
+
+func TestClientSendAcknowledgedOnce(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc))
+	require.Nil(t, err)
+	defer c.Close()
+
+	m := &msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"}
+
+	require.Nil(t, c.Send(m))
+	require.Nil(t, c.Send(m))
+
+	assert.Equal(t, 1, sc.frames(msgproto.MsgType_MSG))
+}
+
+func TestClientSendIdempotent(t *testing.T) {
+	var dropped bool
+
+	// the first message is not acknowledged, so the send times out
+	sc := newScriptedConn(func(sc *scriptedConn, hdr *msgproto.Header) error {
+		if hdr.Type == msgproto.MsgType_MSG && !dropped {
+			dropped = true
+			return nil
+		}
+		return acknowledge(sc, hdr)
+	})
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), RequestTimeout(time.Millisecond*100))
+	require.Nil(t, err)
+	defer c.Close()
+
+	err = c.SendIdempotent("order-1", &msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	assert.Equal(t, ErrRequestTimeout, err)
+
+	// the retry is sent with the id of the first attempt
+	err = c.SendIdempotent("order-1", &msgproto.Message{Id: "2", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	require.Nil(t, err)
+
+	// once acknowledged, the message is not sent again
+	err = c.SendIdempotent("order-1", &msgproto.Message{Id: "3", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	require.Nil(t, err)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var ids []string
+	for _, hdr := range sc.written {
+		if hdr.Type == msgproto.MsgType_MSG {
+			ids = append(ids, hdr.Id)
+		}
+	}
+
+	assert.Equal(t, []string{"1", "1"}, ids)
+}
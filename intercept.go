@@ -0,0 +1,68 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// OutboundInterceptor is called with the claims of every payload the
+// client signs, before it is signed. It can add or change claims, or
+// return an error to stop the payload from being signed and sent
+type OutboundInterceptor func(claims map[string]interface{}) error
+
+// StampClaims returns an interceptor that adds claims to every payload,
+// such as an app version or device information. Claims that are already
+// set by the payload are kept
+func StampClaims(claims map[string]interface{}) OutboundInterceptor {
+	return func(payload map[string]interface{}) error {
+		for k, v := range claims {
+			if _, ok := payload[k]; !ok {
+				payload[k] = v
+			}
+		}
+		return nil
+	}
+}
+
+// RequireClaims returns an interceptor that rejects payloads missing any
+// of the given claims
+func RequireClaims(names ...string) OutboundInterceptor {
+	return func(payload map[string]interface{}) error {
+		for _, name := range names {
+			if _, ok := payload[name]; !ok {
+				return errors.New("payload is missing required claim " + name)
+			}
+		}
+		return nil
+	}
+}
+
+// intercept runs the outbound interceptors over a payload
+func (c *Client) intercept(data []byte) ([]byte, error) {
+	if len(c.interceptors) == 0 {
+		return data, nil
+	}
+
+	var claims map[string]interface{}
+
+	// numbers are kept as they are so timestamps are not reformatted
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(&claims)
+	if err != nil || claims == nil {
+		return nil, errors.New("intercepted payload must be a json object")
+	}
+
+	for _, fn := range c.interceptors {
+		err = fn(claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(claims)
+}
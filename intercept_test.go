@@ -0,0 +1,83 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func signedClaims(t *testing.T, data []byte) map[string]interface{} {
+	jws, err := jose.ParseSigned(string(data))
+	require.Nil(t, err)
+
+	payload, err := jws.Verify(pubkey)
+	require.Nil(t, err)
+
+	var claims map[string]interface{}
+	require.Nil(t, json.Unmarshal(payload, &claims))
+
+	return claims
+}
+
+func TestClientInterceptOutbound(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	var seen []string
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InterceptOutbound(
+		StampClaims(map[string]interface{}{"gid": "group-1", "app": "1.2.0"}),
+		func(claims map[string]interface{}) error {
+			seen = append(seen, claims["typ"].(string))
+			return nil
+		},
+	))
+	require.Nil(t, err)
+	defer c.Close()
+
+	data, err := c.Sign(map[string]interface{}{"typ": "test", "gid": "group-2", "iat": 1600000000})
+	require.Nil(t, err)
+
+	claims := signedClaims(t, data)
+	assert.Equal(t, "group-2", claims["gid"])
+	assert.Equal(t, "1.2.0", claims["app"])
+	assert.Equal(t, float64(1600000000), claims["iat"])
+	assert.Equal(t, []string{"test"}, seen)
+
+	// request helpers sign through the interceptors too
+	_, payload, err := c.SignRequest("identities.facts.query.req", "", DefaultAuthenticationExpiry, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "1.2.0", signedClaims(t, payload)["app"])
+}
+
+func TestClientInterceptOutboundReject(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InterceptOutbound(RequireClaims("typ", "gid")))
+	require.Nil(t, err)
+	defer c.Close()
+
+	_, err = c.Sign(map[string]interface{}{"typ": "test"})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "gid")
+
+	_, err = c.Sign(map[string]interface{}{"typ": "test", "gid": "group-1"})
+	assert.Nil(t, err)
+
+	_, err = c.Sign([]string{"not", "an", "object"})
+	assert.NotNil(t, err)
+
+	c.interceptors = append(c.interceptors, func(map[string]interface{}) error {
+		return errors.New("rejected")
+	})
+
+	_, err = c.Sign(map[string]interface{}{"typ": "test", "gid": "group-1"})
+	assert.EqualError(t, err, "rejected")
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// ErrNoInboundStore is returned when replaying messages without an inbound
+// store configured with the AtLeastOnce option
+var ErrNoInboundStore = errors.New("at least once delivery is not enabled for inbound messages")
+
+// ReplayFrom calls fn with each message in the AtLeastOnce inbound store,
+// which holds the messages that were received but not yet acknowledged
+// with Ack, that the server timestamped at or after since. Messages without
+// a timestamp are always replayed. Messages are replayed in the order they
+// were received, stopping if fn returns an error
+func (c *Client) ReplayFrom(since time.Time, fn func(m *msgproto.Message) error) error {
+	return c.replay(func(m *msgproto.Message) bool {
+		if m.Timestamp == nil {
+			return true
+		}
+		return !time.Unix(m.Timestamp.Seconds, int64(m.Timestamp.Nanos)).Before(since)
+	}, fn)
+}
+
+// ReplayFromOffset calls fn with each message in the AtLeastOnce inbound
+// store with an offset greater than or equal to offset, in the order they
+// were received, stopping if fn returns an error
+func (c *Client) ReplayFromOffset(offset int64, fn func(m *msgproto.Message) error) error {
+	return c.replay(func(m *msgproto.Message) bool {
+		return m.Offset >= offset
+	}, fn)
+}
+
+func (c *Client) replay(match func(m *msgproto.Message) bool, fn func(m *msgproto.Message) error) error {
+	if c.inbound == nil {
+		return ErrNoInboundStore
+	}
+
+	messages, err := c.inbound.List()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if !match(m) {
+			continue
+		}
+
+		err = fn(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientReplay(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	dir, err := ioutil.TempDir("", "messaging")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	inbound, err := NewDirectoryStore(dir)
+	require.Nil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	err = c.ReplayFromOffset(0, func(*msgproto.Message) error { return nil })
+	assert.Equal(t, ErrNoInboundStore, err)
+	c.Close()
+
+	c, err = New(s.endpoint, "someID", "1", privkey, AtLeastOnce(nil, inbound))
+	require.Nil(t, err)
+	defer c.Close()
+
+	start := time.Now()
+
+	for i := 1; i <= 4; i++ {
+		sent := &timestamp.Timestamp{Seconds: start.Add(time.Duration(i) * time.Second).Unix()}
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: strconv.Itoa(i), Sender: "test:1", Recipient: "someID:1", Offset: int64(i), Timestamp: sent}
+
+		m, err := c.Receive()
+		require.Nil(t, err)
+
+		// handled messages are not replayed
+		if m.Id == "4" {
+			require.Nil(t, c.Ack(m))
+		}
+	}
+
+	var ids []string
+
+	err = c.ReplayFromOffset(2, func(m *msgproto.Message) error {
+		ids = append(ids, m.Id)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"2", "3"}, ids)
+
+	ids = nil
+
+	err = c.ReplayFrom(start, func(m *msgproto.Message) error {
+		ids = append(ids, m.Id)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+
+	err = c.ReplayFrom(start.Add(time.Hour), func(m *msgproto.Message) error {
+		t.Fatal("no messages should be replayed")
+		return nil
+	})
+	require.Nil(t, err)
+}
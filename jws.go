@@ -0,0 +1,392 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// PublicKeyFunc returns the public keys that are valid for a given self ID
+type PublicKeyFunc func(selfID string) ([]crypto.PublicKey, error)
+
+// Claims contains the standard claims of a JWS payload
+type Claims struct {
+	ID             string
+	Type           string
+	Issuer         string
+	Subject        string
+	Audience       string
+	ConversationID string
+	Version        int
+	IssuedAt       time.Time
+	ExpiresAt      time.Time
+}
+
+// UnmarshalJSON decodes claims, accepting timestamps as either RFC3339 strings or unix seconds
+func (cl *Claims) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID             string          `json:"jti"`
+		Type           string          `json:"typ"`
+		Issuer         string          `json:"iss"`
+		Subject        string          `json:"sub"`
+		Audience       string          `json:"aud"`
+		ConversationID string          `json:"cid"`
+		Version        int             `json:"ver"`
+		IssuedAt       json.RawMessage `json:"iat"`
+		ExpiresAt      json.RawMessage `json:"exp"`
+	}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	cl.ID = raw.ID
+	cl.Type = raw.Type
+	cl.Issuer = raw.Issuer
+	cl.Subject = raw.Subject
+	cl.Audience = raw.Audience
+	cl.ConversationID = raw.ConversationID
+	cl.Version = raw.Version
+
+	cl.IssuedAt, err = parseTimeClaim(raw.IssuedAt)
+	if err != nil {
+		return err
+	}
+
+	cl.ExpiresAt, err = parseTimeClaim(raw.ExpiresAt)
+
+	return err
+}
+
+func parseTimeClaim(data json.RawMessage) (time.Time, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return time.Time{}, nil
+	}
+
+	if data[0] == '"' {
+		var s string
+
+		err := json.Unmarshal(data, &s)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Parse(time.RFC3339, s)
+	}
+
+	secs, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return time.Time{}, errors.New("invalid timestamp claim")
+	}
+
+	return time.Unix(int64(secs), 0), nil
+}
+
+// DecodePayload verifies the JWS carried by a message and decodes its payload into v.
+// The signing keys are resolved with the PublicKeys option, the issuer must match the
+// sender and payloads with an exp claim in the past are rejected
+func (c *Client) DecodePayload(m *msgproto.Message, v interface{}) (*Claims, error) {
+	payload, claims, err := c.verifyPayload(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if v != nil {
+		err = json.Unmarshal(payload, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+func (c *Client) verifyPayload(m *msgproto.Message) ([]byte, *Claims, error) {
+	if c.publicKeys == nil {
+		return nil, nil, errors.New("no public key source configured")
+	}
+
+	env, err := c.envelope(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if env.issuer == "" {
+		return nil, nil, errors.New("payload has no issuer")
+	}
+
+	if m.Sender == "" {
+		return nil, nil, errors.New("message has no sender")
+	}
+
+	if selfIDOf(m.Sender) != env.issuer {
+		return nil, nil, errors.New("payload issuer does not match sender")
+	}
+
+	payload, claims, err := c.verifySigned(m.Ciphertext, env.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.replays != nil {
+		err = c.replays.check(claims, m, c.clock.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	payload, claims.Version, err = c.migrate(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return payload, claims, nil
+}
+
+// verifySigned verifies a JWS with the public keys of its issuer
+// and decodes its claims
+func (c *Client) verifySigned(data []byte, issuer string) ([]byte, *Claims, error) {
+	if c.publicKeys == nil {
+		return nil, nil, errors.New("no public key source configured")
+	}
+
+	if issuer == "" {
+		return nil, nil, errors.New("payload has no issuer")
+	}
+
+	jws, err := jose.ParseSigned(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := c.publicKeys(issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kid := jws.Signatures[0].Header.KeyID
+
+	for _, k := range keys {
+		if !keyMatches(k, kid) {
+			continue
+		}
+
+		payload, err := jws.Verify(k)
+		if err != nil {
+			continue
+		}
+
+		cty, _ := jws.Signatures[0].Protected.ExtraHeaders[jose.HeaderContentType].(string)
+
+		codec, err := CodecOf(payload, cty)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		payload, err = jsonPayload(payload, cty)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c.peerCodecs.learn(issuer, codec)
+
+		var claims Claims
+
+		err = json.Unmarshal(payload, &claims)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !claims.ExpiresAt.IsZero() && c.clock.Now().After(claims.ExpiresAt) {
+			return nil, nil, errors.New("payload has expired")
+		}
+
+		return payload, &claims, nil
+	}
+
+	return nil, nil, errors.New("payload signature is invalid")
+}
+
+// selfIDOf returns the self ID of an address in the form selfID:deviceID
+func selfIDOf(address string) string {
+	return strings.Split(address, ":")[0]
+}
+
+// Sign signs a payload with the client's key using JSON serialization.
+// If the MessageTTL option is set, payloads without an exp claim expire
+// after the TTL
+func (c *Client) Sign(claims interface{}) ([]byte, error) {
+	return c.SignWithTTL(claims, c.messageTTL)
+}
+
+// SignWithTTL signs a payload that expires after ttl, unless it already
+// has an exp claim. A ttl of zero does not add an expiry. Outbound
+// interceptors and validators are run before the payload is signed
+func (c *Client) SignWithTTL(claims interface{}, ttl time.Duration) ([]byte, error) {
+	return c.sign(claims, ttl, c.codec)
+}
+
+// SignWithCodec signs a payload encoded with a codec instead of the
+// client's default codec
+func (c *Client) SignWithCodec(codec Codec, claims interface{}) ([]byte, error) {
+	if codec == nil {
+		return nil, errors.New("payload codec must not be nil")
+	}
+
+	return c.sign(claims, c.messageTTL, codec)
+}
+
+// SignFor signs a payload for a peer, encoded with the codec set for it
+// with SetPeerCodec, or else the codec of the last payload received from it
+func (c *Client) SignFor(selfID string, claims interface{}) ([]byte, error) {
+	return c.sign(claims, c.messageTTL, c.peerCodec(selfID))
+}
+
+func (c *Client) sign(claims interface{}, ttl time.Duration, codec Codec) ([]byte, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = c.intercept(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = c.stampVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.validateOutbound(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		data, err = c.withExpiry(data, ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var opts *jose.SignerOptions
+
+	if codec != nil && codec != JSONCodec {
+		data, err = codec.Marshal(json.RawMessage(data))
+		if err != nil {
+			return nil, err
+		}
+
+		// the codec is named so the recipient can decode the payload
+		opts = (&jose.SignerOptions{}).WithContentType(jose.ContentType(codec.Name()))
+	}
+
+	signer, err := c.signer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	jws, err := signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(jws.FullSerialize()), nil
+}
+
+// signer returns a signer for the key selected with UseSigningKey
+func (c *Client) signer(opts *jose.SignerOptions) (jose.Signer, error) {
+	s, err := c.activeSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(s.alg), Key: s}, opts)
+}
+
+// SignRequest signs a request payload of the given type in a new
+// conversation, addressed to subject, which may be empty. The iss, jti, cid,
+// iat and exp claims are set from the client's identity and the server's
+// clock, and fields are added to them. It returns the conversation id and
+// the payload
+func (c *Client) SignRequest(typ, subject string, expiry time.Duration, fields map[string]interface{}) (string, []byte, error) {
+	cid := c.NewID()
+
+	payload, err := c.signConversation(typ, cid, subject, expiry, fields)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return cid, payload, nil
+}
+
+// signConversation signs a payload of the given type in the conversation
+// cid, addressed to subject, which may be empty
+func (c *Client) signConversation(typ, cid, subject string, expiry time.Duration, fields map[string]interface{}) ([]byte, error) {
+	now := c.serverNow()
+
+	claims := map[string]interface{}{
+		"typ": typ,
+		"jti": c.NewID(),
+		"cid": cid,
+		"iss": c.selfID,
+		"iat": now.Unix(),
+		"exp": now.Add(expiry).Unix(),
+	}
+
+	if subject != "" {
+		claims["sub"] = subject
+		claims["aud"] = subject
+	}
+
+	for k, v := range fields {
+		claims[k] = v
+	}
+
+	if subject != "" {
+		return c.SignFor(subject, claims)
+	}
+
+	return c.Sign(claims)
+}
+
+// RequestMessage wraps a signed payload in a message from the client's
+// device to recipient, formatted as selfID:deviceID
+func (c *Client) RequestMessage(recipient string, payload []byte) *msgproto.Message {
+	return &msgproto.Message{
+		Id:         c.NewID(),
+		Type:       msgproto.MsgType_MSG,
+		Sender:     c.selfID + ":" + c.deviceID,
+		Recipient:  recipient,
+		Ciphertext: payload,
+	}
+}
+
+// verifyResponse verifies a response and decodes it into v, checking that
+// it has the expected type and belongs to the conversation
+func (c *Client) verifyResponse(m *msgproto.Message, typ, cid string, v interface{}) (*Claims, error) {
+	claims, err := c.DecodePayload(m, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Type != typ {
+		return nil, errors.New("response has unexpected type " + claims.Type)
+	}
+
+	if claims.ConversationID != cid {
+		return nil, errors.New("response does not belong to the conversation")
+	}
+
+	return claims, nil
+}
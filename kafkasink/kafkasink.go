@@ -0,0 +1,63 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package kafkasink archives messages to a Kafka topic. It is used with the
+// messaging client's Archive option:
+//
+//	client, err := messaging.New(endpoint, appID, device, appKey,
+//		messaging.Archive(kafkasink.New(writer), messaging.ArchiveConfig{Outbound: true}))
+//
+// Each archive batch is written as one batch of records, so a Writer that
+// produces them in a single request keeps archiving to one round trip per
+// flush.
+package kafkasink
+
+import (
+	"context"
+	"encoding/json"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+)
+
+// Record is a message written to the topic
+type Record struct {
+	Key   []byte
+	Value []byte
+}
+
+// Writer writes records to a Kafka topic. A failed batch is retried in
+// full, so records already written may be written again
+type Writer interface {
+	WriteRecords(ctx context.Context, records []Record) error
+}
+
+// Sink archives messages as JSON records keyed by the conversation's
+// counterparty, so each conversation is kept in order within a partition
+type Sink struct {
+	w Writer
+}
+
+// New creates a sink writing to w
+func New(w Writer) *Sink {
+	return &Sink{w: w}
+}
+
+// Archive writes a batch of messages as a single batch of records
+func (s *Sink) Archive(ctx context.Context, batch []*messaging.ArchivedMessage) error {
+	records := make([]Record, len(batch))
+
+	for i, m := range batch {
+		value, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		key := m.Sender
+		if m.Direction == messaging.AuditSent {
+			key = m.Recipient
+		}
+
+		records[i] = Record{Key: []byte(key), Value: value}
+	}
+
+	return s.w.WriteRecords(ctx, records)
+}
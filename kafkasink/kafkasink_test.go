@@ -0,0 +1,42 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package kafkasink
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type writer struct {
+	records []Record
+}
+
+func (w *writer) WriteRecords(ctx context.Context, records []Record) error {
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	w := &writer{}
+
+	err := New(w).Archive(context.Background(), []*messaging.ArchivedMessage{
+		{Direction: messaging.AuditReceived, ID: "1", Sender: "alice:1", Recipient: "bob:1", Payload: []byte("hello")},
+		{Direction: messaging.AuditSent, ID: "2", Sender: "bob:1", Recipient: "alice:1", Payload: []byte("hi")},
+	})
+	require.Nil(t, err)
+	require.Len(t, w.records, 2)
+
+	// records are keyed by the other party of the conversation
+	assert.Equal(t, "alice:1", string(w.records[0].Key))
+	assert.Equal(t, "alice:1", string(w.records[1].Key))
+
+	var m messaging.ArchivedMessage
+	require.Nil(t, json.Unmarshal(w.records[1].Value, &m))
+	assert.Equal(t, "2", m.ID)
+	assert.Equal(t, []byte("hi"), m.Payload)
+}
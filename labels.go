@@ -0,0 +1,25 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// labels returns the pprof labels that attribute a goroutine to the client
+// and the role it plays, so goroutine dumps of services running many
+// clients can be told apart
+func (c *Client) labels(role string) pprof.LabelSet {
+	return pprof.Labels("selfID", c.selfID, "deviceID", c.deviceID, "role", role)
+}
+
+// labelled runs fn with the goroutine tagged with the client's labels,
+// restoring its previous labels when fn returns. Goroutines inherit the
+// labels of the goroutine that starts them, so every goroutine started
+// while connecting is labelled with its own role
+func (c *Client) labelled(role string, fn func()) {
+	pprof.Do(context.Background(), c.labels(role), func(context.Context) {
+		fn()
+	})
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientGoroutineLabels(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "labelledID", "7", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	labelled := func(dump, role string) bool {
+		return strings.Contains(dump, `"deviceID":"7", "role":"`+role+`", "selfID":"labelledID"`)
+	}
+
+	// the goroutines may not have started yet. assert.Eventually is not
+	// used, as dumping goroutines can outlast its tick
+	var dump string
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond * 10) {
+		var buf bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		dump = buf.String()
+
+		if labelled(dump, "reader") && labelled(dump, "writer") && labelled(dump, "pipeline") {
+			break
+		}
+	}
+
+	for _, role := range []string{"reader", "writer", "pipeline"} {
+		assert.True(t, labelled(dump, role), "no %s goroutine is labelled", role)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyWindow is the number of recent ACK latencies summarised by AckLatency
+const DefaultLatencyWindow = 1024
+
+// LatencySummary summarises the time the server took to acknowledge recent messages
+type LatencySummary struct {
+	Count int64 // messages acknowledged since the client was created
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// LatencySLO is a threshold on the time the server takes to acknowledge
+// messages. OnBreach is called once Consecutive messages in a row have taken
+// longer than Threshold, or timed out, and OnRecover when a message is next
+// acknowledged within it, giving early warning of server side degradation.
+// The callbacks are called synchronously and must not block
+type LatencySLO struct {
+	Threshold   time.Duration
+	Consecutive int
+	OnBreach    func(latency time.Duration)
+	OnRecover   func(latency time.Duration)
+}
+
+// latencyTracker records the ACK latency of recent messages
+type latencyTracker struct {
+	window   []time.Duration
+	next     int
+	count    int64
+	slo      *LatencySLO
+	slow     int
+	breached bool
+	mu       sync.Mutex
+}
+
+func newLatencyTracker(size int) *latencyTracker {
+	return &latencyTracker{window: make([]time.Duration, 0, size)}
+}
+
+func validateLatencySLO(slo LatencySLO) error {
+	if slo.Threshold <= 0 {
+		return errors.New("latency threshold must be positive")
+	}
+
+	if slo.Consecutive < 1 {
+		return errors.New("latency slo requires at least one consecutive message")
+	}
+
+	return nil
+}
+
+// observe records the latency of an acknowledged message, or of one that
+// timed out if acked is false
+func (l *latencyTracker) observe(d time.Duration, acked bool) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+
+	if acked {
+		l.count++
+
+		if len(l.window) < cap(l.window) {
+			l.window = append(l.window, d)
+		} else {
+			l.window[l.next] = d
+			l.next = (l.next + 1) % len(l.window)
+		}
+	}
+
+	var onBreach, onRecover func(time.Duration)
+
+	if l.slo != nil {
+		if !acked || d > l.slo.Threshold {
+			l.slow++
+			if l.slow >= l.slo.Consecutive && !l.breached {
+				l.breached = true
+				onBreach = l.slo.OnBreach
+			}
+		} else {
+			l.slow = 0
+			if l.breached {
+				l.breached = false
+				onRecover = l.slo.OnRecover
+			}
+		}
+	}
+
+	l.mu.Unlock()
+
+	if onBreach != nil {
+		onBreach(d)
+	}
+
+	if onRecover != nil {
+		onRecover(d)
+	}
+}
+
+// summary returns percentiles of the latencies in the window
+func (l *latencyTracker) summary() LatencySummary {
+	if l == nil {
+		return LatencySummary{}
+	}
+
+	l.mu.Lock()
+	window := append([]time.Duration{}, l.window...)
+	s := LatencySummary{Count: l.count}
+	l.mu.Unlock()
+
+	if len(window) == 0 {
+		return s
+	}
+
+	sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+
+	percentile := func(p float64) time.Duration {
+		return window[int(float64(len(window)-1)*p)]
+	}
+
+	s.P50 = percentile(0.5)
+	s.P90 = percentile(0.9)
+	s.P99 = percentile(0.99)
+	s.Max = window[len(window)-1]
+
+	return s
+}
+
+// AckLatency summarises the time between messages being written and the
+// server acknowledging them, over the most recent DefaultLatencyWindow messages
+func (c *Client) AckLatency() LatencySummary {
+	return c.latency.summary()
+}
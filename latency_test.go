@@ -0,0 +1,63 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	var breaches, recoveries []time.Duration
+
+	l := newLatencyTracker(4)
+	l.slo = &LatencySLO{
+		Threshold:   time.Millisecond * 100,
+		Consecutive: 2,
+		OnBreach:    func(d time.Duration) { breaches = append(breaches, d) },
+		OnRecover:   func(d time.Duration) { recoveries = append(recoveries, d) },
+	}
+
+	for _, ms := range []int{10, 20, 30, 40, 50} {
+		l.observe(time.Duration(ms)*time.Millisecond, true)
+	}
+
+	s := l.summary()
+	assert.Equal(t, int64(5), s.Count)
+	assert.Equal(t, time.Millisecond*30, s.P50)
+	assert.Equal(t, time.Millisecond*50, s.Max)
+
+	// a single slow message does not breach the slo
+	l.observe(time.Millisecond*200, true)
+	l.observe(time.Millisecond*10, true)
+	assert.Len(t, breaches, 0)
+
+	l.observe(time.Millisecond*200, true)
+	l.observe(time.Second, false)
+	l.observe(time.Millisecond*300, true)
+	assert.Equal(t, []time.Duration{time.Second}, breaches)
+
+	l.observe(time.Millisecond*10, true)
+	assert.Equal(t, []time.Duration{time.Millisecond * 10}, recoveries)
+}
+
+func TestClientAckLatency(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		err = c.Send(&msgproto.Message{Id: id, Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+		require.Nil(t, err)
+	}
+
+	s := c.AckLatency()
+	assert.Equal(t, int64(3), s.Count)
+	assert.True(t, s.Max >= s.P50)
+}
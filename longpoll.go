@@ -0,0 +1,313 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionHeader identifies a long polling session
+const SessionHeader = "X-Self-Session"
+
+// DefaultLongPollRetry is how often a client that is long polling tries to
+// connect with a websocket again
+const DefaultLongPollRetry = time.Minute
+
+// errWebsocketAvailable ends a long polling connection once a websocket
+// can be connected instead
+var errWebsocketAvailable = errors.New("websocket connection is available")
+
+// longPollConn speaks the messaging protocol over plain HTTP requests to the
+// endpoint, for networks that do not allow websockets:
+//
+//   - POST with no session header opens a session, returned in the session header
+//   - POST with a session header sends a single frame in the body
+//   - GET with a session header waits for frames from the server, returned as a
+//     sequence of frames each prefixed with its length as a big endian uint32.
+//     An empty response means no frames arrived before the server's timeout
+//   - DELETE with a session header closes the session
+//
+// There are no ping frames, so each poll the server answers is treated as
+// a pong, and the server must answer polls within the read deadline
+type longPollConn struct {
+	*frameQueue
+	client  *http.Client
+	url     string
+	session string
+	timeout time.Duration // for sending a frame
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Transport returns the name of the transport used to connect to the
+// server, either "websocket" or "longpoll"
+func (c *Client) Transport() string {
+	if c.longPollAfter > 0 && atomic.LoadInt32(&c.dialFailures) >= c.longPollAfter {
+		return "longpoll"
+	}
+
+	return "websocket"
+}
+
+// connectLongPoll opens a long polling session with the server
+func (c *Client) connectLongPoll() (transport, error) {
+	url := c.endpoint
+
+	if strings.HasPrefix(url, "unix://") {
+		return nil, errors.New("long polling is not supported over unix endpoints")
+	}
+
+	switch {
+	case strings.HasPrefix(url, "ws://"):
+		url = "http://" + strings.TrimPrefix(url, "ws://")
+	case strings.HasPrefix(url, "wss://"):
+		url = "https://" + strings.TrimPrefix(url, "wss://")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = handshakeHeader()
+
+	started := c.clock.Now()
+
+	client := c.dialOptions.httpClient()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(SessionHeader) == "" {
+		return nil, fmt.Errorf("long poll session failed with status %d", resp.StatusCode)
+	}
+
+	c.measureSkew(resp, started, c.clock.Now())
+	c.capabilities.negotiate(resp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lp := &longPollConn{
+		frameQueue: newFrameQueue(time.Now().Add(c.deadline)),
+		client:     client,
+		url:        url,
+		session:    resp.Header.Get(SessionHeader),
+		timeout:    c.deadline,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	go lp.poll(ctx)
+
+	return lp, nil
+}
+
+// poll requests frames from the server until the session is closed
+func (lp *longPollConn) poll(ctx context.Context) {
+	for {
+		err := lp.receive(ctx)
+		if err != nil {
+			lp.fail(err)
+			return
+		}
+	}
+}
+
+func (lp *longPollConn) receive(ctx context.Context) error {
+	req, err := lp.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := lp.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("long poll failed with status %d", resp.StatusCode)
+	}
+
+	// the server answering is the long polling equivalent of a pong
+	lp.ponged()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	var size [4]byte
+
+	for {
+		_, err := io.ReadFull(resp.Body, size[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		n := binary.BigEndian.Uint32(size[:])
+
+		// check the size before allocating the frame
+		if !lp.allowed(int64(n)) {
+			return websocket.ErrReadLimit
+		}
+
+		frame := make([]byte, n)
+
+		_, err = io.ReadFull(resp.Body, frame)
+		if err != nil {
+			return err
+		}
+
+		err = lp.push(frame)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (lp *longPollConn) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, lp.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set(SessionHeader, lp.session)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+
+	return req, nil
+}
+
+// WriteMessage sends a frame to the server
+func (lp *longPollConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.BinaryMessage {
+		return errors.New("long polling only supports binary frames")
+	}
+
+	ctx, cancel := context.WithTimeout(lp.ctx, lp.timeout)
+	defer cancel()
+
+	req, err := lp.request(ctx, http.MethodPost, data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := lp.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("long poll send failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// WriteControl emulates websocket control frames. Pings are answered by
+// the next poll, so they only fail once the session is closed, and close
+// frames end the session
+func (lp *longPollConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	switch messageType {
+	case websocket.PingMessage:
+		select {
+		case <-lp.done:
+			return errors.New("session closed")
+		default:
+			return nil
+		}
+	case websocket.CloseMessage:
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+
+		req, err := lp.request(ctx, http.MethodDelete, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := lp.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		resp.Body.Close()
+
+		// the session is closed, so the close is acknowledged immediately
+		lp.closeWith(closeError(data))
+		lp.cancel()
+
+		return nil
+	}
+
+	return errors.New("unsupported control frame")
+}
+
+// Close stops polling without notifying the server
+func (lp *longPollConn) Close() error {
+	lp.shutdown()
+	lp.cancel()
+	return nil
+}
+
+// retryWebsocket tries to connect with a websocket every LongPollRetry
+// interval while the connection is long polling, and reconnects once it
+// succeeds. It exits when the connection is torn down
+func (c *Client) retryWebsocket(conn *connection) {
+	if !c.reconnect || c.Transport() != "longpoll" {
+		return
+	}
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-c.clock.After(c.longPollRetry):
+		}
+
+		ws, _, err := c.dialTransport()
+		if err != nil {
+			continue
+		}
+
+		ws.Close()
+
+		atomic.StoreInt32(&c.dialFailures, 0)
+
+		if !c.teardown(conn, errWebsocketAvailable) {
+			return
+		}
+
+		select {
+		case <-conn.readerDone:
+		case <-c.clock.After(c.deadline):
+		}
+
+		c.tryReconnect(errWebsocketAvailable)
+
+		return
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type longPollServer struct {
+	s        *httptest.Server
+	endpoint string
+	out      chan []byte
+	in       chan *msgproto.Message
+	closed   chan struct{}
+}
+
+func newLongPollServer() *longPollServer {
+	lp := &longPollServer{out: make(chan []byte, 64), in: make(chan *msgproto.Message, 64), closed: make(chan struct{}, 1)}
+	lp.s = httptest.NewServer(http.HandlerFunc(lp.handler))
+	lp.endpoint = "ws" + strings.TrimPrefix(lp.s.URL, "http")
+	return lp
+}
+
+func (lp *longPollServer) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "" {
+		http.Error(w, "websockets are blocked", http.StatusForbidden)
+		return
+	}
+
+	session := r.Header.Get(SessionHeader)
+
+	switch {
+	case r.Method == http.MethodPost && session == "":
+		w.Header().Set(SessionHeader, "session")
+	case r.Method == http.MethodPost:
+		data, _ := ioutil.ReadAll(r.Body)
+
+		var hdr msgproto.Header
+		proto.Unmarshal(data, &hdr)
+
+		if hdr.Type == msgproto.MsgType_MSG {
+			var m msgproto.Message
+			proto.Unmarshal(data, &m)
+			lp.in <- &m
+		}
+
+		ack, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: hdr.Id})
+		lp.out <- ack
+	case r.Method == http.MethodGet:
+		select {
+		case frame := <-lp.out:
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+			w.Write(size[:])
+			w.Write(frame)
+		case <-time.After(100 * time.Millisecond):
+			w.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+	case r.Method == http.MethodDelete:
+		lp.closed <- struct{}{}
+	}
+}
+
+func TestClientLongPollFallback(t *testing.T) {
+	s := newLongPollServer()
+	defer s.s.Close()
+
+	_, err := New(s.endpoint, "someID", "1", privkey)
+	assert.NotNil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, LongPollFallback(1))
+	require.Nil(t, err)
+	assert.Equal(t, "longpoll", c.Transport())
+
+	err = c.Send(&msgproto.Message{Id: "1", Recipient: "test:1", Ciphertext: []byte("hello")})
+	require.Nil(t, err)
+
+	m := <-s.in
+	assert.Equal(t, "hello", string(m.Ciphertext))
+
+	data, err := proto.Marshal(&msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hi")})
+	require.Nil(t, err)
+	s.out <- data
+
+	m, err = c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "hi", string(m.Ciphertext))
+
+	c.Close()
+
+	select {
+	case <-s.closed:
+	case <-time.After(time.Second):
+		t.Fatal("session was not closed")
+	}
+}
+
+func TestClientLongPollRetriesWebsocket(t *testing.T) {
+	s := newLongPollServer()
+	defer s.s.Close()
+
+	var available int32
+	var conns []*scriptedConn
+	var mu sync.Mutex
+
+	dialer := func(c *Client) error {
+		c.dialer = func() (transport, *http.Response, error) {
+			if atomic.LoadInt32(&available) == 0 {
+				return nil, nil, errors.New("websockets are blocked")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			sc := newScriptedConn(acknowledge)
+			conns = append(conns, sc)
+
+			return sc, nil, nil
+		}
+		return nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, dialer, AutoReconnect(true), LongPollFallback(1), LongPollRetry(time.Millisecond*20))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "longpoll", c.Transport())
+
+	atomic.StoreInt32(&available, 1)
+
+	// the client reconnects with a websocket once one can be connected
+	authenticated := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, sc := range conns {
+			if sc.frames(msgproto.MsgType_AUTH) > 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for i := 0; !authenticated(); i++ {
+		require.Less(t, i, 100, "client did not reconnect with a websocket")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Equal(t, "websocket", c.Transport())
+}
+
+func TestLongPollWriteTimeout(t *testing.T) {
+	block := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer s.Close()
+	defer close(block)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lp := &longPollConn{
+		frameQueue: newFrameQueue(time.Now().Add(time.Minute)),
+		client:     http.DefaultClient,
+		url:        s.URL,
+		session:    "session",
+		timeout:    time.Millisecond * 50,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	// sends give up after the timeout
+	err := lp.WriteMessage(websocket.BinaryMessage, []byte("frame"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// and are cancelled once the connection is closed
+	lp.Close()
+
+	err = lp.WriteMessage(websocket.BinaryMessage, []byte("frame"))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
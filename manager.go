@@ -0,0 +1,188 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// Identity identifies a client by its self ID and device
+type Identity struct {
+	SelfID   string
+	DeviceID string
+}
+
+// ManagedMessage is a message received by one of a manager's clients
+type ManagedMessage struct {
+	Identity Identity
+	Message  *msgproto.Message
+}
+
+// Manager owns clients for multiple identities connected to the same
+// endpoint. Options passed to the manager are applied to every client, and
+// messages from all clients are delivered on a single receive channel
+type Manager struct {
+	endpoint string
+	opts     []func(*Client) error
+	recv     chan *ManagedMessage
+	mu       sync.Mutex
+	clients  map[Identity]*managedClient
+}
+
+type managedClient struct {
+	client *Client
+	stop   chan struct{}
+}
+
+// NewManager creates a manager for the given endpoint
+func NewManager(endpoint string, opts ...func(*Client) error) *Manager {
+	return &Manager{
+		endpoint: endpoint,
+		opts:     opts,
+		recv:     make(chan *ManagedMessage, DefaultBufferSize),
+		clients:  make(map[Identity]*managedClient),
+	}
+}
+
+// Add connects a client for the given identity. Options are applied after
+// the manager's shared options
+func (m *Manager) Add(selfID, deviceID, privateKey string, opts ...func(*Client) error) (*Client, error) {
+	id := Identity{SelfID: selfID, DeviceID: deviceID}
+
+	m.mu.Lock()
+	_, ok := m.clients[id]
+	m.mu.Unlock()
+
+	if ok {
+		return nil, errors.New("identity is already managed")
+	}
+
+	all := make([]func(*Client) error, 0, len(m.opts)+len(opts))
+	all = append(all, m.opts...)
+	all = append(all, opts...)
+
+	c, err := New(m.endpoint, selfID, deviceID, privateKey, all...)
+	if err != nil {
+		return nil, err
+	}
+
+	mc := &managedClient{client: c, stop: make(chan struct{})}
+
+	m.mu.Lock()
+	if _, ok := m.clients[id]; ok {
+		m.mu.Unlock()
+		c.Close()
+		return nil, errors.New("identity is already managed")
+	}
+	m.clients[id] = mc
+	m.mu.Unlock()
+
+	go m.forward(id, mc)
+
+	return c, nil
+}
+
+func (m *Manager) forward(id Identity, mc *managedClient) {
+	for {
+		select {
+		case <-mc.stop:
+			return
+		case msg := <-mc.client.ReceiveChan():
+			select {
+			case m.recv <- &ManagedMessage{Identity: id, Message: msg}:
+			case <-mc.stop:
+				return
+			}
+		}
+	}
+}
+
+// Client returns the client for an identity
+func (m *Manager) Client(selfID, deviceID string) (*Client, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mc, ok := m.clients[Identity{SelfID: selfID, DeviceID: deviceID}]
+	if !ok {
+		return nil, false
+	}
+
+	return mc.client, true
+}
+
+// Identities returns the identities of all managed clients
+func (m *Manager) Identities() []Identity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]Identity, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Remove closes the client for an identity and stops delivering its messages
+func (m *Manager) Remove(selfID, deviceID string) {
+	id := Identity{SelfID: selfID, DeviceID: deviceID}
+
+	m.mu.Lock()
+	mc, ok := m.clients[id]
+	delete(m.clients, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	close(mc.stop)
+	mc.client.Close()
+}
+
+// ReceiveChan returns the channel messages from all clients are delivered on
+func (m *Manager) ReceiveChan() <-chan *ManagedMessage {
+	return m.recv
+}
+
+// Stats returns the sum of the stats of all managed clients. Uptime is the
+// longest uptime of any client
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total Stats
+
+	for _, mc := range m.clients {
+		s := mc.client.Stats()
+
+		total.MessagesSent += s.MessagesSent
+		total.MessagesReceived += s.MessagesReceived
+		total.Acks += s.Acks
+		total.Errors += s.Errors
+		total.ACLResponses += s.ACLResponses
+		total.Reconnects += s.Reconnects
+		total.Dropped += s.Dropped
+		total.PendingRequests += s.PendingRequests
+		total.SendQueueDepth += s.SendQueueDepth
+		total.ReceiveQueueDepth += s.ReceiveQueueDepth
+		total.BytesIn += s.BytesIn
+		total.BytesOut += s.BytesOut
+
+		if s.Uptime > total.Uptime {
+			total.Uptime = s.Uptime
+		}
+	}
+
+	return total
+}
+
+// Close closes all managed clients
+func (m *Manager) Close() {
+	for _, id := range m.Identities() {
+		m.Remove(id.SelfID, id.DeviceID)
+	}
+}
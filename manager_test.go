@@ -0,0 +1,52 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	m := NewManager(s.endpoint, ReadDeadline(time.Second))
+	defer m.Close()
+
+	a, err := m.Add("alice", "1", privkey)
+	require.Nil(t, err)
+
+	_, err = m.Add("bob", "1", privkey)
+	require.Nil(t, err)
+
+	_, err = m.Add("alice", "1", privkey)
+	assert.NotNil(t, err)
+
+	c, ok := m.Client("alice", "1")
+	require.True(t, ok)
+	assert.Equal(t, a, c)
+	assert.Len(t, m.Identities(), 2)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "test:1", Recipient: "alice:1"}
+
+	select {
+	case mm := <-m.ReceiveChan():
+		assert.Equal(t, "1", mm.Message.Id)
+		assert.NotEmpty(t, mm.Identity.SelfID)
+	case <-time.After(time.Second):
+		t.Fatal("message was not received")
+	}
+
+	assert.Equal(t, int64(1), m.Stats().MessagesReceived)
+
+	m.Remove("alice", "1")
+	assert.True(t, a.IsClosed())
+
+	_, ok = m.Client("alice", "1")
+	assert.False(t, ok)
+}
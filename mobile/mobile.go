@@ -0,0 +1,206 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package mobile wraps the messaging client with an API made only of types
+// supported by gomobile, so it can be bound into iOS and Android apps:
+//
+//	gomobile bind -target=android github.com/selfid-net/self-messaging-client/mobile
+package mobile
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// Config holds optional client settings. The zero value uses the client's defaults
+type Config struct {
+	// AutoReconnect reconnects the client when the connection is lost
+	AutoReconnect bool
+	// SendBuffer is the size of the send queue
+	SendBuffer int
+	// ReceiveBuffer is the size of the receive queue
+	ReceiveBuffer int
+	// MaxRetries is the number of reconnection attempts
+	MaxRetries int
+	// RetryIntervalMillis is the time between reconnection attempts in milliseconds
+	RetryIntervalMillis int64
+}
+
+// NewConfig returns a config with the client's defaults
+func NewConfig() *Config {
+	return &Config{
+		AutoReconnect:       true,
+		SendBuffer:          messaging.DefaultBufferSize,
+		ReceiveBuffer:       messaging.DefaultBufferSize,
+		MaxRetries:          messaging.DefaultRetries,
+		RetryIntervalMillis: int64(messaging.DefaultRetryInterval / time.Millisecond),
+	}
+}
+
+// Message is a message received by the client
+type Message struct {
+	ID        string
+	Sender    string
+	Recipient string
+	Payload   []byte
+	Offset    int64
+}
+
+// MessageHandler is implemented by the app to receive messages
+type MessageHandler interface {
+	OnMessage(m *Message)
+}
+
+// Client is a messaging client for mobile apps
+type Client struct {
+	client  *messaging.Client
+	handler MessageHandler
+	started bool
+	mu      sync.Mutex
+}
+
+// NewClient connects to the messaging server as the given device
+func NewClient(endpoint, selfID, deviceID, privateKey string) (*Client, error) {
+	return NewClientWithConfig(endpoint, selfID, deviceID, privateKey, NewConfig())
+}
+
+// NewClientWithConfig connects to the messaging server using the given settings
+func NewClientWithConfig(endpoint, selfID, deviceID, privateKey string, cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	opts := []func(*messaging.Client) error{
+		messaging.AutoReconnect(cfg.AutoReconnect),
+	}
+
+	if cfg.SendBuffer > 0 {
+		opts = append(opts, messaging.SendBuffer(cfg.SendBuffer))
+	}
+
+	if cfg.ReceiveBuffer > 0 {
+		opts = append(opts, messaging.ReceiveBuffer(cfg.ReceiveBuffer))
+	}
+
+	if cfg.MaxRetries > 0 {
+		opts = append(opts, messaging.MaxRetries(cfg.MaxRetries))
+	}
+
+	if cfg.RetryIntervalMillis > 0 {
+		opts = append(opts, messaging.RetryInterval(time.Duration(cfg.RetryIntervalMillis)*time.Millisecond))
+	}
+
+	c, err := messaging.New(endpoint, selfID, deviceID, privateKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{client: c}, nil
+}
+
+// SelfID returns the self ID the client authenticates as
+func (c *Client) SelfID() string {
+	return c.client.SelfID()
+}
+
+// DeviceID returns the device the client authenticates as
+func (c *Client) DeviceID() string {
+	return c.client.DeviceID()
+}
+
+// Send sends a payload to a recipient in the form selfID:deviceID,
+// returning the id of the message once the server has accepted it
+func (c *Client) Send(recipient string, payload []byte) (string, error) {
+	m := &msgproto.Message{
+		Id:         c.client.NewID(),
+		Type:       msgproto.MsgType_MSG,
+		Sender:     c.client.SelfID() + ":" + c.client.DeviceID(),
+		Recipient:  recipient,
+		Ciphertext: payload,
+	}
+
+	err := c.client.Send(m)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Id, nil
+}
+
+// Receive waits for the next message. It must not be used with a message handler
+func (c *Client) Receive() (*Message, error) {
+	m, err := c.client.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	return convert(m), nil
+}
+
+// SetMessageHandler delivers all received messages to the handler, replacing
+// any previous handler. Messages are delivered one at a time
+func (c *Client) SetMessageHandler(h MessageHandler) error {
+	if h == nil {
+		return errors.New("message handler must not be nil")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handler = h
+
+	if !c.started {
+		c.started = true
+		go c.dispatch()
+	}
+
+	return nil
+}
+
+func (c *Client) dispatch() {
+	for m := range c.client.ReceiveChan() {
+		c.mu.Lock()
+		h := c.handler
+		c.mu.Unlock()
+
+		h.OnMessage(convert(m))
+	}
+}
+
+// PermitAll allows all senders to message the client
+func (c *Client) PermitAll() error {
+	return c.client.PermitAll()
+}
+
+// PermitSender allows a sender to message the client until the given unix time in seconds
+func (c *Client) PermitSender(selfID string, expiresUnix int64) error {
+	return c.client.PermitSender(selfID, time.Unix(expiresUnix, 0))
+}
+
+// BlockSender prevents a sender from messaging the client
+func (c *Client) BlockSender(selfID string) error {
+	return c.client.BlockSender(selfID)
+}
+
+// IsClosed reports whether the client's connection is closed
+func (c *Client) IsClosed() bool {
+	return c.client.IsClosed()
+}
+
+// Close closes the client's connection
+func (c *Client) Close() {
+	c.client.Close()
+}
+
+func convert(m *msgproto.Message) *Message {
+	return &Message{
+		ID:        m.Id,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		Payload:   m.Ciphertext,
+		Offset:    m.Offset,
+	}
+}
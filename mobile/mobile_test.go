@@ -0,0 +1,172 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package mobile
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// relay is a test server that forwards messages to the connection of their recipient
+type relay struct {
+	s     *httptest.Server
+	conns map[string]*websocket.Conn
+	mu    sync.Mutex
+}
+
+func newRelay() *relay {
+	r := &relay{conns: make(map[string]*websocket.Conn)}
+	r.s = httptest.NewServer(http.HandlerFunc(r.handler))
+	return r
+}
+
+func (r *relay) endpoint() string {
+	return "ws" + strings.TrimPrefix(r.s.URL, "http")
+}
+
+func (r *relay) write(wc *websocket.Conn, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (r *relay) ack(wc *websocket.Conn, id string) {
+	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: id})
+	r.write(wc, data)
+}
+
+func (r *relay) handler(w http.ResponseWriter, req *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	_, data, err := wc.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var auth msgproto.Auth
+
+	err = proto.Unmarshal(data, &auth)
+	if err != nil {
+		return
+	}
+
+	token, err := jose.ParseSigned(auth.Token)
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	json.Unmarshal(token.UnsafePayloadWithoutVerification(), &claims)
+
+	r.mu.Lock()
+	r.conns[claims.Issuer] = wc
+	r.mu.Unlock()
+
+	r.ack(wc, auth.Id)
+
+	for {
+		_, data, err := wc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return
+		}
+
+		r.ack(wc, m.Id)
+
+		if m.Type != msgproto.MsgType_MSG {
+			continue
+		}
+
+		r.mu.Lock()
+		rc, ok := r.conns[strings.Split(m.Recipient, ":")[0]]
+		r.mu.Unlock()
+
+		if ok {
+			r.write(rc, data)
+		}
+	}
+}
+
+func testKey() string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(priv.Seed())
+}
+
+type handler chan *Message
+
+func (h handler) OnMessage(m *Message) {
+	h <- m
+}
+
+func TestClient(t *testing.T) {
+	r := newRelay()
+	defer r.s.Close()
+
+	alice, err := NewClient(r.endpoint(), "alice", "1", testKey())
+	require.Nil(t, err)
+	defer alice.Close()
+
+	cfg := NewConfig()
+	cfg.ReceiveBuffer = 16
+
+	bob, err := NewClientWithConfig(r.endpoint(), "bob", "1", testKey(), cfg)
+	require.Nil(t, err)
+	defer bob.Close()
+
+	id, err := alice.Send("bob:1", []byte("hello"))
+	require.Nil(t, err)
+
+	m, err := bob.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, id, m.ID)
+	assert.Equal(t, "alice:1", m.Sender)
+	assert.Equal(t, []byte("hello"), m.Payload)
+
+	assert.NotNil(t, bob.SetMessageHandler(nil))
+
+	h := make(handler, 1)
+	require.Nil(t, bob.SetMessageHandler(h))
+
+	_, err = alice.Send("bob:1", []byte("again"))
+	require.Nil(t, err)
+
+	select {
+	case m := <-h:
+		assert.Equal(t, []byte("again"), m.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpackEncode(t *testing.T) {
+	cases := map[string]string{
+		`0`:                    "00",
+		`127`:                  "7f",
+		`128`:                  "cc80",
+		`65536`:                "ce00010000",
+		`-1`:                   "ff",
+		`-33`:                  "d0df",
+		`-129`:                 "d1ff7f",
+		`18446744073709551615`: "cfffffffffffffffff",
+		`1.5`:                  "ca3fc00000",
+		`1.1`:                  "cb3ff199999999999a",
+		`null`:                 "c0",
+		`true`:                 "c3",
+		`"a"`:                  "a161",
+		`[1,2]`:                "920102",
+		`{"b":1,"a":[]}`:       "82a16190a16201",
+	}
+
+	for in, want := range cases {
+		v, err := jsonValue(json.RawMessage(in))
+		require.Nil(t, err)
+
+		var buf bytes.Buffer
+
+		require.Nil(t, encodeMsgpack(&buf, v), in)
+		assert.Equal(t, want, hex.EncodeToString(buf.Bytes()), in)
+	}
+}
+
+func TestMsgpackDecode(t *testing.T) {
+	long := strings.Repeat("x", 40)
+
+	cases := map[string]string{
+		"d3fffffffffffffffe":                      `-2`,
+		"d2fffffffe":                              `-2`,
+		"cd0100":                                  `256`,
+		"c403010203":                              `"AQID"`,
+		"dc0002c2c3":                              `[false,true]`,
+		"de0001a161c0":                            `{"a":null}`,
+		"d928" + hex.EncodeToString([]byte(long)): `"` + long + `"`,
+	}
+
+	for in, want := range cases {
+		data, err := hex.DecodeString(in)
+		require.Nil(t, err)
+
+		js, err := msgpackCodec{}.toJSON(data)
+		require.Nil(t, err, in)
+		assert.JSONEq(t, want, string(js), in)
+	}
+
+	invalid := []string{
+		"",           // no value
+		"cd01",       // truncated integer
+		"a561",       // truncated string
+		"8101c0",     // integer map key
+		"0000",       // trailing data
+		"d40100",     // extension type
+		"ddffffffff", // huge array length
+	}
+
+	for _, in := range invalid {
+		data, err := hex.DecodeString(in)
+		require.Nil(t, err)
+
+		_, err = decodeMsgpack(data)
+		assert.NotNil(t, err, in)
+	}
+
+	deep := append(bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2), 0x00)
+	_, err := decodeMsgpack(deep)
+	assert.NotNil(t, err)
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	type reading struct {
+		Sensor  string    `json:"sensor"`
+		Values  []float64 `json:"values"`
+		Counter uint64    `json:"counter"`
+		Delta   int       `json:"delta"`
+		Raw     []byte    `json:"raw"`
+	}
+
+	in := reading{Sensor: "greenhouse-4", Values: []float64{21.5, 1.1, -3}, Counter: 1 << 40, Delta: -70000, Raw: []byte("raw")}
+
+	data, err := MsgpackCodec.Marshal(in)
+	require.Nil(t, err)
+
+	js, err := json.Marshal(in)
+	require.Nil(t, err)
+	assert.True(t, len(data) < len(js))
+
+	var out reading
+
+	require.Nil(t, MsgpackCodec.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
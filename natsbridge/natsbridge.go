@@ -0,0 +1,185 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package natsbridge connects a messaging client to a NATS mesh. Received
+// messages are republished on <prefix>.in.<sender>.<type>, so subscribers can
+// pick the senders and types they handle with wildcards, and JSON requests
+// published on <prefix>.out are sent as messages, with the outcome published
+// to the request's reply subject.
+package natsbridge
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// DefaultPrefix is the prefix of the subjects used by the bridge
+	DefaultPrefix = "self"
+	// RawType is the type of messages whose payload could not be verified
+	RawType = "raw"
+)
+
+// Conn publishes and subscribes to NATS subjects. Subscribe calls fn with the
+// subject, reply subject and data of each message until unsubscribe is called
+type Conn interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, fn func(subject, reply string, data []byte)) (unsubscribe func() error, err error)
+}
+
+// Message is published for each message received by the client
+type Message struct {
+	ID        string `json:"id"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Offset    int64  `json:"offset"`
+	Type      string `json:"type"`
+	Payload   []byte `json:"payload"`
+}
+
+// Request is sent to the outbound subject to send a message
+type Request struct {
+	Recipient string `json:"recipient"`
+	Payload   []byte `json:"payload"`
+}
+
+// Reply is published to the reply subject of a request once it has been sent
+type Reply struct {
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Bridge forwards messages between a client and NATS
+type Bridge struct {
+	// Prefix is prepended to all subjects
+	Prefix string
+	// OnError is called when a message can't be published
+	OnError func(m *msgproto.Message, err error)
+
+	client *messaging.Client
+	conn   Conn
+}
+
+// New creates a bridge between a client and a NATS connection
+func New(client *messaging.Client, conn Conn) *Bridge {
+	return &Bridge{
+		Prefix: DefaultPrefix,
+		client: client,
+		conn:   conn,
+	}
+}
+
+// InboundSubject returns the subject a message from sender with the given type is published on:
+// <prefix>.in.<sender self ID>.<type>
+func (b *Bridge) InboundSubject(sender, typ string) string {
+	return b.Prefix + ".in." + token(strings.Split(sender, ":")[0]) + "." + token(typ)
+}
+
+// OutboundSubject returns the subject requests to send messages are received on
+func (b *Bridge) OutboundSubject() string {
+	return b.Prefix + ".out"
+}
+
+// Run forwards messages in both directions until the context is cancelled
+func (b *Bridge) Run(ctx context.Context) error {
+	unsubscribe, err := b.conn.Subscribe(b.OutboundSubject(), b.send)
+	if err != nil {
+		return err
+	}
+
+	defer unsubscribe()
+
+	for {
+		select {
+		case m, ok := <-b.client.ReceiveChan():
+			if !ok {
+				return nil
+			}
+
+			err := b.Publish(m)
+			if err != nil && b.OnError != nil {
+				b.OnError(m, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Publish republishes a received message. Messages with a verified payload
+// are published under the payload's type, all others under RawType
+func (b *Bridge) Publish(m *msgproto.Message) error {
+	typ := RawType
+
+	claims, err := b.client.DecodePayload(m, nil)
+	if err == nil && claims.Type != "" {
+		typ = claims.Type
+	}
+
+	data, err := json.Marshal(&Message{
+		ID:        m.Id,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		Offset:    m.Offset,
+		Type:      typ,
+		Payload:   m.Ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.conn.Publish(b.InboundSubject(m.Sender, typ), data)
+}
+
+// send sends a message for a request and replies with its outcome
+func (b *Bridge) send(subject, reply string, data []byte) {
+	var req Request
+	var resp Reply
+
+	err := json.Unmarshal(data, &req)
+	if err == nil {
+		m := &msgproto.Message{
+			Id:         b.client.NewID(),
+			Type:       msgproto.MsgType_MSG,
+			Sender:     b.client.SelfID() + ":" + b.client.DeviceID(),
+			Recipient:  req.Recipient,
+			Ciphertext: req.Payload,
+		}
+
+		err = b.client.Send(m)
+		resp.ID = m.Id
+	}
+
+	if err != nil {
+		resp = Reply{Error: err.Error()}
+	}
+
+	if reply == "" {
+		return
+	}
+
+	data, err = json.Marshal(&resp)
+	if err != nil {
+		return
+	}
+
+	b.conn.Publish(reply, data)
+}
+
+// token replaces characters that are not allowed in a subject token
+func token(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
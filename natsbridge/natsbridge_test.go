@@ -0,0 +1,262 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package natsbridge
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type publication struct {
+	subject string
+	data    []byte
+}
+
+// mesh is an in memory NATS connection
+type mesh struct {
+	published chan publication
+	handlers  map[string]func(subject, reply string, data []byte)
+	mu        sync.Mutex
+}
+
+func (m *mesh) Publish(subject string, data []byte) error {
+	m.published <- publication{subject, data}
+	return nil
+}
+
+func (m *mesh) Subscribe(subject string, fn func(subject, reply string, data []byte)) (func() error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[subject] = fn
+
+	return func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.handlers, subject)
+		return nil
+	}, nil
+}
+
+func (m *mesh) request(subject, reply string, data []byte) {
+	m.mu.Lock()
+	fn := m.handlers[subject]
+	m.mu.Unlock()
+
+	fn(subject, reply, data)
+}
+
+func TestBridge(t *testing.T) {
+	r := newRelay()
+	defer r.close()
+
+	alicekey, alicepub := newKey()
+	bobkey, _ := newKey()
+
+	keys := messaging.PublicKeys(func(selfID string) ([]crypto.PublicKey, error) {
+		if selfID == "alice" {
+			return []crypto.PublicKey{alicepub}, nil
+		}
+		return nil, errors.New("unknown identity")
+	})
+
+	alice, err := messaging.New(r.endpoint(), "alice", "1", alicekey)
+	require.Nil(t, err)
+	defer alice.Close()
+
+	bob, err := messaging.New(r.endpoint(), "bob", "1", bobkey, keys)
+	require.Nil(t, err)
+	defer bob.Close()
+
+	m := &mesh{published: make(chan publication, 8), handlers: make(map[string]func(string, string, []byte))}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	b := New(bob, m)
+	go b.Run(ctx)
+
+	payload, err := alice.Sign(map[string]interface{}{"typ": "greeting", "iss": "alice"})
+	require.Nil(t, err)
+
+	// a verified message is published under its type
+	aliceBridge := New(alice, m)
+	aliceBridge.send("self.out", "", mustJSON(t, &Request{Recipient: "bob:1", Payload: payload}))
+
+	p := <-m.published
+	assert.Equal(t, "self.in.alice.greeting", p.subject)
+
+	var msg Message
+	require.Nil(t, json.Unmarshal(p.data, &msg))
+	assert.Equal(t, "alice:1", msg.Sender)
+	assert.Equal(t, payload, msg.Payload)
+
+	// an unverified message is published as raw
+	aliceBridge.send("self.out", "", mustJSON(t, &Request{Recipient: "bob:1", Payload: []byte("hello")}))
+
+	p = <-m.published
+	assert.Equal(t, "self.in.alice.raw", p.subject)
+
+	// requests on the outbound subject are sent and replied to
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.handlers["self.out"] != nil
+	}, time.Second, time.Millisecond)
+
+	m.request("self.out", "inbox.1", mustJSON(t, &Request{Recipient: "alice:1", Payload: []byte("hi")}))
+
+	p = <-m.published
+	assert.Equal(t, "inbox.1", p.subject)
+
+	var reply Reply
+	require.Nil(t, json.Unmarshal(p.data, &reply))
+	assert.NotEmpty(t, reply.ID)
+	assert.Empty(t, reply.Error)
+
+	received, err := alice.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, reply.ID, received.Id)
+}
+
+func TestToken(t *testing.T) {
+	assert.Equal(t, "a_b_c", token("a.b*c"))
+	assert.Equal(t, "_", token(""))
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.Nil(t, err)
+	return data
+}
+
+// relay is a messaging server that acknowledges every frame and forwards
+// messages to their recipient
+type relay struct {
+	s     *httptest.Server
+	conns map[string]*websocket.Conn
+	mu    sync.Mutex
+}
+
+// newRelay starts a relay
+func newRelay() *relay {
+	r := &relay{conns: make(map[string]*websocket.Conn)}
+	r.s = httptest.NewServer(http.HandlerFunc(r.handler))
+	return r
+}
+
+// endpoint returns the websocket endpoint of the relay
+func (r *relay) endpoint() string {
+	return "ws" + strings.TrimPrefix(r.s.URL, "http")
+}
+
+func (r *relay) write(wc *websocket.Conn, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (r *relay) ack(wc *websocket.Conn, id string) {
+	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: id})
+	r.write(wc, data)
+}
+
+func (r *relay) handler(w http.ResponseWriter, req *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	_, data, err := wc.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var auth msgproto.Auth
+
+	err = proto.Unmarshal(data, &auth)
+	if err != nil {
+		return
+	}
+
+	token, err := jose.ParseSigned(auth.Token)
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	json.Unmarshal(token.UnsafePayloadWithoutVerification(), &claims)
+
+	r.mu.Lock()
+	r.conns[claims.Issuer] = wc
+	r.mu.Unlock()
+
+	r.ack(wc, auth.Id)
+
+	for {
+		_, data, err := wc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return
+		}
+
+		r.ack(wc, m.Id)
+
+		if m.Type != msgproto.MsgType_MSG {
+			continue
+		}
+
+		r.mu.Lock()
+		rc, ok := r.conns[strings.Split(m.Recipient, ":")[0]]
+		r.mu.Unlock()
+
+		if ok {
+			r.write(rc, data)
+		}
+	}
+}
+
+// close shuts down the relay
+func (r *relay) close() {
+	r.s.Close()
+}
+
+// newKey generates a private key encoded as the client expects and its public key
+func newKey() (string, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(priv.Seed()), pub
+}
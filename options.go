@@ -3,6 +3,10 @@
 package messaging
 
 import (
+	"crypto"
+	"crypto/tls"
+	"errors"
+	"net/url"
 	"time"
 
 	msgproto "github.com/selfid-net/self-messaging-client/proto"
@@ -11,6 +15,9 @@ import (
 // SendBuffer sets the size of the send buffer
 func SendBuffer(sz int) func(c *Client) error {
 	return func(c *Client) error {
+		if sz < 1 {
+			return errors.New("send buffer must be at least 1")
+		}
 		c.send = make(chan *request, sz)
 		return nil
 	}
@@ -19,7 +26,34 @@ func SendBuffer(sz int) func(c *Client) error {
 // ReceiveBuffer sets the size of the receive buffer
 func ReceiveBuffer(sz int) func(c *Client) error {
 	return func(c *Client) error {
+		if sz < 0 {
+			return errors.New("receive buffer must not be negative")
+		}
 		c.recv = make(chan *msgproto.Message, sz)
+		c.envelopes = newEnvelopeCache(sz * 2)
+		return nil
+	}
+}
+
+// JWSResponseBuffer sets the number of responses buffered for each
+// conversation registered with JWSResponses
+func JWSResponseBuffer(sz int) func(c *Client) error {
+	return func(c *Client) error {
+		if sz < 1 {
+			return errors.New("jws response buffer must be at least 1")
+		}
+		c.jwsBuffer = sz
+		return nil
+	}
+}
+
+// EventBuffer sets the number of events buffered for Events
+func EventBuffer(sz int) func(c *Client) error {
+	return func(c *Client) error {
+		if sz < 1 {
+			return errors.New("event buffer must be at least 1")
+		}
+		c.events = make(chan Event, sz)
 		return nil
 	}
 }
@@ -27,7 +61,39 @@ func ReceiveBuffer(sz int) func(c *Client) error {
 // AutoReconnect enables retrying a connection if it closes unexpectedly
 func AutoReconnect(enabled bool) func(c *Client) error {
 	return func(c *Client) error {
-		c.reconnect = true
+		c.reconnect = enabled
+		return nil
+	}
+}
+
+// ReconnectOnSessionReplaced sets whether the client reconnects after the
+// server closes the connection because the device connected elsewhere.
+// Disabling it stops two instances from repeatedly taking over each other's session
+func ReconnectOnSessionReplaced(enabled bool) func(c *Client) error {
+	return func(c *Client) error {
+		c.reconnectReplaced = enabled
+		return nil
+	}
+}
+
+// MaxRetries sets the number of reconnect attempts made before giving up
+func MaxRetries(n int) func(c *Client) error {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("max retries must not be negative")
+		}
+		c.maxretries = n
+		return nil
+	}
+}
+
+// RetryInterval sets the time to wait between reconnect attempts
+func RetryInterval(interval time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("retry interval must be positive")
+		}
+		c.retryInterval = interval
 		return nil
 	}
 }
@@ -35,7 +101,800 @@ func AutoReconnect(enabled bool) func(c *Client) error {
 // ReadDeadline sets the tcp read timeout
 func ReadDeadline(deadline time.Duration) func(c *Client) error {
 	return func(c *Client) error {
+		if deadline <= 0 {
+			return errors.New("read deadline must be positive")
+		}
 		c.deadline = deadline
 		return nil
 	}
 }
+
+// PublicKeys sets the source of public keys used to verify inbound payloads
+func PublicKeys(fn PublicKeyFunc) func(c *Client) error {
+	return func(c *Client) error {
+		c.publicKeys = fn
+		return nil
+	}
+}
+
+// DeadLetters sets a queue that receives messages rejected by the server
+func DeadLetters(q DeadLetterQueue) func(c *Client) error {
+	return func(c *Client) error {
+		c.deadLetters = q
+		return nil
+	}
+}
+
+// AtLeastOnce enables at least once delivery. Outbound messages are stored
+// before they are sent and removed once the server has responded, with any
+// unacknowledged messages resent after reconnecting. Inbound messages are
+// journaled before delivery and redelivered on startup until they are
+// acknowledged with Ack, and can be reprocessed with ReplayFrom or
+// ReplayFromOffset. Either store may be nil
+func AtLeastOnce(outbound, inbound MessageStore) func(c *Client) error {
+	return func(c *Client) error {
+		c.outbound = outbound
+		c.inbound = inbound
+		return nil
+	}
+}
+
+// ConsumerRetries sets how many times Consume retries a failed handler and
+// the backoff between attempts, which increases linearly with each attempt
+func ConsumerRetries(retries int, backoff time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if retries < 0 || backoff < 0 {
+			return errors.New("consumer retries and backoff must not be negative")
+		}
+		c.consumerRetries = retries
+		c.consumerBackoff = backoff
+		return nil
+	}
+}
+
+// Watchdog forces a reconnect when no frames, including pongs, have been
+// received from the server for the given period. Reconnecting also
+// requires AutoReconnect to be enabled
+func Watchdog(period time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if period < 0 {
+			return errors.New("watchdog period must not be negative")
+		}
+		c.watchdogPeriod = period
+		return nil
+	}
+}
+
+// WithClock sets the clock used for timestamps, timeouts and tickers
+func WithClock(clock Clock) func(c *Client) error {
+	return func(c *Client) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		c.clock = clock
+		return nil
+	}
+}
+
+// OnError sets a handler for errors that occur in the background. The
+// handler is called synchronously and must not block
+func OnError(fn func(error)) func(c *Client) error {
+	return func(c *Client) error {
+		c.onError = fn
+		return nil
+	}
+}
+
+// WithCipher sets the cipher used to encrypt sent messages and decrypt received messages
+func WithCipher(cipher Cipher) func(c *Client) error {
+	return func(c *Client) error {
+		if cipher == nil {
+			return errors.New("cipher must not be nil")
+		}
+		c.cipher = cipher
+		return nil
+	}
+}
+
+// GroupKeys sets the store group keys are persisted in
+func GroupKeys(store GroupKeyStore) func(c *Client) error {
+	return func(c *Client) error {
+		if store == nil {
+			return errors.New("group key store must not be nil")
+		}
+		c.groupKeys = store
+		return nil
+	}
+}
+
+// WithDirectory sets the directory used to look up devices. Unless the
+// PublicKeys option is also used, payloads are verified with the
+// directory's public keys
+func WithDirectory(d Directory) func(c *Client) error {
+	return func(c *Client) error {
+		if d == nil {
+			return errors.New("directory must not be nil")
+		}
+		c.directory = d
+		if c.publicKeys == nil {
+			c.publicKeys = d.PublicKeys
+		}
+		return nil
+	}
+}
+
+// ReplayProtection rejects verified payloads whose jti has already been
+// received in the last ttl. Up to size ids are remembered, and onReplay,
+// if not nil, is called when a replay is detected. Payloads without a jti
+// are rejected. A zero size or ttl uses the default
+func ReplayProtection(size int, ttl time.Duration, onReplay func(*Claims, *msgproto.Message)) func(c *Client) error {
+	return func(c *Client) error {
+		if size < 1 {
+			size = DefaultReplayCacheSize
+		}
+		if ttl <= 0 {
+			ttl = DefaultReplayTTL
+		}
+		c.replays = newReplayCache(size, ttl, onReplay)
+		return nil
+	}
+}
+
+// Audit records the metadata of every message sent and received to sink.
+// Records are passed through each redact function before they are recorded
+func Audit(sink AuditSink, redact ...func(*AuditRecord)) func(c *Client) error {
+	return func(c *Client) error {
+		if sink == nil {
+			return errors.New("audit sink must not be nil")
+		}
+		c.auditor = &auditor{sink: sink, redacts: redact}
+		return nil
+	}
+}
+
+// FrameTap calls fn with a copy of every raw frame read from or written to
+// the connection, including the authentication frames. It is intended for
+// debugging and is called from the reader and writer, so it must not block
+func FrameTap(fn func(*Frame)) func(c *Client) error {
+	return func(c *Client) error {
+		c.frameTap = fn
+		return nil
+	}
+}
+
+// IDGenerator sets the function used to generate message, request and
+// payload ids. The default generates random UUIDs
+func IDGenerator(fn func() string) func(c *Client) error {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("id generator must not be nil")
+		}
+		c.idGenerator = fn
+		return nil
+	}
+}
+
+// InboundWorkers sets the number of workers that decrypt, parse and route
+// received messages. Messages from the same sender are always processed by
+// the same worker, so they are delivered in order
+func InboundWorkers(n int) func(c *Client) error {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("inbound workers must be at least 1")
+		}
+		c.inboundWorkers = n
+		return nil
+	}
+}
+
+// MaxMemory bounds the bytes buffered by messages waiting to be written and
+// received messages until the application takes them, including those in
+// the receive queues and response channels. Sends wait for room in the
+// budget for up to the request timeout, or fail immediately for SendAsync,
+// and reading from the connection pauses while the budget is exhausted
+func MaxMemory(bytes int64) func(c *Client) error {
+	return func(c *Client) error {
+		if bytes < 1 {
+			return errors.New("memory budget must be positive")
+		}
+		c.memory = newBudget(bytes)
+		c.requests.memory = c.memory
+		return nil
+	}
+}
+
+// MaxMessageSize limits the encoded size of messages sent and received by the client.
+// Sending a larger message fails with ErrMessageTooLarge, while a larger received
+// message is skipped and reported as dropped. A limit of zero disables the check
+func MaxMessageSize(outbound, inbound int64) func(c *Client) error {
+	return func(c *Client) error {
+		if outbound < 0 || inbound < 0 {
+			return errors.New("message size limit must not be negative")
+		}
+		c.maxOutbound = outbound
+		c.maxInbound = inbound
+		return nil
+	}
+}
+
+// LongPollFallback switches to long polling over HTTP after the websocket
+// connection to the server fails to be established the given number of
+// consecutive times. With AutoReconnect enabled, the client tries the
+// websocket again every LongPollRetry interval while long polling, and
+// reconnects with it once it succeeds
+func LongPollFallback(failures int) func(c *Client) error {
+	return func(c *Client) error {
+		if failures < 1 {
+			return errors.New("long poll fallback requires at least one failure")
+		}
+		c.longPollAfter = int32(failures)
+		return nil
+	}
+}
+
+// LongPollRetry sets how often a client that has fallen back to long
+// polling tries to connect with a websocket again
+func LongPollRetry(interval time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("long poll retry interval must be positive")
+		}
+		c.longPollRetry = interval
+		return nil
+	}
+}
+
+// Archive asynchronously passes received messages, and optionally sent
+// messages, to a sink in batches. Closing the client archives any queued messages
+func Archive(sink ArchiveSink, cfg ArchiveConfig) func(c *Client) error {
+	return func(c *Client) error {
+		if sink == nil {
+			return errors.New("archive sink must not be nil")
+		}
+		c.archiver = newArchiver(sink, cfg, c.report)
+		return nil
+	}
+}
+
+// SharedRequests shares JWS request registrations with other instances
+// connected with the same identity. Responses to requests registered by
+// another instance are forwarded to it instead of being received
+func SharedRequests(store RequestStore) func(c *Client) error {
+	return func(c *Client) error {
+		if store == nil {
+			return errors.New("request store must not be nil")
+		}
+		c.requests.shared = store
+		c.requests.report = c.report
+		return store.Listen(c.receiveForwarded)
+	}
+}
+
+// InjectFaults drops, duplicates, corrupts and delays frames and forces
+// disconnects, to test an application's resilience. It must not be used in production
+func InjectFaults(f Faults) func(c *Client) error {
+	return func(c *Client) error {
+		for _, p := range []float64{f.Drop, f.Duplicate, f.Corrupt} {
+			if p < 0 || p > 1 {
+				return errors.New("fault probabilities must be between 0 and 1")
+			}
+		}
+		c.faults = &f
+		return nil
+	}
+}
+
+// ShapeTraffic adds latency and jitter to the client's connection and
+// limits its bandwidth. It must not be used in production
+func ShapeTraffic(s Shaping) func(c *Client) error {
+	return func(c *Client) error {
+		if s.Latency < 0 || s.Jitter < 0 || s.Bandwidth < 0 {
+			return errors.New("traffic shaping settings must not be negative")
+		}
+		c.shaping = &s
+		return nil
+	}
+}
+
+// DrainOutbox sends the messages committed to an application's outbox,
+// checking for new messages at the given interval
+func DrainOutbox(o Outbox, interval time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if o == nil {
+			return errors.New("outbox must not be nil")
+		}
+		c.outboxDrainer = newOutboxDrainer(o, interval)
+		return nil
+	}
+}
+
+// RestoreState restores state exported with ExportState before the client
+// connects, so the first connection resumes from the exported offset. It
+// must follow the ReplayProtection option if the state has payload ids
+func RestoreState(data []byte) func(c *Client) error {
+	return func(c *Client) error {
+		st, err := c.restore(data)
+		if err != nil {
+			return err
+		}
+		c.restored = st.Outbound
+		return nil
+	}
+}
+
+// LimitSenders accepts at most limit messages from each sender every
+// interval. onLimit is called with each message over the limit and decides
+// whether it is dropped or deferred until the sender's next interval. If
+// onLimit is nil, excess messages are dropped. Deferred messages count
+// towards the interval they are delivered in and may be delivered after
+// messages received later. At most DefaultBufferSize messages are deferred
+// for each sender, and they are held within the MaxMemory budget until
+// they are delivered
+func LimitSenders(limit int, interval time.Duration, onLimit func(m *msgproto.Message) LimitAction) func(c *Client) error {
+	return func(c *Client) error {
+		if limit < 1 {
+			return errors.New("sender limit must be at least 1")
+		}
+		if interval <= 0 {
+			return errors.New("sender limit interval must be positive")
+		}
+		c.senderLimits = newSenderLimiter(limit, interval, onLimit)
+		return nil
+	}
+}
+
+// AcceptSender drops received messages whose sender is rejected by fn
+// before they are delivered. It is applied in addition to the server's ACL
+// rules, so it can enforce rules the server does not support, such as
+// accepting messages from some of a sender's devices
+func AcceptSender(fn func(sender, device string) bool) func(c *Client) error {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("sender policy must not be nil")
+		}
+		c.senderPolicy = fn
+		return nil
+	}
+}
+
+// MessageTTL adds an exp claim to payloads signed by the client that do not
+// have one, so recipients can discard them if they are delivered late
+func MessageTTL(ttl time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return errors.New("message ttl must be positive")
+		}
+		c.messageTTL = ttl
+		return nil
+	}
+}
+
+// DiscardExpired discards received messages whose payload expired more than
+// grace ago instead of delivering them. Discarded messages are counted in
+// the Expired stat
+func DiscardExpired(grace time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if grace < 0 {
+			return errors.New("expiry grace must not be negative")
+		}
+		c.expiryGrace = grace
+		return nil
+	}
+}
+
+// InboundQueue delivers received messages whose payload type matches one of
+// types to a separate queue of the given size, read with ReceiveQueue or
+// QueueChan instead of Receive. Types ending in * match any payload type
+// with the prefix. Queues are matched in the order they are added, so time
+// sensitive responses are not held up behind bulk messages. Once inbound
+// queues are added, each queue, including the receive queue, has its own
+// backpressure: messages for a full queue are dropped with
+// ErrInboundQueueFull rather than delaying the other queues, so size queues
+// for their peak backlog
+func InboundQueue(name string, size int, types ...string) func(c *Client) error {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("inbound queue must have a name")
+		}
+		if c.queue(name) != nil {
+			return errors.New("inbound queue " + name + " already exists")
+		}
+		if len(types) == 0 {
+			return errors.New("inbound queue must match at least one payload type")
+		}
+		c.inboundQueues = append(c.inboundQueues, &inboundQueue{
+			name:  name,
+			types: types,
+			ch:    make(chan *msgproto.Message, size),
+			held:  &heldMessages{},
+		})
+		return nil
+	}
+}
+
+// ReceiveSkipPolicy sets what ReceiveOfType does with messages of other types
+func ReceiveSkipPolicy(policy SkipPolicy) func(c *Client) error {
+	return func(c *Client) error {
+		if policy != HoldSkipped && policy != RequeueSkipped {
+			return errors.New("unknown skip policy")
+		}
+		c.skipPolicy = policy
+		return nil
+	}
+}
+
+// MaxACLExpiry sets the longest a sender can be permitted for, matching
+// the server's policy. By default the expiry is not limited
+func MaxACLExpiry(d time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("maximum acl expiry must be positive")
+		}
+		c.maxACLExpiry = d
+		return nil
+	}
+}
+
+// RequestTimeout sets how long to wait for the server to respond to a request
+func RequestTimeout(timeout time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("request timeout must be positive")
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// TLSConfig sets the TLS configuration used to connect to the server
+func TLSConfig(cfg *tls.Config) func(c *Client) error {
+	return func(c *Client) error {
+		if cfg == nil {
+			return errors.New("tls config must not be nil")
+		}
+		c.dialOptions.tls = cfg
+		return nil
+	}
+}
+
+// SendWatermarks calls the watermark callbacks as the send queue fills and
+// drains. It must follow the SendBuffer option
+func SendWatermarks(w Watermarks) func(c *Client) error {
+	return func(c *Client) error {
+		wm, err := newWatermark(w, cap(c.send))
+		if err != nil {
+			return err
+		}
+		c.sendWatermark = wm
+		return nil
+	}
+}
+
+// ReceiveWatermarks calls the watermark callbacks as the receive queue fills
+// and drains. The queue is polled while it is above the high watermark, so
+// OnLow is also called when messages are read from ReceiveChan. It must
+// follow the ReceiveBuffer option
+func ReceiveWatermarks(w Watermarks) func(c *Client) error {
+	return func(c *Client) error {
+		wm, err := newWatermark(w, cap(c.recv))
+		if err != nil {
+			return err
+		}
+		c.recvWatermark = wm
+		return nil
+	}
+}
+
+// HappyEyeballs connects to the server's IPv6 and IPv4 addresses in
+// parallel, starting a new attempt every attemptDelay until one succeeds,
+// as described by RFC 8305. Each attempt times out after addressTimeout.
+// Zero values use DefaultAttemptDelay and DefaultAddressTimeout
+func HappyEyeballs(attemptDelay, addressTimeout time.Duration) func(c *Client) error {
+	return func(c *Client) error {
+		if attemptDelay < 0 || addressTimeout < 0 {
+			return errors.New("happy eyeballs delays must not be negative")
+		}
+		if attemptDelay == 0 {
+			attemptDelay = DefaultAttemptDelay
+		}
+		if addressTimeout == 0 {
+			addressTimeout = DefaultAddressTimeout
+		}
+		c.dialOptions.eyeballs = &happyEyeballs{attemptDelay: attemptDelay, addressTimeout: addressTimeout}
+		return nil
+	}
+}
+
+// SOCKS5Proxy connects to the server through a SOCKS5 proxy at address,
+// authenticating with username and password if username is not empty.
+// Without this option, a proxy is read from the environment, including
+// socks5:// URLs in HTTPS_PROXY or ALL_PROXY
+func SOCKS5Proxy(address, username, password string) func(c *Client) error {
+	return func(c *Client) error {
+		if address == "" {
+			return errors.New("proxy address must not be empty")
+		}
+		c.dialOptions.proxy = &url.URL{Scheme: "socks5", Host: address}
+		if username != "" {
+			c.dialOptions.proxy.User = url.UserPassword(username, password)
+		}
+		return nil
+	}
+}
+
+// CustomResolver looks up the server's addresses with r instead of the
+// system resolver, such as a *net.Resolver using an internal DNS service
+// or StaticHosts
+func CustomResolver(r Resolver) func(c *Client) error {
+	return func(c *Client) error {
+		if r == nil {
+			return errors.New("resolver must not be nil")
+		}
+		c.dialOptions.resolver = r
+		return nil
+	}
+}
+
+// StandbyConnection keeps a second connection open that replaces the
+// current connection when it fails, so reconnecting does not wait for a new
+// connection to be dialed. The standby is authenticated when it is promoted,
+// so it does not replace the current session. It requires AutoReconnect
+func StandbyConnection(enabled bool) func(c *Client) error {
+	return func(c *Client) error {
+		c.standby = nil
+		if enabled {
+			c.standby = newStandby(c)
+		}
+		return nil
+	}
+}
+
+// Priority asks the server for preferential delivery on the client's
+// connections, from 1 for batch senders to MaxPriority for interactive
+// clients. Servers that do not support the priorities capability ignore
+// it. Messages can override it with SetPriority
+func Priority(n int) func(c *Client) error {
+	return func(c *Client) error {
+		if n < 0 || n > MaxPriority {
+			return ErrInvalidPriority
+		}
+		c.priority = n
+		return nil
+	}
+}
+
+// InterceptOutbound adds interceptors that are run, in order, over the
+// claims of every payload the client signs. They can stamp common claims
+// or reject payloads that don't follow conventions, so individual call
+// sites can't forget required fields
+func InterceptOutbound(interceptors ...OutboundInterceptor) func(c *Client) error {
+	return func(c *Client) error {
+		for _, fn := range interceptors {
+			if fn == nil {
+				return errors.New("outbound interceptor must not be nil")
+			}
+		}
+		c.interceptors = append(c.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// ValidatePayload registers validators for payloads with the given typ
+// claim. Outbound payloads that fail validation are not signed, and
+// inbound messages that fail are dropped and reported to the error handler
+func ValidatePayload(typ string, validators ...PayloadValidator) func(c *Client) error {
+	return func(c *Client) error {
+		if typ == "" {
+			return errors.New("validated payload type must not be empty")
+		}
+		for _, fn := range validators {
+			if fn == nil {
+				return errors.New("payload validator must not be nil")
+			}
+		}
+		if c.validators == nil {
+			c.validators = make(map[string][]PayloadValidator)
+		}
+		c.validators[typ] = append(c.validators[typ], validators...)
+		return nil
+	}
+}
+
+// PayloadSchema validates payloads with the given typ claim against a
+// JSON Schema, as with ValidatePayload
+func PayloadSchema(typ string, schema *Schema) func(c *Client) error {
+	return func(c *Client) error {
+		if schema == nil {
+			return errors.New("payload schema must not be nil")
+		}
+		err := schema.compile()
+		if err != nil {
+			return err
+		}
+		return ValidatePayload(typ, schema.Validate)(c)
+	}
+}
+
+// PayloadCodec sets the codec payloads are encoded with when they are
+// signed. Received payloads are decoded with the codec that encoded them,
+// which must be registered with RegisterCodec
+func PayloadCodec(codec Codec) func(c *Client) error {
+	return func(c *Client) error {
+		if codec == nil {
+			return errors.New("payload codec must not be nil")
+		}
+		c.codec = codec
+		if codec == JSONCodec {
+			// payloads are already json
+			c.codec = nil
+		}
+		return nil
+	}
+}
+
+// PublishExpvar publishes the client's stats with expvar, under
+// ExpvarPrefix or, if a namespace is given, ExpvarPrefix.namespace. Each
+// name can only be used by one open client at a time
+func PublishExpvar(namespace string) func(c *Client) error {
+	return func(c *Client) error {
+		c.expvarName = ExpvarPrefix
+		if namespace != "" {
+			c.expvarName += "." + namespace
+		}
+		return nil
+	}
+}
+
+// TakeOver closes any other open client in the process that is connected
+// as the same device, instead of failing with ErrAlreadyConnected
+func TakeOver(enabled bool) func(c *Client) error {
+	return func(c *Client) error {
+		c.takeOver = enabled
+		return nil
+	}
+}
+
+// SigningKey signs payloads, tokens and ACL rules with key using alg instead
+// of the client's Ed25519 private key. key may be backed by an HSM or KMS that
+// does not support EdDSA, such as an ECDSA P-256 key for ES256 or an RSA key
+// for RS256
+func SigningKey(alg Algorithm, key crypto.Signer) func(c *Client) error {
+	return func(c *Client) error {
+		s, err := newKeySigner(alg, key, "")
+		if err != nil {
+			return err
+		}
+		c.signingKey = s
+		return nil
+	}
+}
+
+// KeyID sets the kid header of payloads signed with the client's key, so
+// recipients can select the key to verify them with
+func KeyID(kid string) func(c *Client) error {
+	return func(c *Client) error {
+		if kid == "" {
+			return errors.New("key id must not be empty")
+		}
+		c.keyID = kid
+		return nil
+	}
+}
+
+// AddSigningKey holds another key alongside the client's key, such as the
+// new key during a rotation, which is selected with UseSigningKey
+func AddSigningKey(kid string, alg Algorithm, key crypto.Signer) func(c *Client) error {
+	return func(c *Client) error {
+		if kid == "" {
+			return errors.New("signing key id must not be empty")
+		}
+
+		if _, ok := c.signingKeys[kid]; ok {
+			return errors.New("signing key id is already in use")
+		}
+
+		s, err := newKeySigner(alg, key, kid)
+		if err != nil {
+			return err
+		}
+
+		if c.signingKeys == nil {
+			c.signingKeys = make(map[string]*keySigner)
+		}
+
+		c.signingKeys[kid] = s
+
+		return nil
+	}
+}
+
+// Idempotency sets how long, and how many, acknowledged message ids and
+// idempotency keys are remembered, so messages are not written again after
+// the server has accepted them
+func Idempotency(ttl time.Duration, size int) func(c *Client) error {
+	return func(c *Client) error {
+		if ttl <= 0 || size < 1 {
+			return errors.New("idempotency ttl and size must be positive")
+		}
+		c.idempotency = newIdempotency(ttl, size)
+		return nil
+	}
+}
+
+// AckLatencySLO calls the SLO's callbacks when the time the server takes to
+// acknowledge messages exceeds its threshold for a number of messages in a row
+func AckLatencySLO(slo LatencySLO) func(c *Client) error {
+	return func(c *Client) error {
+		err := validateLatencySLO(slo)
+		if err != nil {
+			return err
+		}
+		c.latency.slo = &slo
+		return nil
+	}
+}
+
+// TransformInbound adds stages that transform received messages, in order,
+// before they are delivered
+func TransformInbound(stages ...InboundTransform) func(c *Client) error {
+	return func(c *Client) error {
+		for _, fn := range stages {
+			if fn == nil {
+				return errors.New("inbound transform must not be nil")
+			}
+		}
+		c.transforms = append(c.transforms, stages...)
+		return nil
+	}
+}
+
+// PayloadVersion sets the current version of payloads with the given typ
+// claim. Payloads the client signs are stamped with it as their ver claim,
+// and received payloads with an older version are upgraded before they are
+// validated or decoded, by running the migrations from their version up.
+// migrations[v] upgrades version v to v+1, so services can be upgraded one
+// at a time while still exchanging payloads
+func PayloadVersion(typ string, current int, migrations map[int]Migration) func(c *Client) error {
+	return func(c *Client) error {
+		err := validatePayloadVersion(typ, current, migrations)
+		if err != nil {
+			return err
+		}
+		if c.versions == nil {
+			c.versions = make(map[string]*payloadVersion)
+		}
+		c.versions[typ] = &payloadVersion{current: current, migrations: migrations}
+		return nil
+	}
+}
+
+// RecipientLimit limits the messages to each recipient that can be queued or
+// awaiting a response at once, so a slow or unreachable recipient can't fill
+// the shared send queue and delay messages to others. Messages beyond the
+// limit wait for up to the request timeout, or fail immediately when sent
+// with SendAsync, with ErrRecipientBusy
+func RecipientLimit(n int) func(c *Client) error {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("recipient limit must be positive")
+		}
+		c.recipients = newRecipientLimiter(n)
+		return nil
+	}
+}
+
+// EnsureACLOnConnect permits the given rules each time the client connects
+// or reconnects, for deployments that reset permissions with the connection.
+// Rules without an expiry are permitted indefinitely, and rules whose expiry
+// has passed are skipped. Use a source of "*" to permit all identities
+func EnsureACLOnConnect(rules []ACLRule) func(c *Client) error {
+	return func(c *Client) error {
+		for _, rule := range rules {
+			if rule.Source == "" {
+				return errors.New("acl rule has no source")
+			}
+		}
+		c.connectACL = append([]ACLRule(nil), rules...)
+		return nil
+	}
+}
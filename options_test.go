@@ -0,0 +1,105 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestOptionsInvalid(t *testing.T) {
+	cases := map[string]func(*Client) error{
+		"send buffer":          SendBuffer(0),
+		"receive buffer":       ReceiveBuffer(-1),
+		"jws response buffer":  JWSResponseBuffer(0),
+		"max retries":          MaxRetries(-1),
+		"retry interval":       RetryInterval(0),
+		"read deadline":        ReadDeadline(0),
+		"request timeout":      RequestTimeout(-time.Second),
+		"consumer retries":     ConsumerRetries(-1, time.Second),
+		"watchdog":             Watchdog(-time.Second),
+		"clock":                WithClock(nil),
+		"cipher":               WithCipher(nil),
+		"group keys":           GroupKeys(nil),
+		"directory":            WithDirectory(nil),
+		"audit":                Audit(nil),
+		"id generator":         IDGenerator(nil),
+		"inbound workers":      InboundWorkers(0),
+		"max memory":           MaxMemory(0),
+		"max message size":     MaxMessageSize(-1, 0),
+		"long poll fallback":   LongPollFallback(0),
+		"long poll retry":      LongPollRetry(0),
+		"archive":              Archive(nil, ArchiveConfig{}),
+		"shared requests":      SharedRequests(nil),
+		"outbox":               DrainOutbox(nil, 0),
+		"restore state":        RestoreState([]byte("{}")),
+		"limit senders":        LimitSenders(0, time.Second, nil),
+		"accept sender":        AcceptSender(nil),
+		"message ttl":          MessageTTL(0),
+		"discard expired":      DiscardExpired(-time.Second),
+		"inbound queue":        InboundQueue("", 1, "typ"),
+		"skip policy":          ReceiveSkipPolicy(SkipPolicy(5)),
+		"max acl expiry":       MaxACLExpiry(0),
+		"tls config":           TLSConfig(nil),
+		"happy eyeballs":       HappyEyeballs(-time.Second, 0),
+		"socks5 proxy":         SOCKS5Proxy("", "", ""),
+		"custom resolver":      CustomResolver(nil),
+		"priority":             Priority(MaxPriority + 1),
+		"outbound interceptor": InterceptOutbound(nil),
+		"payload validator":    ValidatePayload(""),
+		"payload schema":       PayloadSchema("test", nil),
+		"payload codec":        PayloadCodec(nil),
+		"event buffer":         EventBuffer(0),
+		"signing key":          SigningKey(ES256, ed25519.NewKeyFromSeed(make([]byte, 32))),
+		"key id":               KeyID(""),
+		"add signing key":      AddSigningKey("", EdDSA, ed25519.NewKeyFromSeed(make([]byte, 32))),
+		"idempotency":          Idempotency(0, 1),
+		"ack latency slo":      AckLatencySLO(LatencySLO{}),
+		"transform inbound":    TransformInbound(nil),
+		"payload version":      PayloadVersion("", 1, nil),
+		"payload migration":    PayloadVersion("a", 2, map[int]Migration{2: nil}),
+		"recipient limit":      RecipientLimit(0),
+		"ensure acl":           EnsureACLOnConnect([]ACLRule{{}}),
+	}
+
+	for name, opt := range cases {
+		_, err := New("ws://localhost:0", "someID", "1", privkey, opt)
+
+		var oerr *OptionsError
+		if assert.True(t, errors.As(err, &oerr), name) {
+			assert.Len(t, oerr.Errors, 1, name)
+		}
+	}
+}
+
+func TestOptionsAggregated(t *testing.T) {
+	_, err := New("ws://localhost:0", "someID", "1", privkey, SendBuffer(0), ReadDeadline(0), MaxRetries(3))
+
+	var oerr *OptionsError
+	require.True(t, errors.As(err, &oerr))
+	require.Len(t, oerr.Errors, 2)
+	assert.EqualError(t, err, "invalid options: send buffer must be at least 1; read deadline must be positive")
+}
+
+func TestOptionsConflicts(t *testing.T) {
+	_, err := New("ws://localhost:0", "someID", "1", privkey, MaxMemory(1024), MaxMessageSize(2048, 4096))
+
+	var oerr *OptionsError
+	require.True(t, errors.As(err, &oerr))
+	assert.Len(t, oerr.Errors, 2)
+}
+
+func TestOptionsAutoReconnect(t *testing.T) {
+	c := &Client{}
+
+	require.Nil(t, AutoReconnect(true)(c))
+	assert.True(t, c.reconnect)
+
+	require.Nil(t, AutoReconnect(false)(c))
+	assert.False(t, c.reconnect)
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// DefaultOutboxInterval is how often the outbox is checked for messages to send
+	DefaultOutboxInterval = time.Second
+	// DefaultOutboxBatch is the most messages read from the outbox at once
+	DefaultOutboxBatch = 100
+)
+
+// Outbox is a table of messages to send kept in the application's own
+// database. The application inserts messages in the same transaction as
+// the changes they describe, and the client sends them once committed, so
+// a message is sent if and only if the transaction commits. For example:
+//
+//	CREATE TABLE outbox (
+//		seq     BIGSERIAL PRIMARY KEY,
+//		id      TEXT NOT NULL UNIQUE,
+//		message BYTEA NOT NULL,
+//		sent_at TIMESTAMP,
+//		error   TEXT
+//	)
+//
+// A message may be sent more than once if the client stops after the server
+// acknowledges it but before it is marked as sent, so recipients should
+// deduplicate messages by id
+type Outbox interface {
+	// Pending returns up to limit committed messages that have not been sent, oldest first
+	Pending(limit int) ([]*msgproto.Message, error)
+	// MarkSent records that the server accepted a message, or rejected it with err
+	MarkSent(id string, err error) error
+}
+
+// outboxDrainer sends messages from an outbox
+type outboxDrainer struct {
+	outbox   Outbox
+	interval time.Duration
+	wake     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+	started  int32
+}
+
+func newOutboxDrainer(o Outbox, interval time.Duration) *outboxDrainer {
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+
+	return &outboxDrainer{
+		outbox:   o,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// startOutbox starts draining the outbox if one is configured
+func (c *Client) startOutbox() {
+	if c.outboxDrainer == nil {
+		return
+	}
+
+	atomic.StoreInt32(&c.outboxDrainer.started, 1)
+	go c.drainOutbox()
+}
+
+// drainOutbox sends pending messages until the client is closed
+func (c *Client) drainOutbox() {
+	d := c.outboxDrainer
+	defer close(d.stopped)
+
+	ticker := c.clock.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		c.sendOutbox()
+
+		select {
+		case <-ticker.Chan():
+		case <-d.wake:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// sendOutbox sends the pending messages in the outbox in order. It stops at
+// the first message that fails to send for a reason other than being
+// rejected, so it can be retried without reordering messages
+func (c *Client) sendOutbox() {
+	d := c.outboxDrainer
+
+	for {
+		if c.IsClosed() {
+			return
+		}
+
+		messages, err := d.outbox.Pending(DefaultOutboxBatch)
+		if err != nil {
+			c.report(fmt.Errorf("failed to read outbox: %w", err))
+			return
+		}
+
+		for _, m := range messages {
+			err := c.Send(m)
+			if err != nil {
+				if !isServerError(err) {
+					return
+				}
+			}
+
+			merr := d.outbox.MarkSent(m.Id, err)
+			if merr != nil {
+				c.report(fmt.Errorf("failed to mark outbox message %s as sent: %w", m.Id, merr))
+				return
+			}
+		}
+
+		if len(messages) < DefaultOutboxBatch {
+			return
+		}
+	}
+}
+
+// FlushOutbox sends the messages in the outbox without waiting for the next
+// check. Call it after committing a transaction that adds to the outbox
+func (c *Client) FlushOutbox() {
+	if c.outboxDrainer == nil {
+		return
+	}
+
+	select {
+	case c.outboxDrainer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops draining the outbox, waiting for any send in progress
+func (d *outboxDrainer) stop() {
+	if d == nil {
+		return
+	}
+
+	d.once.Do(func() {
+		close(d.done)
+	})
+
+	if atomic.LoadInt32(&d.started) != 0 {
+		<-d.stopped
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryOutbox struct {
+	pending []*msgproto.Message
+	sent    map[string]error
+	mu      sync.Mutex
+}
+
+func (o *memoryOutbox) add(m *msgproto.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, m)
+}
+
+func (o *memoryOutbox) Pending(limit int) ([]*msgproto.Message, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) > limit {
+		return o.pending[:limit], nil
+	}
+
+	return append([]*msgproto.Message{}, o.pending...), nil
+}
+
+func (o *memoryOutbox) MarkSent(id string, err error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, m := range o.pending {
+		if m.Id == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+
+	o.sent[id] = err
+
+	return nil
+}
+
+func (o *memoryOutbox) results() map[string]error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	r := make(map[string]error)
+	for k, v := range o.sent {
+		r[k] = v
+	}
+
+	return r
+}
+
+func TestClientDrainOutbox(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	outbox := &memoryOutbox{sent: make(map[string]error)}
+	outbox.add(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Recipient: "test:1", Ciphertext: []byte("one")})
+
+	c, err := New(s.endpoint, "someID", "1", privkey, DrainOutbox(outbox, time.Hour))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(outbox.results()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	outbox.add(&msgproto.Message{Id: "2", Type: msgproto.MsgType_MSG, Recipient: "error", Ciphertext: []byte("two")})
+	outbox.add(&msgproto.Message{Id: "3", Type: msgproto.MsgType_MSG, Recipient: "test:1", Ciphertext: []byte("three")})
+	c.FlushOutbox()
+
+	assert.Eventually(t, func() bool {
+		return len(outbox.results()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	results := outbox.results()
+	assert.Nil(t, results["1"])
+	assert.NotNil(t, results["2"])
+	assert.Nil(t, results["3"])
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// maxSkippedID is the longest id kept when scanning a skipped frame
+const maxSkippedID = 256
+
+// skippedFrame is a frame that exceeded the inbound size limit
+type skippedFrame struct {
+	typ    msgproto.MsgType
+	id     string
+	offset int64
+}
+
+// scanSkipped reads the type, id and offset of a frame, discarding its
+// other fields so that the frame is never held in memory
+func scanSkipped(r io.Reader) (*skippedFrame, error) {
+	var f skippedFrame
+
+	br := bufio.NewReader(r)
+
+	for {
+		key, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return &f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field, wireType := key>>3, key&7
+
+		switch wireType {
+		case proto.WireVarint:
+			v, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+
+			switch field {
+			case 1:
+				f.typ = msgproto.MsgType(v)
+			case 7:
+				f.offset = int64(v)
+			}
+		case proto.WireBytes:
+			l, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+
+			if field == 2 && l <= maxSkippedID {
+				id := make([]byte, l)
+
+				_, err = io.ReadFull(br, id)
+				if err != nil {
+					return nil, err
+				}
+
+				f.id = string(id)
+				continue
+			}
+
+			err = discard(br, int64(l))
+			if err != nil {
+				return nil, err
+			}
+		case proto.WireFixed64:
+			err = discard(br, 8)
+			if err != nil {
+				return nil, err
+			}
+		case proto.WireFixed32:
+			err = discard(br, 4)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("unsupported wire type")
+		}
+	}
+}
+
+func discard(r io.Reader, n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// skipFrame drops a frame that exceeded the inbound size limit. The offset
+// of a skipped message is recorded so it is not sent again on reconnect
+func (c *Client) skipFrame(f *skippedFrame) {
+	c.touch()
+
+	if f.typ == msgproto.MsgType_MSG {
+		c.advanceOffset(f.offset)
+	}
+
+	c.dropped(f.id, fmt.Errorf("dropped %s frame %s: %w", f.typ, f.id, ErrMessageTooLarge))
+}
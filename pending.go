@@ -0,0 +1,180 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCancelled is returned when a queued message is cancelled before it is written
+var ErrCancelled = errors.New("message was cancelled")
+
+// PendingState describes how far a pending request has progressed
+type PendingState int
+
+const (
+	// PendingQueued requests are in the send buffer waiting to be written
+	PendingQueued PendingState = iota
+	// PendingWritten requests have been written and are waiting for the server's response
+	PendingWritten
+	// PendingResponses are JWS requests waiting for responses from other identities
+	PendingResponses
+)
+
+func (s PendingState) String() string {
+	switch s {
+	case PendingQueued:
+		return "queued"
+	case PendingWritten:
+		return "written"
+	case PendingResponses:
+		return "responses"
+	}
+	return "unknown"
+}
+
+// PendingRequest describes a request that has not completed
+type PendingRequest struct {
+	ID        string
+	State     PendingState
+	Message   bool   // true for messages, false for ACL and other requests
+	Recipient string // set for queued messages
+	Size      int64  // encoded size of queued requests
+	QueuedAt  time.Time
+}
+
+// sendQueue tracks the requests in the send buffer so they can be
+// listed and cancelled before the writer takes them
+type sendQueue struct {
+	requests map[string]*request
+	mu       sync.Mutex
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{requests: make(map[string]*request)}
+}
+
+func (q *sendQueue) add(r *request) {
+	q.mu.Lock()
+	q.requests[r.id] = r
+	q.mu.Unlock()
+}
+
+func (q *sendQueue) remove(r *request) {
+	q.mu.Lock()
+	if q.requests[r.id] == r {
+		delete(q.requests, r.id)
+	}
+	q.mu.Unlock()
+}
+
+// take removes a request that the writer is about to write. It
+// returns false if the request has been cancelled
+func (q *sendQueue) take(r *request) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if r.cancelled {
+		return false
+	}
+
+	if q.requests[r.id] == r {
+		delete(q.requests, r.id)
+	}
+
+	return true
+}
+
+// cancel marks a queued request as cancelled, returning it if it had not been taken
+func (q *sendQueue) cancel(id string) *request {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r, ok := q.requests[id]
+	if !ok {
+		return nil
+	}
+
+	r.cancelled = true
+	delete(q.requests, id)
+
+	return r
+}
+
+func (q *sendQueue) list() []*request {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rs := make([]*request, 0, len(q.requests))
+	for _, r := range q.requests {
+		rs = append(rs, r)
+	}
+
+	return rs
+}
+
+// sortRequests sorts requests by the time they were queued
+func sortRequests(rs []*request) {
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].queuedAt.Before(rs[j].queuedAt)
+	})
+}
+
+// Pending lists the requests that are queued to be written, waiting for
+// the server's response or waiting for JWS responses, oldest queued first
+func (c *Client) Pending() []PendingRequest {
+	var pending []PendingRequest
+
+	queued := make(map[string]bool)
+
+	queue := c.queued.list()
+	sortRequests(queue)
+
+	for _, r := range queue {
+		queued[r.id] = true
+		pending = append(pending, PendingRequest{
+			ID:        r.id,
+			State:     PendingQueued,
+			Message:   r.isMsg,
+			Recipient: r.recipient,
+			Size:      r.size,
+			QueuedAt:  r.queuedAt,
+		})
+	}
+
+	c.requests.mu.RLock()
+	for id := range c.requests.requests {
+		if !queued[id] {
+			pending = append(pending, PendingRequest{ID: id, State: PendingWritten})
+		}
+	}
+	c.requests.mu.RUnlock()
+
+	c.requests.jwsmu.RLock()
+	for id := range c.requests.jwsRequests {
+		pending = append(pending, PendingRequest{ID: id, State: PendingResponses})
+	}
+	c.requests.jwsmu.RUnlock()
+
+	return pending
+}
+
+// CancelPending cancels a request that is queued but has not been written.
+// The sender receives ErrCancelled. It returns false if the request is not
+// queued, either because it has already been written or does not exist
+func (c *Client) CancelPending(id string) bool {
+	r := c.queued.cancel(id)
+	if r == nil {
+		return false
+	}
+
+	c.requests.cancel(r.id)
+	c.memory.release(r.size)
+	releaseMarshalBuffer(r.buf)
+	r.response <- ErrCancelled
+
+	return true
+}
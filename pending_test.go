@@ -0,0 +1,90 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPending(t *testing.T) {
+	// no writer is running, so queued requests stay in the send buffer
+	c := &Client{
+		send:     make(chan *request, 4),
+		counters: &counters{},
+		requests: newRequestCache(),
+		queued:   newSendQueue(),
+		clock:    systemClock{},
+	}
+
+	r1, err := c.enqueue("1", &msgproto.Message{Id: "1", Recipient: "alice:1"}, false, time.Time{})
+	require.Nil(t, err)
+
+	r2, err := c.enqueue("2", &msgproto.Message{Id: "2", Recipient: "bob:1"}, false, time.Time{})
+	require.Nil(t, err)
+
+	c.requests.registerJWS("3", 1)
+
+	pending := c.Pending()
+	require.Len(t, pending, 3)
+	assert.Equal(t, "1", pending[0].ID)
+	assert.Equal(t, PendingQueued, pending[0].State)
+	assert.Equal(t, "alice:1", pending[0].Recipient)
+	assert.True(t, pending[0].Message)
+	assert.Equal(t, "2", pending[1].ID)
+	assert.Equal(t, PendingResponses, pending[2].State)
+
+	assert.True(t, c.CancelPending("1"))
+	assert.False(t, c.CancelPending("1"))
+	assert.Equal(t, ErrCancelled, <-r1.response)
+
+	// the writer skips cancelled requests and takes the rest
+	assert.False(t, c.queued.take(<-c.send))
+	assert.True(t, c.queued.take(<-c.send))
+	assert.False(t, c.CancelPending(r2.id))
+
+	pending = c.Pending()
+	require.Len(t, pending, 2)
+	assert.Equal(t, PendingWritten, pending[0].State)
+	assert.Equal(t, "2", pending[0].ID)
+}
+
+func TestClientControlLane(t *testing.T) {
+	c := &Client{
+		send:     make(chan *request, 4),
+		control:  make(chan *request, 4),
+		counters: &counters{},
+		requests: newRequestCache(),
+		queued:   newSendQueue(),
+		clock:    systemClock{},
+		deadline: time.Minute,
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := c.enqueue(id, &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Recipient: "alice:1"}, false, time.Time{})
+		require.Nil(t, err)
+	}
+
+	_, err := c.enqueue("acl", &msgproto.AccessControlList{Type: msgproto.MsgType_ACL, Id: "acl"}, false, time.Time{})
+	require.Nil(t, err)
+
+	mt := newMemoryTransport()
+	conn := &connection{ws: mt, done: make(chan struct{})}
+	defer close(conn.done)
+
+	go c.writer(conn)
+
+	assert.Eventually(t, func() bool {
+		return len(mt.frames()) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	// the acl request is written before the queued messages
+	var hdr msgproto.Header
+	require.Nil(t, proto.Unmarshal(mt.frames()[0], &hdr))
+	assert.Equal(t, msgproto.MsgType_ACL, hdr.Type)
+}
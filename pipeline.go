@@ -0,0 +1,166 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// DefaultInboundWorkers is the number of workers that process received messages
+const DefaultInboundWorkers = 4
+
+// pipeline processes received messages on a set of workers so that parsing
+// payloads does not hold up reading acknowledgements and pongs from the
+// connection. Messages are assigned to workers by sender, so messages from
+// the same sender are delivered in the order they were received
+type pipeline struct {
+	queues  []chan *msgproto.Message
+	stopped chan struct{} // closed once nothing more is dispatched
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// startPipeline starts the workers for a connection. They exit once the
+// reader has stopped dispatching messages and their queues have been
+// drained, so every message dispatched is delivered
+func (c *Client) startPipeline(conn *connection) *pipeline {
+	p := &pipeline{queues: make([]chan *msgproto.Message, c.inboundWorkers), stopped: make(chan struct{})}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan *msgproto.Message, DefaultBufferSize)
+		worker, q := i, p.queues[i]
+		p.wg.Add(1)
+		go c.labelled("pipeline", func() {
+			defer p.wg.Done()
+			c.work(p, worker, q)
+		})
+	}
+
+	return p
+}
+
+func (p *pipeline) dispatch(m *msgproto.Message) {
+	p.queues[workerFor(m.Sender, len(p.queues))] <- m
+}
+
+// workerFor returns the worker that processes messages from a sender
+func workerFor(sender string, workers int) int {
+	if workers < 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(sender))
+
+	return int(h.Sum32() % uint32(workers))
+}
+
+// stop tells the workers that nothing more will be dispatched. It is
+// called by the reader when it exits
+func (p *pipeline) stop() {
+	p.once.Do(func() {
+		close(p.stopped)
+	})
+}
+
+func (c *Client) work(p *pipeline, worker int, queue chan *msgproto.Message) {
+	var timer <-chan time.Time
+	var armed time.Time
+
+	for {
+		// wake when the next message deferred by the sender limit is due
+		due, ok := c.senderLimits.next(worker)
+		if ok && !due.Equal(armed) {
+			timer = c.clock.After(due.Sub(c.clock.Now()))
+			armed = due
+		}
+
+		select {
+		case m := <-queue:
+			c.process(m)
+		case <-timer:
+			timer, armed = nil, time.Time{}
+			for _, m := range c.senderLimits.due(worker, c.clock.Now()) {
+				c.process(m)
+			}
+		case <-p.stopped:
+			for {
+				select {
+				case m := <-queue:
+					c.process(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// process delivers a message and returns its bytes to the memory budget,
+// unless they are still held by a queue or the sender limit
+func (c *Client) process(m *msgproto.Message) {
+	n := int64(len(m.Ciphertext))
+	if c.deliver(m) {
+		return
+	}
+	c.memory.release(n)
+}
+
+// deliver decrypts a received message and routes it to a waiting request,
+// one of the client's handlers or the receive queue. It returns true if the
+// message is held, in a queue for the application or deferred to be
+// delivered later, so its bytes stay in the memory budget
+func (c *Client) deliver(m *msgproto.Message) bool {
+	size := int64(len(m.Ciphertext))
+
+	c.advanceOffset(m.Offset)
+
+	limited, deferred := c.limitSender(m)
+	if limited {
+		return deferred
+	}
+
+	err := c.decrypt(m)
+	if err != nil {
+		c.dropped(m.Id, fmt.Errorf("failed to decrypt message: %w", err))
+		return false
+	}
+
+	err = c.transform(m)
+	if err != nil {
+		c.dropped(m.Id, fmt.Errorf("failed to transform message: %w", err))
+		return false
+	}
+
+	in := newInbound(m)
+	in.size = size
+	if c.expired(in) || c.invalid(in) {
+		return false
+	}
+
+	c.envelopes.put(in.msg, in.env)
+	c.audit(AuditReceived, in.msg, nil)
+	c.archive(AuditReceived, in.msg, nil)
+
+	registered, delivered := c.requests.sendJWS(in.conversationID(), in.msg, in.size)
+
+	switch {
+	case !registered && c.forwardJWS(in):
+	case !registered && c.deliverTopic(in):
+	case !registered && c.deliverStream(in):
+	case !registered && c.deliverFile(in):
+	case !registered && c.deliverGroupKey(in):
+	case !registered:
+		c.journal(in.msg)
+		return c.enqueueInbound(in)
+	case !delivered:
+		c.dropped(in.msg.Id, fmt.Errorf("response buffer for conversation %s is full", in.conversationID()))
+	}
+
+	return delivered
+}
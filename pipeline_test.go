@@ -0,0 +1,68 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"strconv"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientInboundWorkers(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	_, err := New(s.endpoint, "someID", "1", privkey, InboundWorkers(0))
+	assert.NotNil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InboundWorkers(3), ReceiveBuffer(512))
+	require.Nil(t, err)
+
+	senders := []string{"a:1", "b:1", "c:1", "d:1"}
+
+	for i := 0; i < 100; i++ {
+		for _, sender := range senders {
+			s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: strconv.Itoa(i), Sender: sender, Recipient: "someID:1"}
+		}
+	}
+
+	next := make(map[string]int)
+
+	for i := 0; i < 100*len(senders); i++ {
+		m, err := c.Receive()
+		require.Nil(t, err)
+
+		// messages from each sender arrive in order
+		assert.Equal(t, strconv.Itoa(next[m.Sender]), m.Id)
+		next[m.Sender]++
+	}
+}
+
+func TestPipelineDeliversAfterTeardown(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), MaxMemory(1024))
+	require.Nil(t, err)
+	defer c.Close()
+
+	conn := &connection{done: make(chan struct{})}
+	p := c.startPipeline(conn)
+
+	// messages the reader dispatches after the connection is torn down are still delivered
+	close(conn.done)
+
+	m := &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "late", Sender: "alice:1", Recipient: "someID:1", Ciphertext: []byte("hello")}
+	require.Nil(t, c.memory.acquire(int64(len(m.Ciphertext)), nil, nil))
+	p.dispatch(m)
+
+	p.stop()
+	p.wg.Wait()
+
+	received, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "late", received.Id)
+	assert.Equal(t, int64(0), c.memory.inUse())
+}
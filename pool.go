@@ -0,0 +1,63 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// maxPooledBuffer is the largest buffer returned to a pool. Larger buffers
+// are left for the garbage collector so one large message doesn't pin memory
+const maxPooledBuffer = 64 * 1024
+
+var marshalBuffers = sync.Pool{
+	New: func() interface{} {
+		return proto.NewBuffer(make([]byte, 0, 1024))
+	},
+}
+
+var readBuffers = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, 1024))
+	},
+}
+
+// marshal encodes a message into a pooled buffer. The buffer should be
+// released with releaseMarshalBuffer once its contents have been written
+func marshal(m proto.Message) (*proto.Buffer, error) {
+	b := marshalBuffers.Get().(*proto.Buffer)
+	b.Reset()
+
+	err := b.Marshal(m)
+	if err != nil {
+		releaseMarshalBuffer(b)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func releaseMarshalBuffer(b *proto.Buffer) {
+	if b == nil || cap(b.Bytes()) > maxPooledBuffer {
+		return
+	}
+
+	marshalBuffers.Put(b)
+}
+
+func getReadBuffer() *bytes.Buffer {
+	b := readBuffers.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func releaseReadBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+
+	readBuffers.Put(b)
+}
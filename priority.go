@@ -0,0 +1,47 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// MaxPriority is the highest priority a connection or message can ask for.
+// Priorities range from 1, for batch senders, to MaxPriority, for
+// interactive clients. Zero leaves the priority to the server
+const MaxPriority = 9
+
+// ErrInvalidPriority is returned for priorities outside 0 to MaxPriority
+var ErrInvalidPriority = errors.New("priority must be between 0 and 9")
+
+// SetPriority sets the priority hint of a message, overriding the priority
+// of the connection it is sent on. Servers that do not support the
+// priorities capability ignore it
+func SetPriority(m *msgproto.Message, priority int) error {
+	if priority < 0 || priority > MaxPriority {
+		return ErrInvalidPriority
+	}
+
+	m.Priority = uint32(priority)
+
+	return nil
+}
+
+// PriorityOf returns the priority hint of a message, or zero if it has none
+func PriorityOf(m *msgproto.Message) int {
+	if m.Priority > MaxPriority {
+		return 0
+	}
+
+	return int(m.Priority)
+}
+
+// withPriority adds the connection's priority to an authentication
+// request if the server supports priorities
+func (c *Client) withPriority(auth *msgproto.Auth) {
+	if c.Supports(CapabilityPriorities) {
+		auth.Priority = uint32(c.priority)
+	}
+}
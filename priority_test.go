@@ -0,0 +1,75 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPriority(t *testing.T) {
+	m := &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1"}
+
+	assert.Equal(t, 0, PriorityOf(m))
+
+	require.Nil(t, SetPriority(m, 7))
+	assert.Equal(t, 7, PriorityOf(m))
+
+	assert.Equal(t, ErrInvalidPriority, SetPriority(m, MaxPriority+1))
+	assert.Equal(t, ErrInvalidPriority, SetPriority(m, -1))
+
+	data, err := proto.Marshal(m)
+	require.Nil(t, err)
+
+	var decoded msgproto.Message
+
+	require.Nil(t, proto.Unmarshal(data, &decoded))
+	assert.Equal(t, 7, PriorityOf(&decoded))
+
+	require.Nil(t, SetPriority(m, 0))
+	assert.Equal(t, 0, PriorityOf(m))
+}
+
+func TestClientPriority(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	s.header = http.Header{CapabilitiesHeader: []string{CapabilityAcks + "," + CapabilityPriorities}}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, Priority(8))
+	require.Nil(t, err)
+	defer c.Close()
+
+	m := &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte("hello")}
+	require.Nil(t, SetPriority(m, 2))
+
+	require.Nil(t, c.Send(m))
+
+	rm, err := wait(s.in)
+	require.Nil(t, err)
+	assert.Equal(t, 2, PriorityOf(rm))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assert.Equal(t, []int{8}, s.priority)
+}
+
+func TestClientPriorityUnsupported(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, Priority(8))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assert.Equal(t, []int{0}, s.priority)
+}
@@ -23,11 +23,14 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type Auth struct {
-	Type                 MsgType  `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
-	Id                   string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
-	Token                string   `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
-	Device               string   `protobuf:"bytes,4,opt,name=device,proto3" json:"device,omitempty"`
-	Offset               uint64   `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	Type   MsgType `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
+	Id     string  `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Token  string  `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	Device string  `protobuf:"bytes,4,opt,name=device,proto3" json:"device,omitempty"`
+	Offset uint64  `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	// priority of the connection, from 1 to 9, for servers that support
+	// the priorities capability
+	Priority             uint32   `protobuf:"varint,6,opt,name=priority,proto3" json:"priority,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -93,6 +96,13 @@ func (m *Auth) GetOffset() uint64 {
 	return 0
 }
 
+func (m *Auth) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Auth)(nil), "msgproto.Auth")
 }
@@ -100,17 +110,18 @@ func init() {
 func init() { proto.RegisterFile("auth.proto", fileDescriptor_8bbd6f3875b0e874) }
 
 var fileDescriptor_8bbd6f3875b0e874 = []byte{
-	// 181 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4a, 0x2c, 0x2d, 0xc9,
-	0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xc8, 0x2d, 0x4e, 0x07, 0xb3, 0xa4, 0x78, 0x73,
-	0x8b, 0xd3, 0x4b, 0x2a, 0x0b, 0x52, 0x21, 0x12, 0x4a, 0xcd, 0x8c, 0x5c, 0x2c, 0x8e, 0xa5, 0x25,
-	0x19, 0x42, 0xaa, 0x5c, 0x2c, 0x20, 0x61, 0x09, 0x46, 0x05, 0x46, 0x0d, 0x3e, 0x23, 0x41, 0x3d,
-	0x98, 0x06, 0x3d, 0xdf, 0xe2, 0xf4, 0x90, 0xca, 0x82, 0xd4, 0x20, 0xb0, 0xb4, 0x10, 0x1f, 0x17,
-	0x53, 0x66, 0x8a, 0x04, 0x93, 0x02, 0xa3, 0x06, 0x67, 0x10, 0x53, 0x66, 0x8a, 0x90, 0x08, 0x17,
-	0x6b, 0x49, 0x7e, 0x76, 0x6a, 0x9e, 0x04, 0x33, 0x58, 0x08, 0xc2, 0x11, 0x12, 0xe3, 0x62, 0x4b,
-	0x49, 0x2d, 0xcb, 0x4c, 0x4e, 0x95, 0x60, 0x01, 0x0b, 0x43, 0x79, 0x20, 0xf1, 0xfc, 0xb4, 0xb4,
-	0xe2, 0xd4, 0x12, 0x09, 0x56, 0x05, 0x46, 0x0d, 0x96, 0x20, 0x28, 0xcf, 0x49, 0x92, 0x4b, 0x3c,
-	0x2f, 0xb5, 0x44, 0xaf, 0x38, 0x35, 0x27, 0x2d, 0x33, 0x45, 0x2f, 0xb1, 0xa0, 0x00, 0xe2, 0xba,
-	0xe4, 0xfc, 0x9c, 0x24, 0x36, 0x30, 0xcb, 0x18, 0x10, 0x00, 0x00, 0xff, 0xff, 0x1d, 0x5c, 0x04,
-	0x68, 0xce, 0x00, 0x00, 0x00,
+	// 195 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x8e, 0xc1, 0x4a, 0xc4, 0x30,
+	0x10, 0x86, 0x49, 0xcd, 0x96, 0x75, 0x60, 0x17, 0x0c, 0xa2, 0x71, 0x4f, 0x41, 0x10, 0x72, 0xca,
+	0x41, 0x9f, 0x40, 0xef, 0x5e, 0x82, 0x2f, 0x50, 0x9b, 0x69, 0x1b, 0x6c, 0x9b, 0xd0, 0x4c, 0x85,
+	0x3e, 0x8d, 0xaf, 0x2a, 0x4d, 0xab, 0xb7, 0xff, 0xfb, 0x3e, 0x06, 0x06, 0xa0, 0x9a, 0xa9, 0x33,
+	0x71, 0x0a, 0x14, 0xc4, 0x71, 0x48, 0x6d, 0x5e, 0x97, 0xd3, 0x90, 0x5a, 0x5a, 0x22, 0x6e, 0xe1,
+	0xf1, 0x87, 0x01, 0x7f, 0x9d, 0xa9, 0x13, 0x4f, 0xc0, 0x57, 0x2d, 0x99, 0x62, 0xfa, 0xfc, 0x7c,
+	0x63, 0xfe, 0x0e, 0xcc, 0x7b, 0x6a, 0x3f, 0x96, 0x88, 0x36, 0x67, 0x71, 0x86, 0xc2, 0x3b, 0x59,
+	0x28, 0xa6, 0xaf, 0x6d, 0xe1, 0x9d, 0xb8, 0x85, 0x03, 0x85, 0x2f, 0x1c, 0xe5, 0x55, 0x56, 0x1b,
+	0x88, 0x3b, 0x28, 0x1d, 0x7e, 0xfb, 0x1a, 0x25, 0xcf, 0x7a, 0xa7, 0xd5, 0x87, 0xa6, 0x49, 0x48,
+	0xf2, 0xa0, 0x98, 0xe6, 0x76, 0x27, 0x71, 0x81, 0x63, 0x9c, 0x7c, 0x98, 0x3c, 0x2d, 0xb2, 0x54,
+	0x4c, 0x9f, 0xec, 0x3f, 0xbf, 0x3d, 0xc0, 0xfd, 0x88, 0x64, 0x12, 0xf6, 0x8d, 0x77, 0xa6, 0x8a,
+	0x71, 0xfb, 0xbc, 0x0e, 0xfd, 0x67, 0x99, 0xd7, 0xcb, 0xef, 0x00, 0x4b, 0xfb, 0xe2, 0xe4, 0xea,
+	0x00, 0x00, 0x00,
 }
@@ -24,16 +24,18 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type Message struct {
-	Type                 MsgType              `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
-	Id                   string               `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
-	Sender               string               `protobuf:"bytes,3,opt,name=sender,proto3" json:"sender,omitempty"`
-	Recipient            string               `protobuf:"bytes,4,opt,name=recipient,proto3" json:"recipient,omitempty"`
-	Ciphertext           []byte               `protobuf:"bytes,5,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
-	Timestamp            *timestamp.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Offset               int64                `protobuf:"varint,7,opt,name=offset,proto3" json:"offset,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
-	XXX_unrecognized     []byte               `json:"-"`
-	XXX_sizecache        int32                `json:"-"`
+	Type       MsgType              `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
+	Id         string               `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Sender     string               `protobuf:"bytes,3,opt,name=sender,proto3" json:"sender,omitempty"`
+	Recipient  string               `protobuf:"bytes,4,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Ciphertext []byte               `protobuf:"bytes,5,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	Timestamp  *timestamp.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Offset     int64                `protobuf:"varint,7,opt,name=offset,proto3" json:"offset,omitempty"`
+	// priority of the message, overriding the priority of the connection
+	Priority             uint32   `protobuf:"varint,8,opt,name=priority,proto3" json:"priority,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -110,6 +112,13 @@ func (m *Message) GetOffset() int64 {
 	return 0
 }
 
+func (m *Message) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Message)(nil), "msgproto.Message")
 }
@@ -117,21 +126,22 @@ func init() {
 func init() { proto.RegisterFile("message.proto", fileDescriptor_33c57e4bae7b9afd) }
 
 var fileDescriptor_33c57e4bae7b9afd = []byte{
-	// 250 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x50, 0x4f, 0x4b, 0xfb, 0x30,
-	0x18, 0x26, 0xdd, 0x7e, 0xdd, 0xaf, 0xaf, 0x6e, 0x60, 0x0e, 0x1a, 0x8b, 0x68, 0x10, 0x84, 0x9c,
-	0x32, 0x98, 0x17, 0xcf, 0xde, 0x77, 0x09, 0xfb, 0x02, 0x5d, 0xfb, 0x36, 0x06, 0x9a, 0x26, 0x34,
-	0x11, 0xdc, 0x37, 0xf6, 0x63, 0xc8, 0xd2, 0xd6, 0x79, 0x7b, 0x9f, 0x3f, 0x79, 0x78, 0x9e, 0xc0,
-	0xda, 0x62, 0x08, 0x95, 0x46, 0xe9, 0x07, 0x17, 0x1d, 0xfd, 0x6f, 0x83, 0x4e, 0x57, 0xb9, 0xb6,
-	0x41, 0xc7, 0x93, 0x9f, 0x84, 0xf2, 0x49, 0x3b, 0xa7, 0x3b, 0xdc, 0x26, 0x74, 0xfc, 0x6c, 0xb7,
-	0xd1, 0x58, 0x0c, 0xb1, 0xb2, 0x7e, 0x34, 0x3c, 0x7f, 0x13, 0x58, 0xed, 0xc7, 0x2c, 0xfa, 0x02,
-	0xcb, 0xf3, 0x53, 0x46, 0x38, 0x11, 0x9b, 0xdd, 0x8d, 0x9c, 0x43, 0xe5, 0x3e, 0xe8, 0xc3, 0xc9,
-	0xa3, 0x4a, 0x32, 0xdd, 0x40, 0x66, 0x1a, 0x96, 0x71, 0x22, 0x0a, 0x95, 0x99, 0x86, 0xde, 0x42,
-	0x1e, 0xb0, 0x6f, 0x70, 0x60, 0x8b, 0xc4, 0x4d, 0x88, 0x3e, 0x40, 0x31, 0x60, 0x6d, 0xbc, 0xc1,
-	0x3e, 0xb2, 0x65, 0x92, 0x2e, 0x04, 0x7d, 0x04, 0xa8, 0x8d, 0xff, 0xc0, 0x21, 0xe2, 0x57, 0x64,
-	0xff, 0x38, 0x11, 0xd7, 0xea, 0x0f, 0x43, 0xdf, 0xa0, 0xf8, 0xed, 0xca, 0x72, 0x4e, 0xc4, 0xd5,
-	0xae, 0x94, 0xe3, 0x1a, 0x39, 0xaf, 0x91, 0x87, 0xd9, 0xa1, 0x2e, 0xe6, 0x73, 0x1f, 0xd7, 0xb6,
-	0x01, 0x23, 0x5b, 0x71, 0x22, 0x16, 0x6a, 0x42, 0xef, 0xf7, 0x70, 0xd7, 0x63, 0x94, 0x01, 0xbb,
-	0xd6, 0x34, 0xb2, 0xf2, 0xd3, 0x17, 0xd4, 0xae, 0x3b, 0xe6, 0xe9, 0x7a, 0xfd, 0x09, 0x00, 0x00,
-	0xff, 0xff, 0xee, 0x96, 0x39, 0x59, 0x57, 0x01, 0x00, 0x00,
+	// 263 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xb1, 0x6a, 0xf3, 0x30,
+	0x14, 0x85, 0x91, 0x93, 0xdf, 0xb1, 0xf5, 0xd7, 0x81, 0x6a, 0x68, 0x55, 0x53, 0x5a, 0x51, 0x28,
+	0x68, 0x52, 0x20, 0x5d, 0x3a, 0x77, 0xcf, 0x22, 0xf2, 0x02, 0x8e, 0x7d, 0xad, 0x0a, 0x6c, 0x4b,
+	0x48, 0x2a, 0xd4, 0x7b, 0x1f, 0xbc, 0x44, 0xb6, 0x93, 0x6e, 0xf7, 0x3b, 0xe7, 0xe8, 0x72, 0x8f,
+	0x70, 0xd1, 0x83, 0xf7, 0x95, 0x02, 0x61, 0x9d, 0x09, 0x86, 0x64, 0xbd, 0x57, 0x71, 0x2a, 0x8b,
+	0xde, 0xab, 0x30, 0xda, 0xd9, 0x28, 0x9f, 0x95, 0x31, 0xaa, 0x83, 0x5d, 0xa4, 0xd3, 0x57, 0xbb,
+	0x0b, 0xba, 0x07, 0x1f, 0xaa, 0xde, 0x4e, 0x81, 0x97, 0x9f, 0x04, 0x6f, 0x0e, 0xd3, 0x2e, 0xf2,
+	0x8a, 0xd7, 0xe7, 0xa7, 0x14, 0x31, 0xc4, 0xb7, 0xfb, 0x5b, 0xb1, 0x2c, 0x15, 0x07, 0xaf, 0x8e,
+	0xa3, 0x05, 0x19, 0x6d, 0xb2, 0xc5, 0x89, 0x6e, 0x68, 0xc2, 0x10, 0xcf, 0x65, 0xa2, 0x1b, 0x72,
+	0x87, 0x53, 0x0f, 0x43, 0x03, 0x8e, 0xae, 0xa2, 0x36, 0x13, 0x79, 0xc4, 0xb9, 0x83, 0x5a, 0x5b,
+	0x0d, 0x43, 0xa0, 0xeb, 0x68, 0x5d, 0x05, 0xf2, 0x84, 0x71, 0xad, 0xed, 0x27, 0xb8, 0x00, 0xdf,
+	0x81, 0xfe, 0x63, 0x88, 0xdf, 0xc8, 0x3f, 0x0a, 0x79, 0xc7, 0xf9, 0xe5, 0x56, 0x9a, 0x32, 0xc4,
+	0xff, 0xef, 0x4b, 0x31, 0xb5, 0x11, 0x4b, 0x1b, 0x71, 0x5c, 0x12, 0xf2, 0x1a, 0x3e, 0xdf, 0x63,
+	0xda, 0xd6, 0x43, 0xa0, 0x1b, 0x86, 0xf8, 0x4a, 0xce, 0x44, 0x4a, 0x9c, 0x59, 0xa7, 0x8d, 0xd3,
+	0x61, 0xa4, 0x19, 0x43, 0xbc, 0x90, 0x17, 0xfe, 0x78, 0xc0, 0xf7, 0x03, 0x04, 0xe1, 0xa1, 0x6b,
+	0x75, 0x23, 0x2a, 0x3b, 0x7f, 0x4f, 0x6d, 0xba, 0x53, 0x1a, 0xa7, 0xb7, 0xdf, 0x01, 0x00, 0x26,
+	0xb6, 0xe8, 0x89, 0x73, 0x01, 0x00, 0x00,
 }
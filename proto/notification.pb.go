@@ -23,10 +23,13 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type Notification struct {
-	Type                 MsgType  `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
-	Id                   string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
-	Error                string   `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
-	Errtype              ErrType  `protobuf:"varint,4,opt,name=errtype,proto3,enum=msgproto.ErrType" json:"errtype,omitempty"`
+	Type    MsgType `protobuf:"varint,1,opt,name=type,proto3,enum=msgproto.MsgType" json:"type,omitempty"`
+	Id      string  `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Error   string  `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Errtype ErrType `protobuf:"varint,4,opt,name=errtype,proto3,enum=msgproto.ErrType" json:"errtype,omitempty"`
+	// resume_token is sent with an authentication ACK by servers that
+	// support the resume capability
+	ResumeToken          string   `protobuf:"bytes,5,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -85,6 +88,13 @@ func (m *Notification) GetErrtype() ErrType {
 	return ErrType_ErrConnection
 }
 
+func (m *Notification) GetResumeToken() string {
+	if m != nil {
+		return m.ResumeToken
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Notification)(nil), "msgproto.Notification")
 }
@@ -92,17 +102,18 @@ func init() {
 func init() { proto.RegisterFile("notification.proto", fileDescriptor_736a457d4a5efa07) }
 
 var fileDescriptor_736a457d4a5efa07 = []byte{
-	// 179 bytes of a gzipped FileDescriptorProto
+	// 202 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0xca, 0xcb, 0x2f, 0xc9,
 	0x4c, 0xcb, 0x4c, 0x4e, 0x2c, 0xc9, 0xcc, 0xcf, 0xd3, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2,
 	0xc8, 0x2d, 0x4e, 0x07, 0xb3, 0xa4, 0x78, 0x73, 0x8b, 0xd3, 0x4b, 0x2a, 0x0b, 0x52, 0xf5, 0xa0,
-	0xdc, 0xd4, 0xa2, 0x22, 0x04, 0x57, 0xa9, 0x83, 0x91, 0x8b, 0xc7, 0x0f, 0x49, 0xbb, 0x90, 0x2a,
-	0x17, 0x0b, 0x48, 0x5a, 0x82, 0x51, 0x81, 0x51, 0x83, 0xcf, 0x48, 0x50, 0x0f, 0x66, 0x8e, 0x9e,
-	0x6f, 0x71, 0x7a, 0x48, 0x65, 0x41, 0x6a, 0x10, 0x58, 0x5a, 0x88, 0x8f, 0x8b, 0x29, 0x33, 0x45,
-	0x82, 0x49, 0x81, 0x51, 0x83, 0x33, 0x88, 0x29, 0x33, 0x45, 0x48, 0x84, 0x8b, 0x35, 0xb5, 0xa8,
-	0x28, 0xbf, 0x48, 0x82, 0x19, 0x2c, 0x04, 0xe1, 0x08, 0x69, 0x73, 0xb1, 0x43, 0xad, 0x93, 0x60,
-	0x41, 0x37, 0xcf, 0xb5, 0xa8, 0x08, 0x6c, 0x1e, 0x4c, 0x85, 0x93, 0x24, 0x97, 0x78, 0x5e, 0x6a,
-	0x89, 0x5e, 0x71, 0x6a, 0x4e, 0x5a, 0x66, 0x8a, 0x5e, 0x62, 0x41, 0x01, 0xc4, 0x89, 0xc9, 0xf9,
-	0x39, 0x49, 0x6c, 0x60, 0x96, 0x31, 0x20, 0x00, 0x00, 0xff, 0xff, 0xd7, 0x19, 0x38, 0x81, 0xea,
-	0x00, 0x00, 0x00,
+	0xdc, 0xd4, 0xa2, 0x22, 0x04, 0x57, 0x69, 0x35, 0x23, 0x17, 0x8f, 0x1f, 0x92, 0x76, 0x21, 0x55,
+	0x2e, 0x16, 0x90, 0xb4, 0x04, 0xa3, 0x02, 0xa3, 0x06, 0x9f, 0x91, 0xa0, 0x1e, 0xcc, 0x1c, 0x3d,
+	0xdf, 0xe2, 0xf4, 0x90, 0xca, 0x82, 0xd4, 0x20, 0xb0, 0xb4, 0x10, 0x1f, 0x17, 0x53, 0x66, 0x8a,
+	0x04, 0x93, 0x02, 0xa3, 0x06, 0x67, 0x10, 0x53, 0x66, 0x8a, 0x90, 0x08, 0x17, 0x6b, 0x6a, 0x51,
+	0x51, 0x7e, 0x91, 0x04, 0x33, 0x58, 0x08, 0xc2, 0x11, 0xd2, 0xe6, 0x62, 0x87, 0x5a, 0x27, 0xc1,
+	0x82, 0x6e, 0x9e, 0x6b, 0x51, 0x11, 0xd8, 0x3c, 0x98, 0x0a, 0x21, 0x45, 0x2e, 0x9e, 0xa2, 0xd4,
+	0xe2, 0xd2, 0xdc, 0xd4, 0xf8, 0x92, 0xfc, 0xec, 0xd4, 0x3c, 0x09, 0x56, 0xb0, 0x49, 0xdc, 0x10,
+	0xb1, 0x10, 0x90, 0x90, 0x93, 0x24, 0x97, 0x78, 0x5e, 0x6a, 0x89, 0x5e, 0x71, 0x6a, 0x4e, 0x5a,
+	0x66, 0x8a, 0x5e, 0x62, 0x41, 0x01, 0xc4, 0x17, 0xc9, 0xf9, 0x39, 0x49, 0x6c, 0x60, 0x96, 0x31,
+	0x60, 0x00, 0xbd, 0xd7, 0xd9, 0xb0, 0x0d, 0x01, 0x00, 0x00,
 }
@@ -0,0 +1,176 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TopicPrefix prefixes the typ claim of messages published to a topic
+const TopicPrefix = "topic."
+
+// TopicHandler handles a verified message published to a topic
+type TopicHandler func(claims *Claims, data json.RawMessage)
+
+// Subscription is a handler subscribed to a topic. Messages are delivered
+// to the handler in order, and are dropped if it falls too far behind
+type Subscription struct {
+	topic   string
+	handler TopicHandler
+	queue   chan *topicMessage
+	done    chan struct{}
+	once    sync.Once
+	client  *Client
+}
+
+type topicMessage struct {
+	claims *Claims
+	data   json.RawMessage
+}
+
+// subscriptions tracks the client's subscriptions by topic
+type subscriptions struct {
+	topics map[string][]*Subscription
+	mu     sync.RWMutex
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{topics: make(map[string][]*Subscription)}
+}
+
+// Publish signs data and sends it on a topic to each of the recipients,
+// formatted as selfID:deviceID. Each recipient is sent its own message
+func (c *Client) Publish(topic string, recipients []string, data interface{}) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	now := c.serverNow()
+
+	payload, err := c.Sign(map[string]interface{}{
+		"typ":  TopicPrefix + topic,
+		"jti":  c.NewID(),
+		"iss":  c.selfID,
+		"iat":  now.Unix(),
+		"data": json.RawMessage(encoded),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipients {
+		err = c.Send(c.RequestMessage(r, payload))
+		if err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", r, err)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe registers a handler for messages published to a topic. Messages
+// on a topic without subscribers are delivered to Receive as normal
+func (c *Client) Subscribe(topic string, handler TopicHandler) *Subscription {
+	s := &Subscription{
+		topic:   topic,
+		handler: handler,
+		queue:   make(chan *topicMessage, DefaultBufferSize),
+		done:    make(chan struct{}),
+		client:  c,
+	}
+
+	c.subscriptions.mu.Lock()
+	c.subscriptions.topics[topic] = append(c.subscriptions.topics[topic], s)
+	c.subscriptions.mu.Unlock()
+
+	go s.run()
+
+	return s
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case m := <-s.queue:
+			s.handler(m.claims, m.data)
+		}
+	}
+}
+
+// Unsubscribe stops delivering messages to the subscription's handler
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		subs := s.client.subscriptions
+
+		subs.mu.Lock()
+		// deliverTopic ranges over the list without the lock held, so it
+		// is replaced rather than modified
+		var list []*Subscription
+		for _, other := range subs.topics[s.topic] {
+			if other != s {
+				list = append(list, other)
+			}
+		}
+
+		if len(list) == 0 {
+			delete(subs.topics, s.topic)
+		} else {
+			subs.topics[s.topic] = list
+		}
+		subs.mu.Unlock()
+
+		close(s.done)
+	})
+}
+
+// deliverTopic fans a received topic message out to its subscribers. It
+// returns false if the message is not on a topic with subscribers
+func (c *Client) deliverTopic(in *inbound) bool {
+	if in.env == nil || !strings.HasPrefix(in.env.typ, TopicPrefix) {
+		return false
+	}
+
+	topic := strings.TrimPrefix(in.env.typ, TopicPrefix)
+
+	c.subscriptions.mu.RLock()
+	subs := c.subscriptions.topics[topic]
+	c.subscriptions.mu.RUnlock()
+
+	if len(subs) == 0 {
+		return false
+	}
+
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	claims, err := c.DecodePayload(in.msg, &body)
+	if err != nil {
+		c.dropped(in.msg.Id, fmt.Errorf("dropped message on topic %s: %w", topic, err))
+		return true
+	}
+
+	m := &topicMessage{claims: claims, data: body.Data}
+
+	for _, s := range subs {
+		select {
+		case s.queue <- m:
+		case <-s.done:
+		default:
+			c.dropped(in.msg.Id, fmt.Errorf("dropped message on topic %s: subscriber is full", topic))
+		}
+	}
+
+	return true
+}
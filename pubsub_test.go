@@ -0,0 +1,104 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"encoding/json"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPublish(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+
+	recipients := make(chan string, 2)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			m := <-s.in
+
+			env, err := parseEnvelope(m.Ciphertext)
+			require.Nil(t, err)
+			assert.Equal(t, TopicPrefix+"prices", env.typ)
+
+			recipients <- m.Recipient
+		}
+	}()
+
+	err = c.Publish("prices", []string{"a:1", "b:1"}, map[string]int{"gbp": 1})
+	require.Nil(t, err)
+
+	assert.ElementsMatch(t, []string{"a:1", "b:1"}, []string{<-recipients, <-recipients})
+}
+
+func TestClientSubscribe(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	first := make(chan string, 1)
+	second := make(chan string, 1)
+
+	sub := c.Subscribe("prices", func(claims *Claims, data json.RawMessage) {
+		first <- string(data)
+	})
+
+	c.Subscribe("prices", func(claims *Claims, data json.RawMessage) {
+		assert.Equal(t, "test", claims.Issuer)
+		second <- string(data)
+	})
+
+	payload := testSignedPayload(privkey, map[string]interface{}{
+		"jti":  "1",
+		"typ":  TopicPrefix + "prices",
+		"iss":  "test",
+		"data": map[string]int{"gbp": 1},
+	})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+
+	for _, ch := range []chan string{first, second} {
+		select {
+		case data := <-ch:
+			assert.Equal(t, `{"gbp":1}`, data)
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered to subscriber")
+		}
+	}
+
+	c.subscriptions.mu.RLock()
+	delivering := c.subscriptions.topics["prices"]
+	c.subscriptions.mu.RUnlock()
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	// a list of subscribers being delivered to is not modified
+	assert.Equal(t, sub, delivering[0])
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+
+	<-second
+	assert.Len(t, first, 0)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "3", Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte(`{"payload": "eyJ0eXAiOiJ0b3BpYy5vdGhlciJ9"}`)}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "3", m.Id)
+}
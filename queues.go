@@ -0,0 +1,127 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// ErrInboundQueueFull is reported when a message is dropped because its inbound queue is full
+var ErrInboundQueueFull = errors.New("inbound queue is full")
+
+// inboundQueue receives messages whose payload type matches one of its types
+type inboundQueue struct {
+	name  string
+	types []string
+	ch    chan *msgproto.Message
+	held  *heldMessages // skipped by ReceiveOfType
+}
+
+// matches returns true if a payload type matches one of the queue's types.
+// Types ending in * match any payload type with the same prefix
+func (q *inboundQueue) matches(typ string) bool {
+	for _, t := range q.types {
+		if strings.HasSuffix(t, "*") {
+			if strings.HasPrefix(typ, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		} else if t == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queueFor returns the queue a received message is delivered to. Messages
+// that match no queue are delivered to the receive queue
+func (c *Client) queueFor(in *inbound) chan *msgproto.Message {
+	if in.env == nil {
+		return c.recv
+	}
+
+	if q := c.queueOfType(in.env.typ); q != nil {
+		return q.ch
+	}
+
+	return c.recv
+}
+
+// queueOfType returns the inbound queue a payload type is delivered to, or
+// nil if it is delivered to the receive queue
+func (c *Client) queueOfType(typ string) *inboundQueue {
+	for _, q := range c.inboundQueues {
+		if q.matches(typ) {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// enqueueInbound delivers a received message to its queue. With a single
+// queue it waits for room, which stops reading from the connection. With
+// inbound queues, a full queue drops the message instead so it can't delay
+// messages for the other queues. It returns true if the message was queued,
+// in which case its bytes are held in the memory budget until it is taken
+func (c *Client) enqueueInbound(in *inbound) bool {
+	ch := c.queueFor(in)
+
+	if len(c.inboundQueues) == 0 {
+		ch <- in.msg
+		c.memory.hold(ch, in.size)
+		c.checkReceiveQueue()
+		return true
+	}
+
+	select {
+	case ch <- in.msg:
+		c.memory.hold(ch, in.size)
+		c.checkReceiveQueue()
+		return true
+	default:
+		c.dropped(in.msg.Id, fmt.Errorf("dropped message %s: %w", in.msg.Id, ErrInboundQueueFull))
+		return false
+	}
+}
+
+// queue returns a named inbound queue
+func (c *Client) queue(name string) *inboundQueue {
+	for _, q := range c.inboundQueues {
+		if q.name == name {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// ReceiveQueue receives the next message from a queue added with the InboundQueue option
+func (c *Client) ReceiveQueue(name string) (*msgproto.Message, error) {
+	q := c.queue(name)
+	if q == nil {
+		return nil, errors.New("unknown inbound queue " + name)
+	}
+
+	m := q.held.take(func(*msgproto.Message) bool { return true })
+	if m != nil {
+		return m, nil
+	}
+
+	return c.receiveFrom(q.ch)
+}
+
+// QueueChan returns the channel of a queue added with the InboundQueue
+// option, or nil if there is no queue with the name
+func (c *Client) QueueChan(name string) chan *msgproto.Message {
+	q := c.queue(name)
+	if q == nil {
+		return nil
+	}
+
+	return q.ch
+}
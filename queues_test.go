@@ -0,0 +1,76 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientInboundQueue(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InboundQueue("control", 1, "identities.*", "ping"))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Nil(t, c.QueueChan("unknown"))
+	_, err = c.ReceiveQueue("unknown")
+	assert.NotNil(t, err)
+
+	control, err := c.Sign(map[string]interface{}{"typ": "identities.authenticate.resp"})
+	require.Nil(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("bulk")}
+	}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "control", Sender: "test:1", Recipient: "someID:1", Ciphertext: control}
+
+	m, err := c.ReceiveQueue("control")
+	require.Nil(t, err)
+	assert.Equal(t, "control", m.Id)
+
+	for _, id := range []string{"1", "2", "3"} {
+		m, err = c.Receive()
+		require.Nil(t, err)
+		assert.Equal(t, id, m.Id)
+	}
+}
+
+func TestClientInboundQueueFull(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InboundQueue("bulk", 1, "bulk"), InboundWorkers(1))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	bulk, err := c.Sign(map[string]interface{}{"typ": "bulk"})
+	require.Nil(t, err)
+
+	for _, id := range []string{"1", "2"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "test:1", Recipient: "someID:1", Ciphertext: bulk}
+	}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "other", Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hello")}
+
+	// the full bulk queue does not hold up other messages from the same worker
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "other", m.Id)
+
+	e, ok := nextEvent(t, c).(MessageDropped)
+	require.True(t, ok)
+	assert.Equal(t, "2", e.ID)
+	assert.True(t, errors.Is(e.Err, ErrInboundQueueFull))
+
+	m, err = c.ReceiveQueue("bulk")
+	require.Nil(t, err)
+	assert.Equal(t, "1", m.Id)
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// LimitAction is what to do with a message that exceeds its sender's rate limit
+type LimitAction int
+
+const (
+	// DropMessage discards the message
+	DropMessage LimitAction = iota
+	// DeferMessage delivers the message once the sender's interval has passed
+	DeferMessage
+)
+
+// maxDeferred is the number of messages that can be deferred for each sender
+const maxDeferred = DefaultBufferSize
+
+// ErrTooManyDeferred is reported for messages dropped because too many
+// messages from their sender are already deferred
+var ErrTooManyDeferred = errors.New("too many messages deferred for sender")
+
+// senderLimiter counts the messages received from each sender in fixed intervals
+type senderLimiter struct {
+	limit    int
+	interval time.Duration
+	onLimit  func(m *msgproto.Message) LimitAction
+	windows  map[string]*senderWindow
+	deferred map[int]*deferrals
+	swept    time.Time
+	mu       sync.Mutex
+}
+
+// deferrals are the deferred messages from the senders a pipeline worker serves
+type deferrals struct {
+	messages []deferredMessage
+	senders  map[string]int
+}
+
+type deferredMessage struct {
+	msg *msgproto.Message
+	due time.Time
+}
+
+type senderWindow struct {
+	start time.Time
+	count int
+}
+
+func newSenderLimiter(limit int, interval time.Duration, onLimit func(m *msgproto.Message) LimitAction) *senderLimiter {
+	return &senderLimiter{
+		limit:    limit,
+		interval: interval,
+		onLimit:  onLimit,
+		windows:  make(map[string]*senderWindow),
+		deferred: make(map[int]*deferrals),
+	}
+}
+
+// allow counts a message from sender, returning how long to wait until the
+// sender's next interval if the limit has been reached
+func (l *senderLimiter) allow(sender string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// forget senders whose interval has passed
+	if now.Sub(l.swept) >= l.interval {
+		for s, w := range l.windows {
+			if now.Sub(w.start) >= l.interval {
+				delete(l.windows, s)
+			}
+		}
+		l.swept = now
+	}
+
+	w, ok := l.windows[sender]
+	if !ok || now.Sub(w.start) >= l.interval {
+		w = &senderWindow{start: now}
+		l.windows[sender] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.start.Add(l.interval).Sub(now)
+	}
+
+	w.count++
+
+	return true, 0
+}
+
+// hold defers a message for a worker until it is due, returning false if
+// too many messages from its sender are already deferred
+func (l *senderLimiter) hold(worker int, m *msgproto.Message, due time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.deferred[worker]
+	if !ok {
+		d = &deferrals{senders: make(map[string]int)}
+		l.deferred[worker] = d
+	}
+
+	if d.senders[m.Sender] >= maxDeferred {
+		return false
+	}
+
+	d.senders[m.Sender]++
+	d.messages = append(d.messages, deferredMessage{msg: m, due: due})
+
+	return true
+}
+
+// next returns when the first of a worker's deferred messages is due
+func (l *senderLimiter) next(worker int) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.deferred[worker]
+	if !ok || len(d.messages) == 0 {
+		return time.Time{}, false
+	}
+
+	next := d.messages[0].due
+	for _, dm := range d.messages[1:] {
+		if dm.due.Before(next) {
+			next = dm.due
+		}
+	}
+
+	return next, true
+}
+
+// due removes and returns a worker's deferred messages that are due, in
+// the order they were deferred
+func (l *senderLimiter) due(worker int, now time.Time) []*msgproto.Message {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.deferred[worker]
+	if !ok {
+		return nil
+	}
+
+	var due []*msgproto.Message
+
+	remaining := d.messages[:0]
+	for _, dm := range d.messages {
+		if dm.due.After(now) {
+			remaining = append(remaining, dm)
+			continue
+		}
+
+		due = append(due, dm.msg)
+
+		d.senders[dm.msg.Sender]--
+		if d.senders[dm.msg.Sender] == 0 {
+			delete(d.senders, dm.msg.Sender)
+		}
+	}
+
+	d.messages = remaining
+
+	return due
+}
+
+// drain removes and returns every deferred message
+func (l *senderLimiter) drain() []*msgproto.Message {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var messages []*msgproto.Message
+
+	for worker, d := range l.deferred {
+		for _, dm := range d.messages {
+			messages = append(messages, dm.msg)
+		}
+		delete(l.deferred, worker)
+	}
+
+	return messages
+}
+
+// limitSender returns true if a received message exceeds its sender's rate
+// limit and has been dropped or deferred. Deferred messages are held for
+// the pipeline worker that serves their sender, which delivers them once
+// they are due, and their bytes stay reserved from the memory budget until
+// then
+func (c *Client) limitSender(m *msgproto.Message) (limited bool, deferred bool) {
+	if c.senderLimits == nil {
+		return false, false
+	}
+
+	now := c.clock.Now()
+
+	ok, wait := c.senderLimits.allow(selfIDOf(m.Sender), now)
+	if ok {
+		return false, false
+	}
+
+	action := DropMessage
+	if c.senderLimits.onLimit != nil {
+		action = c.senderLimits.onLimit(m)
+	}
+
+	if action != DeferMessage {
+		c.dropped(m.Id, nil)
+		return true, false
+	}
+
+	if !c.senderLimits.hold(workerFor(m.Sender, c.inboundWorkers), m, now.Add(wait)) {
+		c.dropped(m.Id, fmt.Errorf("dropped message %s: %w", m.Id, ErrTooManyDeferred))
+		return true, false
+	}
+
+	return true, true
+}
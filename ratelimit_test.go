@@ -0,0 +1,112 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientLimitSenders(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	clock := newManualClock()
+
+	limited := make(chan string, 4)
+
+	onLimit := func(m *msgproto.Message) LimitAction {
+		limited <- m.Id
+		if m.Id == "a3" {
+			return DeferMessage
+		}
+		return DropMessage
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, WithClock(clock), LimitSenders(2, time.Minute, onLimit))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, id := range []string{"a1", "a2", "a3", "a4"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "alice:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+	}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "b1", Sender: "bob:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		m, err := c.Receive()
+		require.Nil(t, err)
+		ids = append(ids, m.Id)
+	}
+
+	sort.Strings(ids)
+	assert.Equal(t, []string{"a1", "a2", "b1"}, ids)
+	assert.Equal(t, "a3", <-limited)
+	assert.Equal(t, "a4", <-limited)
+
+	// the deferred message is delivered once the interval has passed
+	var deferred *msgproto.Message
+
+	assert.Eventually(t, func() bool {
+		clock.Advance(time.Minute)
+
+		select {
+		case deferred = <-c.ReceiveChan():
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	require.NotNil(t, deferred)
+	assert.Equal(t, "a3", deferred.Id)
+	assert.Equal(t, int64(1), c.Stats().Dropped)
+}
+
+func TestClientLimitSendersDeferred(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	onLimit := func(m *msgproto.Message) LimitAction {
+		return DeferMessage
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, LimitSenders(1, time.Hour, onLimit), MaxMemory(1024))
+	require.Nil(t, err)
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	for i := 1; i <= maxDeferred+2; i++ {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "a", Sender: "alice:1", Recipient: "someID:1", Offset: int64(i), Ciphertext: []byte("hi")}
+	}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), m.Offset)
+
+	// deferred messages are bounded and stay within the memory budget
+	e, ok := nextEvent(t, c).(MessageDropped)
+	require.True(t, ok)
+	assert.True(t, errors.Is(e.Err, ErrTooManyDeferred))
+
+	for i := 0; c.memory.inUse() != int64(maxDeferred*2); i++ {
+		require.Less(t, i, 100, "deferred messages were not held in the budget")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// and are received again by the client that takes over
+	data, err := c.Handover(context.Background())
+	require.Nil(t, err)
+
+	var st state
+	require.Nil(t, json.Unmarshal(data, &st))
+	assert.Equal(t, int64(1), st.Offset)
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRecipientBusy is returned when a message can't be queued without exceeding its recipient's RecipientLimit
+var ErrRecipientBusy = errors.New("too many messages in flight to recipient")
+
+// recipientLimiter bounds the messages to each recipient that are queued or
+// awaiting a response, so a recipient whose sends keep timing out can't fill
+// the send queue. A nil limiter is unlimited
+type recipientLimiter struct {
+	limit    int
+	inflight map[string]int
+	freed    chan struct{}
+	mu       sync.Mutex
+}
+
+func newRecipientLimiter(limit int) *recipientLimiter {
+	return &recipientLimiter{limit: limit, inflight: make(map[string]int), freed: make(chan struct{})}
+}
+
+// acquire takes a slot for a message to recipient, waiting until the
+// timeout fires for another message to it to complete. If the timeout is
+// nil it does not wait
+func (l *recipientLimiter) acquire(recipient string, timeout <-chan time.Time) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+
+		if l.inflight[recipient] < l.limit {
+			l.inflight[recipient]++
+			l.mu.Unlock()
+			return nil
+		}
+
+		freed := l.freed
+		l.mu.Unlock()
+
+		if timeout == nil {
+			return ErrRecipientBusy
+		}
+
+		select {
+		case <-freed:
+		case <-timeout:
+			return ErrRecipientBusy
+		}
+	}
+}
+
+// release frees a recipient's slot and wakes anything waiting for one
+func (l *recipientLimiter) release(recipient string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight[recipient]--
+	if l.inflight[recipient] < 1 {
+		delete(l.inflight, recipient)
+	}
+
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// inFlight returns the number of messages to a recipient that hold a slot
+func (l *recipientLimiter) inFlight(recipient string) int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inflight[recipient]
+}
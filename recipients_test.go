@@ -0,0 +1,69 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipientLimiter(t *testing.T) {
+	l := newRecipientLimiter(1)
+
+	require.Nil(t, l.acquire("alice", nil))
+	assert.Equal(t, ErrRecipientBusy, l.acquire("alice", nil))
+	assert.Equal(t, ErrRecipientBusy, l.acquire("alice", time.After(time.Millisecond*10)))
+
+	// other recipients are not affected
+	require.Nil(t, l.acquire("bob", nil))
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		l.release("alice")
+	}()
+
+	require.Nil(t, l.acquire("alice", time.After(time.Second)))
+	assert.Equal(t, 1, l.inFlight("alice"))
+
+	l.release("alice")
+	l.release("bob")
+	assert.Empty(t, l.inflight)
+}
+
+func TestClientRecipientLimit(t *testing.T) {
+	// messages to slow are never acknowledged
+	sc := newScriptedConn(func(sc *scriptedConn, hdr *msgproto.Header) error {
+		if hdr.Type == msgproto.MsgType_MSG && hdr.Id != "alice" {
+			return nil
+		}
+		return acknowledge(sc, hdr)
+	})
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), RecipientLimit(1), RequestTimeout(time.Millisecond*200))
+	require.Nil(t, err)
+	defer c.Close()
+
+	slow := make(chan error, 2)
+
+	c.SendAsync(&msgproto.Message{Id: "slow-1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "slow:1"}, func(err error) {
+		slow <- err
+	})
+
+	c.SendAsync(&msgproto.Message{Id: "slow-2", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "slow:1"}, func(err error) {
+		slow <- err
+	})
+
+	assert.Equal(t, ErrRecipientBusy, <-slow)
+
+	// messages to other recipients are still sent
+	err = c.Send(&msgproto.Message{Id: "alice", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice:1"})
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrRequestTimeout, <-slow)
+	assert.Equal(t, 0, c.recipients.inFlight("slow:1"))
+	assert.Equal(t, 0, c.recipients.inFlight("alice:1"))
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package redisrequests implements a messaging.RequestStore backed by Redis,
+// for services that run several instances with the same identity behind a
+// load balancer. Each instance records the requests it is waiting on in a
+// key, and responses received by other instances are published to its channel.
+//
+// Registrations expire after TTL, so responses are not forwarded to an
+// instance that stopped without deregistering.
+package redisrequests
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultPrefix is prepended to all keys and channels
+	DefaultPrefix = "self:"
+	// DefaultTTL is how long a request registration is kept
+	DefaultTTL = time.Hour
+)
+
+// Redis is the subset of Redis commands used by the store. Get reports
+// whether the key exists, and Subscribe calls fn with the payload of each
+// message published to the channel
+type Redis interface {
+	Set(key, value string, ttl time.Duration) error
+	Get(key string) (string, bool, error)
+	Del(key string) error
+	Publish(channel string, data []byte) error
+	Subscribe(channel string, fn func(data []byte)) error
+}
+
+// Store shares request registrations between instances through Redis
+type Store struct {
+	// Prefix is prepended to all keys and channels
+	Prefix string
+	// TTL is how long a request registration is kept
+	TTL time.Duration
+
+	redis    Redis
+	instance string
+}
+
+type forwarded struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// New creates a store for an instance. Every instance sharing the
+// identity must have a unique instance name
+func New(redis Redis, instance string) *Store {
+	return &Store{
+		Prefix:   DefaultPrefix,
+		TTL:      DefaultTTL,
+		redis:    redis,
+		instance: instance,
+	}
+}
+
+func (s *Store) key(id string) string {
+	return s.Prefix + "request:" + id
+}
+
+func (s *Store) channel(instance string) string {
+	return s.Prefix + "responses:" + instance
+}
+
+// Register records that this instance is waiting for responses to id
+func (s *Store) Register(id string) error {
+	return s.redis.Set(s.key(id), s.instance, s.TTL)
+}
+
+// Deregister removes the registration for id if it belongs to this instance
+func (s *Store) Deregister(id string) error {
+	owner, ok, err := s.redis.Get(s.key(id))
+	if err != nil || !ok || owner != s.instance {
+		return err
+	}
+
+	return s.redis.Del(s.key(id))
+}
+
+// Forward publishes a response to the instance that registered id
+func (s *Store) Forward(id string, data []byte) (bool, error) {
+	owner, ok, err := s.redis.Get(s.key(id))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// the registration is stale if it belongs to this instance
+	if owner == s.instance {
+		return false, nil
+	}
+
+	msg, err := json.Marshal(&forwarded{ID: id, Data: data})
+	if err != nil {
+		return false, err
+	}
+
+	err = s.redis.Publish(s.channel(owner), msg)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Listen calls fn with each response forwarded to this instance
+func (s *Store) Listen(fn func(id string, data []byte)) error {
+	if fn == nil {
+		return errors.New("listener must not be nil")
+	}
+
+	return s.redis.Subscribe(s.channel(s.instance), func(data []byte) {
+		var f forwarded
+
+		if json.Unmarshal(data, &f) == nil {
+			fn(f.ID, f.Data)
+		}
+	})
+}
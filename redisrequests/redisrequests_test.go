@@ -0,0 +1,100 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package redisrequests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memory is an in memory Redis
+type memory struct {
+	values map[string]string
+	subs   map[string][]func([]byte)
+	mu     sync.Mutex
+}
+
+func newMemory() *memory {
+	return &memory{values: make(map[string]string), subs: make(map[string][]func([]byte))}
+}
+
+func (m *memory) Set(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memory) Get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	return v, ok, nil
+}
+
+func (m *memory) Del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *memory) Publish(channel string, data []byte) error {
+	m.mu.Lock()
+	subs := m.subs[channel]
+	m.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(data)
+	}
+
+	return nil
+}
+
+func (m *memory) Subscribe(channel string, fn func([]byte)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[channel] = append(m.subs[channel], fn)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	r := newMemory()
+
+	a := New(r, "a")
+	b := New(r, "b")
+
+	received := make(map[string][]byte)
+
+	require.Nil(t, a.Listen(func(id string, data []byte) { received[id] = data }))
+
+	// unregistered responses are not forwarded
+	ok, err := b.Forward("123", []byte("response"))
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	require.Nil(t, a.Register("123"))
+
+	// responses received by the instance that registered the request are not forwarded
+	ok, err = a.Forward("123", []byte("response"))
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = b.Forward("123", []byte("response"))
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("response"), received["123"])
+
+	// only the owner can remove a registration
+	require.Nil(t, b.Deregister("123"))
+	_, ok, _ = r.Get("self:request:123")
+	assert.True(t, ok)
+
+	require.Nil(t, a.Deregister("123"))
+	_, ok, _ = r.Get("self:request:123")
+	assert.False(t, ok)
+}
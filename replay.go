@@ -0,0 +1,103 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// DefaultReplayCacheSize is the number of payload ids remembered for replay protection
+	DefaultReplayCacheSize = 100000
+	// DefaultReplayTTL is how long payload ids are remembered for replay protection
+	DefaultReplayTTL = time.Hour
+)
+
+// ErrReplayedPayload is returned when a payload's jti has already been seen from its issuer
+var ErrReplayedPayload = errors.New("payload has already been received")
+
+// replayCache remembers the issuer and jti of verified payloads. The least
+// recently seen ids are forgotten when the cache is full or their TTL has passed
+type replayCache struct {
+	size     int
+	ttl      time.Duration
+	onReplay func(*Claims, *msgproto.Message)
+	entries  map[replayKey]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+// replayKey identifies a payload. The jti is only unique to its issuer, so
+// one identity can't reuse another's jti to have its payloads rejected
+type replayKey struct {
+	issuer string
+	id     string
+}
+
+type replayEntry struct {
+	key  replayKey
+	msg  *msgproto.Message
+	seen time.Time
+}
+
+func newReplayCache(size int, ttl time.Duration, onReplay func(*Claims, *msgproto.Message)) *replayCache {
+	return &replayCache{
+		size:     size,
+		ttl:      ttl,
+		onReplay: onReplay,
+		entries:  make(map[replayKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// check records a payload id, returning ErrReplayedPayload if it was seen
+// in another message within the TTL. Decoding the same message again is
+// not a replay
+func (rc *replayCache) check(claims *Claims, m *msgproto.Message, now time.Time) error {
+	if claims.ID == "" {
+		return errors.New("payload has no jti")
+	}
+
+	key := replayKey{issuer: claims.Issuer, id: claims.ID}
+
+	rc.mu.Lock()
+
+	if e, ok := rc.entries[key]; ok {
+		entry := e.Value.(*replayEntry)
+
+		if now.Sub(entry.seen) < rc.ttl {
+			replayed := entry.msg != m
+			rc.mu.Unlock()
+
+			if !replayed {
+				return nil
+			}
+
+			if rc.onReplay != nil {
+				rc.onReplay(claims, m)
+			}
+
+			return ErrReplayedPayload
+		}
+
+		rc.order.Remove(e)
+		delete(rc.entries, key)
+	}
+
+	rc.entries[key] = rc.order.PushFront(&replayEntry{key: key, msg: m, seen: now})
+
+	for rc.order.Len() > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*replayEntry).key)
+	}
+
+	rc.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayCache(t *testing.T) {
+	rc := newReplayCache(2, time.Minute, nil)
+	now := time.Now()
+
+	a, b := &msgproto.Message{}, &msgproto.Message{}
+
+	assert.Nil(t, rc.check(&Claims{ID: "1"}, a, now))
+	assert.Nil(t, rc.check(&Claims{ID: "1"}, a, now))
+	assert.Equal(t, ErrReplayedPayload, rc.check(&Claims{ID: "1"}, b, now))
+	assert.NotNil(t, rc.check(&Claims{}, b, now))
+
+	// ids are only unique to their issuer
+	assert.Nil(t, rc.check(&Claims{Issuer: "alice", ID: "1"}, b, now))
+	assert.Equal(t, ErrReplayedPayload, rc.check(&Claims{Issuer: "alice", ID: "1"}, a, now))
+
+	// expired ids are no longer replays
+	assert.Nil(t, rc.check(&Claims{ID: "1"}, b, now.Add(time.Minute)))
+
+	// the least recently seen id is evicted when the cache is full
+	assert.Nil(t, rc.check(&Claims{ID: "2"}, a, now))
+	assert.Nil(t, rc.check(&Claims{ID: "3"}, a, now))
+	assert.Nil(t, rc.check(&Claims{ID: "1"}, a, now))
+}
+
+func TestClientReplayProtection(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	replays := make(chan string, 1)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys), ReplayProtection(0, 0, func(claims *Claims, m *msgproto.Message) {
+		replays <- claims.ID
+	}))
+	require.Nil(t, err)
+
+	payload := testSignedPayload(privkey, map[string]interface{}{
+		"jti": "abc",
+		"iss": "test",
+		"msg": "hello",
+	})
+
+	for i := 0; i < 2; i++ {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test:1", Recipient: "someID:1", Ciphertext: payload}
+	}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+
+	_, err = c.DecodePayload(m, nil)
+	require.Nil(t, err)
+
+	_, err = c.DecodePayload(m, nil)
+	require.Nil(t, err)
+
+	_, err = c.ReceiveInto(nil)
+	assert.Equal(t, ErrReplayedPayload, err)
+	assert.Equal(t, "abc", <-replays)
+}
@@ -3,7 +3,6 @@
 package messaging
 
 import (
-	"errors"
 	"sync"
 	"time"
 
@@ -15,6 +14,9 @@ import (
 type requestCache struct {
 	requests    map[string]chan proto.Message
 	jwsRequests map[string]chan *msgproto.Message
+	shared      RequestStore
+	report      func(error)
+	memory      *budget // holds the bytes of buffered responses
 	mu          sync.RWMutex
 	jwsmu       sync.RWMutex
 }
@@ -26,15 +28,22 @@ func newRequestCache() *requestCache {
 	}
 }
 
-// Send sends a response to the waiting thread
-func (rc *requestCache) send(reqID string, m proto.Message) {
+// Send sends a response to the waiting thread. It returns false if no
+// request is waiting for it. Only the first response is kept, so a server
+// that responds twice can't block the reader
+func (rc *requestCache) send(reqID string, m proto.Message) bool {
 	rc.mu.Lock()
 	ch, ok := rc.requests[reqID]
 	rc.mu.Unlock()
 
 	if ok {
-		ch <- m
+		select {
+		case ch <- m:
+		default:
+		}
 	}
+
+	return ok
 }
 
 // Register makes a request
@@ -48,6 +57,15 @@ func (rc *requestCache) register(reqID string) chan proto.Message {
 	return ch
 }
 
+// registered returns true if a request is awaiting a response
+func (rc *requestCache) registered(reqID string) bool {
+	rc.mu.RLock()
+	_, ok := rc.requests[reqID]
+	rc.mu.RUnlock()
+
+	return ok
+}
+
 // Cancel cancels a request
 func (rc *requestCache) cancel(reqID string) {
 	rc.mu.Lock()
@@ -56,7 +74,7 @@ func (rc *requestCache) cancel(reqID string) {
 }
 
 // Wait for a response from the server
-func (rc *requestCache) wait(reqID string, timeout time.Duration) (proto.Message, error) {
+func (rc *requestCache) wait(reqID string, timeout <-chan time.Time) (proto.Message, error) {
 	rc.mu.RLock()
 	ch := rc.requests[reqID]
 	rc.mu.RUnlock()
@@ -70,64 +88,116 @@ func (rc *requestCache) wait(reqID string, timeout time.Duration) (proto.Message
 	select {
 	case resp := <-ch:
 		return resp, nil
-	case <-time.After(timeout):
-		return nil, errors.New("request timed out")
+	case <-timeout:
+		return nil, ErrRequestTimeout
 	}
 }
 
-// Send sends a response to the waiting thread. Will return true if there is a valid request registered
-func (rc *requestCache) sendJWS(reqID string, m *msgproto.Message) bool {
+// awaiting returns the number of requests awaiting a response from the
+// server, not counting JWS requests
+func (rc *requestCache) awaiting() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return len(rc.requests)
+}
+
+// pending returns the number of requests awaiting a response
+func (rc *requestCache) pending() int {
+	rc.mu.RLock()
+	n := len(rc.requests)
+	rc.mu.RUnlock()
+
+	rc.jwsmu.RLock()
+	n += len(rc.jwsRequests)
+	rc.jwsmu.RUnlock()
+
+	return n
+}
+
+// Send sends a response to the waiting thread. Will return true if there is a valid request
+// registered, and whether the response was delivered or dropped because the buffer is full.
+// The size bytes reserved for a delivered response are held until it is taken
+func (rc *requestCache) sendJWS(reqID string, m *msgproto.Message, size int64) (bool, bool) {
 	if reqID == "" {
-		return false
+		return false, false
 	}
 
+	// the lock is held while sending so the channel can't be closed underneath us
 	rc.jwsmu.Lock()
-	ch, ok := rc.jwsRequests[reqID]
-	rc.jwsmu.Unlock()
+	defer rc.jwsmu.Unlock()
 
+	ch, ok := rc.jwsRequests[reqID]
 	if !ok {
-		return false
+		return false, false
 	}
 
-	ch <- m
-
-	return true
+	select {
+	case ch <- m:
+		rc.memory.hold(ch, size)
+		return true, true
+	default:
+		return true, false
+	}
 }
 
-// Register makes a request
-func (rc *requestCache) registerJWS(reqID string) chan *msgproto.Message {
-	ch := make(chan *msgproto.Message, 1)
+// Register makes a request that buffers up to size responses
+func (rc *requestCache) registerJWS(reqID string, size int) chan *msgproto.Message {
+	ch := make(chan *msgproto.Message, size)
 
 	rc.jwsmu.Lock()
 	rc.jwsRequests[reqID] = ch
 	rc.jwsmu.Unlock()
 
+	rc.share(reqID)
+
 	return ch
 }
 
 // Cancel cancels a request
 func (rc *requestCache) cancelJWS(reqID string) {
 	rc.jwsmu.Lock()
+	rc.memory.drop(rc.jwsRequests[reqID])
 	delete(rc.jwsRequests, reqID)
 	rc.jwsmu.Unlock()
+
+	rc.unshare(reqID)
+}
+
+// closeJWS cancels a request and closes its channel
+func (rc *requestCache) closeJWS(reqID string) {
+	rc.jwsmu.Lock()
+	ch, ok := rc.jwsRequests[reqID]
+	rc.memory.drop(ch)
+	delete(rc.jwsRequests, reqID)
+	rc.jwsmu.Unlock()
+
+	rc.unshare(reqID)
+
+	if ok {
+		close(ch)
+	}
 }
 
 // Wait for a response from the server
-func (rc *requestCache) waitJWS(reqID string, timeout time.Duration) (*msgproto.Message, error) {
+func (rc *requestCache) waitJWS(reqID string, timeout <-chan time.Time) (*msgproto.Message, error) {
 	rc.jwsmu.RLock()
 	ch := rc.jwsRequests[reqID]
 	rc.jwsmu.RUnlock()
 
 	defer func() {
 		rc.jwsmu.Lock()
+		rc.memory.drop(ch)
 		delete(rc.jwsRequests, reqID)
 		rc.jwsmu.Unlock()
+
+		rc.unshare(reqID)
 	}()
 
 	select {
 	case resp := <-ch:
 		return resp, nil
-	case <-time.After(timeout):
-		return nil, errors.New("request timed out")
+	case <-timeout:
+		return nil, ErrRequestTimeout
 	}
 }
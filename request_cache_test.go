@@ -0,0 +1,37 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCacheDuplicateResponse(t *testing.T) {
+	rc := newRequestCache()
+	rc.register("1")
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		assert.True(t, rc.send("1", &msgproto.Notification{Id: "1", Type: msgproto.MsgType_ACK}))
+		assert.True(t, rc.send("1", &msgproto.Notification{Id: "1", Type: msgproto.MsgType_ERR}))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("duplicate response blocked")
+	}
+
+	resp, err := rc.wait("1", time.After(time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, msgproto.MsgType_ACK, resp.(*msgproto.Notification).Type)
+
+	assert.False(t, rc.send("1", &msgproto.Notification{Id: "1", Type: msgproto.MsgType_ACK}))
+}
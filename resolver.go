@@ -0,0 +1,45 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Resolver looks up the addresses of a host. It is satisfied by *net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// StaticHosts is a resolver that maps hosts to fixed addresses. Hosts that
+// are not in the map are looked up with Fallback, or the system resolver if
+// Fallback is nil
+type StaticHosts struct {
+	Hosts    map[string][]string
+	Fallback Resolver
+}
+
+// LookupIPAddr returns the addresses mapped to host
+func (s *StaticHosts) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := s.Hosts[strings.ToLower(host)]
+	if !ok {
+		if s.Fallback != nil {
+			return s.Fallback.LookupIPAddr(ctx, host)
+		}
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	ips := make([]net.IPAddr, 0, len(addrs))
+
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, &net.DNSError{Err: "invalid static address " + a, Name: host}
+		}
+		ips = append(ips, net.IPAddr{IP: ip})
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingResolver struct {
+	hosts []string
+}
+
+func (r *recordingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.hosts = append(r.hosts, host)
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestStaticHosts(t *testing.T) {
+	fallback := &recordingResolver{}
+
+	r := &StaticHosts{
+		Hosts:    map[string][]string{"messaging.internal": {"10.0.0.1", "2001:db8::1"}},
+		Fallback: fallback,
+	}
+
+	ips, err := r.LookupIPAddr(context.Background(), "Messaging.Internal")
+	require.Nil(t, err)
+	require.Len(t, ips, 2)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+	assert.Equal(t, "2001:db8::1", ips[1].String())
+
+	_, err = r.LookupIPAddr(context.Background(), "other.internal")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"other.internal"}, fallback.hosts)
+
+	r.Hosts["bad.internal"] = []string{"not-an-ip"}
+
+	_, err = r.LookupIPAddr(context.Background(), "bad.internal")
+	assert.NotNil(t, err)
+}
+
+func TestClientCustomResolver(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.endpoint, "ws://"))
+
+	r := &StaticHosts{Hosts: map[string][]string{"messaging.internal": {"127.0.0.1"}}}
+
+	c, err := New("ws://messaging.internal:"+port, "someID", "1", privkey, CustomResolver(r))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.False(t, c.IsClosed())
+}
+
+func TestClientCustomResolverWithHappyEyeballs(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.endpoint, "ws://"))
+
+	r := &recordingResolver{}
+
+	c, err := New("ws://messaging.internal:"+port, "someID", "1", privkey, HappyEyeballs(0, 0), CustomResolver(r))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, []string{"messaging.internal"}, r.hosts)
+}
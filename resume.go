@@ -0,0 +1,63 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync"
+	"sync/atomic"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// resumption holds the token used to resume the session when reconnecting
+type resumption struct {
+	token string
+	mu    sync.Mutex
+}
+
+func (r *resumption) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.token
+}
+
+func (r *resumption) set(token string) {
+	r.mu.Lock()
+	r.token = token
+	r.mu.Unlock()
+}
+
+// resume authenticates with the resumption token from the previous
+// connection, skipping token generation. It returns false if there is no
+// token or the server does not support resumption. A rejected token is
+// discarded so the next attempt uses full authentication
+func (c *Client) resume(ws transport) (bool, error) {
+	token := c.resumption.get()
+	if token == "" || !c.Supports(CapabilityResume) {
+		return false, nil
+	}
+
+	err := c.authenticate(ws, token)
+	if err != nil {
+		c.resumption.set("")
+		return false, err
+	}
+
+	atomic.AddInt64(&c.counters.resumed, 1)
+
+	return true, nil
+}
+
+// storeResumeToken keeps the resumption token sent with an authentication ACK
+func (c *Client) storeResumeToken(ack *msgproto.Notification) {
+	if !c.Supports(CapabilityResume) {
+		return
+	}
+
+	if ack.ResumeToken == "" {
+		return
+	}
+
+	c.resumption.set(ack.ResumeToken)
+}
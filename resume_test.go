@@ -0,0 +1,95 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newResumeServer(token string) *testserver {
+	s := newServer()
+	s.header = http.Header{CapabilitiesHeader: []string{CapabilityAcks + "," + CapabilityResume}}
+	s.resume = token
+	return s
+}
+
+func (t *testserver) resumptions() []bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]bool(nil), t.resumed...)
+}
+
+func TestClientResume(t *testing.T) {
+	s := newResumeServer("resume-1")
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), MaxRetries(3), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "resume-1", c.resumption.get())
+
+	s.disconnect()
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	}, time.Second*2, time.Millisecond*10)
+
+	assert.Equal(t, []bool{false, true}, s.resumptions())
+	assert.Equal(t, int64(1), c.Stats().Resumed)
+}
+
+func TestClientResumeRejected(t *testing.T) {
+	s := newResumeServer("resume-1")
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), MaxRetries(3), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.mu.Lock()
+	s.resume = "resume-2"
+	s.mu.Unlock()
+
+	s.disconnect()
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	}, time.Second*2, time.Millisecond*10)
+
+	assert.Equal(t, []bool{false, false}, s.resumptions())
+	assert.Equal(t, int64(0), c.Stats().Resumed)
+	assert.Equal(t, "resume-2", c.resumption.get())
+}
+
+func TestClientResumeUnsupported(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	s.resume = "resume-1"
+
+	c, err := New(s.endpoint, "someID", "1", privkey, AutoReconnect(true), MaxRetries(3), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	// the server did not advertise the resume capability
+	assert.Equal(t, "", c.resumption.get())
+}
+
+func TestRotateKeyDiscardsResumption(t *testing.T) {
+	s := newResumeServer("resume-1")
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	require.Nil(t, c.RotateKey(privkey))
+	assert.Equal(t, "", c.resumption.get())
+}
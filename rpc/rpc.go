@@ -0,0 +1,190 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+// Package rpc implements request/response calls between identities over
+// the messaging client. Calls and replies are signed JWS payloads that are
+// correlated by their conversation id
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// TypeRequest is the typ claim of a call
+	TypeRequest = "rpc.request"
+	// TypeResponse is the typ claim of a reply
+	TypeResponse = "rpc.response"
+	// DefaultExpiry is how long a call is valid for if its context has no deadline
+	DefaultExpiry = time.Minute
+)
+
+// Error is an error returned by a remote method
+type Error struct {
+	Method  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "rpc: " + e.Method + ": " + e.Message
+}
+
+// Handler handles a call. The sender is the address the call was sent from
+// and params are the JSON encoded arguments. The returned value is JSON
+// encoded and sent as the reply
+type Handler func(ctx context.Context, sender string, params json.RawMessage) (interface{}, error)
+
+type request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Server dispatches calls received by a messaging client to registered methods
+type Server struct {
+	client  *messaging.Client
+	methods map[string]Handler
+	mu      sync.RWMutex
+}
+
+// NewServer creates a server that replies with the given client
+func NewServer(c *messaging.Client) *Server {
+	return &Server{client: c, methods: make(map[string]Handler)}
+}
+
+// Register registers a handler for a method
+func (s *Server) Register(method string, h Handler) {
+	s.mu.Lock()
+	s.methods[method] = h
+	s.mu.Unlock()
+}
+
+// Handle handles a message if it is a call, returning false if it is not.
+// Calls that fail verification are not handled
+func (s *Server) Handle(ctx context.Context, m *msgproto.Message) bool {
+	var req request
+
+	claims, err := s.client.DecodePayload(m, &req)
+	if err != nil || claims.Type != TypeRequest {
+		return false
+	}
+
+	var resp response
+
+	s.mu.RLock()
+	h, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	if !ok {
+		resp.Error = "method not found"
+	} else {
+		resp.Result, resp.Error = call(ctx, h, m.Sender, req.Params)
+	}
+
+	s.client.Respond(m, map[string]interface{}{
+		"typ":    TypeResponse,
+		"result": resp.Result,
+		"error":  resp.Error,
+	})
+
+	return true
+}
+
+func call(ctx context.Context, h Handler, sender string, params json.RawMessage) (json.RawMessage, string) {
+	result, err := h(ctx, sender, params)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	return data, ""
+}
+
+// Serve handles calls received by the client until the context is done.
+// Messages that are not calls are passed to fallback, if it is not nil
+func (s *Server) Serve(ctx context.Context, fallback func(*msgproto.Message)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m := <-s.client.ReceiveChan():
+			if !s.Handle(ctx, m) && fallback != nil {
+				fallback(m)
+			}
+		}
+	}
+}
+
+// Client makes calls to methods served by other identities
+type Client struct {
+	client *messaging.Client
+}
+
+// NewClient creates a client that calls methods with the given messaging client
+func NewClient(c *messaging.Client) *Client {
+	return &Client{client: c}
+}
+
+// Call calls a method on recipient, formatted as selfID:deviceID, and
+// decodes the reply into reply. Errors returned by the method are
+// returned as an *Error
+func (c *Client) Call(ctx context.Context, recipient, method string, args interface{}, reply interface{}) error {
+	params, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	expiry := DefaultExpiry
+	if deadline, ok := ctx.Deadline(); ok {
+		expiry = time.Until(deadline)
+	}
+
+	cid, payload, err := c.client.SignRequest(TypeRequest, strings.Split(recipient, ":")[0], expiry, map[string]interface{}{
+		"method": method,
+		"params": json.RawMessage(params),
+	})
+	if err != nil {
+		return err
+	}
+
+	m, err := c.client.JWSRequestAndWait(ctx, cid, c.client.RequestMessage(recipient, payload))
+	if err != nil {
+		return err
+	}
+
+	var resp response
+
+	claims, err := c.client.DecodePayload(m, &resp)
+	if err != nil {
+		return err
+	}
+
+	if claims.Type != TypeResponse || claims.ConversationID != cid {
+		return errors.New("rpc: reply does not match call")
+	}
+
+	if resp.Error != "" {
+		return &Error{Method: method, Message: resp.Error}
+	}
+
+	if reply == nil || len(resp.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Result, reply)
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package rpc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	messaging "github.com/selfid-net/self-messaging-client"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// relay is a test server that forwards messages to the connection of their recipient
+type relay struct {
+	s     *httptest.Server
+	conns map[string]*websocket.Conn
+	mu    sync.Mutex
+}
+
+func newRelay() *relay {
+	r := &relay{conns: make(map[string]*websocket.Conn)}
+	r.s = httptest.NewServer(http.HandlerFunc(r.handler))
+	return r
+}
+
+func (r *relay) endpoint() string {
+	return "ws" + strings.TrimPrefix(r.s.URL, "http")
+}
+
+func (r *relay) write(wc *websocket.Conn, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (r *relay) ack(wc *websocket.Conn, id string) {
+	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: id})
+	r.write(wc, data)
+}
+
+func (r *relay) handler(w http.ResponseWriter, req *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	_, data, err := wc.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var auth msgproto.Auth
+
+	err = proto.Unmarshal(data, &auth)
+	if err != nil {
+		return
+	}
+
+	token, err := jose.ParseSigned(auth.Token)
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	json.Unmarshal(token.UnsafePayloadWithoutVerification(), &claims)
+
+	r.mu.Lock()
+	r.conns[claims.Issuer] = wc
+	r.mu.Unlock()
+
+	r.ack(wc, auth.Id)
+
+	for {
+		_, data, err := wc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m msgproto.Message
+
+		err = proto.Unmarshal(data, &m)
+		if err != nil {
+			return
+		}
+
+		r.ack(wc, m.Id)
+
+		if m.Type != msgproto.MsgType_MSG {
+			continue
+		}
+
+		r.mu.Lock()
+		rc, ok := r.conns[strings.Split(m.Recipient, ":")[0]]
+		r.mu.Unlock()
+
+		if ok {
+			r.write(rc, data)
+		}
+	}
+}
+
+func testKey() (string, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(priv.Seed()), pub
+}
+
+func TestCall(t *testing.T) {
+	r := newRelay()
+	defer r.s.Close()
+
+	alicekey, alicepub := testKey()
+	bobkey, bobpub := testKey()
+
+	keys := messaging.PublicKeys(func(selfID string) ([]crypto.PublicKey, error) {
+		switch selfID {
+		case "alice":
+			return []crypto.PublicKey{alicepub}, nil
+		case "bob":
+			return []crypto.PublicKey{bobpub}, nil
+		}
+		return nil, errors.New("unknown identity")
+	})
+
+	alice, err := messaging.New(r.endpoint(), "alice", "1", alicekey, keys)
+	require.Nil(t, err)
+	defer alice.Close()
+
+	bob, err := messaging.New(r.endpoint(), "bob", "1", bobkey, keys)
+	require.Nil(t, err)
+	defer bob.Close()
+
+	srv := NewServer(bob)
+	srv.Register("add", func(ctx context.Context, sender string, params json.RawMessage) (interface{}, error) {
+		var args [2]int
+
+		err := json.Unmarshal(params, &args)
+		if err != nil {
+			return nil, err
+		}
+
+		return args[0] + args[1], nil
+	})
+	srv.Register("fail", func(ctx context.Context, sender string, params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("failed on purpose")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	go srv.Serve(ctx, nil)
+
+	client := NewClient(alice)
+
+	var sum int
+
+	err = client.Call(ctx, "bob:1", "add", []int{1, 2}, &sum)
+	require.Nil(t, err)
+	assert.Equal(t, 3, sum)
+
+	err = client.Call(ctx, "bob:1", "fail", nil, nil)
+	require.NotNil(t, err)
+	assert.Equal(t, &Error{Method: "fail", Message: "failed on purpose"}, err)
+
+	err = client.Call(ctx, "bob:1", "missing", nil, nil)
+	assert.EqualError(t, err, "rpc: missing: method not found")
+
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancelShort()
+
+	err = client.Call(short, "nobody:1", "add", []int{1, 2}, &sum)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// PayloadValidator validates the JSON payload of a message. Validators may
+// return a *ValidationError to report the fields that are invalid
+type PayloadValidator func(payload []byte) error
+
+// FieldError describes an invalid field of a payload. Path is a JSON
+// pointer to the field, which is empty for the payload itself
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+// ValidationError is returned for payloads that fail validation
+type ValidationError struct {
+	Type   string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+
+	for i, fe := range e.Errors {
+		if fe.Path == "" {
+			msgs[i] = fe.Message
+		} else {
+			msgs[i] = fe.Path + ": " + fe.Message
+		}
+	}
+
+	return "invalid " + e.Type + " payload: " + strings.Join(msgs, "; ")
+}
+
+// Schema is a JSON Schema supporting the type, required, properties,
+// additionalProperties, items, enum, minLength, maxLength, pattern,
+// minimum and maximum keywords. Other keywords are ignored
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	pattern              *regexp.Regexp
+}
+
+// ParseSchema decodes a JSON Schema document
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// compile compiles the patterns of the schema and its subschemas
+func (s *Schema) compile() error {
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern: %w", err)
+		}
+		s.pattern = re
+	}
+
+	for _, p := range s.Properties {
+		if p == nil {
+			return errors.New("schema property must not be null")
+		}
+
+		err := p.compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+
+	return nil
+}
+
+// Validate checks a JSON payload against the schema
+func (s *Schema) Validate(payload []byte) error {
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	err := dec.Decode(&v)
+	if err != nil {
+		return &ValidationError{Errors: []FieldError{{Message: "payload is not valid json"}}}
+	}
+
+	var errs []FieldError
+
+	s.validate(v, "", &errs)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *[]FieldError) {
+	fail := func(msg string) {
+		*errs = append(*errs, FieldError{Path: path, Message: msg})
+	}
+
+	if s.Type != "" && !schemaType(s.Type, v) {
+		fail("must be of type " + s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		fail("must be one of the allowed values")
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				*errs = append(*errs, FieldError{Path: path + "/" + name, Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			ps, ok := s.Properties[name]
+			switch {
+			case ok:
+				ps.validate(val[name], path+"/"+name, errs)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				*errs = append(*errs, FieldError{Path: path + "/" + name, Message: "is not allowed"})
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(item, fmt.Sprintf("%s/%d", path, i), errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail(fmt.Sprintf("must be at least %d characters", *s.MinLength))
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail(fmt.Sprintf("must be at most %d characters", *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			fail("must match " + s.Pattern)
+		}
+	case json.Number:
+		f, _ := val.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			fail(fmt.Sprintf("must be at least %v", *s.Minimum))
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail(fmt.Sprintf("must be at most %v", *s.Maximum))
+		}
+	}
+}
+
+// schemaType reports whether a decoded JSON value has a JSON Schema type
+func schemaType(typ string, v interface{}) bool {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	case json.Number:
+		if typ == "number" {
+			return true
+		}
+		_, err := val.Int64()
+		return typ == "integer" && err == nil
+	}
+
+	return false
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		// enum values are decoded without UseNumber
+		if n, ok := v.(json.Number); ok {
+			f, err := n.Float64()
+			if err == nil && reflect.DeepEqual(e, f) {
+				return true
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validatePayload runs the validators registered for a payload's typ
+func (c *Client) validatePayload(typ string, payload []byte) error {
+	validators := c.validators[typ]
+	if len(validators) == 0 {
+		return nil
+	}
+
+	verr := &ValidationError{Type: typ}
+
+	for _, fn := range validators {
+		err := fn(payload)
+		if err == nil {
+			continue
+		}
+
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			verr.Errors = append(verr.Errors, ve.Errors...)
+		} else {
+			verr.Errors = append(verr.Errors, FieldError{Message: err.Error()})
+		}
+	}
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+
+	return nil
+}
+
+// validateOutbound validates a payload before it is signed
+func (c *Client) validateOutbound(payload []byte) error {
+	if len(c.validators) == 0 {
+		return nil
+	}
+
+	var claims struct {
+		Type string `json:"typ"`
+	}
+
+	err := json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil
+	}
+
+	return c.validatePayload(claims.Type, payload)
+}
+
+// invalid reports whether a received payload fails validation, in which
+// case it is dropped and the error is reported
+func (c *Client) invalid(in *inbound) bool {
+	if len(c.validators) == 0 || in.env == nil {
+		return false
+	}
+
+	// older payload versions are validated as they will be delivered
+	payload, _, err := c.migrate(in.env.payload)
+	if err == nil {
+		err = c.validatePayload(in.env.typ, payload)
+	}
+	if err == nil {
+		return false
+	}
+
+	c.dropped(in.msg.Id, fmt.Errorf("dropped message %s: %w", in.msg.Id, err))
+
+	return true
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["typ", "name", "tags"],
+	"additionalProperties": false,
+	"properties": {
+		"typ": {"type": "string"},
+		"jti": {"type": "string"},
+		"name": {"type": "string", "minLength": 2, "maxLength": 8, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"status": {"enum": ["active", "suspended", 3]},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	require.Nil(t, err)
+
+	assert.Nil(t, s.Validate([]byte(`{"typ": "test", "name": "alice", "age": 30, "status": 3, "tags": ["a"]}`)))
+
+	err = s.Validate([]byte(`{"typ": "test", "name": "A", "age": 30.5, "status": "gone", "tags": ["a", 1], "extra": true}`))
+	require.NotNil(t, err)
+
+	var ve *ValidationError
+	require.True(t, errors.As(err, &ve))
+
+	assert.Equal(t, []FieldError{
+		{Path: "/age", Message: "must be of type integer"},
+		{Path: "/extra", Message: "is not allowed"},
+		{Path: "/name", Message: "must be at least 2 characters"},
+		{Path: "/name", Message: "must match ^[a-z]+$"},
+		{Path: "/status", Message: "must be one of the allowed values"},
+		{Path: "/tags/1", Message: "must be of type string"},
+	}, ve.Errors)
+
+	err = s.Validate([]byte(`{"typ": "test"}`))
+	require.True(t, errors.As(err, &ve))
+	assert.Equal(t, []FieldError{{Path: "/name", Message: "is required"}, {Path: "/tags", Message: "is required"}}, ve.Errors)
+
+	err = s.Validate([]byte(`[]`))
+	assert.EqualError(t, err, "invalid  payload: must be of type object")
+
+	_, err = ParseSchema([]byte(`{"type": "string", "pattern": "("}`))
+	assert.NotNil(t, err)
+}
+
+func TestClientValidatePayloadOutbound(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	schema, err := ParseSchema([]byte(testSchema))
+	require.Nil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PayloadSchema("test", schema), ValidatePayload("test", func(payload []byte) error {
+		return nil
+	}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	_, err = c.Sign(map[string]interface{}{"typ": "test", "name": "alice", "tags": []string{}})
+	assert.Nil(t, err)
+
+	_, err = c.Sign(map[string]interface{}{"typ": "test", "name": "alice"})
+	require.NotNil(t, err)
+	assert.EqualError(t, err, "invalid test payload: /tags: is required")
+
+	// other types are not validated
+	_, err = c.Sign(map[string]interface{}{"typ": "other"})
+	assert.Nil(t, err)
+}
+
+func TestClientValidatePayloadInbound(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	var mu sync.Mutex
+	var reported []error
+
+	c, err := New(s.endpoint, "someID", "1", privkey,
+		ValidatePayload("test", func(payload []byte) error {
+			return errors.New("always invalid")
+		}),
+		OnError(func(err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		}),
+	)
+	require.Nil(t, err)
+	defer c.Close()
+
+	invalid := testSignedPayload(privkey, map[string]interface{}{"typ": "test"})
+	valid := testSignedPayload(privkey, map[string]interface{}{"typ": "other"})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "invalid", Sender: "test:1", Recipient: "someID:1", Ciphertext: invalid}
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "valid", Sender: "test:1", Recipient: "someID:1", Ciphertext: valid}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "valid", m.Id)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, reported, 1)
+
+	var ve *ValidationError
+	require.True(t, errors.As(reported[0], &ve))
+	assert.Equal(t, "test", ve.Type)
+	assert.Equal(t, []FieldError{{Message: "always invalid"}}, ve.Errors)
+}
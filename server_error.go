@@ -0,0 +1,96 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"strings"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// ErrorCode classifies the errors returned by the server
+type ErrorCode int
+
+const (
+	// ErrorUnknown is an error the client could not classify
+	ErrorUnknown ErrorCode = iota
+	// ErrorAuth is returned when the client's token is invalid or has expired
+	ErrorAuth
+	// ErrorACLDenied is returned when the recipient does not permit the sender
+	ErrorACLDenied
+	// ErrorRateLimited is returned when the client has sent too many requests
+	ErrorRateLimited
+	// ErrorPayloadTooLarge is returned when a message exceeds the server's size limit
+	ErrorPayloadTooLarge
+	// ErrorBadRequest is returned for malformed requests
+	ErrorBadRequest
+	// ErrorInternal is returned when the server failed to handle a request
+	ErrorInternal
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorAuth:
+		return "auth"
+	case ErrorACLDenied:
+		return "acl denied"
+	case ErrorRateLimited:
+		return "rate limited"
+	case ErrorPayloadTooLarge:
+		return "payload too large"
+	case ErrorBadRequest:
+		return "bad request"
+	case ErrorInternal:
+		return "internal"
+	}
+	return "unknown"
+}
+
+// ServerError is returned when the server rejects a request with an ERR notification
+type ServerError struct {
+	Code    ErrorCode
+	Type    msgproto.ErrType
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// Retryable returns true if the request may succeed if it is sent again later
+func (e *ServerError) Retryable() bool {
+	return e.Code == ErrorRateLimited || e.Code == ErrorInternal
+}
+
+// newServerError classifies an ERR notification. The server only reports
+// a broad error type, so rate limits and size limits are recognised by
+// their message
+func newServerError(n *msgproto.Notification) *ServerError {
+	e := &ServerError{Type: n.Errtype, Message: n.Error}
+
+	msg := strings.ToLower(n.Error)
+
+	switch {
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many"):
+		e.Code = ErrorRateLimited
+	case strings.Contains(msg, "too large") || strings.Contains(msg, "too big"):
+		e.Code = ErrorPayloadTooLarge
+	case n.Errtype == msgproto.ErrType_ErrAuth:
+		e.Code = ErrorAuth
+	case n.Errtype == msgproto.ErrType_ErrACL:
+		e.Code = ErrorACLDenied
+	case n.Errtype == msgproto.ErrType_ErrBadRequest, n.Errtype == msgproto.ErrType_ErrMessage:
+		e.Code = ErrorBadRequest
+	case n.Errtype == msgproto.ErrType_ErrInternal:
+		e.Code = ErrorInternal
+	}
+
+	return e
+}
+
+// isServerError returns true if err is an error returned by the server
+func isServerError(err error) bool {
+	var serr *ServerError
+	return errors.As(err, &serr)
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerErrorClassification(t *testing.T) {
+	cases := []struct {
+		errtype   msgproto.ErrType
+		message   string
+		code      ErrorCode
+		retryable bool
+	}{
+		{msgproto.ErrType_ErrAuth, "token expired", ErrorAuth, false},
+		{msgproto.ErrType_ErrACL, "sender not permitted", ErrorACLDenied, false},
+		{msgproto.ErrType_ErrBadRequest, "Rate limit exceeded", ErrorRateLimited, true},
+		{msgproto.ErrType_ErrMessage, "message too large", ErrorPayloadTooLarge, false},
+		{msgproto.ErrType_ErrBadRequest, "invalid recipient", ErrorBadRequest, false},
+		{msgproto.ErrType_ErrInternal, "database unavailable", ErrorInternal, true},
+		{msgproto.ErrType_ErrConnection, "recipient rejected", ErrorUnknown, false},
+	}
+
+	for _, tc := range cases {
+		err := newServerError(&msgproto.Notification{Type: msgproto.MsgType_ERR, Error: tc.message, Errtype: tc.errtype})
+		assert.Equal(t, tc.code, err.Code, tc.message)
+		assert.Equal(t, tc.retryable, err.Retryable(), tc.message)
+		assert.Equal(t, tc.message, err.Error())
+	}
+}
+
+func TestClientServerError(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	err = c.Send(&msgproto.Message{Id: "1", Recipient: "error", Ciphertext: []byte("hello")})
+
+	var serr *ServerError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, "recipient rejected", serr.Message)
+}
+
+func TestClientReauthenticate(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	// the message is sent again after reauthenticating
+	atomic.StoreInt32(&s.expired, 1)
+
+	err = c.Send(&msgproto.Message{Id: "1", Recipient: "test:1", Ciphertext: []byte("hello")})
+	require.Nil(t, err)
+
+	s.mu.Lock()
+	assert.Len(t, s.offsets, 2)
+	s.mu.Unlock()
+
+	// the message is only sent again once
+	atomic.StoreInt32(&s.expired, 2)
+
+	err = c.Send(&msgproto.Message{Id: "2", Recipient: "test:1", Ciphertext: []byte("hello")})
+
+	var serr *ServerError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, ErrorAuth, serr.Code)
+	assert.Equal(t, int64(2), c.Stats().Reconnects)
+}
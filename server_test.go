@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/gorilla/websocket"
@@ -21,10 +24,19 @@ type testserver struct {
 	in       chan msgproto.Message
 	out      chan interface{}
 	endpoint string
+	conns    []*websocket.Conn
+	closes   chan *websocket.CloseError
+	header   http.Header // sent with the handshake response
+	offsets  []uint64    // offset requested by each authentication
+	expired  int32       // number of messages to reject with an auth error, accessed atomically
+	resume   string      // resumption token sent with authentication ACKs
+	resumed  []bool      // whether each authentication used the resumption token
+	priority []int       // connection priority requested by each authentication
+	mu       sync.Mutex
 }
 
 func newServer() *testserver {
-	s := testserver{in: make(chan msgproto.Message), out: make(chan interface{}, 1024)}
+	s := testserver{in: make(chan msgproto.Message), out: make(chan interface{}, 1024), closes: make(chan *websocket.CloseError, 8)}
 	m := http.NewServeMux()
 	m.HandleFunc("/", s.testHandler)
 	s.s = httptest.NewServer(m)
@@ -40,61 +52,98 @@ func errorMessage(id string, err error) []byte {
 	return m
 }
 
-func (t *testserver) testHandler(w http.ResponseWriter, r *http.Request) {
-	u := websocket.Upgrader{}
-
-	wc, err := u.Upgrade(w, r, nil)
+// verifyToken checks the signed token of an authentication request. Tokens
+// that are not signed, such as stale resumption tokens, are rejected
+func (t *testserver) verifyToken(wc *websocket.Conn, req *msgproto.Auth) bool {
+	rt, err := jose.ParseSigned(req.Token)
 	if err != nil {
-		panic(err)
+		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, errors.New("invalid token")))
+		wc.Close()
+		return false
 	}
 
-	_, msg, err := wc.ReadMessage()
+	payload, err := rt.Verify(pubkey)
 	if err != nil {
+		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, err))
 		panic(err)
 	}
 
-	var req msgproto.Auth
+	var claims map[string]interface{}
 
-	err = proto.Unmarshal(msg, &req)
+	err = json.Unmarshal(payload, &claims)
 	if err != nil {
 		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, err))
 		panic(err)
 	}
 
-	rt, err := jose.ParseSigned(req.Token)
+	_, ok := claims["iss"]
+	if !ok {
+		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, errors.New("invalid issuer")))
+		panic("invalid issuer")
+	}
+
+	return true
+}
+
+func (t *testserver) testHandler(w http.ResponseWriter, r *http.Request) {
+	u := websocket.Upgrader{}
+
+	wc, err := u.Upgrade(w, r, t.header)
 	if err != nil {
 		panic(err)
 	}
 
-	payload, err := rt.Verify(pubkey)
+	_, msg, err := wc.ReadMessage()
 	if err != nil {
-		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, err))
 		panic(err)
 	}
 
-	var claims map[string]interface{}
+	var req msgproto.Auth
 
-	err = json.Unmarshal(payload, &claims)
+	err = proto.Unmarshal(msg, &req)
 	if err != nil {
 		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, err))
 		panic(err)
 	}
 
-	_, ok := claims["iss"]
-	if !ok {
-		wc.WriteMessage(websocket.BinaryMessage, errorMessage(req.Id, errors.New("invalid issuer")))
-		panic("invalid issuer")
+	t.mu.Lock()
+	resume := t.resume
+	t.mu.Unlock()
+
+	resumed := resume != "" && req.Token == resume
+
+	if !resumed && !t.verifyToken(wc, &req) {
+		return
 	}
 
-	data, _ := proto.Marshal(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: req.Id})
+	ack := &msgproto.Notification{Type: msgproto.MsgType_ACK, Id: req.Id, ResumeToken: resume}
+
+	data, _ := proto.Marshal(ack)
 	wc.WriteMessage(websocket.BinaryMessage, data)
 
+	t.mu.Lock()
+	t.conns = append(t.conns, wc)
+	t.offsets = append(t.offsets, req.Offset)
+	t.resumed = append(t.resumed, resumed)
+	t.priority = append(t.priority, int(req.Priority))
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+
 	go func() {
+		defer close(done)
+
 		for {
 			var h msgproto.Header
 
 			_, data, err := wc.ReadMessage()
 			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					select {
+					case t.closes <- ce:
+					default:
+					}
+				}
 				return
 			}
 
@@ -104,19 +153,31 @@ func (t *testserver) testHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			t.out <- &msgproto.Notification{Type: msgproto.MsgType_ACK, Id: h.Id}
+			if h.Type != msgproto.MsgType_MSG {
+				t.out <- &msgproto.Notification{Type: msgproto.MsgType_ACK, Id: h.Id}
+				continue
+			}
 
-			if h.Type == msgproto.MsgType_MSG {
-				var m msgproto.Message
+			var m msgproto.Message
 
-				err = proto.Unmarshal(data, &m)
-				if err != nil {
-					log.Println(err)
-					return
-				}
+			err = proto.Unmarshal(data, &m)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			if atomic.LoadInt32(&t.expired) > 0 && atomic.AddInt32(&t.expired, -1) >= 0 {
+				t.out <- &msgproto.Notification{Type: msgproto.MsgType_ERR, Id: h.Id, Error: "token expired", Errtype: msgproto.ErrType_ErrAuth}
+				continue
+			}
 
-				t.in <- m
+			if m.Recipient == "error" {
+				t.out <- &msgproto.Notification{Type: msgproto.MsgType_ERR, Id: h.Id, Error: "recipient rejected"}
+				continue
 			}
+
+			t.out <- &msgproto.Notification{Type: msgproto.MsgType_ACK, Id: h.Id}
+			t.in <- m
 		}
 	}()
 
@@ -124,10 +185,17 @@ func (t *testserver) testHandler(w http.ResponseWriter, r *http.Request) {
 		for {
 			var data []byte
 			var err error
+			var e interface{}
 
-			e := <-t.out
+			select {
+			case <-done:
+				return
+			case e = <-t.out:
+			}
 
 			switch v := e.(type) {
+			case []byte:
+				data = v
 			case *msgproto.Message:
 				data, err = proto.Marshal(v)
 			case *msgproto.Notification:
@@ -144,6 +212,31 @@ func (t *testserver) testHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// disconnect drops all connections without sending a close frame
+func (t *testserver) disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, wc := range t.conns {
+		wc.UnderlyingConn().Close()
+	}
+
+	t.conns = nil
+}
+
+// shutdown sends a close frame with the given code and reason on all connections
+func (t *testserver) shutdown(code int, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, wc := range t.conns {
+		wc.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	}
+}
+
+// close drops all connections and shuts the server down, so clients a
+// test leaves open don't hold their device in later tests
 func (t *testserver) close() {
+	t.disconnect()
 	t.s.Close()
 }
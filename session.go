@@ -0,0 +1,13 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import "errors"
+
+// CloseSessionReplaced is the close code sent by the server when the same
+// device has connected from elsewhere
+const CloseSessionReplaced = 4001
+
+// ErrSessionReplaced is reported when the connection is closed because
+// another connection was opened for the same device
+var ErrSessionReplaced = errors.New("session replaced by another connection for this device")
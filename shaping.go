@@ -0,0 +1,216 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Shaping simulates a slow network on the client's connection, to test
+// timeout and backpressure settings. It applies to frames in both directions
+// once the connection has authenticated
+type Shaping struct {
+	// Latency is added to the delivery of each frame
+	Latency time.Duration
+	// Jitter randomly varies the latency by up to this much either way
+	Jitter time.Duration
+	// Bandwidth limits the bytes per second in each direction. Zero is unlimited
+	Bandwidth int64
+	// Seed seeds the jitter, so a run can be reproduced
+	Seed int64
+}
+
+type shapedFrame struct {
+	messageType int
+	data        []byte
+	err         error
+	due         time.Time
+}
+
+// link delays frames by the time it takes to transmit them and their
+// latency, delivering them in order
+type link struct {
+	shaping Shaping
+	rand    *rand.Rand
+	free    time.Time // when the link has finished transmitting earlier frames
+	last    time.Time // when the previous frame is delivered
+	mu      sync.Mutex
+}
+
+// schedule returns when a frame of n bytes sent now is delivered
+func (l *link) schedule(n int) (time.Time, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if l.free.Before(now) {
+		l.free = now
+	}
+
+	var transmit time.Duration
+	if l.shaping.Bandwidth > 0 {
+		transmit = time.Duration(int64(n) * int64(time.Second) / l.shaping.Bandwidth)
+	}
+
+	l.free = l.free.Add(transmit)
+
+	latency := l.shaping.Latency
+	if l.shaping.Jitter > 0 {
+		latency += time.Duration(l.rand.Int63n(int64(2*l.shaping.Jitter))) - l.shaping.Jitter
+	}
+
+	if latency < 0 {
+		latency = 0
+	}
+
+	due := l.free.Add(latency)
+
+	// frames are not reordered by jitter
+	if due.Before(l.last) {
+		due = l.last
+	}
+
+	l.last = due
+
+	return due, l.free.Sub(now)
+}
+
+// shapedConn delays the frames of a transport
+type shapedConn struct {
+	transport
+	in     *link
+	out    *link
+	frames chan shapedFrame
+	writes chan shapedFrame
+	werr   chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s Shaping) wrap(t transport) transport {
+	sc := &shapedConn{
+		transport: t,
+		in:        &link{shaping: s, rand: rand.New(rand.NewSource(s.Seed))},
+		out:       &link{shaping: s, rand: rand.New(rand.NewSource(s.Seed + 1))},
+		frames:    make(chan shapedFrame, DefaultBufferSize),
+		writes:    make(chan shapedFrame, DefaultBufferSize),
+		werr:      make(chan error, 1),
+		done:      make(chan struct{}),
+	}
+
+	go sc.receive()
+	go sc.send()
+
+	return sc
+}
+
+// receive reads frames as they arrive and schedules their delivery
+func (sc *shapedConn) receive() {
+	for {
+		t, r, err := sc.transport.NextReader()
+
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+
+		f := shapedFrame{messageType: t, data: data, err: err, due: time.Now()}
+		if err == nil {
+			f.due, _ = sc.in.schedule(len(data))
+		}
+
+		select {
+		case sc.frames <- f:
+		case <-sc.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes frames once they are due
+func (sc *shapedConn) send() {
+	for {
+		select {
+		case f := <-sc.writes:
+			time.Sleep(time.Until(f.due))
+
+			err := sc.transport.WriteMessage(f.messageType, f.data)
+			if err != nil {
+				select {
+				case sc.werr <- err:
+				default:
+				}
+			}
+		case <-sc.done:
+			return
+		}
+	}
+}
+
+// NextReader returns the next frame once it is due
+func (sc *shapedConn) NextReader() (int, io.Reader, error) {
+	select {
+	case f := <-sc.frames:
+		if f.err != nil {
+			return f.messageType, nil, f.err
+		}
+
+		time.Sleep(time.Until(f.due))
+
+		return f.messageType, bytes.NewReader(f.data), nil
+	case <-sc.done:
+		return 0, nil, io.ErrClosedPipe
+	}
+}
+
+// ReadMessage returns the next frame once it is due
+func (sc *shapedConn) ReadMessage() (int, []byte, error) {
+	t, r, err := sc.NextReader()
+	if err != nil {
+		return t, nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+
+	return t, data, err
+}
+
+// WriteMessage waits for the frame to be transmitted at the link's
+// bandwidth and queues it to be written after its latency. Errors from
+// writing earlier frames are returned
+func (sc *shapedConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case err := <-sc.werr:
+		return err
+	default:
+	}
+
+	due, transmit := sc.out.schedule(len(data))
+	time.Sleep(transmit)
+
+	select {
+	case sc.writes <- shapedFrame{messageType: messageType, data: append([]byte(nil), data...), due: due}:
+		return nil
+	case <-sc.done:
+		return io.ErrClosedPipe
+	}
+}
+
+// Close stops delivering frames and closes the transport
+func (sc *shapedConn) Close() error {
+	sc.once.Do(func() {
+		close(sc.done)
+	})
+
+	return sc.transport.Close()
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkSchedule(t *testing.T) {
+	l := &link{shaping: Shaping{Latency: time.Second, Bandwidth: 100}}
+
+	start := time.Now()
+
+	due, transmit := l.schedule(50)
+	assert.InDelta(t, float64(500*time.Millisecond), float64(transmit), float64(10*time.Millisecond))
+	assert.InDelta(t, float64(1500*time.Millisecond), float64(due.Sub(start)), float64(10*time.Millisecond))
+
+	// the second frame waits for the first to be transmitted
+	due, transmit = l.schedule(50)
+	assert.InDelta(t, float64(time.Second), float64(transmit), float64(10*time.Millisecond))
+	assert.InDelta(t, float64(2*time.Second), float64(due.Sub(start)), float64(10*time.Millisecond))
+}
+
+func TestShaping(t *testing.T) {
+	mt := newMemoryTransport()
+	sc := Shaping{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}.wrap(mt)
+	defer sc.Close()
+
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		require.Nil(t, mt.push([]byte{byte(i)}))
+	}
+
+	// frames are delayed but not reordered
+	for i := 0; i < 3; i++ {
+		_, data, err := sc.ReadMessage()
+		require.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, data)
+	}
+
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 40*time.Millisecond)
+	assert.True(t, elapsed < 150*time.Millisecond)
+
+	require.Nil(t, sc.WriteMessage(websocket.BinaryMessage, []byte("frame")))
+	assert.Len(t, mt.frames(), 0)
+
+	assert.Eventually(t, func() bool { return len(mt.frames()) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestClientShapeTraffic(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	_, err := New(s.endpoint, "someID", "1", privkey, ShapeTraffic(Shaping{Latency: -1}))
+	assert.NotNil(t, err)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ShapeTraffic(Shaping{Latency: 25 * time.Millisecond}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	start := time.Now()
+
+	err = c.Send(&msgproto.Message{Id: "1", Recipient: "test:1"})
+	require.Nil(t, err)
+
+	// the message and its acknowledgement are both delayed
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// RequestStore shares JWS request registrations between instances of a
+// service that connect with the same identity, so a response received by
+// one instance can be delivered to the instance that made the request
+type RequestStore interface {
+	// Register records that this instance is waiting for responses to id
+	Register(id string) error
+	// Deregister removes this instance's registration for id
+	Deregister(id string) error
+	// Forward passes an encoded response to the instance that registered id.
+	// It reports false if no other instance has registered it
+	Forward(id string, data []byte) (bool, error)
+	// Listen calls fn with each response forwarded to this instance
+	Listen(fn func(id string, data []byte)) error
+}
+
+// share registers a request with the shared store, if one is configured
+func (rc *requestCache) share(reqID string) {
+	if rc.shared == nil {
+		return
+	}
+
+	err := rc.shared.Register(reqID)
+	if err != nil {
+		rc.report(fmt.Errorf("failed to share request %s: %w", reqID, err))
+	}
+}
+
+// unshare removes a request from the shared store, if one is configured
+func (rc *requestCache) unshare(reqID string) {
+	if rc.shared == nil {
+		return
+	}
+
+	err := rc.shared.Deregister(reqID)
+	if err != nil {
+		rc.report(fmt.Errorf("failed to remove shared request %s: %w", reqID, err))
+	}
+}
+
+// forwardJWS passes a response that no local request is waiting for to
+// the instance that registered it. It reports whether it was forwarded
+func (c *Client) forwardJWS(in *inbound) bool {
+	cid := in.conversationID()
+	if c.requests.shared == nil || cid == "" {
+		return false
+	}
+
+	data, err := proto.Marshal(in.msg)
+	if err != nil {
+		c.report(fmt.Errorf("failed to encode response %s: %w", in.msg.Id, err))
+		return false
+	}
+
+	forwarded, err := c.requests.shared.Forward(cid, data)
+	if err != nil {
+		c.report(fmt.Errorf("failed to forward response %s: %w", in.msg.Id, err))
+		return false
+	}
+
+	return forwarded
+}
+
+// receiveForwarded delivers a response forwarded by another instance
+func (c *Client) receiveForwarded(id string, data []byte) {
+	var m msgproto.Message
+
+	err := proto.Unmarshal(data, &m)
+	if err != nil {
+		c.report(fmt.Errorf("failed to decode forwarded response: %w", err))
+		return
+	}
+
+	registered, delivered := c.requests.sendJWS(id, &m, 0)
+
+	switch {
+	case !registered:
+		c.report(fmt.Errorf("received forwarded response for unknown request %s", id))
+	case !delivered:
+		c.report(fmt.Errorf("response buffer for conversation %s is full", id))
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sharedRequests is an in memory request store shared by several instances
+type sharedRequests struct {
+	owners    map[string]string
+	listeners map[string]func(string, []byte)
+	mu        sync.Mutex
+}
+
+type instanceRequests struct {
+	shared   *sharedRequests
+	instance string
+}
+
+func (s *instanceRequests) Register(id string) error {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	s.shared.owners[id] = s.instance
+	return nil
+}
+
+func (s *instanceRequests) Deregister(id string) error {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	if s.shared.owners[id] == s.instance {
+		delete(s.shared.owners, id)
+	}
+	return nil
+}
+
+func (s *instanceRequests) Forward(id string, data []byte) (bool, error) {
+	s.shared.mu.Lock()
+	owner, ok := s.shared.owners[id]
+	fn := s.shared.listeners[owner]
+	s.shared.mu.Unlock()
+
+	if !ok || owner == s.instance || fn == nil {
+		return false, nil
+	}
+
+	fn(id, data)
+
+	return true, nil
+}
+
+func (s *instanceRequests) Listen(fn func(string, []byte)) error {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	s.shared.listeners[s.instance] = fn
+	return nil
+}
+
+func TestClientSharedRequests(t *testing.T) {
+	sa := newServer()
+	defer sa.close()
+
+	sb := newServer()
+	defer sb.close()
+
+	shared := &sharedRequests{owners: make(map[string]string), listeners: make(map[string]func(string, []byte))}
+
+	a, err := New(sa.endpoint, "someID", "1", privkey, SharedRequests(&instanceRequests{shared, "a"}))
+	require.Nil(t, err)
+
+	b, err := New(sb.endpoint, "someID", "1", privkey, SharedRequests(&instanceRequests{shared, "b"}))
+	require.Nil(t, err)
+
+	ch := a.JWSResponses("123456")
+
+	// the response is received by the instance that did not make the request
+	response := `{"payload": "eyJjaWQiOiAiMTIzNDU2In0"}`
+	sb.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "tset", Recipient: "test", Ciphertext: []byte(response)}
+
+	select {
+	case m := <-ch:
+		assert.Equal(t, "1", m.Id)
+	case <-time.After(time.Second):
+		t.Fatal("response was not forwarded")
+	}
+
+	a.CloseJWSResponses("123456")
+
+	// once the request is closed, responses are received as normal
+	sb.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "2", Sender: "tset", Recipient: "test", Ciphertext: []byte(response)}
+
+	m, err := b.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "2", m.Id)
+}
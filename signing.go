@@ -0,0 +1,239 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Algorithm is a JOSE signature algorithm the client can sign with
+type Algorithm string
+
+const (
+	// EdDSA signs with an Ed25519 key. It is the default
+	EdDSA Algorithm = "EdDSA"
+	// ES256 signs with an ECDSA P-256 key and SHA-256
+	ES256 Algorithm = "ES256"
+	// RS256 signs with an RSA key using PKCS #1 v1.5 and SHA-256
+	RS256 Algorithm = "RS256"
+)
+
+// keySigner signs JWS payloads with a crypto.Signer, which lets keys
+// held in an HSM or KMS sign without exposing the private key
+type keySigner struct {
+	alg Algorithm
+	key crypto.Signer
+	kid string
+}
+
+func newKeySigner(alg Algorithm, key crypto.Signer, kid string) (*keySigner, error) {
+	if key == nil {
+		return nil, errors.New("signing key must not be nil")
+	}
+
+	var ok bool
+
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		ok = alg == EdDSA
+	case *ecdsa.PublicKey:
+		ok = alg == ES256 && pub.Curve == elliptic.P256()
+	case *rsa.PublicKey:
+		ok = alg == RS256
+	}
+
+	if !ok {
+		return nil, errors.New("signing key does not support algorithm " + string(alg))
+	}
+
+	return &keySigner{alg: alg, key: key, kid: kid}, nil
+}
+
+// Public returns the public key of the signing key. Its key id is
+// added to the kid header of the payloads it signs
+func (s *keySigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.key.Public(), KeyID: s.kid, Algorithm: string(s.alg), Use: "sig"}
+}
+
+// Algs returns the algorithm the key signs with
+func (s *keySigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{jose.SignatureAlgorithm(s.alg)}
+}
+
+// SignPayload signs a payload, converting the signature to its JWS encoding
+func (s *keySigner) SignPayload(payload []byte, alg jose.SignatureAlgorithm) ([]byte, error) {
+	if Algorithm(alg) != s.alg {
+		return nil, jose.ErrUnsupportedAlgorithm
+	}
+
+	if s.alg == EdDSA {
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(payload)
+
+	sig, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || s.alg != ES256 {
+		return sig, err
+	}
+
+	// crypto.Signer returns ecdsa signatures as ASN.1, but JWS uses r || s
+	var rs struct {
+		R, S *big.Int
+	}
+
+	_, err = asn1.Unmarshal(sig, &rs)
+	if err != nil {
+		return nil, err
+	}
+
+	if rs.R == nil || rs.S == nil || rs.R.BitLen() > 256 || rs.S.BitLen() > 256 {
+		return nil, errors.New("invalid ecdsa signature")
+	}
+
+	out := make([]byte, 64)
+	r, sv := rs.R.Bytes(), rs.S.Bytes()
+	copy(out[32-len(r):32], r)
+	copy(out[64-len(sv):], sv)
+
+	return out, nil
+}
+
+// ErrUnknownSigningKey is returned when selecting a signing key the client does not hold
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// defaultSigner returns the signer for the client's private key, or the key
+// set with the SigningKey option. The lock must be held
+func (c *Client) defaultSigner() (*keySigner, error) {
+	if c.signingKey != nil {
+		return &keySigner{alg: c.signingKey.alg, key: c.signingKey.key, kid: c.keyID}, nil
+	}
+
+	pks, err := base64.RawStdEncoding.DecodeString(c.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pks) != ed25519.SeedSize {
+		return nil, errors.New("invalid private key length")
+	}
+
+	return &keySigner{alg: EdDSA, key: ed25519.NewKeyFromSeed(pks), kid: c.keyID}, nil
+}
+
+// activeSigner returns the signer for the key selected with UseSigningKey
+func (c *Client) activeSigner() (*keySigner, error) {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+
+	if s, ok := c.signingKeys[c.activeKeyID]; ok && c.activeKeyID != c.keyID {
+		return s, nil
+	}
+
+	return c.defaultSigner()
+}
+
+// UseSigningKey selects the key payloads and tokens are signed with by its
+// key id. The client's own key is selected with the id set by the KeyID
+// option, and other keys are added with AddSigningKey. As with RotateKey,
+// the new key is used the next time the client authenticates
+func (c *Client) UseSigningKey(kid string) error {
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+
+	if _, ok := c.signingKeys[kid]; !ok && kid != c.keyID {
+		return ErrUnknownSigningKey
+	}
+
+	c.activeKeyID = kid
+
+	// sessions authenticated with the old key are not resumed
+	c.resumption.set("")
+
+	return nil
+}
+
+// PublicKeySet returns the public keys of every key the client holds as a
+// JSON web key set, so they can be advertised to recipients that need to
+// verify payloads signed with either key while it is rotated
+func (c *Client) PublicKeySet() ([]byte, error) {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+
+	var set jose.JSONWebKeySet
+
+	s, err := c.defaultSigner()
+	if err == nil {
+		set.Keys = append(set.Keys, *s.Public())
+	} else if len(c.signingKeys) == 0 {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(c.signingKeys))
+	for kid := range c.signingKeys {
+		kids = append(kids, kid)
+	}
+
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		set.Keys = append(set.Keys, *c.signingKeys[kid].Public())
+	}
+
+	return json.Marshal(set)
+}
+
+// ParsePublicKeySet parses a JSON web key set, such as one returned by
+// PublicKeySet, into keys that can be returned by a PublicKeyFunc. Payloads
+// with a kid header are only verified with the key that has the same id
+func ParsePublicKeySet(data []byte) ([]crypto.PublicKey, error) {
+	var set jose.JSONWebKeySet
+
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]crypto.PublicKey, 0, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if !k.Valid() || !k.IsPublic() {
+			return nil, errors.New("key set contains an invalid public key")
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// keyMatches returns false if a key has a different id to the kid header
+// of a payload. Keys without an id match any payload
+func keyMatches(key crypto.PublicKey, kid string) bool {
+	if kid == "" {
+		return true
+	}
+
+	switch k := key.(type) {
+	case jose.JSONWebKey:
+		return k.KeyID == "" || k.KeyID == kid
+	case *jose.JSONWebKey:
+		return k.KeyID == "" || k.KeyID == kid
+	}
+
+	return true
+}
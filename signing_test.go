@@ -0,0 +1,120 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestClientSigningKey(t *testing.T) {
+	eckey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	rsakey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	keys := map[Algorithm]crypto.Signer{
+		ES256: eckey,
+		RS256: rsakey,
+	}
+
+	for alg, key := range keys {
+		t.Run(string(alg), func(t *testing.T) {
+			pub := key.Public()
+
+			sc := newScriptedConn(acknowledge)
+
+			c, err := New("wss://scripted", "someID", "1", "", scriptedDialer(sc), SigningKey(alg, key), PublicKeys(func(selfID string) ([]crypto.PublicKey, error) {
+				return []crypto.PublicKey{pub}, nil
+			}))
+			require.Nil(t, err)
+			defer c.Close()
+
+			token, err := c.generateToken()
+			require.Nil(t, err)
+
+			jws, err := jose.ParseSigned(token)
+			require.Nil(t, err)
+			assert.Equal(t, string(alg), jws.Signatures[0].Header.Algorithm)
+
+			_, err = jws.Verify(pub)
+			require.Nil(t, err)
+
+			payload, err := c.Sign(map[string]string{"iss": "someID", "msg": "hello"})
+			require.Nil(t, err)
+
+			var v struct {
+				Msg string `json:"msg"`
+			}
+
+			_, err = c.DecodePayload(&msgproto.Message{Sender: "someID:1", Ciphertext: payload}, &v)
+			require.Nil(t, err)
+			assert.Equal(t, "hello", v.Msg)
+
+			sig, err := c.SignDetached([]byte("content"))
+			require.Nil(t, err)
+
+			_, err = c.VerifyDetached(sig, []byte("content"))
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestClientSigningKeyRotation(t *testing.T) {
+	newkey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	var set []crypto.PublicKey
+
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), KeyID("old"), AddSigningKey("new", ES256, newkey), PublicKeys(func(selfID string) ([]crypto.PublicKey, error) {
+		return set, nil
+	}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	data, err := c.PublicKeySet()
+	require.Nil(t, err)
+
+	set, err = ParsePublicKeySet(data)
+	require.Nil(t, err)
+	require.Len(t, set, 2)
+
+	claims := map[string]string{"iss": "someID"}
+
+	old, err := c.Sign(claims)
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrUnknownSigningKey, c.UseSigningKey("unknown"))
+	require.Nil(t, c.UseSigningKey("new"))
+
+	rotated, err := c.Sign(claims)
+	require.Nil(t, err)
+
+	for kid, payload := range map[string][]byte{"old": old, "new": rotated} {
+		jws, err := jose.ParseSigned(string(payload))
+		require.Nil(t, err)
+		assert.Equal(t, kid, jws.Signatures[0].Header.KeyID)
+
+		// both keys verify during the rotation window
+		_, err = c.DecodePayload(&msgproto.Message{Sender: "someID:1", Ciphertext: payload}, nil)
+		assert.Nil(t, err)
+	}
+
+	// a key is only tried for payloads with its id
+	set = set[:1]
+
+	_, err = c.DecodePayload(&msgproto.Message{Sender: "someID:1", Ciphertext: rotated}, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// minClockSkew is the smallest skew that will be compensated for. The
+// server's Date header only has a resolution of one second
+const minClockSkew = time.Second * 2
+
+// measureSkew derives the difference between the server's clock and ours
+// from the Date header of the websocket handshake response. The server's
+// time is compared against the midpoint of the handshake to account for
+// the round trip
+func (c *Client) measureSkew(resp *http.Response, started, finished time.Time) {
+	if resp == nil {
+		return
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	local := started.Add(finished.Sub(started) / 2)
+	skew := date.Sub(local)
+
+	if skew > -minClockSkew && skew < minClockSkew {
+		skew = 0
+	}
+
+	atomic.StoreInt64(&c.skew, int64(skew))
+}
+
+// ClockSkew returns the measured difference between the server's clock and
+// the client's. A positive skew means the server is ahead
+func (c *Client) ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.skew))
+}
+
+// serverNow returns the current time as the server sees it. It is used
+// when generating expiry claims that the server will validate
+func (c *Client) serverNow() time.Time {
+	return c.clock.Now().Add(c.ClockSkew())
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// standby keeps a second connection that replaces the current connection
+// when it fails, so reconnecting does not have to wait for a dial. It is
+// only authenticated once it is promoted, as the server allows one session
+// for each device
+type standby struct {
+	client  *Client
+	ready   *standbyConn
+	filling bool
+	closed  bool
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+func newStandby(c *Client) *standby {
+	return &standby{client: c, done: make(chan struct{})}
+}
+
+// fill establishes a standby connection in the background if there is none
+func (s *standby) fill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.ready != nil || s.filling {
+		return
+	}
+
+	s.filling = true
+
+	go s.client.labelled("standby", s.run)
+}
+
+func (s *standby) run() {
+	for {
+		ws, err := s.client.connect()
+		if err == nil {
+			// pongs on the standby connection say nothing about the current one
+			ws.SetPongHandler(func(string) error {
+				ws.SetReadDeadline(time.Now().Add(s.client.deadline))
+				return nil
+			})
+
+			sc := newStandbyConn(ws)
+
+			s.mu.Lock()
+			s.filling = false
+			closed := s.closed
+			if !closed {
+				s.ready = sc
+			}
+			s.mu.Unlock()
+
+			if closed {
+				sc.Close()
+				return
+			}
+
+			go s.keep(sc)
+			return
+		}
+
+		s.client.report(fmt.Errorf("standby connection failed: %w", err))
+
+		select {
+		case <-s.client.clock.After(s.client.retryInterval):
+		case <-s.done:
+			s.mu.Lock()
+			s.filling = false
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// keep pings the standby connection until it is promoted. If it fails, or
+// the server sends anything before it has authenticated, it is replaced
+// with a new one
+func (s *standby) keep(sc *standbyConn) {
+	defer close(sc.kept)
+
+	ping := s.client.clock.NewTicker(s.client.deadline / 2)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-sc.promoted:
+			return
+		case <-sc.frames:
+		case <-ping.Chan():
+			err := sc.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.client.deadline))
+			if err == nil {
+				continue
+			}
+		case <-sc.dead:
+		}
+
+		s.mu.Lock()
+		if s.ready == sc {
+			s.ready = nil
+		}
+		s.mu.Unlock()
+
+		sc.Close()
+		s.fill()
+
+		return
+	}
+}
+
+// take removes the standby connection so it can replace the current
+// connection. It returns nil if there is no healthy standby connection
+func (s *standby) take() transport {
+	s.mu.Lock()
+	sc := s.ready
+	s.ready = nil
+	s.mu.Unlock()
+
+	if sc == nil {
+		return nil
+	}
+
+	close(sc.promoted)
+	<-sc.kept
+
+	select {
+	case <-sc.dead:
+		sc.Close()
+		return nil
+	default:
+	}
+
+	return sc
+}
+
+// ok reports whether a standby connection is ready
+func (s *standby) ok() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ready != nil
+}
+
+// discard closes the standby connection, which is then replaced
+func (s *standby) discard() {
+	s.mu.Lock()
+	sc := s.ready
+	s.ready = nil
+	s.mu.Unlock()
+
+	if sc != nil {
+		sc.Close()
+	}
+}
+
+// close closes the standby connection and stops replacing it
+func (s *standby) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	sc := s.ready
+	s.ready = nil
+	s.mu.Unlock()
+
+	if sc != nil {
+		sc.Close()
+	}
+}
+
+// standbyConn reads frames from a connection in the background, so that
+// pings are answered before it is promoted and it can be handed over to
+// the client's reader without losing frames
+type standbyConn struct {
+	transport
+	frames   chan []byte
+	dead     chan struct{}
+	err      error
+	promoted chan struct{}
+	kept     chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newStandbyConn(ws transport) *standbyConn {
+	sc := &standbyConn{
+		transport: ws,
+		frames:    make(chan []byte, DefaultBufferSize),
+		dead:      make(chan struct{}),
+		promoted:  make(chan struct{}),
+		kept:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	go sc.pump()
+
+	return sc
+}
+
+func (sc *standbyConn) pump() {
+	for {
+		_, data, err := sc.transport.ReadMessage()
+		if err != nil {
+			sc.err = err
+			close(sc.dead)
+			return
+		}
+
+		select {
+		case sc.frames <- data:
+		case <-sc.closed:
+			return
+		}
+	}
+}
+
+// NextReader returns the next frame read from the connection
+func (sc *standbyConn) NextReader() (int, io.Reader, error) {
+	// frames read before the connection failed are returned first
+	select {
+	case data := <-sc.frames:
+		return websocket.BinaryMessage, bytes.NewReader(data), nil
+	default:
+	}
+
+	select {
+	case data := <-sc.frames:
+		return websocket.BinaryMessage, bytes.NewReader(data), nil
+	case <-sc.dead:
+		return 0, nil, sc.err
+	}
+}
+
+// ReadMessage reads the next frame
+func (sc *standbyConn) ReadMessage() (int, []byte, error) {
+	t, r, err := sc.NextReader()
+	if err != nil {
+		return t, nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+
+	return t, data, err
+}
+
+// Close closes the connection
+func (sc *standbyConn) Close() error {
+	sc.once.Do(func() {
+		close(sc.closed)
+	})
+
+	return sc.transport.Close()
+}
+
+// StandbyReady reports whether a standby connection is ready to replace
+// the current connection. It is always false without the StandbyConnection
+// option
+func (c *Client) StandbyReady() bool {
+	return c.standby != nil && c.standby.ok()
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// poll waits for cond to be true for up to a second
+func poll(t *testing.T, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		require.Less(t, i, 100, "condition was not met")
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// standbyOn reports whether the client's standby is the given connection
+func standbyOn(c *Client, sc *scriptedConn) bool {
+	c.standby.mu.Lock()
+	defer c.standby.mu.Unlock()
+
+	return c.standby.ready != nil && c.standby.ready.transport == sc
+}
+
+func TestClientStandbyFailover(t *testing.T) {
+	first := newScriptedConn(acknowledge)
+	second := newScriptedConn(acknowledge)
+	third := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(first, second, third), StandbyConnection(true), AutoReconnect(true), MaxRetries(3), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	poll(t, c.StandbyReady)
+
+	// the standby does not authenticate, so it can't replace the current session
+	assert.Equal(t, 1, first.frames(msgproto.MsgType_AUTH))
+	assert.Equal(t, 0, second.frames(msgproto.MsgType_AUTH))
+
+	first.fail(&websocket.CloseError{Code: websocket.CloseAbnormalClosure})
+
+	poll(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	})
+
+	// the standby was promoted and authenticated, and a new standby replaced it
+	poll(t, c.StandbyReady)
+	assert.Equal(t, 1, second.frames(msgproto.MsgType_AUTH))
+	assert.Equal(t, 0, third.frames(msgproto.MsgType_AUTH))
+
+	err = c.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	require.Nil(t, err)
+	assert.Equal(t, 1, second.frames(msgproto.MsgType_MSG))
+}
+
+func TestClientStandbyReplaced(t *testing.T) {
+	first := newScriptedConn(acknowledge)
+	second := newScriptedConn(acknowledge)
+	third := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(first, second, third), StandbyConnection(true), AutoReconnect(true), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	poll(t, c.StandbyReady)
+
+	second.fail(&websocket.CloseError{Code: websocket.CloseAbnormalClosure})
+
+	poll(t, func() bool {
+		return standbyOn(c, third)
+	})
+
+	assert.Equal(t, int64(0), c.Stats().Reconnects)
+	assert.False(t, c.IsClosed())
+}
+
+func TestClientStandbyUnexpectedFrame(t *testing.T) {
+	first := newScriptedConn(acknowledge)
+	second := newScriptedConn(acknowledge)
+	third := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(first, second, third), StandbyConnection(true), AutoReconnect(true), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	poll(t, c.StandbyReady)
+
+	// frames on a standby that has not authenticated are not delivered, and it is replaced
+	second.reply(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1"})
+
+	poll(t, func() bool {
+		return standbyOn(c, third)
+	})
+
+	select {
+	case m := <-c.ReceiveChan():
+		t.Fatalf("received %s from the standby connection", m.Id)
+	default:
+	}
+}
+
+func TestClientStandbyDisabled(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.False(t, c.StandbyReady())
+	assert.Equal(t, 1, sc.frames(msgproto.MsgType_AUTH))
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// stateVersion is the version of the exported state format
+const stateVersion = 1
+
+// state is the client state carried between processes
+type state struct {
+	Version  int       `json:"version"`
+	Offset   int64     `json:"offset,omitempty"`
+	Payloads []seenID  `json:"payloads,omitempty"`
+	Outbound [][]byte  `json:"outbound,omitempty"`
+	ACLRules []ACLRule `json:"acl_rules,omitempty"`
+}
+
+// seenID is a payload id remembered for replay protection
+type seenID struct {
+	Issuer string    `json:"iss"`
+	ID     string    `json:"id"`
+	Seen   time.Time `json:"seen"`
+}
+
+// advanceOffset records the offset of a received message
+func (c *Client) advanceOffset(offset int64) {
+	for {
+		current := atomic.LoadInt64(&c.offset)
+		if offset <= current || atomic.CompareAndSwapInt64(&c.offset, current, offset) {
+			return
+		}
+	}
+}
+
+// ExportState returns the client's state so that a new client can continue
+// where this one stopped, on this host or another. It includes the offset of
+// the last message received, the payload ids remembered for replay
+// protection, messages queued but not yet written and the cached ACL rules.
+// Close the client before exporting so no further messages are received
+func (c *Client) ExportState() ([]byte, error) {
+	st := state{
+		Version:  stateVersion,
+		Offset:   atomic.LoadInt64(&c.offset),
+		ACLRules: c.aclRules.get(),
+	}
+
+	if c.replays != nil {
+		st.Payloads = c.replays.export()
+	}
+
+	st.Outbound = c.queued.messages()
+
+	return json.Marshal(&st)
+}
+
+// ImportState restores state exported by another client. The messages that
+// were queued are sent, and an error is returned if any of them can't be
+// queued. The next connection resumes from the exported
+// offset. To resume the first connection, import the state with the
+// RestoreState option instead
+func (c *Client) ImportState(data []byte) error {
+	st, err := c.restore(data)
+	if err != nil {
+		return err
+	}
+
+	c.restored = st.Outbound
+
+	return c.sendRestored()
+}
+
+// sendRestored sends the queued messages from restored state, waiting for
+// room in the send queue. Messages that can't be queued are counted in the
+// returned error, while those that fail once sent are reported with OnError
+func (c *Client) sendRestored() error {
+	messages := c.restored
+	c.restored = nil
+
+	var failed int
+	var first error
+
+	for _, m := range messages {
+		em := &msgproto.Message{}
+
+		err := proto.Unmarshal(m, em)
+		if err == nil {
+			// queued messages have already been encrypted
+			err = c.queueAsync(em, time.Time{}, true, func(err error) {
+				if err != nil {
+					c.report(fmt.Errorf("failed to send restored message %s: %w", em.Id, err))
+				}
+			})
+		}
+
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to queue %d of %d restored messages: %w", failed, len(messages), first)
+	}
+
+	return nil
+}
+
+// restore restores everything but the queued messages, which are returned
+func (c *Client) restore(data []byte) (*state, error) {
+	var st state
+
+	err := json.Unmarshal(data, &st)
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", st.Version)
+	}
+
+	c.advanceOffset(st.Offset)
+
+	if len(st.ACLRules) > 0 {
+		c.aclRules.set(st.ACLRules)
+	}
+
+	if len(st.Payloads) > 0 {
+		if c.replays == nil {
+			return nil, errors.New("state has payload ids but replay protection is not enabled")
+		}
+		c.replays.restore(st.Payloads)
+	}
+
+	return &st, nil
+}
+
+// export returns the remembered payload ids, oldest first
+func (rc *replayCache) export() []seenID {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	ids := make([]seenID, 0, rc.order.Len())
+	for e := rc.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*replayEntry)
+		ids = append(ids, seenID{Issuer: entry.key.issuer, ID: entry.key.id, Seen: entry.seen})
+	}
+
+	return ids
+}
+
+// restore remembers payload ids seen by another client. Any message with
+// one of the ids is treated as a replay
+func (rc *replayCache) restore(ids []seenID) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, id := range ids {
+		key := replayKey{issuer: id.Issuer, id: id.ID}
+
+		if _, ok := rc.entries[key]; ok {
+			continue
+		}
+
+		rc.entries[key] = rc.order.PushFront(&replayEntry{key: key, seen: id.Seen})
+	}
+
+	for rc.order.Len() > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*replayEntry).key)
+	}
+}
+
+// messages returns copies of the queued messages, oldest first
+func (q *sendQueue) messages() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var queued []*request
+	for _, r := range q.requests {
+		if r.isMsg {
+			queued = append(queued, r)
+		}
+	}
+
+	sortRequests(queued)
+
+	messages := make([][]byte, len(queued))
+	for i, r := range queued {
+		messages[i] = append([]byte{}, r.buf.Bytes()...)
+	}
+
+	return messages
+}
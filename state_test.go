@@ -0,0 +1,100 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientExportImportState(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ReplayProtection(0, 0, nil))
+	require.Nil(t, err)
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "in", Sender: "test:1", Recipient: "someID:1", Offset: 5, Ciphertext: []byte("hi")}
+
+	_, err = c.Receive()
+	require.Nil(t, err)
+
+	exp := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	require.Nil(t, c.PermitSender("alice", exp))
+
+	require.Nil(t, c.replays.check(&Claims{Issuer: "test", ID: "seen"}, &msgproto.Message{}, time.Now()))
+
+	// a message still in the send buffer
+	buf, err := marshal(&msgproto.Message{Type: msgproto.MsgType_MSG, Id: "queued", Recipient: "test:1"})
+	require.Nil(t, err)
+	c.queued.add(&request{id: "queued", buf: buf, isMsg: true})
+
+	state, err := c.ExportState()
+	require.Nil(t, err)
+	c.Close()
+
+	received := make(chan msgproto.Message, 1)
+	go func() {
+		for m := range s.in {
+			received <- m
+		}
+	}()
+
+	c, err = New(s.endpoint, "someID", "1", privkey, ReplayProtection(0, 0, nil), RestoreState(state))
+	require.Nil(t, err)
+	defer c.Close()
+
+	s.mu.Lock()
+	assert.Equal(t, []uint64{0, 5}, s.offsets)
+	s.mu.Unlock()
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "queued", m.Id)
+	case <-time.After(time.Second):
+		t.Fatal("queued message was not sent")
+	}
+
+	assert.Equal(t, ErrReplayedPayload, c.replays.check(&Claims{Issuer: "test", ID: "seen"}, &msgproto.Message{}, time.Now()))
+	assert.Nil(t, c.replays.check(&Claims{Issuer: "other", ID: "seen"}, &msgproto.Message{}, time.Now()))
+	assert.Equal(t, []ACLRule{{Source: "alice", Expires: exp}}, c.CachedACLRules())
+}
+
+func TestClientImportStateQueueFull(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), SendBuffer(1))
+	require.Nil(t, err)
+	defer c.Close()
+
+	var st state
+	st.Version = stateVersion
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		data, err := proto.Marshal(&msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "someID:1", Recipient: "test:1"})
+		require.Nil(t, err)
+		st.Outbound = append(st.Outbound, data)
+	}
+
+	data, err := json.Marshal(&st)
+	require.Nil(t, err)
+
+	// restored messages wait for room in the send queue rather than being dropped
+	require.Nil(t, c.ImportState(data))
+
+	for i := 0; sc.frames(msgproto.MsgType_MSG) < 5; i++ {
+		require.Less(t, i, 100, "restored messages were not sent")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	c.Close()
+
+	err = c.ImportState(data)
+	assert.EqualError(t, err, "failed to queue 5 of 5 restored messages: connection is closed")
+}
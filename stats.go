@@ -0,0 +1,76 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the client's counters. Acks and Errors count the
+// server's responses to sent messages and ACL requests, while ACLResponses
+// counts ACL list responses. Uptime is the duration of the current connection
+// and is zero while the client is disconnected or reconnecting. Expired counts
+// received messages discarded because their payload had expired
+type Stats struct {
+	MessagesSent      int64
+	MessagesReceived  int64
+	Acks              int64
+	Errors            int64
+	ACLResponses      int64
+	Reconnects        int64
+	Dropped           int64
+	Expired           int64
+	Resumed           int64
+	PendingRequests   int
+	SendQueueDepth    int
+	ReceiveQueueDepth int
+	BytesIn           int64
+	BytesOut          int64
+	Uptime            time.Duration
+}
+
+// counters tracks client activity. all fields must be accessed atomically
+type counters struct {
+	sent        int64
+	received    int64
+	acks        int64
+	errors      int64
+	acls        int64
+	reconnects  int64
+	dropped     int64
+	expired     int64
+	resumed     int64
+	bytesIn     int64
+	bytesOut    int64
+	connectedAt int64
+	lastFrame   int64
+	lastPong    int64
+}
+
+// Stats returns a snapshot of the client's counters
+func (c *Client) Stats() Stats {
+	s := Stats{
+		MessagesSent:      atomic.LoadInt64(&c.counters.sent),
+		MessagesReceived:  atomic.LoadInt64(&c.counters.received),
+		Acks:              atomic.LoadInt64(&c.counters.acks),
+		Errors:            atomic.LoadInt64(&c.counters.errors),
+		ACLResponses:      atomic.LoadInt64(&c.counters.acls),
+		Reconnects:        atomic.LoadInt64(&c.counters.reconnects),
+		Dropped:           atomic.LoadInt64(&c.counters.dropped),
+		Expired:           atomic.LoadInt64(&c.counters.expired),
+		Resumed:           atomic.LoadInt64(&c.counters.resumed),
+		PendingRequests:   c.requests.pending(),
+		SendQueueDepth:    c.SendQueueDepth(),
+		ReceiveQueueDepth: len(c.recv),
+		BytesIn:           atomic.LoadInt64(&c.counters.bytesIn),
+		BytesOut:          atomic.LoadInt64(&c.counters.bytesOut),
+	}
+
+	connectedAt := atomic.LoadInt64(&c.counters.connectedAt)
+	if connectedAt > 0 && !c.IsClosed() {
+		s.Uptime = c.clock.Now().Sub(time.Unix(0, connectedAt))
+	}
+
+	return s
+}
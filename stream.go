@@ -0,0 +1,391 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StreamPrefix prefixes the typ claim of stream frames
+	StreamPrefix = "stream."
+	// DefaultStreamWindow is the number of frames that can be sent before
+	// the receiver acknowledges them
+	DefaultStreamWindow = 16
+	// DefaultStreamChunkSize is the maximum amount of data sent in a frame
+	DefaultStreamChunkSize = 16 * 1024
+)
+
+const (
+	streamOpen  = StreamPrefix + "open"
+	streamData  = StreamPrefix + "data"
+	streamAck   = StreamPrefix + "ack"
+	streamClose = StreamPrefix + "close"
+)
+
+// Stream is an ordered stream of data to another identity, framed into
+// messages. Writes block while the receiver has a full window of
+// unacknowledged frames, and reads return data in the order it was written.
+// Reads fail if a frame is missing for longer than the request timeout
+type Stream struct {
+	id       string
+	peer     string
+	client   *Client
+	mu       sync.Mutex
+	wmu      sync.Mutex // held while writing so a failed frame can be unsent
+	sent     int64
+	acked    int64
+	received int64
+	ackedIn  int64
+	final    int64
+	pending  map[int64][]byte
+	buf      []byte
+	closed   bool
+	readable chan struct{}
+	writable chan struct{}
+}
+
+// streams tracks the client's open streams by id
+type streams struct {
+	open   map[string]*Stream
+	accept chan *Stream
+	mu     sync.Mutex
+}
+
+type streamFrame struct {
+	Seq  int64  `json:"seq"`
+	Data []byte `json:"data,omitempty"`
+}
+
+func newStreams() *streams {
+	return &streams{
+		open:   make(map[string]*Stream),
+		accept: make(chan *Stream, DefaultBufferSize),
+	}
+}
+
+func (c *Client) newStream(id, peer string) *Stream {
+	s := &Stream{
+		id:       id,
+		peer:     peer,
+		client:   c,
+		final:    -1,
+		pending:  make(map[int64][]byte),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+
+	c.streams.mu.Lock()
+	c.streams.open[id] = s
+	c.streams.mu.Unlock()
+
+	return s
+}
+
+// OpenStream opens a stream to recipient, formatted as selfID:deviceID
+func (c *Client) OpenStream(recipient string) (io.ReadWriteCloser, error) {
+	s := c.newStream(c.NewID(), recipient)
+
+	err := s.send(streamOpen, streamFrame{}, false)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// AcceptStream waits for another identity to open a stream to the client
+func (c *Client) AcceptStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	select {
+	case s := <-c.streams.accept:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Write frames p into messages, blocking while the send window is full
+func (s *Stream) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	var n int
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > DefaultStreamChunkSize {
+			chunk = chunk[:DefaultStreamChunkSize]
+		}
+
+		seq, err := s.reserve()
+		if err != nil {
+			return n, err
+		}
+
+		err = s.send(streamData, streamFrame{Seq: seq, Data: chunk}, false)
+		if err != nil {
+			// the frame is sent again with the same sequence number by the
+			// next write, so the other side doesn't wait for it
+			s.mu.Lock()
+			s.sent--
+			s.mu.Unlock()
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// reserve waits for room in the send window and returns the next sequence number
+func (s *Stream) reserve() (int64, error) {
+	for {
+		s.mu.Lock()
+
+		if s.closed {
+			s.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+
+		if s.sent-s.acked < DefaultStreamWindow {
+			seq := s.sent
+			s.sent++
+			s.mu.Unlock()
+			return seq, nil
+		}
+
+		s.mu.Unlock()
+
+		select {
+		case <-s.writable:
+		case <-s.client.clock.After(s.client.timeout):
+			return 0, errors.New("stream write timed out")
+		}
+	}
+}
+
+// Read reads data in the order it was written. It returns io.EOF once the
+// other side has closed the stream and all of its data has been read
+func (s *Stream) Read(p []byte) (int, error) {
+	var gap <-chan time.Time
+	var waiting int64
+
+	for {
+		s.mu.Lock()
+
+		if s.closed {
+			s.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+
+		if len(s.buf) > 0 {
+			n := copy(p, s.buf)
+			s.buf = s.buf[n:]
+
+			var ack int64 = -1
+			if len(s.buf) == 0 && s.received > s.ackedIn {
+				s.ackedIn = s.received
+				ack = s.received
+			}
+
+			s.mu.Unlock()
+
+			if ack >= 0 {
+				s.send(streamAck, streamFrame{Seq: ack}, true)
+			}
+
+			return n, nil
+		}
+
+		if s.final >= 0 && s.received >= s.final {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+
+		// later frames or the end of the stream have been received, so the
+		// next frame is missing rather than not yet sent
+		missing := len(s.pending) > 0 || s.final >= 0
+		received := s.received
+
+		s.mu.Unlock()
+
+		switch {
+		case !missing:
+			gap = nil
+		case gap == nil || waiting != received:
+			gap = s.client.clock.After(s.client.timeout)
+			waiting = received
+		}
+
+		select {
+		case <-s.readable:
+		case <-gap:
+			return 0, fmt.Errorf("stream frame %d was not received", received)
+		}
+	}
+}
+
+// Close tells the other side no more data will be written and releases the stream
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.release()
+	notify(s.readable)
+	notify(s.writable)
+
+	// wait for a write in progress, which may unsend its last frame
+	s.wmu.Lock()
+	s.mu.Lock()
+	final := s.sent
+	s.mu.Unlock()
+	s.wmu.Unlock()
+
+	return s.send(streamClose, streamFrame{Seq: final}, false)
+}
+
+func (s *Stream) release() {
+	s.client.streams.mu.Lock()
+	delete(s.client.streams.open, s.id)
+	s.client.streams.mu.Unlock()
+}
+
+func (s *Stream) send(typ string, f streamFrame, async bool) error {
+	payload, err := s.client.Sign(map[string]interface{}{
+		"typ":  typ,
+		"jti":  s.client.NewID(),
+		"cid":  s.id,
+		"iss":  s.client.selfID,
+		"iat":  s.client.serverNow().Unix(),
+		"seq":  f.Seq,
+		"data": f.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	m := s.client.RequestMessage(s.peer, payload)
+
+	if async {
+		s.client.SendAsync(m, func(err error) {
+			if err != nil {
+				s.client.report(fmt.Errorf("failed to acknowledge stream %s: %w", s.id, err))
+			}
+		})
+		return nil
+	}
+
+	return s.client.Send(m)
+}
+
+// receive adds a data frame to the stream, moving any frames that are now
+// in order into the read buffer
+func (s *Stream) receive(f *streamFrame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f.Seq < s.received {
+		return nil
+	}
+
+	if f.Seq >= s.ackedIn+DefaultStreamWindow {
+		return errors.New("frame is outside of the receive window")
+	}
+
+	s.pending[f.Seq] = f.Data
+
+	for {
+		data, ok := s.pending[s.received]
+		if !ok {
+			break
+		}
+
+		delete(s.pending, s.received)
+		s.buf = append(s.buf, data...)
+		s.received++
+	}
+
+	notify(s.readable)
+
+	return nil
+}
+
+// deliverStream routes a received stream frame to its stream. It returns
+// false if the message is not a stream frame
+func (c *Client) deliverStream(in *inbound) bool {
+	if in.env == nil || !strings.HasPrefix(in.env.typ, StreamPrefix) {
+		return false
+	}
+
+	var f streamFrame
+
+	claims, err := c.DecodePayload(in.msg, &f)
+	if err == nil && claims.ConversationID == "" {
+		err = errors.New("frame has no stream id")
+	}
+
+	if err != nil {
+		c.dropped(in.msg.Id, fmt.Errorf("dropped stream frame: %w", err))
+		return true
+	}
+
+	c.streams.mu.Lock()
+	s, ok := c.streams.open[claims.ConversationID]
+	c.streams.mu.Unlock()
+
+	switch {
+	case claims.Type == streamOpen && !ok:
+		s = c.newStream(claims.ConversationID, in.msg.Sender)
+		select {
+		case c.streams.accept <- s:
+		default:
+			s.release()
+			err = errors.New("too many streams waiting to be accepted")
+		}
+	case !ok:
+		err = errors.New("stream is not open")
+	case s.peer != in.msg.Sender:
+		err = errors.New("frame was not sent by the stream's peer")
+	case claims.Type == streamData:
+		err = s.receive(&f)
+	case claims.Type == streamAck:
+		s.mu.Lock()
+		if f.Seq > s.acked {
+			s.acked = f.Seq
+		}
+		s.mu.Unlock()
+		notify(s.writable)
+	case claims.Type == streamClose:
+		s.mu.Lock()
+		s.final = f.Seq
+		s.mu.Unlock()
+		notify(s.readable)
+	}
+
+	if err != nil {
+		c.dropped(in.msg.Id, fmt.Errorf("dropped stream frame: %w", err))
+	}
+
+	return true
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStreamFrame(typ, id string, seq int64, data []byte) *msgproto.Message {
+	return &msgproto.Message{
+		Type:      msgproto.MsgType_MSG,
+		Sender:    "peer:1",
+		Recipient: "someID:1",
+		Ciphertext: testSignedPayload(privkey, map[string]interface{}{
+			"typ":  typ,
+			"iss":  "peer",
+			"cid":  id,
+			"seq":  seq,
+			"data": data,
+		}),
+	}
+}
+
+func testStreamClient(t *testing.T, s *testserver) *Client {
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys))
+	require.Nil(t, err)
+
+	return c
+}
+
+func TestClientOpenStream(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c := testStreamClient(t, s)
+
+	received := make(chan []byte, 1)
+
+	go func() {
+		var data []byte
+
+		for {
+			m := <-s.in
+
+			var f struct {
+				Type string `json:"typ"`
+				ID   string `json:"cid"`
+				streamFrame
+			}
+
+			env, err := parseEnvelope(m.Ciphertext)
+			require.Nil(t, err)
+			require.Nil(t, json.Unmarshal(env.payload, &f))
+
+			switch f.Type {
+			case streamData:
+				data = append(data, f.Data...)
+				s.out <- testStreamFrame(streamAck, f.ID, f.Seq+1, nil)
+			case streamClose:
+				received <- data
+				return
+			}
+		}
+	}()
+
+	st, err := c.OpenStream("peer:1")
+	require.Nil(t, err)
+
+	data := make([]byte, DefaultStreamChunkSize*DefaultStreamWindow*2+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	n, err := st.Write(data)
+	require.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	require.Nil(t, st.Close())
+
+	select {
+	case r := <-received:
+		assert.Equal(t, data, r)
+	case <-time.After(time.Second * 5):
+		t.Fatal("stream was not received")
+	}
+}
+
+func TestClientAcceptStream(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	c := testStreamClient(t, s)
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	s.out <- testStreamFrame(streamOpen, "s1", 0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	st, err := c.AcceptStream(ctx)
+	require.Nil(t, err)
+
+	// frames are reassembled in order
+	s.out <- testStreamFrame(streamData, "s1", 1, []byte(" world"))
+	s.out <- testStreamFrame(streamData, "s1", 0, []byte("hello"))
+	s.out <- testStreamFrame(streamClose, "s1", 2, nil)
+
+	data, err := ioutil.ReadAll(st)
+	require.Nil(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestClientStreamWriteFailure(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	var failed int32
+
+	fail := func(claims map[string]interface{}) error {
+		if claims["typ"] == streamData && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, InterceptOutbound(fail))
+	require.Nil(t, err)
+	defer c.Close()
+
+	seqs := make(chan int64, 2)
+
+	go func() {
+		for m := range s.in {
+			var f struct {
+				Type string `json:"typ"`
+				streamFrame
+			}
+
+			env, err := parseEnvelope(m.Ciphertext)
+			if err != nil || json.Unmarshal(env.payload, &f) != nil {
+				continue
+			}
+
+			if f.Type != streamOpen {
+				seqs <- f.Seq
+			}
+		}
+	}()
+
+	st, err := c.OpenStream("peer:1")
+	require.Nil(t, err)
+
+	_, err = st.Write([]byte("hello"))
+	require.NotNil(t, err)
+
+	// the frame that failed to send is sent again with the same sequence number
+	_, err = st.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Nil(t, st.Close())
+
+	for _, seq := range []int64{0, 1} {
+		select {
+		case got := <-seqs:
+			assert.Equal(t, seq, got)
+		case <-time.After(time.Second):
+			t.Fatal("stream frame was not sent")
+		}
+	}
+}
+
+func TestClientStreamMissingFrame(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys), RequestTimeout(time.Millisecond*50))
+	require.Nil(t, err)
+	defer c.Close()
+
+	go func() {
+		for range s.in {
+		}
+	}()
+
+	s.out <- testStreamFrame(streamOpen, "s1", 0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	st, err := c.AcceptStream(ctx)
+	require.Nil(t, err)
+
+	s.out <- testStreamFrame(streamData, "s1", 1, []byte("world"))
+
+	// reads don't wait forever for a frame that was never sent
+	_, err = st.Read(make([]byte, 16))
+	assert.EqualError(t, err, "stream frame 0 was not received")
+}
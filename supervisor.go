@@ -0,0 +1,56 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is reported when the reader or writer panics
+type PanicError struct {
+	Routine string
+	Value   interface{}
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v", e.Routine, e.Value)
+}
+
+// supervise runs one of the connection's goroutines, recovering from any
+// panic. A panic tears the connection down and, if enabled, reconnects,
+// as the connection's state can no longer be trusted
+func (c *Client) supervise(routine string, conn *connection, fn func()) {
+	go func() {
+		defer func() {
+			r := recover()
+			if routine == "reader" {
+				close(conn.readerDone)
+			}
+
+			if r == nil {
+				return
+			}
+
+			err := &PanicError{Routine: routine, Value: r, Stack: debug.Stack()}
+			c.report(err)
+
+			if !c.teardown(conn, err) {
+				return
+			}
+
+			if routine != "reader" {
+				// wait for the reader to exit so it can't race the new connection
+				select {
+				case <-conn.readerDone:
+				case <-c.clock.After(c.deadline):
+				}
+			}
+
+			c.tryReconnect(err)
+		}()
+
+		c.labelled(routine, fn)
+	}()
+}
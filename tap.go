@@ -0,0 +1,72 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+const (
+	// FrameInbound is the direction of frames read from the connection
+	FrameInbound = "in"
+	// FrameOutbound is the direction of frames written to the connection
+	FrameOutbound = "out"
+)
+
+// Frame is a raw websocket frame mirrored by the FrameTap option
+type Frame struct {
+	Time      time.Time
+	Direction string
+	Type      msgproto.MsgType
+	ID        string
+	Data      []byte
+	// Err is set if the frame's header could not be decoded
+	Err error
+}
+
+// TapWriter returns a frame tap that writes a line describing each frame,
+// followed by a hex dump of its contents, to w
+func TapWriter(w io.Writer) func(*Frame) {
+	var mu sync.Mutex
+
+	return func(f *Frame) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if f.Err != nil {
+			fmt.Fprintf(w, "%s %s undecodable frame (%d bytes): %v\n", f.Time.Format(time.RFC3339Nano), f.Direction, len(f.Data), f.Err)
+		} else {
+			fmt.Fprintf(w, "%s %s %s %s (%d bytes)\n", f.Time.Format(time.RFC3339Nano), f.Direction, f.Type, f.ID, len(f.Data))
+		}
+
+		fmt.Fprint(w, hex.Dump(f.Data))
+	}
+}
+
+// tap mirrors a raw frame to the frame tap, if one is configured
+func (c *Client) tap(direction string, data []byte) {
+	if c.frameTap == nil {
+		return
+	}
+
+	var hdr msgproto.Header
+
+	f := &Frame{
+		Time:      c.clock.Now(),
+		Direction: direction,
+		Data:      append([]byte(nil), data...),
+	}
+
+	f.Err = proto.Unmarshal(data, &hdr)
+	f.Type = hdr.Type
+	f.ID = hdr.Id
+
+	c.frameTap(f)
+}
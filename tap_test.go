@@ -0,0 +1,65 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientFrameTap(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	frames := make(chan *Frame, 16)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, FrameTap(func(f *Frame) {
+		frames <- f
+	}))
+	require.Nil(t, err)
+
+	auth := <-frames
+	assert.Equal(t, FrameOutbound, auth.Direction)
+	assert.Equal(t, msgproto.MsgType_AUTH, auth.Type)
+
+	ack := <-frames
+	assert.Equal(t, FrameInbound, ack.Direction)
+	assert.Equal(t, msgproto.MsgType_ACK, ack.Type)
+	assert.Equal(t, auth.ID, ack.ID)
+
+	go func() {
+		<-s.in
+	}()
+
+	err = c.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "other:1"})
+	require.Nil(t, err)
+
+	var seen []string
+
+	timeout := time.After(time.Second)
+
+	for len(seen) < 2 {
+		select {
+		case f := <-frames:
+			seen = append(seen, f.Direction+" "+f.Type.String()+" "+f.ID)
+		case <-timeout:
+			t.Fatal("frames were not tapped")
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"out MSG 1", "in ACK 1"}, seen)
+}
+
+func TestTapWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	TapWriter(&buf)(&Frame{Direction: FrameInbound, Type: msgproto.MsgType_ACK, ID: "1", Data: []byte{1, 2}})
+
+	assert.Contains(t, buf.String(), "in ACK 1 (2 bytes)")
+	assert.Contains(t, buf.String(), "01 02")
+}
@@ -11,24 +11,34 @@ import (
 
 var NtpServer = "time.google.com"
 
+const (
+	ntpSyncInterval  = time.Hour
+	ntpRetryInterval = time.Minute
+	ntpTimeout       = time.Second * 5
+)
+
 // Time contains information about the NTP server
 type Time struct {
 	lastCheck int64
 	ntpOffset int64
+	syncing   int32
 }
 
+// NewTime creates a time source corrected by NTP. The offset is synchronised
+// in the background, so the system time is used until the first sync completes
 func NewTime() *Time {
-	t := Time{}
-	t.syncNTP()
-	return &t
+	return &Time{}
 }
 
+// Now returns the current time, starting a background sync if the offset is stale
 func (c *Time) Now() time.Time {
 	now := time.Now().Add(c.timeOffset())
 
-	if now.After(c.lastChecked().Add(1 * time.Hour)) {
-		c.syncNTP()
-		now = time.Now().Add(c.timeOffset())
+	if now.After(c.lastChecked().Add(ntpSyncInterval)) && atomic.CompareAndSwapInt32(&c.syncing, 0, 1) {
+		go func() {
+			c.syncNTP()
+			atomic.StoreInt32(&c.syncing, 0)
+		}()
 	}
 
 	return now
@@ -43,8 +53,10 @@ func (c *Time) lastChecked() time.Time {
 }
 
 func (c *Time) syncNTP() error {
-	response, err := ntp.Query(NtpServer)
+	response, err := ntp.QueryWithOptions(NtpServer, ntp.QueryOptions{Timeout: ntpTimeout})
 	if err != nil {
+		// retry sooner than a successful sync would
+		atomic.StoreInt64(&c.lastCheck, time.Now().Add(ntpRetryInterval-ntpSyncInterval).Unix())
 		return err
 	}
 
@@ -0,0 +1,72 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// InboundTransform is a stage that transforms a received message before it
+// is delivered, such as by decrypting or decompressing its payload, or
+// rewriting payloads from senders still using an old format. Stages run in
+// the order they were added, after the client's cipher has decrypted the
+// message and before it is routed, validated or delivered, so they run
+// before any signature is verified. A stage that returns an error drops
+// the message
+type InboundTransform func(m *msgproto.Message) error
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// GunzipPayload returns a stage that decompresses gzipped payloads.
+// Payloads larger than limit once decompressed are rejected, unless limit
+// is zero. Payloads that are not gzipped are left as they are
+func GunzipPayload(limit int64) InboundTransform {
+	return func(m *msgproto.Message) error {
+		if !bytes.HasPrefix(m.Ciphertext, gzipMagic) {
+			return nil
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(m.Ciphertext))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		var r io.Reader = zr
+		if limit > 0 {
+			r = io.LimitReader(zr, limit+1)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if limit > 0 && int64(len(data)) > limit {
+			return errors.New("decompressed payload is too large")
+		}
+
+		m.Ciphertext = data
+
+		return nil
+	}
+}
+
+// transform runs the inbound transform stages over a received message
+func (c *Client) transform(m *msgproto.Message) error {
+	for _, fn := range c.transforms {
+		err := fn(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestGunzipPayload(t *testing.T) {
+	m := &msgproto.Message{Ciphertext: gzipped([]byte("hello"))}
+	require.Nil(t, GunzipPayload(5)(m))
+	assert.Equal(t, []byte("hello"), m.Ciphertext)
+
+	// payloads that are not compressed are left as they are
+	require.Nil(t, GunzipPayload(5)(m))
+	assert.Equal(t, []byte("hello"), m.Ciphertext)
+
+	m = &msgproto.Message{Ciphertext: gzipped([]byte("hello world"))}
+	assert.NotNil(t, GunzipPayload(5)(m))
+}
+
+func TestClientTransformInbound(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	upgrade := func(m *msgproto.Message) error {
+		if bytes.Equal(m.Ciphertext, []byte("reject")) {
+			return errors.New("unsupported payload")
+		}
+		m.Ciphertext = bytes.Replace(m.Ciphertext, []byte("v1"), []byte("v2"), 1)
+		return nil
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, TransformInbound(GunzipPayload(0), upgrade))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	s.out <- &msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1", Ciphertext: gzipped([]byte("reject"))}
+
+	e, ok := nextEvent(t, c).(MessageDropped)
+	require.True(t, ok)
+	assert.Equal(t, "1", e.ID)
+
+	s.out <- &msgproto.Message{Id: "2", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1", Ciphertext: gzipped([]byte("payload v1"))}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "2", m.Id)
+	assert.Equal(t, []byte("payload v2"), m.Ciphertext)
+}
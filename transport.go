@@ -0,0 +1,352 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialOptions configures how connections to the server are opened
+type dialOptions struct {
+	tls      *tls.Config
+	eyeballs *happyEyeballs
+	proxy    *url.URL
+	resolver Resolver
+}
+
+// proxyFunc returns the proxy to connect through. Without a configured
+// proxy, the proxy is read from HTTPS_PROXY and the other variables used by
+// net/http, falling back to a socks5 proxy in ALL_PROXY
+func (o *dialOptions) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if o.proxy != nil {
+		return http.ProxyURL(o.proxy)
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := http.ProxyFromEnvironment(req)
+		if u != nil || err != nil {
+			return u, err
+		}
+
+		return allProxy()
+	}
+}
+
+// allProxy returns the socks5 proxy configured with ALL_PROXY, if any
+func allProxy() (*url.URL, error) {
+	v := os.Getenv("ALL_PROXY")
+	if v == "" {
+		v = os.Getenv("all_proxy")
+	}
+
+	if v == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, nil
+	}
+
+	return u, nil
+}
+
+// netDial returns the function used to open network connections, or nil
+// to use the default dialer
+func (o *dialOptions) netDial() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o.eyeballs != nil {
+		he := *o.eyeballs
+		if o.resolver != nil {
+			he.lookup = o.resolver.LookupIPAddr
+		}
+		return he.DialContext
+	}
+
+	if o.resolver != nil {
+		// addresses are tried one at a time, moving on when an attempt fails or times out
+		he := &happyEyeballs{
+			attemptDelay:   DefaultAddressTimeout,
+			addressTimeout: DefaultAddressTimeout,
+			lookup:         o.resolver.LookupIPAddr,
+		}
+		return he.DialContext
+	}
+
+	return nil
+}
+
+// unixEndpoint splits a unix:// endpoint into the path of its socket and
+// the websocket URL used for the handshake. The handshake path defaults to
+// "/" and can be set with the path query parameter, as in
+// unix:///run/self/messaging.sock?path=/v1/messaging
+func unixEndpoint(endpoint string) (string, string, bool, error) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return "", "", false, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", true, err
+	}
+
+	socket := u.Path
+	if u.Host != "" {
+		socket = u.Host + u.Path
+	}
+
+	if socket == "" {
+		return "", "", true, errors.New("unix endpoint has no socket path")
+	}
+
+	path := u.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return socket, "ws://localhost" + path, true, nil
+}
+
+// httpClient returns the client used for long polling
+func (o *dialOptions) httpClient() *http.Client {
+	if o.tls == nil && o.eyeballs == nil && o.resolver == nil && o.proxy == nil {
+		return http.DefaultClient
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = o.tls
+	t.Proxy = o.proxyFunc()
+
+	if dial := o.netDial(); dial != nil {
+		t.DialContext = dial
+	}
+
+	return &http.Client{Transport: t}
+}
+
+// transport is a connection to the messaging server that exchanges binary
+// frames. It is satisfied by *websocket.Conn, and lets tests drive the
+// reader, writer and reconnects with scripted connections
+type transport interface {
+	NextReader() (int, io.Reader, error)
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+// dialFunc opens a transport to the server, returning the handshake
+// response if there was one
+type dialFunc func() (transport, *http.Response, error)
+
+// dialTransport opens a websocket to the server, unless a dialer has been
+// set to open connections in its place
+func (c *Client) dialTransport() (transport, *http.Response, error) {
+	if c.dialer != nil {
+		return c.dialer()
+	}
+
+	return dialWebsocket(c.endpoint, handshakeHeader(), &c.dialOptions)
+}
+
+// frameQueue implements the reading side of a transport whose frames are
+// received by another goroutine
+type frameQueue struct {
+	frames   chan []byte
+	errs     chan error
+	done     chan struct{}
+	closed   sync.Once
+	closeErr *websocket.CloseError
+	deadline time.Time
+	limit    int64
+	pong     func(string) error
+	mu       sync.Mutex
+}
+
+func newFrameQueue(deadline time.Time) *frameQueue {
+	return &frameQueue{
+		frames:   make(chan []byte, DefaultBufferSize),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		deadline: deadline,
+	}
+}
+
+// push queues a frame, failing if it exceeds the read limit or the queue is closed
+func (q *frameQueue) push(frame []byte) error {
+	if !q.allowed(int64(len(frame))) {
+		return websocket.ErrReadLimit
+	}
+
+	select {
+	case q.frames <- frame:
+		return nil
+	case <-q.done:
+		return errors.New("session closed")
+	}
+}
+
+// allowed reports whether a frame of n bytes is within the read limit
+func (q *frameQueue) allowed(n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.limit <= 0 || n <= q.limit
+}
+
+// fail makes the next read return err
+func (q *frameQueue) fail(err error) {
+	select {
+	case q.errs <- err:
+	default:
+	}
+}
+
+// closeWith closes the queue, making reads return the close error
+func (q *frameQueue) closeWith(ce *websocket.CloseError) {
+	q.mu.Lock()
+	if q.closeErr == nil {
+		q.closeErr = ce
+	}
+	q.mu.Unlock()
+
+	q.shutdown()
+}
+
+func (q *frameQueue) shutdown() {
+	q.closed.Do(func() {
+		close(q.done)
+	})
+}
+
+// ping emulates a ping that is answered while the queue is open
+func (q *frameQueue) ping() error {
+	select {
+	case <-q.done:
+		return errors.New("session closed")
+	default:
+	}
+
+	return q.ponged()
+}
+
+// ponged calls the pong handler as if the server had answered a ping
+func (q *frameQueue) ponged() error {
+	q.mu.Lock()
+	pong := q.pong
+	q.mu.Unlock()
+
+	if pong != nil {
+		return pong("")
+	}
+
+	return nil
+}
+
+// NextReader waits for the next frame until the read deadline
+func (q *frameQueue) NextReader() (int, io.Reader, error) {
+	for {
+		q.mu.Lock()
+		wait := time.Until(q.deadline)
+		q.mu.Unlock()
+
+		if wait <= 0 {
+			return 0, nil, errors.New("read timed out")
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case frame := <-q.frames:
+			timer.Stop()
+			return websocket.BinaryMessage, bytes.NewReader(frame), nil
+		case err := <-q.errs:
+			timer.Stop()
+			return 0, nil, err
+		case <-q.done:
+			timer.Stop()
+
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			if q.closeErr != nil {
+				return 0, nil, q.closeErr
+			}
+
+			return 0, nil, errors.New("session closed")
+		case <-timer.C:
+			// the deadline may have been extended while waiting
+		}
+	}
+}
+
+// ReadMessage reads the next frame
+func (q *frameQueue) ReadMessage() (int, []byte, error) {
+	t, r, err := q.NextReader()
+	if err != nil {
+		return t, nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+
+	return t, data, err
+}
+
+// SetReadDeadline sets the time NextReader waits for a frame until
+func (q *frameQueue) SetReadDeadline(t time.Time) error {
+	q.mu.Lock()
+	q.deadline = t
+	q.mu.Unlock()
+	return nil
+}
+
+// SetReadLimit sets the maximum size of a frame
+func (q *frameQueue) SetReadLimit(limit int64) {
+	q.mu.Lock()
+	q.limit = limit
+	q.mu.Unlock()
+}
+
+// SetPongHandler sets the handler called when a ping succeeds
+func (q *frameQueue) SetPongHandler(h func(appData string) error) {
+	q.mu.Lock()
+	q.pong = h
+	q.mu.Unlock()
+}
+
+// closeError decodes the payload of a close frame
+func closeError(data []byte) *websocket.CloseError {
+	ce := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+
+	if len(data) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(data))
+		ce.Text = string(data[2:])
+	}
+
+	return ce
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedConn is a transport driven by a test instead of a server. Frames
+// written by the client are passed to respond, which can queue frames for
+// the client to read or return an error to fail the write
+type scriptedConn struct {
+	*frameQueue
+	respond func(sc *scriptedConn, hdr *msgproto.Header) error
+	written []msgproto.Header
+	mu      sync.Mutex
+}
+
+func newScriptedConn(respond func(sc *scriptedConn, hdr *msgproto.Header) error) *scriptedConn {
+	return &scriptedConn{
+		frameQueue: newFrameQueue(time.Now().Add(time.Minute)),
+		respond:    respond,
+	}
+}
+
+// acknowledge answers every frame with an ACK
+func acknowledge(sc *scriptedConn, hdr *msgproto.Header) error {
+	sc.reply(&msgproto.Notification{Type: msgproto.MsgType_ACK, Id: hdr.Id})
+	return nil
+}
+
+func (sc *scriptedConn) reply(m proto.Message) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+
+	sc.push(data)
+}
+
+func (sc *scriptedConn) WriteMessage(messageType int, data []byte) error {
+	var hdr msgproto.Header
+
+	err := proto.Unmarshal(data, &hdr)
+	if err != nil {
+		return err
+	}
+
+	err = sc.respond(sc, &hdr)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.written = append(sc.written, hdr)
+	sc.mu.Unlock()
+
+	return nil
+}
+
+func (sc *scriptedConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	switch messageType {
+	case websocket.PingMessage:
+		return sc.ping()
+	case websocket.CloseMessage:
+		sc.closeWith(closeError(data))
+	}
+
+	return nil
+}
+
+func (sc *scriptedConn) Close() error {
+	sc.shutdown()
+	return nil
+}
+
+// frames returns the number of frames of a type written by the client
+func (sc *scriptedConn) frames(typ msgproto.MsgType) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var n int
+
+	for _, hdr := range sc.written {
+		if hdr.Type == typ {
+			n++
+		}
+	}
+
+	return n
+}
+
+// scriptedDialer returns an option that connects the client to the given
+// connections in turn, failing once they run out
+func scriptedDialer(conns ...*scriptedConn) func(c *Client) error {
+	var mu sync.Mutex
+
+	return func(c *Client) error {
+		c.dialer = func() (transport, *http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if len(conns) == 0 {
+				return nil, nil, errors.New("no more scripted connections")
+			}
+
+			sc := conns[0]
+			conns = conns[1:]
+
+			return sc, nil, nil
+		}
+		return nil
+	}
+}
+
+func TestScriptedConnReceive(t *testing.T) {
+	sc := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, 1, sc.frames(msgproto.MsgType_AUTH))
+
+	sc.reply(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "alice:1", Recipient: "someID:1"})
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.Equal(t, "1", m.Id)
+}
+
+func TestScriptedConnWriteError(t *testing.T) {
+	sc := newScriptedConn(func(sc *scriptedConn, hdr *msgproto.Header) error {
+		if hdr.Type == msgproto.MsgType_MSG {
+			return errors.New("broken pipe")
+		}
+		return acknowledge(sc, hdr)
+	})
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(sc), RequestTimeout(time.Millisecond*100))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, Connected{}, nextEvent(t, c))
+
+	err = c.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	assert.NotNil(t, err)
+
+	e, ok := nextEvent(t, c).(Disconnected)
+	require.True(t, ok)
+	assert.EqualError(t, e.Err, "write failed: broken pipe")
+	assert.True(t, c.IsClosed())
+}
+
+func TestScriptedConnReconnect(t *testing.T) {
+	first := newScriptedConn(acknowledge)
+	second := newScriptedConn(acknowledge)
+
+	c, err := New("wss://scripted", "someID", "1", privkey, scriptedDialer(first, second), AutoReconnect(true), RetryInterval(time.Millisecond*10))
+	require.Nil(t, err)
+	defer c.Close()
+
+	first.fail(&websocket.CloseError{Code: websocket.CloseAbnormalClosure})
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Reconnects == 1 && !c.IsClosed()
+	}, time.Second, time.Millisecond*10)
+
+	assert.Equal(t, 1, second.frames(msgproto.MsgType_AUTH))
+
+	err = c.Send(&msgproto.Message{Id: "1", Type: msgproto.MsgType_MSG, Sender: "someID:1", Recipient: "alice"})
+	require.Nil(t, err)
+	assert.Equal(t, 1, second.frames(msgproto.MsgType_MSG))
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnixServer starts a test server listening on a unix domain socket
+func newUnixServer(t *testing.T, socket string) *testserver {
+	l, err := net.Listen("unix", socket)
+	require.Nil(t, err)
+
+	s := testserver{in: make(chan msgproto.Message), out: make(chan interface{}, 1024), closes: make(chan *websocket.CloseError, 8)}
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/messaging", s.testHandler)
+	s.s = httptest.NewUnstartedServer(m)
+	s.s.Listener.Close()
+	s.s.Listener = l
+	s.s.Start()
+	s.endpoint = "unix://" + socket + "?path=/v1/messaging"
+
+	return &s
+}
+
+func TestUnixEndpoint(t *testing.T) {
+	socket, wsURL, ok, err := unixEndpoint("unix:///run/self/messaging.sock?path=v1/messaging")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "/run/self/messaging.sock", socket)
+	assert.Equal(t, "ws://localhost/v1/messaging", wsURL)
+
+	socket, wsURL, ok, err = unixEndpoint("unix://messaging.sock")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "messaging.sock", socket)
+	assert.Equal(t, "ws://localhost/", wsURL)
+
+	_, _, ok, err = unixEndpoint("wss://messaging.example.com")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, _, err = unixEndpoint("unix://")
+	assert.NotNil(t, err)
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messaging")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newUnixServer(t, filepath.Join(dir, "messaging.sock"))
+	defer s.close()
+
+	c, err := New(s.endpoint, "someID", "1", privkey)
+	require.Nil(t, err)
+	defer c.Close()
+
+	err = c.Send(&msgproto.Message{Type: msgproto.MsgType_MSG, Sender: "test", Recipient: "tset", Ciphertext: []byte("hello")})
+	require.Nil(t, err)
+
+	rm, err := wait(s.in)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), rm.Ciphertext)
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"strings"
+)
+
+// OptionsError is returned by New when one or more options are invalid or
+// conflict with each other
+type OptionsError struct {
+	Errors []error
+}
+
+func (e *OptionsError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return "invalid options: " + strings.Join(msgs, "; ")
+}
+
+// applyOptions applies each option, collecting the errors of all invalid
+// options before checking the options for conflicts
+func (c *Client) applyOptions(opts []func(*Client) error) error {
+	var errs []error
+
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = c.conflicts()
+	}
+
+	if len(errs) > 0 {
+		return &OptionsError{Errors: errs}
+	}
+
+	return nil
+}
+
+// conflicts returns an error for each combination of options that cannot
+// work together
+func (c *Client) conflicts() []error {
+	var errs []error
+
+	if c.memory != nil && c.maxOutbound > c.memory.limit {
+		errs = append(errs, errors.New("maximum outbound message size exceeds the memory budget"))
+	}
+
+	if c.memory != nil && c.maxInbound > c.memory.limit {
+		errs = append(errs, errors.New("maximum inbound message size exceeds the memory budget"))
+	}
+
+	return errs
+}
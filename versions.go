@@ -0,0 +1,142 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Migration upgrades the claims of a payload from one version to the next
+type Migration func(claims map[string]interface{}) error
+
+// payloadVersion is the current version of a payload type and the
+// migrations that upgrade older versions to it
+type payloadVersion struct {
+	current    int
+	migrations map[int]Migration
+}
+
+// stampVersion adds the current version as the ver claim of outbound
+// payloads of a versioned type that do not have one
+func (c *Client) stampVersion(data []byte) ([]byte, error) {
+	if len(c.versions) == 0 {
+		return data, nil
+	}
+
+	var claims map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &claims)
+	if err != nil {
+		return data, nil
+	}
+
+	var typ string
+	json.Unmarshal(claims["typ"], &typ)
+
+	pv, ok := c.versions[typ]
+	if !ok {
+		return data, nil
+	}
+
+	if _, ok := claims["ver"]; ok {
+		return data, nil
+	}
+
+	claims["ver"] = json.RawMessage(fmt.Sprint(pv.current))
+
+	return json.Marshal(claims)
+}
+
+// migrate upgrades a received payload of a versioned type to the current
+// version, returning the payload and its version. Payloads without a ver
+// claim are version 1. Payloads from senders with a newer version are
+// returned as they are
+func (c *Client) migrate(payload []byte) ([]byte, int, error) {
+	if len(c.versions) == 0 {
+		var claims struct {
+			Version int `json:"ver"`
+		}
+		err := json.Unmarshal(payload, &claims)
+		return payload, claims.Version, err
+	}
+
+	var hdr struct {
+		Type    string `json:"typ"`
+		Version int    `json:"ver"`
+	}
+
+	err := json.Unmarshal(payload, &hdr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pv, ok := c.versions[hdr.Type]
+	if !ok {
+		return payload, hdr.Version, nil
+	}
+
+	ver := hdr.Version
+	if ver == 0 {
+		ver = 1
+	}
+
+	if ver >= pv.current {
+		return payload, ver, nil
+	}
+
+	var claims map[string]interface{}
+
+	// numbers are kept as they are so timestamps are not reformatted
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	err = dec.Decode(&claims)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for v := ver; v < pv.current; v++ {
+		fn, ok := pv.migrations[v]
+		if !ok {
+			return nil, 0, fmt.Errorf("no migration from version %d of %s payloads", v, hdr.Type)
+		}
+
+		err = fn(claims)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to migrate version %d of %s payload: %w", v, hdr.Type, err)
+		}
+	}
+
+	claims["ver"] = pv.current
+
+	payload, err = json.Marshal(claims)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return payload, pv.current, nil
+}
+
+func validatePayloadVersion(typ string, current int, migrations map[int]Migration) error {
+	if typ == "" {
+		return errors.New("versioned payload type must not be empty")
+	}
+
+	if current < 1 {
+		return errors.New("payload version must be positive")
+	}
+
+	for v, fn := range migrations {
+		if v < 1 || v >= current {
+			return fmt.Errorf("migration from version %d is outside the versions before %d", v, current)
+		}
+		if fn == nil {
+			return errors.New("payload migration must not be nil")
+		}
+	}
+
+	return nil
+}
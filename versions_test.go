@@ -0,0 +1,113 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// profileVersions renames name to given_name in version 2 and splits it
+// into given and family names in version 3
+var profileVersions = map[int]Migration{
+	1: func(claims map[string]interface{}) error {
+		claims["given_name"] = claims["name"]
+		delete(claims, "name")
+		return nil
+	},
+	2: func(claims map[string]interface{}) error {
+		if claims["given_name"] == "" {
+			return errors.New("missing name")
+		}
+		claims["family_name"] = ""
+		return nil
+	},
+}
+
+func TestClientMigratePayload(t *testing.T) {
+	c := &Client{versions: map[string]*payloadVersion{"profile": {current: 3, migrations: profileVersions}}}
+
+	payload, ver, err := c.migrate([]byte(`{"typ":"profile","name":"alice","iat":1600000000}`))
+	require.Nil(t, err)
+	assert.Equal(t, 3, ver)
+	assert.JSONEq(t, `{"typ":"profile","given_name":"alice","family_name":"","iat":1600000000,"ver":3}`, string(payload))
+
+	payload, ver, err = c.migrate([]byte(`{"typ":"profile","given_name":"alice","ver":2}`))
+	require.Nil(t, err)
+	assert.Equal(t, 3, ver)
+	assert.JSONEq(t, `{"typ":"profile","given_name":"alice","family_name":"","ver":3}`, string(payload))
+
+	// newer versions and other types are left as they are
+	in := []byte(`{"typ":"profile","ver":4}`)
+	payload, ver, err = c.migrate(in)
+	require.Nil(t, err)
+	assert.Equal(t, 4, ver)
+	assert.Equal(t, in, payload)
+
+	in = []byte(`{"typ":"other","name":"alice"}`)
+	payload, ver, err = c.migrate(in)
+	require.Nil(t, err)
+	assert.Equal(t, 0, ver)
+	assert.Equal(t, in, payload)
+
+	_, _, err = c.migrate([]byte(`{"typ":"profile","given_name":"","ver":2}`))
+	assert.EqualError(t, err, "failed to migrate version 2 of profile payload: missing name")
+
+	c.versions["profile"].current = 4
+	_, _, err = c.migrate([]byte(`{"typ":"profile","ver":3}`))
+	assert.EqualError(t, err, "no migration from version 3 of profile payloads")
+}
+
+func TestClientPayloadVersion(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	keys := func(selfID string) ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{pubkey}, nil
+	}
+
+	validated := make(chan string, 1)
+
+	c, err := New(s.endpoint, "someID", "1", privkey, PublicKeys(keys), PayloadVersion("profile", 3, profileVersions), ValidatePayload("profile", func(payload []byte) error {
+		validated <- string(payload)
+		return nil
+	}))
+	require.Nil(t, err)
+	defer c.Close()
+
+	// signed payloads are stamped with the current version
+	signed, err := c.Sign(map[string]interface{}{"typ": "profile", "iss": "someID", "given_name": "alice", "family_name": "smith"})
+	require.Nil(t, err)
+	assert.Contains(t, <-validated, `"ver":3`)
+
+	var v map[string]interface{}
+
+	claims, err := c.DecodePayload(&msgproto.Message{Sender: "someID:1", Ciphertext: signed}, &v)
+	require.Nil(t, err)
+	assert.Equal(t, 3, claims.Version)
+
+	old := testSignedPayload(privkey, map[string]interface{}{"typ": "profile", "iss": "test", "name": "alice"})
+
+	s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "test:1", Recipient: "someID:1", Ciphertext: old}
+
+	var profile struct {
+		GivenName  string `json:"given_name"`
+		FamilyName string `json:"family_name"`
+		Version    int    `json:"ver"`
+	}
+
+	m, err := c.Receive()
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"typ":"profile","iss":"test","given_name":"alice","family_name":"","ver":3}`, <-validated)
+
+	claims, err = c.DecodePayload(m, &profile)
+	require.Nil(t, err)
+	assert.Equal(t, 3, claims.Version)
+	assert.Equal(t, "alice", profile.GivenName)
+	assert.Equal(t, 3, profile.Version)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnectionStalled is reported when the watchdog has not seen any
+// frames from the server within the configured period
+var ErrConnectionStalled = errors.New("connection stalled")
+
+// touch records that a frame has been received from the server
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.counters.lastFrame, c.clock.Now().UnixNano())
+}
+
+// watchdog forces a reconnect if no frames, including pongs, are received
+// within the watchdog period. It exits when the connection is torn down
+func (c *Client) watchdog(conn *connection) {
+	if c.watchdogPeriod <= 0 {
+		return
+	}
+
+	ticker := c.clock.NewTicker(c.watchdogPeriod / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.Chan():
+			last := time.Unix(0, atomic.LoadInt64(&c.counters.lastFrame))
+			if c.clock.Now().Sub(last) < c.watchdogPeriod {
+				continue
+			}
+
+			c.report(ErrConnectionStalled)
+
+			if !c.teardown(conn, ErrConnectionStalled) {
+				return
+			}
+
+			select {
+			case <-conn.readerDone:
+			case <-c.clock.After(c.deadline):
+			}
+
+			c.tryReconnect(ErrConnectionStalled)
+
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// watermarkPollInterval is how often a queue above its high watermark is
+// checked for having fallen back to its low watermark
+const watermarkPollInterval = time.Millisecond * 10
+
+// Watermarks are thresholds on the depth of a queue. OnHigh is called when
+// the depth reaches High, and OnLow once it has fallen back to Low, so an
+// application can pause producers or shed load before the queue is full.
+// The callbacks are called synchronously and must not block
+type Watermarks struct {
+	High   int
+	Low    int
+	OnHigh func(depth int)
+	OnLow  func(depth int)
+}
+
+// watermark tracks whether a queue is above its high watermark
+type watermark struct {
+	Watermarks
+	above int32
+}
+
+func newWatermark(w Watermarks, size int) (*watermark, error) {
+	if w.High < 1 || w.High > size {
+		return nil, errors.New("high watermark must be between 1 and the buffer size")
+	}
+
+	if w.Low < 0 || w.Low >= w.High {
+		return nil, errors.New("low watermark must be below the high watermark")
+	}
+
+	return &watermark{Watermarks: w}, nil
+}
+
+// check calls the callbacks if the depth has crossed a watermark
+func (w *watermark) check(depth int) {
+	if w == nil {
+		return
+	}
+
+	switch {
+	case depth >= w.High:
+		if atomic.CompareAndSwapInt32(&w.above, 0, 1) && w.OnHigh != nil {
+			w.OnHigh(depth)
+		}
+	case depth <= w.Low:
+		if atomic.CompareAndSwapInt32(&w.above, 1, 0) && w.OnLow != nil {
+			w.OnLow(depth)
+		}
+	}
+}
+
+// isAbove returns true if the queue has reached its high watermark and not
+// yet fallen back to its low watermark
+func (w *watermark) isAbove() bool {
+	return w != nil && atomic.LoadInt32(&w.above) == 1
+}
+
+// SendQueueDepth returns the number of requests waiting to be written
+func (c *Client) SendQueueDepth() int {
+	return len(c.send) + c.deadlines.len()
+}
+
+// ReceiveQueueDepth returns the number of messages waiting to be received
+func (c *Client) ReceiveQueueDepth() int {
+	return len(c.recv)
+}
+
+// checkSendQueue checks the send queue's watermarks
+func (c *Client) checkSendQueue() {
+	c.sendWatermark.check(c.SendQueueDepth())
+}
+
+// checkReceiveQueue checks the receive queue's watermarks
+func (c *Client) checkReceiveQueue() {
+	c.recvWatermark.check(len(c.recv))
+}
+
+// watchReceiveQueue checks the receive queue's low watermark while it is
+// above its high watermark, as messages taken from ReceiveChan are not
+// seen by the client. It exits when the connection is torn down
+func (c *Client) watchReceiveQueue(conn *connection) {
+	if c.recvWatermark == nil {
+		return
+	}
+
+	ticker := c.clock.NewTicker(watermarkPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.Chan():
+			if c.recvWatermark.isAbove() {
+				c.checkReceiveQueue()
+			}
+		}
+	}
+}
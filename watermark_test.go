@@ -0,0 +1,115 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientReceiveWatermarks(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	high := make(chan int, 1)
+	low := make(chan int, 1)
+
+	w := Watermarks{
+		High:   3,
+		Low:    1,
+		OnHigh: func(depth int) { high <- depth },
+		OnLow:  func(depth int) { low <- depth },
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ReceiveBuffer(8), ReceiveWatermarks(w))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+	}
+
+	select {
+	case depth := <-high:
+		assert.Equal(t, 3, depth)
+	case <-time.After(time.Second):
+		t.Fatal("high watermark was not reached")
+	}
+
+	assert.Equal(t, 3, c.ReceiveQueueDepth())
+
+	for range []int{0, 1} {
+		_, err = c.Receive()
+		require.Nil(t, err)
+	}
+
+	select {
+	case depth := <-low:
+		assert.Equal(t, 1, depth)
+	case <-time.After(time.Second):
+		t.Fatal("low watermark was not reached")
+	}
+}
+
+func TestWatermarksInvalid(t *testing.T) {
+	_, err := newWatermark(Watermarks{High: 0}, 8)
+	assert.NotNil(t, err)
+
+	_, err = newWatermark(Watermarks{High: 9}, 8)
+	assert.NotNil(t, err)
+
+	_, err = newWatermark(Watermarks{High: 4, Low: 4}, 8)
+	assert.NotNil(t, err)
+
+	_, err = newWatermark(Watermarks{High: 4, Low: 2}, 8)
+	assert.Nil(t, err)
+}
+
+func TestClientReceiveWatermarksReceiveChan(t *testing.T) {
+	s := newServer()
+	defer s.close()
+
+	high := make(chan int, 1)
+	low := make(chan int, 1)
+
+	w := Watermarks{
+		High:   2,
+		Low:    0,
+		OnHigh: func(depth int) { high <- depth },
+		OnLow:  func(depth int) { low <- depth },
+	}
+
+	c, err := New(s.endpoint, "someID", "1", privkey, ReceiveBuffer(8), ReceiveWatermarks(w))
+	require.Nil(t, err)
+	defer c.Close()
+
+	for _, id := range []string{"1", "2"} {
+		s.out <- &msgproto.Message{Type: msgproto.MsgType_MSG, Id: id, Sender: "test:1", Recipient: "someID:1", Ciphertext: []byte("hi")}
+	}
+
+	select {
+	case <-high:
+	case <-time.After(time.Second):
+		t.Fatal("high watermark was not reached")
+	}
+
+	// messages read from the channel drain the queue without a further message
+	for range []int{0, 1} {
+		select {
+		case <-c.ReceiveChan():
+		case <-time.After(time.Second):
+			t.Fatal("message was not received")
+		}
+	}
+
+	select {
+	case depth := <-low:
+		assert.Equal(t, 0, depth)
+	case <-time.After(time.Second):
+		t.Fatal("low watermark was not reached")
+	}
+}
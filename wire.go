@@ -0,0 +1,156 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/protobuf/jsonpb"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+)
+
+// frameMessage returns an empty message for a frame type
+func frameMessage(t msgproto.MsgType) (proto.Message, error) {
+	switch t {
+	case msgproto.MsgType_MSG:
+		return &msgproto.Message{}, nil
+	case msgproto.MsgType_ACK, msgproto.MsgType_ERR:
+		return &msgproto.Notification{}, nil
+	case msgproto.MsgType_AUTH:
+		return &msgproto.Auth{}, nil
+	case msgproto.MsgType_ACL:
+		return &msgproto.AccessControlList{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown frame type %d", t)
+}
+
+// FrameToJSON decodes a protobuf frame as it is sent on the wire and
+// encodes it as JSON, for debugging and golden file tests
+func FrameToJSON(data []byte) ([]byte, error) {
+	var hdr msgproto.Header
+
+	err := proto.Unmarshal(data, &hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := frameMessage(hdr.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = (&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// FrameFromJSON encodes a frame produced by FrameToJSON back into its wire format
+func FrameFromJSON(data []byte) ([]byte, error) {
+	var hdr struct {
+		Type json.RawMessage `json:"type"`
+	}
+
+	err := json.Unmarshal(data, &hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := parseFrameType(hdr.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := frameMessage(t)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jsonpb.Unmarshal(bytes.NewReader(data), m)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(m)
+}
+
+// parseFrameType parses a frame type encoded as either its name or number
+func parseFrameType(data json.RawMessage) (msgproto.MsgType, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return msgproto.MsgType_MSG, nil
+	}
+
+	if data[0] == '"' {
+		var name string
+
+		err := json.Unmarshal(data, &name)
+		if err != nil {
+			return 0, err
+		}
+
+		t, ok := msgproto.MsgType_value[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown frame type %s", name)
+		}
+
+		return msgproto.MsgType(t), nil
+	}
+
+	t, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid frame type %s", data)
+	}
+
+	return msgproto.MsgType(t), nil
+}
+
+// TapJSONWriter returns a frame tap that writes each frame to w as a line of JSON
+func TapJSONWriter(w io.Writer) func(*Frame) {
+	var mu sync.Mutex
+
+	return func(f *Frame) {
+		line := struct {
+			Time      string          `json:"time"`
+			Direction string          `json:"direction"`
+			Frame     json.RawMessage `json:"frame,omitempty"`
+			Error     string          `json:"error,omitempty"`
+		}{
+			Time:      f.Time.Format(time.RFC3339Nano),
+			Direction: f.Direction,
+		}
+
+		frame, err := FrameToJSON(f.Data)
+		if err != nil {
+			line.Error = err.Error()
+		} else {
+			line.Frame = frame
+		}
+
+		data, err := json.Marshal(line)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Write(append(data, '\n'))
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	msgproto "github.com/selfid-net/self-messaging-client/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameJSON(t *testing.T) {
+	frames := []proto.Message{
+		&msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1", Sender: "a:1", Recipient: "b:1", Ciphertext: []byte("hello"), Offset: 10},
+		&msgproto.Notification{Type: msgproto.MsgType_ERR, Id: "2", Error: "recipient rejected"},
+		&msgproto.Auth{Type: msgproto.MsgType_AUTH, Id: "3", Token: "token", Device: "1"},
+	}
+
+	for _, f := range frames {
+		data, err := proto.Marshal(f)
+		require.Nil(t, err)
+
+		js, err := FrameToJSON(data)
+		require.Nil(t, err)
+
+		out, err := FrameFromJSON(js)
+		require.Nil(t, err)
+		assert.Equal(t, data, out)
+	}
+
+	data, err := proto.Marshal(frames[0])
+	require.Nil(t, err)
+
+	js, err := FrameToJSON(data)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"id":"1","sender":"a:1","recipient":"b:1","ciphertext":"aGVsbG8=","offset":"10"}`, string(js))
+
+	_, err = FrameFromJSON([]byte(`{"type":"BOGUS"}`))
+	assert.NotNil(t, err)
+}
+
+func TestTapJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	data, err := proto.Marshal(&msgproto.Message{Type: msgproto.MsgType_MSG, Id: "1"})
+	require.Nil(t, err)
+
+	tap := TapJSONWriter(&buf)
+	tap(&Frame{Time: time.Unix(0, 0).UTC(), Direction: FrameOutbound, Data: data})
+	tap(&Frame{Time: time.Unix(0, 0).UTC(), Direction: FrameInbound, Data: []byte{0xff}})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var line struct {
+		Direction string          `json:"direction"`
+		Frame     json.RawMessage `json:"frame"`
+		Error     string          `json:"error"`
+	}
+
+	require.Nil(t, json.Unmarshal(lines[0], &line))
+	assert.Equal(t, FrameOutbound, line.Direction)
+	assert.JSONEq(t, `{"id":"1"}`, string(line.Frame))
+
+	require.Nil(t, json.Unmarshal(lines[1], &line))
+	assert.NotEmpty(t, line.Error)
+}